@@ -0,0 +1,26 @@
+package smartcrop
+
+import "image"
+
+// align rounds r outwards to multiples of Config.AlignTo, and inwards where it
+// would leave an image with the given bounds. r is relative to the origin of the
+// image.
+func (sca *smartcropAnalyzer) align(r, bounds image.Rectangle) image.Rectangle {
+	n := sca.config.AlignTo
+	if n <= 1 || r.Empty() {
+		return r
+	}
+	down := func(v int) int { return v / n * n }
+	up := func(v, max int) int {
+		if v = (v + n - 1) / n * n; v > max {
+			return down(max)
+		}
+		return v
+	}
+	a := image.Rect(down(r.Min.X), down(r.Min.Y), up(r.Max.X, bounds.Dx()), up(r.Max.Y, bounds.Dy()))
+	if a.Empty() {
+		// the image is smaller than one alignment unit
+		return r
+	}
+	return a
+}
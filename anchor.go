@@ -0,0 +1,46 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// Anchor is a position in the image, with X and Y ranging from 0 (left, top) to
+// 1 (right, bottom).
+type Anchor struct {
+	X, Y float64
+}
+
+// Common anchors for Config.Anchor.
+var (
+	AnchorCenter = Anchor{0.5, 0.5}
+	AnchorTop    = Anchor{0.5, 0}
+	AnchorBottom = Anchor{0.5, 1}
+	AnchorLeft   = Anchor{0, 0.5}
+	AnchorRight  = Anchor{1, 0.5}
+)
+
+// anchorBoost returns the share of its magnitude Config.AnchorBias raises the total
+// score of crop by: AnchorBias when the crop is as close to Config.Anchor as it
+// can get within bounds, falling to 0 at the opposite corner. The products are
+// rounded explicitly, so the result is the same with deterministic scoring.
+func (sca *smartcropAnalyzer) anchorBoost(bounds image.Rectangle, crop Crop) float64 {
+	if sca.config.AnchorBias == 0 {
+		return 0
+	}
+
+	tx := anchorPosition(crop.Min.X-bounds.Min.X, bounds.Dx()-crop.Dx(), sca.config.Anchor.X)
+	ty := anchorPosition(crop.Min.Y-bounds.Min.Y, bounds.Dy()-crop.Dy(), sca.config.Anchor.Y)
+	dx, dy := tx-sca.config.Anchor.X, ty-sca.config.Anchor.Y
+	d := math.Sqrt(float64(dx*dx)+float64(dy*dy)) / math.Sqrt2
+	return float64(sca.config.AnchorBias * (1 - d))
+}
+
+// anchorPosition returns where offset lies between 0 and room, as a fraction.
+// A crop that can't move on an axis is always at the anchor.
+func anchorPosition(offset, room int, anchor float64) float64 {
+	if room <= 0 {
+		return anchor
+	}
+	return float64(offset) / float64(room)
+}
@@ -0,0 +1,173 @@
+// Package barcode finds QR codes and 1D barcodes with gozxing, for use as the
+// smartcrop.BarcodeDetector of an analyzer:
+//
+//	analyzer := smartcrop.New(smartcrop.WithBarcodeDetector(barcode.New()))
+//
+// The regions include the quiet zone around each code, so a crop containing
+// them keeps the codes scannable.
+package barcode
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/multi/qrcode"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// Detector finds the codes in an image, see New and NewQR.
+type Detector struct {
+	oneD []gozxing.Reader
+}
+
+// New returns a Detector for QR codes and the 1D barcode formats gozxing
+// supports. Of each 1D format at most one barcode is found per image.
+func New() *Detector {
+	return &Detector{oneD: []gozxing.Reader{
+		oned.NewMultiFormatUPCEANReader(nil),
+		oned.NewCode128Reader(),
+		oned.NewCode39Reader(),
+		oned.NewCode93Reader(),
+		oned.NewITFReader(),
+		oned.NewCodaBarReader(),
+	}}
+}
+
+// NewQR returns a Detector for QR codes only, which is faster than New.
+func NewQR() *Detector {
+	return &Detector{}
+}
+
+// DetectBarcodes implements smartcrop.BarcodeDetector.
+func (d *Detector) DetectBarcodes(img image.Image) ([]image.Rectangle, error) {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+	// the result points are relative to the origin of img
+	bounds := img.Bounds()
+
+	var codes []image.Rectangle
+	results, err := qrcode.NewQRCodeMultiReader().DecodeMultipleWithoutHint(bmp)
+	if err != nil && !isReaderError(err) {
+		return nil, err
+	}
+	for _, res := range results {
+		codes = append(codes, qrRegion(res.GetResultPoints()).Add(bounds.Min).Intersect(bounds))
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	for _, reader := range d.oneD {
+		res, err := reader.Decode(bmp, hints)
+		if isReaderError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, oneDRegion(img, res.GetResultPoints()).Intersect(bounds))
+	}
+	return codes, nil
+}
+
+// isReaderError reports whether err only tells that no code was found, or none
+// could be decoded.
+func isReaderError(err error) bool {
+	_, ok := err.(gozxing.ReaderException)
+	return ok
+}
+
+// qrRegion returns the region of a QR code given by the centers of its finder
+// patterns, taking 3.5 modules to the edge of the symbol and 4 more of quiet
+// zone.
+func qrRegion(points []gozxing.ResultPoint) image.Rectangle {
+	minX, minY, maxX, maxY := bounding(points)
+	var module float64
+	for _, p := range points {
+		if f, ok := p.(interface{ GetEstimatedModuleSize() float64 }); ok {
+			module = math.Max(module, f.GetEstimatedModuleSize())
+		}
+	}
+	if module == 0 {
+		// the finder patterns of the smallest codes are 14 modules apart
+		module = math.Max(maxX-minX, maxY-minY) / 14
+	}
+	pad := 7.5 * module
+	return image.Rect(int(math.Floor(minX-pad)), int(math.Floor(minY-pad)), int(math.Ceil(maxX+pad)), int(math.Ceil(maxY+pad)))
+}
+
+// oneDRegion returns the region of a 1D barcode found on the scan line through
+// points, which are relative to the origin of img. As the bars run vertically, the rows above and below are added as
+// long as they look like the scan line. A tenth of the width is added to each
+// side for the quiet zone.
+func oneDRegion(img image.Image, points []gozxing.ResultPoint) image.Rectangle {
+	minX, minY, maxX, maxY := bounding(points)
+	b := img.Bounds()
+	minX, maxX = minX+float64(b.Min.X), maxX+float64(b.Min.X)
+	minY, maxY = minY+float64(b.Min.Y), maxY+float64(b.Min.Y)
+	x0, x1 := maxInt(b.Min.X, int(math.Floor(minX))), minInt(b.Max.X, int(math.Ceil(maxX)))
+	y := int(math.Round((minY + maxY) / 2))
+	if x1 <= x0 || y < b.Min.Y || y >= b.Max.Y {
+		return image.Rectangle{}
+	}
+
+	line := row(img, x0, x1, y)
+	top, bottom := y, y+1
+	for top > b.Min.Y && similar(row(img, x0, x1, top-1), line) {
+		top--
+	}
+	for bottom < b.Max.Y && similar(row(img, x0, x1, bottom), line) {
+		bottom++
+	}
+	pad := (x1 - x0) / 10
+	return image.Rect(x0-pad, top-pad, x1+pad, bottom+pad)
+}
+
+func bounding(points []gozxing.ResultPoint) (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.GetX()), math.Max(maxX, p.GetX())
+		minY, maxY = math.Min(minY, p.GetY()), math.Max(maxY, p.GetY())
+	}
+	return minX, minY, maxX, maxY
+}
+
+// row returns the gray values of img from x0 to x1 at y.
+func row(img image.Image, x0, x1, y int) []uint8 {
+	r := make([]uint8, 0, x1-x0)
+	for x := x0; x < x1; x++ {
+		r = append(r, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+	}
+	return r
+}
+
+// similar reports whether two rows differ by less than an eighth of the gray
+// range on average.
+func similar(a, b []uint8) bool {
+	var diff int
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		diff += d
+	}
+	return diff < len(a)*32
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package barcode
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// codeImage returns a white image with bounds, the code m drawn at at.
+func codeImage(m *gozxing.BitMatrix, bounds image.Rectangle, at image.Point) *image.Gray {
+	img := image.NewGray(bounds)
+	draw.Draw(img, bounds, image.White, image.ZP, draw.Src)
+	for y := 0; y < m.GetHeight(); y++ {
+		for x := 0; x < m.GetWidth(); x++ {
+			if m.Get(x, y) {
+				img.SetGray(at.X+x, at.Y+y, color.Gray{})
+			}
+		}
+	}
+	return img
+}
+
+func TestDetectBarcodes(t *testing.T) {
+	qr, err := qrcode.NewQRCodeWriter().Encode("https://example.com/", gozxing.BarcodeFormat_QR_CODE, 100, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := oned.NewCode128Writer().Encode("SMARTCROP", gozxing.BarcodeFormat_CODE_128, 200, 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		detector *Detector
+		code     *gozxing.BitMatrix
+	}{
+		{"QR", NewQR(), qr},
+		{"Code 128", New(), bar},
+	} {
+		var first image.Rectangle
+		for i, bounds := range []image.Rectangle{image.Rect(0, 0, 400, 300), image.Rect(-50, 1000, 350, 1300)} {
+			at := bounds.Min.Add(image.Pt(150, 120))
+			img := codeImage(test.code, bounds, at)
+			codes, err := test.detector.DetectBarcodes(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(codes) != 1 {
+				t.Fatalf("%s in %v: expected a code, got %v", test.name, bounds, codes)
+			}
+			center := at.Add(image.Pt(test.code.GetWidth()/2, test.code.GetHeight()/2))
+			if !center.In(codes[0]) || !codes[0].In(bounds) {
+				t.Errorf("%s in %v: expected a region around %v, got %v", test.name, bounds, center, codes[0])
+			}
+			// the region moves with the origin of the image
+			if r := codes[0].Sub(bounds.Min); i == 0 {
+				first = r
+			} else if r != first {
+				t.Errorf("%s: expected the region %v relative to the origin, got %v", test.name, first, r)
+			}
+		}
+	}
+
+	codes, err := New().DetectBarcodes(image.NewGray(image.Rect(0, 0, 100, 100)))
+	if err != nil || len(codes) != 0 {
+		t.Errorf("expected no codes in a blank image, got %v, %v", codes, err)
+	}
+}
@@ -0,0 +1,90 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// BarcodeDetector finds QR codes and barcodes in an image, see
+// WithBarcodeDetector. The barcode package implements one with gozxing.
+type BarcodeDetector interface {
+	DetectBarcodes(img image.Image) ([]image.Rectangle, error)
+}
+
+// BarcodePolicy decides whether crops keep or remove the codes found by a
+// BarcodeDetector, see Config.BarcodePolicy. Under either policy candidates
+// cutting through a code are skipped unless all of them do.
+type BarcodePolicy int
+
+const (
+	// BarcodeInclude only considers the candidates containing the most codes.
+	// This is the default.
+	BarcodeInclude BarcodePolicy = iota
+	// BarcodeExclude only considers the candidates containing the fewest codes,
+	// none where possible.
+	BarcodeExclude
+)
+
+// withBarcodes returns the analyzer to analyse the analysis image of img, which
+// is scaled by prescalefactor, carrying the codes found in img.
+func (sca *smartcropAnalyzer) withBarcodes(img image.Image, prescalefactor float64) (*smartcropAnalyzer, error) {
+	if sca.barcodes == nil {
+		return sca, nil
+	}
+	// codes are found in the original image, as prescaling may leave them
+	// unreadable
+	found, err := sca.barcodes.DetectBarcodes(img)
+	if err != nil {
+		return nil, err
+	}
+	origin := img.Bounds().Min
+	c := *sca
+	c.codes = make([]image.Rectangle, 0, len(found))
+	for _, r := range found {
+		r = r.Sub(origin)
+		// rounded outwards, so the codes stay within crops containing them
+		c.codes = append(c.codes, image.Rect(
+			int(math.Floor(float64(r.Min.X)*prescalefactor)), int(math.Floor(float64(r.Min.Y)*prescalefactor)),
+			int(math.Ceil(float64(r.Max.X)*prescalefactor)), int(math.Ceil(float64(r.Max.Y)*prescalefactor))))
+	}
+	return &c, nil
+}
+
+// keepCodesWhole applies Config.BarcodePolicy to the candidates cs.
+func (sca *smartcropAnalyzer) keepCodesWhole(cs []Crop) []Crop {
+	if len(sca.codes) == 0 {
+		return cs
+	}
+
+	best := -1
+	var kept []Crop
+	for _, crop := range cs {
+		inside, cut := 0, false
+		for _, r := range sca.codes {
+			switch {
+			case r.In(crop.Rectangle):
+				inside++
+			case r.Overlaps(crop.Rectangle):
+				cut = true
+			}
+		}
+		if cut {
+			continue
+		}
+		rank := inside
+		if sca.config.BarcodePolicy == BarcodeExclude {
+			rank = len(sca.codes) - inside
+		}
+		if rank > best {
+			best, kept = rank, kept[:0]
+		}
+		if rank == best {
+			kept = append(kept, crop)
+		}
+	}
+	if len(kept) == 0 {
+		sca.logger.Log.Println("every candidate cuts through a barcode")
+		return cs
+	}
+	return kept
+}
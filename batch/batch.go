@@ -0,0 +1,373 @@
+// Package batch crops all images below a prefix of an object store, such as an
+// S3 or GCS bucket, for backfills over large collections. The store is accessed
+// through the Bucket interface, which takes a few lines to implement on top of
+// the cloud providers' SDKs, so this package doesn't depend on any of them. Dir
+// implements it for a local directory.
+//
+// A Job writes the crops of every image next to it as JSON, and optionally the
+// cropped renditions themselves. With a checkpoint, an interrupted job resumes
+// after the last image it finished:
+//
+//	job := &batch.Job{
+//		Bucket:     bucket,
+//		Prefix:     "photos/",
+//		Analyzer:   smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()),
+//		Sizes:      []image.Point{{300, 200}, {200, 200}},
+//		Checkpoint: "crops.checkpoint",
+//	}
+//	stats, err := job.Run(ctx)
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	_ "image/gif" // decode GIF images
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/options"
+	"github.com/third-light/smartcrop/xdraw"
+	_ "golang.org/x/image/webp" // decode WebP images
+)
+
+// ErrNotExist is returned by Bucket.Get for a missing object.
+var ErrNotExist = errors.New("Object does not exist")
+
+// Bucket is an object store.
+type Bucket interface {
+	// List calls fn with the key of every object whose key starts with prefix
+	// and sorts after after, in lexical order, as S3's ListObjectsV2 with
+	// StartAfter and GCS's object listing with StartOffset return them. It
+	// stops with the first error returned by fn.
+	List(ctx context.Context, prefix, after string, fn func(key string) error) error
+	// Get returns the content of the object at key, ErrNotExist if there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put stores data at key.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+// Job crops the images below Prefix of Bucket.
+type Job struct {
+	Bucket   Bucket
+	Prefix   string
+	Analyzer smartcrop.Analyzer
+	// Sizes are the sizes to find crops for.
+	Sizes []image.Point
+
+	// Filter decides which keys are images to crop. The default accepts the
+	// extensions .jpg, .jpeg, .png, .gif and .webp.
+	Filter func(key string) bool
+	// Output returns the key to write the crops of the image at key to. The
+	// default appends ".crops.json".
+	Output func(key string) string
+	// Rendition, if set, returns the key to write the rendition of the image at
+	// key at size to, resized with Resizer. JPEG images are written as JPEG,
+	// all others as PNG. Renditions written below Prefix should go below
+	// OutputPrefix, so later runs don't crop them again.
+	Rendition func(key string, size image.Point) string
+	// OutputPrefix, if set, is where the job writes its output below Prefix.
+	// Keys starting with it aren't cropped.
+	OutputPrefix string
+	// Resizer resizes the renditions, xdraw.NewDefaultResizer() by default.
+	Resizer options.Resizer
+	// MaxPixels limits the pixels of the images, checked before they are
+	// decoded, see smartcrop.DecodeLimited. Larger images fail with
+	// smartcrop.ErrImageTooLarge. 0 means 50 megapixels, a negative value no
+	// limit.
+	MaxPixels int64
+
+	// Concurrency is the number of images processed at once, 1 if not positive.
+	Concurrency int
+	// Checkpoint, if set, is the key the job records at which key to resume at.
+	// It is written every CheckpointEvery images, 100 if not positive, and when
+	// the job ends. Images that failed count as done and aren't retried.
+	Checkpoint      string
+	CheckpointEvery int
+	// OnError is called for every image that couldn't be cropped. The job stops
+	// when it returns an error, otherwise the image is counted as failed.
+	OnError func(key string, err error) error
+}
+
+// Stats counts the images a job processed.
+type Stats struct {
+	Cropped, Failed int
+}
+
+// Result is the JSON written for each image.
+type Result struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Crop   Rect    `json:"crop"`
+	Score  float64 `json:"score"`
+}
+
+// Rect is a rectangle in the JSON output.
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type task struct {
+	seq int
+	key string
+}
+
+// Run processes the images, resuming at the checkpoint if there is one, until
+// all are done, ctx is canceled or OnError returns an error.
+func (j *Job) Run(ctx context.Context) (Stats, error) {
+	if len(j.Sizes) == 0 {
+		return Stats{}, smartcrop.ErrInvalidDimensions
+	}
+	after, err := j.loadCheckpoint(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tasks := make(chan task)
+	p := &progress{job: j, done: make(map[int]string), last: after}
+
+	workers := j.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				err := j.process(ctx, t.key)
+				if err != nil && ctx.Err() != nil {
+					// canceled images are left to the next run
+					continue
+				}
+				if err := p.finish(ctx, t, err); err != nil {
+					p.fail(err)
+					cancel()
+				}
+			}
+		}()
+	}
+
+	seq := 0
+	listErr := j.Bucket.List(ctx, j.Prefix, after, func(key string) error {
+		if !j.accept(key) {
+			return nil
+		}
+		select {
+		case tasks <- task{seq, key}:
+			seq++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(tasks)
+	wg.Wait()
+
+	saveErr := j.saveCheckpoint(ctx, p.last)
+	if err := p.err(); err != nil {
+		return p.stats, err
+	}
+	if listErr != nil {
+		return p.stats, listErr
+	}
+	if saveErr != nil {
+		return p.stats, saveErr
+	}
+	return p.stats, ctx.Err()
+}
+
+// accept reports whether key is an image to crop, and not the job's own output.
+func (j *Job) accept(key string) bool {
+	if key == j.Checkpoint || (j.OutputPrefix != "" && strings.HasPrefix(key, j.OutputPrefix)) {
+		return false
+	}
+	if j.Filter != nil {
+		return j.Filter(key)
+	}
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+// process crops the image at key and writes the results.
+func (j *Job) process(ctx context.Context, key string) error {
+	rc, err := j.Bucket.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	maxPixels := j.MaxPixels
+	if maxPixels == 0 {
+		maxPixels = 50e6
+	}
+	img, format, err := smartcrop.DecodeLimited(bytes.NewReader(data), maxPixels)
+	if err != nil {
+		return err
+	}
+	crops, err := smartcrop.FindBestCrops(j.Analyzer, img, j.Sizes)
+	if err != nil {
+		return err
+	}
+
+	results := make([]Result, len(crops))
+	for i, c := range crops {
+		results[i] = Result{
+			Width:  j.Sizes[i].X,
+			Height: j.Sizes[i].Y,
+			Crop:   Rect{c.Min.X, c.Min.Y, c.Dx(), c.Dy()},
+			Score:  c.Score.Normalized,
+		}
+	}
+	out, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	output := key + ".crops.json"
+	if j.Output != nil {
+		output = j.Output(key)
+	}
+	if err := j.Bucket.Put(ctx, output, out, "application/json"); err != nil {
+		return err
+	}
+
+	if j.Rendition == nil {
+		return nil
+	}
+	resizer := j.Resizer
+	if resizer == nil {
+		resizer = xdraw.NewDefaultResizer()
+	}
+	origin := img.Bounds().Min
+	for i, c := range crops {
+		size := j.Sizes[i]
+		r := resizer.Resize(smartcrop.CropImage(img, c.Rectangle.Add(origin)), uint(size.X), uint(size.Y))
+		var buf bytes.Buffer
+		contentType := "image/png"
+		if format == "jpeg" {
+			contentType = "image/jpeg"
+			err = jpeg.Encode(&buf, r, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, r)
+		}
+		if err != nil {
+			return err
+		}
+		if err := j.Bucket.Put(ctx, j.Rendition(key, size), buf.Bytes(), contentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCheckpoint returns the key to resume after, "" without a checkpoint.
+func (j *Job) loadCheckpoint(ctx context.Context) (string, error) {
+	if j.Checkpoint == "" {
+		return "", nil
+	}
+	rc, err := j.Bucket.Get(ctx, j.Checkpoint)
+	if err == ErrNotExist {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	return string(data), err
+}
+
+// saveCheckpoint records that all images up to key are done.
+func (j *Job) saveCheckpoint(ctx context.Context, key string) error {
+	if j.Checkpoint == "" || key == "" {
+		return nil
+	}
+	// the checkpoint is written even after cancellation, so work isn't lost
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+	return j.Bucket.Put(ctx, j.Checkpoint, []byte(key), "text/plain")
+}
+
+// progress tracks the finished images of a run. Images finish out of order, so
+// the checkpoint advances only as far as all images before it are done.
+type progress struct {
+	job *Job
+
+	mu       sync.Mutex
+	stats    Stats
+	done     map[int]string
+	next     int
+	last     string
+	unsaved  int
+	firstErr error
+}
+
+// finish records the outcome of t, calls OnError for a failure and writes the
+// checkpoint when due.
+func (p *progress) finish(ctx context.Context, t task, err error) error {
+	if err != nil && p.job.OnError != nil {
+		if err := p.job.OnError(t.key, err); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.stats.Failed++
+	} else {
+		p.stats.Cropped++
+	}
+	p.done[t.seq] = t.key
+	for key, ok := p.done[p.next]; ok; key, ok = p.done[p.next] {
+		delete(p.done, p.next)
+		p.next++
+		p.last = key
+		p.unsaved++
+	}
+
+	every := p.job.CheckpointEvery
+	if every <= 0 {
+		every = 100
+	}
+	if p.unsaved < every {
+		return nil
+	}
+	p.unsaved = 0
+	return p.job.saveCheckpoint(ctx, p.last)
+}
+
+func (p *progress) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.firstErr == nil {
+		p.firstErr = err
+	}
+}
+
+func (p *progress) err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.firstErr
+}
@@ -0,0 +1,214 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+// tempDir returns a Dir in a new temporary directory and a function removing it.
+func tempDir(t *testing.T) (Dir, func()) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Dir(dir), func() { os.RemoveAll(dir) }
+}
+
+// putImage stores a width x height PNG at key.
+func putImage(t *testing.T, d Dir, key string, width, height int) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 90, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(context.Background(), key, buf.Bytes(), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newJob(d Dir) *Job {
+	return &Job{
+		Bucket:   d,
+		Prefix:   "photos/",
+		Analyzer: smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()),
+		Sizes:    []image.Point{{100, 100}, {120, 60}},
+	}
+}
+
+func TestRun(t *testing.T) {
+	d, cleanup := tempDir(t)
+	defer cleanup()
+	for _, key := range []string{"photos/a.png", "photos/b.png", "photos/notes.txt", "other/c.png"} {
+		putImage(t, d, key, 200, 150)
+	}
+
+	job := newJob(d)
+	job.Concurrency = 2
+	job.OutputPrefix = "photos/renditions/"
+	job.Rendition = func(key string, size image.Point) string {
+		return fmt.Sprintf("photos/renditions/%s-%dx%d.png", filepath.Base(key), size.X, size.Y)
+	}
+	stats, err := job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Cropped: 2}) {
+		t.Fatalf("expected two images cropped, got %+v", stats)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(string(d), "photos", "a.png.crops.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []Result
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].Width != 120 || results[1].Crop.Width != 2*results[1].Crop.Height {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	f, err := os.Open(filepath.Join(string(d), "photos", "renditions", "a.png-120x60.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cfg, err := png.DecodeConfig(f); err != nil || cfg.Width != 120 || cfg.Height != 60 {
+		t.Fatalf("expected a 120x60 rendition, got %+v (%v)", cfg, err)
+	}
+
+	// the renditions aren't cropped again
+	stats, err = job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Cropped: 2}) {
+		t.Fatalf("expected only the two images cropped again, got %+v", stats)
+	}
+}
+
+func TestRunCheckpoint(t *testing.T) {
+	d, cleanup := tempDir(t)
+	defer cleanup()
+	for _, key := range []string{"photos/a.png", "photos/b.png", "photos/c.png"} {
+		putImage(t, d, key, 200, 150)
+	}
+
+	// an earlier run got as far as b
+	job := newJob(d)
+	job.Checkpoint = "photos/crops.checkpoint"
+	if err := d.Put(context.Background(), job.Checkpoint, []byte("photos/b.png"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Cropped: 1}) {
+		t.Fatalf("expected to resume after b, got %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(string(d), "photos", "a.png.crops.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected a to be skipped, got %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(string(d), "photos", "crops.checkpoint")); err != nil || string(data) != "photos/c.png" {
+		t.Fatalf("expected the checkpoint at c, got %q (%v)", data, err)
+	}
+
+	// only images added since are cropped
+	putImage(t, d, "photos/d.png", 200, 150)
+	stats, err = job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Cropped: 1}) {
+		t.Fatalf("expected only d to be cropped, got %+v", stats)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	d, cleanup := tempDir(t)
+	defer cleanup()
+	for _, key := range []string{"photos/a.png", "photos/b.png", "photos/c.png"} {
+		putImage(t, d, key, 200, 150)
+	}
+
+	// the job is canceled once the first image failed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := newJob(d)
+	job.Checkpoint = "photos/crops.checkpoint"
+	job.CheckpointEvery = 1
+	job.OnError = func(key string, err error) error {
+		cancel()
+		return nil
+	}
+	job.Filter = func(key string) bool { return true }
+	if err := d.Put(ctx, "photos/0.txt", []byte("not an image"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := job.Run(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if stats.Failed != 1 || stats.Cropped > 1 {
+		t.Fatalf("expected the run to stop after the failed image, got %+v", stats)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(string(d), "photos", "crops.checkpoint"))
+	if err != nil || string(data) < "photos/0.txt" {
+		t.Fatalf("expected the checkpoint to be kept, got %q (%v)", data, err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	d, cleanup := tempDir(t)
+	defer cleanup()
+	putImage(t, d, "photos/large.png", 400, 300)
+	putImage(t, d, "photos/small.png", 200, 150)
+
+	job := newJob(d)
+	job.MaxPixels = 100000
+	var failed []string
+	job.OnError = func(key string, err error) error {
+		if err != smartcrop.ErrImageTooLarge {
+			t.Errorf("%s: expected ErrImageTooLarge, got %v", key, err)
+		}
+		failed = append(failed, key)
+		return nil
+	}
+	stats, err := job.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (Stats{Cropped: 1, Failed: 1}) || len(failed) != 1 || failed[0] != "photos/large.png" {
+		t.Fatalf("expected the large image to fail, got %+v and %v", stats, failed)
+	}
+
+	// an error returned by OnError stops the job
+	stop := errors.New("stop")
+	job.OnError = func(key string, err error) error { return stop }
+	if _, err := job.Run(context.Background()); err != stop {
+		t.Fatalf("expected the error of OnError, got %v", err)
+	}
+
+	job.Sizes = nil
+	if _, err := job.Run(context.Background()); err != smartcrop.ErrInvalidDimensions {
+		t.Fatalf("expected ErrInvalidDimensions, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package smartcrop
+
+import (
+	"image"
+)
+
+// boxResize shrinks img to width x height by averaging the source pixels each
+// destination pixel covers, in integer arithmetic. Gray images stay gray, all
+// others become RGBA. Enlarging repeats source pixels.
+func boxResize(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	xs := boxSpans(b.Dx(), width)
+	ys := boxSpans(b.Dy(), height)
+
+	if gray, ok := img.(*image.Gray); ok {
+		out := image.NewGray(image.Rect(0, 0, width, height))
+		for oy := 0; oy < height; oy++ {
+			for ox := 0; ox < width; ox++ {
+				var sum, n int
+				for y := ys[oy][0]; y < ys[oy][1]; y++ {
+					row := gray.Pix[gray.PixOffset(b.Min.X, b.Min.Y+y):]
+					for x := xs[ox][0]; x < xs[ox][1]; x++ {
+						sum += int(row[x])
+					}
+					n += xs[ox][1] - xs[ox][0]
+				}
+				out.Pix[oy*out.Stride+ox] = uint8((sum + n/2) / n)
+			}
+		}
+		return out
+	}
+
+	src := toRGBA(img)
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for oy := 0; oy < height; oy++ {
+		for ox := 0; ox < width; ox++ {
+			var sum [4]int
+			var n int
+			for y := ys[oy][0]; y < ys[oy][1]; y++ {
+				row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+				for x := xs[ox][0]; x < xs[ox][1]; x++ {
+					p := row[4*x : 4*x+4 : 4*x+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+				n += xs[ox][1] - xs[ox][0]
+			}
+			i := oy*out.Stride + 4*ox
+			for c := range sum {
+				out.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return out
+}
+
+// boxSpans returns the first and last source pixel plus one covered by each of
+// m destination pixels over n source pixels. Every span covers at least one
+// pixel.
+func boxSpans(n, m int) [][2]int {
+	spans := make([][2]int, m)
+	for i := range spans {
+		lo, hi := i*n/m, (i+1)*n/m
+		if lo > n-1 {
+			lo = n - 1
+		}
+		if hi <= lo {
+			hi = lo + 1
+		}
+		spans[i] = [2]int{lo, hi}
+	}
+	return spans
+}
@@ -0,0 +1,79 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// FindConsistentCrop finds the best crop of img like FindBestCrop, among the
+// candidates whose intersection over union with reference is at least minIoU,
+// so crops of a set of similar images, like product shots taken in a burst,
+// match. reference is in coordinates of img, usually the crop found for the
+// first image of the set; use MapCrop if the images differ in size.
+//
+// If no candidate is close enough, reference itself is returned, with its score
+// on img.
+func (sca *smartcropAnalyzer) FindConsistentCrop(img image.Image, width, height int, reference image.Rectangle, minIoU float64) (Crop, error) {
+	if width == 0 && height == 0 {
+		return Crop{}, ErrInvalidDimensions
+	}
+
+	img, offset := atOrigin(img)
+	reference = reference.Sub(offset)
+	analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, err := sca.preprocessForAnalysis(img, width, height)
+	if err != nil {
+		return Crop{}, err
+	}
+	tuned, _ := sca.tunedFor(analysisImg).limited(analysisImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+	tuned, err = tuned.withBarcodes(img, prescalefactor)
+	if err != nil {
+		return Crop{}, err
+	}
+	allCrops, faceRects, o, err := tuned.analyse(analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, nil)
+	if err != nil {
+		return Crop{}, err
+	}
+
+	var close []Crop
+	for _, crop := range allCrops {
+		if iou(unscale(crop.Rectangle, prescalefactor), reference) >= minIoU {
+			close = append(close, crop)
+		}
+	}
+	if len(close) > 0 {
+		topCrop := tuned.findTopCrop(close, faceRects)
+		topCrop.Rectangle = unscale(topCrop.Rectangle, prescalefactor).Canon().Add(offset)
+		topCrop.AlgorithmVersion = AlgorithmVersion
+		return topCrop, nil
+	}
+
+	sca.logger.Log.Printf("no candidate within IoU %f of the reference, keeping it\n", minIoU)
+	r := prescaled(reference, prescalefactor).Intersect(o.Bounds())
+	crop := Crop{Rectangle: r, AlgorithmVersion: AlgorithmVersion}
+	crop.Score = tuned.score(o, crop, faceRects, newImportanceKernels(nil))
+	crop.Rectangle = reference.Add(offset)
+	return crop, nil
+}
+
+// prescaled maps r from original to analysis coordinates, the inverse of unscale.
+func prescaled(r image.Rectangle, prescalefactor float64) image.Rectangle {
+	if prescalefactor == 1.0 {
+		return r
+	}
+	return image.Rect(
+		int(math.Round(float64(r.Min.X)*prescalefactor)),
+		int(math.Round(float64(r.Min.Y)*prescalefactor)),
+		int(math.Round(float64(r.Max.X)*prescalefactor)),
+		int(math.Round(float64(r.Max.Y)*prescalefactor)),
+	)
+}
+
+// iou returns the intersection over union of a and b, 0 if either is empty.
+func iou(a, b image.Rectangle) float64 {
+	inter := a.Intersect(b)
+	if inter.Empty() {
+		return 0
+	}
+	i := float64(inter.Dx() * inter.Dy())
+	return i / (float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - i)
+}
@@ -0,0 +1,157 @@
+package smartcrop
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheKey identifies a crop decision: the image content, the requested size and
+// the config and settings of the analyzer it was made with.
+type CacheKey struct {
+	// Image is a hash of the dimensions and pixels of the image.
+	Image uint64
+	// Width and Height are the requested crop size.
+	Width, Height int
+	// Config is a fingerprint of the config of the analyzer.
+	Config uint64
+	// Analyzer is a fingerprint of the other settings of the analyzer, its
+	// resizer and templates. An analyzer whose decisions depend on code, a
+	// custom FaceDetector, SensitiveDetector, BarcodeDetector or
+	// ColorConverter, a ScoreFunc or a CandidateGenerator of a pointer or func
+	// type, has a fingerprint of its own, so it never gets the decisions of
+	// another analyzer sharing the cache.
+	Analyzer uint64
+}
+
+// Cache stores crop decisions for FindBestCrop, see WithCache. Implementations
+// must be safe for concurrent use.
+type Cache interface {
+	Get(key CacheKey) (image.Rectangle, bool)
+	Set(key CacheKey, crop image.Rectangle)
+}
+
+// LRUCache is an in-memory Cache holding a fixed number of crops, evicting the
+// least recently used one first.
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[CacheKey]*list.Element
+}
+
+type lruEntry struct {
+	key  CacheKey
+	crop image.Rectangle
+}
+
+// NewLRUCache returns an LRUCache holding up to size crops.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{size: size, order: list.New(), items: make(map[CacheKey]*list.Element)}
+}
+
+// Get implements Cache.
+func (c *LRUCache) Get(key CacheKey) (image.Rectangle, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return image.Rectangle{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).crop, true
+}
+
+// Set implements Cache.
+func (c *LRUCache) Set(key CacheKey, crop image.Rectangle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry).crop = crop
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.size <= 0 {
+		return
+	}
+	for c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, crop: crop})
+}
+
+// Len returns the number of crops in c.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// cacheKey returns the key of the crop decision for img at width x height.
+func (sca *smartcropAnalyzer) cacheKey(img image.Image, width, height int) CacheKey {
+	return CacheKey{Image: contentHash(img), Width: width, Height: height, Config: sca.configHash, Analyzer: sca.analyzerHash}
+}
+
+// analyzerIDs counts the analyzers with a fingerprint of their own.
+var analyzerIDs uint64
+
+// analyzerHash returns the CacheKey.Analyzer fingerprint of an analyzer with
+// the settings s.
+func analyzerHash(s settings) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "resizer=%T;", s.resizer)
+	for _, t := range s.templates {
+		fmt.Fprintf(h, "template=%x;", contentHash(t))
+	}
+	generator := reflect.ValueOf(s.config.CandidateGenerator)
+	opaque := generator.IsValid() && (generator.Kind() == reflect.Ptr || generator.Kind() == reflect.Func)
+	if s.faces != nil || s.sensitive != nil || s.barcodes != nil || s.colors != nil || s.config.ScoreFunc != nil || opaque {
+		fmt.Fprintf(h, "analyzer=%d;", atomic.AddUint64(&analyzerIDs, 1))
+	}
+	return h.Sum64()
+}
+
+// contentHash returns the FNV-1a hash of the bounds and pixels of img. Images
+// stored in other than the common types are hashed as RGBA.
+func contentHash(img image.Image) uint64 {
+	h := fnv.New64a()
+	b := img.Bounds()
+	var dims [32]byte
+	for i, v := range []int{b.Min.X, b.Min.Y, b.Max.X, b.Max.Y} {
+		binary.BigEndian.PutUint64(dims[8*i:], uint64(v))
+	}
+	h.Write(dims[:])
+
+	if b.Empty() {
+		return h.Sum64()
+	}
+	// hash the rows, since the strides may include pixels outside the bounds
+	switch img := img.(type) {
+	case *image.Gray:
+		h.Write([]byte("gray"))
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			h.Write(img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)])
+		}
+	case *image.YCbCr:
+		fmt.Fprintf(h, "ycbcr%d", img.SubsampleRatio)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			h.Write(img.Y[img.YOffset(b.Min.X, y) : img.YOffset(b.Max.X-1, y)+1])
+			h.Write(img.Cb[img.COffset(b.Min.X, y) : img.COffset(b.Max.X-1, y)+1])
+			h.Write(img.Cr[img.COffset(b.Min.X, y) : img.COffset(b.Max.X-1, y)+1])
+		}
+	default:
+		rgba := toRGBA(img)
+		h.Write([]byte("rgba"))
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			h.Write(rgba.Pix[rgba.PixOffset(b.Min.X, y):rgba.PixOffset(b.Max.X, y)])
+		}
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,238 @@
+package smartcrop
+
+import (
+	"image"
+	"sort"
+)
+
+// CandidateGenerator proposes the candidate crops an analysis scores, see
+// Config.CandidateGenerator.
+type CandidateGenerator interface {
+	// Candidates calls fn for each candidate crop until fn returns false.
+	// Candidates not within s.Area are skipped.
+	Candidates(s CandidateSpace, fn func(r image.Rectangle) bool)
+}
+
+// CandidateSpace describes the candidates a CandidateGenerator may propose, in
+// analysis coordinates.
+type CandidateSpace struct {
+	// Area is the part of the image candidates have to lie within.
+	Area image.Rectangle
+	// Width and Height are the crop size at scale 1, Scales the scales to
+	// generate candidates for, largest first.
+	Width, Height float64
+	Scales        []float64
+	// Step is the configured distance between grid positions.
+	Step int
+	// Faces are the faces found, if face detection is enabled.
+	Faces []image.Rectangle
+	// Channels is the detector output.
+	Channels *ScoreMap
+}
+
+// size returns the crop size at scale.
+func (s CandidateSpace) size(scale float64) (int, int) {
+	return int(s.Width * scale), int(s.Height * scale)
+}
+
+// GridCandidates places candidates of every scale on a grid of Step pixels
+// across the area. It is the default generator.
+type GridCandidates struct{}
+
+// Candidates implements CandidateGenerator.
+func (GridCandidates) Candidates(s CandidateSpace, fn func(r image.Rectangle) bool) {
+	for _, scale := range s.Scales {
+		for y := s.Area.Min.Y; float64(y)+s.Height*scale <= float64(s.Area.Max.Y); y += s.Step {
+			for x := s.Area.Min.X; float64(x)+s.Width*scale <= float64(s.Area.Max.X); x += s.Step {
+				w, h := s.size(scale)
+				if !fn(image.Rect(x, y, x+w, y+h)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// SymmetricGridCandidates places candidates on the grid of GridCandidates and
+// on its mirror image, which is aligned with the right and bottom edges of the
+// area, so the candidates of a mirrored or rotated image are the mirrored or
+// rotated candidates. Config.Symmetric uses it by default.
+type SymmetricGridCandidates struct{}
+
+// Candidates implements CandidateGenerator.
+func (SymmetricGridCandidates) Candidates(s CandidateSpace, fn func(r image.Rectangle) bool) {
+	for _, scale := range s.Scales {
+		w, h := s.size(scale)
+		for _, y := range symmetricPositions(s.Area.Min.Y, s.Area.Max.Y, h, s.Step) {
+			for _, x := range symmetricPositions(s.Area.Min.X, s.Area.Max.X, w, s.Step) {
+				if !fn(image.Rect(x, y, x+w, y+h)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// symmetricPositions returns the positions every step from min at which a span
+// of n fits before max, along with their mirror images, in increasing order.
+func symmetricPositions(min, max, n, step int) []int {
+	seen := make(map[int]bool)
+	var positions []int
+	for p := min; p+n <= max; p += step {
+		for _, q := range []int{p, min + max - n - p} {
+			if !seen[q] {
+				seen[q] = true
+				positions = append(positions, q)
+			}
+		}
+	}
+	sort.Ints(positions)
+	return positions
+}
+
+// thirdsAnchors are the positions within a crop ThirdsCandidates places points
+// of interest at: the thirds intersections and the center.
+var thirdsAnchors = [][2]float64{{1.0 / 3, 1.0 / 3}, {2.0 / 3, 1.0 / 3}, {1.0 / 3, 2.0 / 3}, {2.0 / 3, 2.0 / 3}, {0.5, 0.5}}
+
+// ThirdsCandidates finds the Points strongest points of interest in the detector
+// output, 4 if Points is 0, and places each of them on the thirds intersections
+// and the center of candidates of every scale.
+type ThirdsCandidates struct {
+	Points int
+}
+
+// Candidates implements CandidateGenerator.
+func (g ThirdsCandidates) Candidates(s CandidateSpace, fn func(r image.Rectangle) bool) {
+	n := g.Points
+	if n <= 0 {
+		n = 4
+	}
+	emit := uniqueCandidates(s.Area, fn)
+	for _, p := range pointsOfInterest(s.Channels, s.Step, n) {
+		for _, scale := range s.Scales {
+			w, h := s.size(scale)
+			for _, a := range thirdsAnchors {
+				min := image.Pt(p.X-int(float64(w)*a[0]), p.Y-int(float64(h)*a[1]))
+				if !emit(image.Rectangle{Min: min, Max: min.Add(image.Pt(w, h))}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// FaceCandidates centers candidates of every scale on each face and on all faces
+// together, and also places each face on the upper third line. Without faces it
+// falls back to GridCandidates.
+type FaceCandidates struct{}
+
+// Candidates implements CandidateGenerator.
+func (FaceCandidates) Candidates(s CandidateSpace, fn func(r image.Rectangle) bool) {
+	if len(s.Faces) == 0 {
+		GridCandidates{}.Candidates(s, fn)
+		return
+	}
+
+	all := s.Faces[0]
+	for _, f := range s.Faces[1:] {
+		all = all.Union(f)
+	}
+	emit := uniqueCandidates(s.Area, fn)
+	for _, f := range append([]image.Rectangle{all}, s.Faces...) {
+		c := f.Min.Add(f.Max).Div(2)
+		for _, scale := range s.Scales {
+			w, h := s.size(scale)
+			for _, a := range [][2]float64{{0.5, 0.5}, {0.5, 1.0 / 3}} {
+				min := image.Pt(c.X-int(float64(w)*a[0]), c.Y-int(float64(h)*a[1]))
+				if !emit(image.Rectangle{Min: min, Max: min.Add(image.Pt(w, h))}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// uniqueCandidates returns a function that moves candidates into area, where
+// they fit, and passes each distinct one to fn.
+func uniqueCandidates(area image.Rectangle, fn func(r image.Rectangle) bool) func(r image.Rectangle) bool {
+	seen := make(map[image.Rectangle]bool)
+	return func(r image.Rectangle) bool {
+		if r.Dx() > area.Dx() || r.Dy() > area.Dy() {
+			return true
+		}
+		d := image.Point{}
+		if r.Min.X < area.Min.X {
+			d.X = area.Min.X - r.Min.X
+		} else if r.Max.X > area.Max.X {
+			d.X = area.Max.X - r.Max.X
+		}
+		if r.Min.Y < area.Min.Y {
+			d.Y = area.Min.Y - r.Min.Y
+		} else if r.Max.Y > area.Max.Y {
+			d.Y = area.Max.Y - r.Max.Y
+		}
+		r = r.Add(d)
+		if seen[r] {
+			return true
+		}
+		seen[r] = true
+		return fn(r)
+	}
+}
+
+// pointsOfInterest returns the centers of the n cells of the detector output o
+// with the most energy, skipping cells next to one already picked. Cells are
+// twice step wide.
+func pointsOfInterest(o *ScoreMap, step, n int) []image.Point {
+	cell := 2 * step
+	if cell < 2 {
+		cell = 2
+	}
+	b := o.Bounds()
+	cols, rows := (b.Dx()+cell-1)/cell, (b.Dy()+cell-1)/cell
+	energy := make([]float64, cols*rows)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := o.PixOffset(x, y)
+			for _, plane := range o.planes {
+				energy[(y-b.Min.Y)/cell*cols+(x-b.Min.X)/cell] += float64(plane[i])
+			}
+		}
+	}
+
+	cells := make([]int, len(energy))
+	for i := range cells {
+		cells[i] = i
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		return energy[cells[i]] > energy[cells[j]]
+	})
+
+	var picked []int
+	var points []image.Point
+	for _, c := range cells {
+		if len(points) >= n || energy[c] == 0 {
+			break
+		}
+		near := false
+		for _, p := range picked {
+			if abs(p%cols-c%cols) <= 1 && abs(p/cols-c/cols) <= 1 {
+				near = true
+				break
+			}
+		}
+		if near {
+			continue
+		}
+		picked = append(picked, c)
+		points = append(points, image.Pt(b.Min.X+c%cols*cell+cell/2, b.Min.Y+c/cols*cell+cell/2))
+	}
+	return points
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,237 @@
+// Command smartcrop-report runs every image in a folder through smartcrop and
+// writes an HTML contact sheet showing the original with the top crops and
+// faces outlined, the score heatmap and the top crops themselves. It is meant for
+// reviewing changes to the algorithm before a release.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+// colors of the top crop outlines, best first, and of the face outlines
+var (
+	cropColors = []color.RGBA{{0, 220, 0, 255}, {255, 210, 0, 255}, {255, 120, 0, 255}}
+	faceColor  = color.RGBA{255, 0, 0, 255}
+)
+
+const topN = 3
+
+type entry struct {
+	Name      string
+	Annotated string
+	Heatmap   string
+	Crops     []cropEntry
+	Faces     int
+	Fallback  bool
+	Err       string
+}
+
+type cropEntry struct {
+	File  string
+	Rect  image.Rectangle
+	Score smartcrop.Score
+}
+
+var page = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>smartcrop report</title>
+<style>
+body { font-family: sans-serif; }
+td { vertical-align: top; padding: 4px; }
+img { max-width: 320px; max-height: 320px; }
+.err { color: #c00; }
+</style>
+</head>
+<body>
+<h1>smartcrop report: {{.Width}}x{{.Height}}</h1>
+<p>Crops are outlined green, yellow and orange from best to third, faces red.</p>
+<table>
+{{range .Entries}}<tr>
+<td><b>{{.Name}}</b>{{if .Fallback}}<br>centered fallback{{end}}<br>{{.Faces}} faces</td>
+{{if .Err}}<td class="err" colspan="2">{{.Err}}</td>{{else}}<td><img src="{{.Annotated}}"></td>
+<td><img src="{{.Heatmap}}"></td>
+{{range .Crops}}<td><img src="{{.File}}"><br>{{.Rect}}<br>score {{printf "%g" .Score.Total}}</td>
+{{end}}{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func main() {
+	input := flag.String("input", "", "input folder")
+	output := flag.String("output", "report", "output folder")
+	w := flag.Int("width", 250, "crop width")
+	h := flag.Int("height", 250, "crop height")
+	classifier := flag.String("classifier", "", "face detection classifier file, enables face detection")
+	flag.Parse()
+
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "No input folder given")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*output, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "can't create output folder: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg := smartcrop.DefaultConfig
+	if *classifier != "" {
+		cfg = smartcrop.FaceDetectConfig
+		cfg.FaceDetectClassifierFile = *classifier
+	}
+	analyzer := smartcrop.NewAnalyzer(cfg, xdraw.NewDefaultResizer())
+
+	files, err := ioutil.ReadDir(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't read input folder: %v\n", err)
+		os.Exit(1)
+	}
+
+	var entries []entry
+	for _, fi := range files {
+		ext := strings.ToLower(filepath.Ext(fi.Name()))
+		if fi.IsDir() || (ext != ".jpg" && ext != ".jpeg" && ext != ".png") {
+			continue
+		}
+		e := process(analyzer, filepath.Join(*input, fi.Name()), *output, *w, *h)
+		if e.Err != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", e.Name, e.Err)
+		}
+		entries = append(entries, e)
+	}
+
+	f, err := os.Create(filepath.Join(*output, "index.html"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't create report: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	err = page.Execute(f, struct {
+		Width, Height int
+		Entries       []entry
+	}{*w, *h, entries})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't write report: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func process(analyzer smartcrop.Analyzer, path, outDir string, width, height int) entry {
+	name := filepath.Base(path)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	e := entry{Name: name}
+
+	img, err := decode(path)
+	if err != nil {
+		e.Err = err.Error()
+		return e
+	}
+
+	res, err := analyzer.Analyze(img, width, height)
+	if err != nil {
+		e.Err = err.Error()
+		return e
+	}
+	crops, err := analyzer.FindAllCrops(img, width, height)
+	if err != nil {
+		e.Err = err.Error()
+		return e
+	}
+	sort.Slice(crops, func(i, j int) bool {
+		return crops[i].Score.Total > crops[j].Score.Total
+	})
+	if len(crops) > topN {
+		crops = crops[:topN]
+	}
+	e.Faces = len(res.Faces)
+	e.Fallback = res.Fallback
+
+	annotated := image.NewRGBA(img.Bounds())
+	draw.Draw(annotated, annotated.Bounds(), img, img.Bounds().Min, draw.Src)
+	// thick enough to survive the downscaling in the browser
+	thickness := 1 + img.Bounds().Dx()/300
+	for i := len(crops) - 1; i >= 0; i-- {
+		outline(annotated, crops[i].Rectangle, cropColors[i], thickness)
+	}
+	for _, r := range res.Faces {
+		outline(annotated, r, faceColor, thickness)
+	}
+
+	e.Annotated = base + "_annotated.jpg"
+	if err := writeJpeg(annotated, filepath.Join(outDir, e.Annotated)); err != nil {
+		e.Err = err.Error()
+		return e
+	}
+	e.Heatmap = base + "_heatmap.png"
+	if err := writePng(res.Heatmap, filepath.Join(outDir, e.Heatmap)); err != nil {
+		e.Err = err.Error()
+		return e
+	}
+	for i, c := range crops {
+		file := fmt.Sprintf("%s_crop%d.jpg", base, i+1)
+		if err := writeJpeg(smartcrop.CropImage(img, c.Rectangle), filepath.Join(outDir, file)); err != nil {
+			e.Err = err.Error()
+			return e
+		}
+		e.Crops = append(e.Crops, cropEntry{File: file, Rect: c.Rectangle, Score: c.Score})
+	}
+	return e
+}
+
+// outline draws the border of r, t pixels wide, on the inside of r.
+func outline(img *image.RGBA, r image.Rectangle, c color.RGBA, t int) {
+	u := &image.Uniform{c}
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+t), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Max.Y-t, r.Max.X, r.Max.Y), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+t, r.Max.Y), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Max.X-t, r.Min.Y, r.Max.X, r.Max.Y), u, image.Point{}, draw.Src)
+}
+
+func decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+func writeJpeg(img image.Image, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return jpeg.Encode(f, img, &jpeg.Options{Quality: 85})
+}
+
+func writePng(img image.Image, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, img)
+}
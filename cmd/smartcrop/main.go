@@ -29,6 +29,7 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"image"
@@ -38,7 +39,8 @@ import (
 	"os"
 
 	"github.com/third-light/smartcrop"
-	"github.com/third-light/smartcrop/nfnt"
+	"github.com/third-light/smartcrop/xdraw"
+	_ "golang.org/x/image/webp"
 )
 
 func main() {
@@ -48,6 +50,7 @@ func main() {
 	h := flag.Int("height", 0, "crop height")
 	resize := flag.Bool("resize", true, "resize after cropping")
 	quality := flag.Int("quality", 85, "jpeg quality")
+	density := flag.Float64("density", cssDPI, "dots per inch SVG inputs are rasterized at")
 	flag.Parse()
 
 	if *input == "" {
@@ -62,12 +65,27 @@ func main() {
 	}
 	defer f.Close()
 
-	img, format, err := image.Decode(f)
+	var img image.Image
+	var format string
+	in := bufio.NewReader(f)
+	if isSVG(*input, in) {
+		format = "svg"
+		img, err = rasterizeSVG(in, *density)
+	} else {
+		img, format, err = image.Decode(in)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "can't decode input file: %v\n", err)
 		os.Exit(1)
 	}
 
+	// cropping first leaves no empty output file behind when it fails
+	img, err = crop(img, *w, *h, *resize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't crop image: %v\n", err)
+		os.Exit(1)
+	}
+
 	out := *output
 	var fOut io.WriteCloser
 	if out == "-" {
@@ -81,29 +99,32 @@ func main() {
 		defer fOut.Close()
 	}
 
-	img = crop(img, *w, *h, *resize)
 	switch format {
-	case "png":
-		png.Encode(fOut, img)
 	case "jpeg":
-		jpeg.Encode(fOut, img, &jpeg.Options{Quality: *quality})
+		err = jpeg.Encode(fOut, img, &jpeg.Options{Quality: *quality})
+	default:
+		// there are no encoders for the other formats, e.g. WebP, AVIF and
+		// rasterized SVG
+		err = png.Encode(fOut, img)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't encode output file: %v\n", err)
+		os.Exit(1)
 	}
 }
 
-func crop(img image.Image, w, h int, resize bool) image.Image {
+func crop(img image.Image, w, h int, resize bool) (image.Image, error) {
 	width, height := getCropDimensions(img, w, h)
-	resizer := nfnt.NewDefaultResizer()
-	analyzer := smartcrop.NewAnalyzer(resizer)
-	topCrop, _ := analyzer.FindBestCrop(img, width, height)
-
-	type SubImager interface {
-		SubImage(r image.Rectangle) image.Image
+	analyzer := smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer())
+	if resize {
+		out, _, err := analyzer.CropAndResize(img, width, height)
+		return out, err
 	}
-	img = img.(SubImager).SubImage(topCrop)
-	if resize && (img.Bounds().Dx() != width || img.Bounds().Dy() != height) {
-		img = resizer.Resize(img, uint(width), uint(height))
+	topCrop, err := analyzer.FindBestCrop(img, width, height)
+	if err != nil {
+		return nil, err
 	}
-	return img
+	return smartcrop.CropImage(img, topCrop), nil
 }
 
 func getCropDimensions(img image.Image, width, height int) (int, int) {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// cssDPI is the density an SVG user unit corresponds to, one CSS pixel.
+const cssDPI = 96
+
+// isSVG reports whether the input named name and buffered in r is an SVG
+// document, by its extension or an <svg element near the start of the file.
+func isSVG(name string, r *bufio.Reader) bool {
+	if strings.EqualFold(filepath.Ext(name), ".svg") {
+		return true
+	}
+	head, _ := r.Peek(1024)
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+// rasterizeSVG renders the SVG document read from r at density dots per inch,
+// so the document size at 96 DPI is its size in pixels.
+func rasterizeSVG(r io.Reader, density float64) (image.Image, error) {
+	if density <= 0 {
+		return nil, errors.New("density must be positive")
+	}
+	icon, err := oksvg.ReadIconStream(r, oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	scale := density / cssDPI
+	w := int(math.Ceil(icon.ViewBox.W * scale))
+	h := int(math.Ceil(icon.ViewBox.H * scale))
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("SVG document has no size")
+	}
+
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	return img, nil
+}
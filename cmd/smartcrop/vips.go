@@ -0,0 +1,16 @@
+//go:build vips
+// +build vips
+
+package main
+
+import (
+	"github.com/davidbyttow/govips/v2/vips"
+	smartcropvips "github.com/third-light/smartcrop/vips"
+)
+
+// Built with the vips tag, the CLI also reads the formats libvips decodes.
+func init() {
+	vips.Startup(nil)
+	smartcropvips.RegisterAVIF()
+	smartcropvips.RegisterHEIF()
+}
@@ -0,0 +1,72 @@
+package smartcrop
+
+import (
+	"errors"
+	"image"
+	"sort"
+
+	"github.com/third-light/smartcrop/options"
+	"golang.org/x/image/draw"
+)
+
+// ErrCollageTooLarge is returned by Collage when the image doesn't hold enough
+// non-overlapping crops for all tiles.
+var ErrCollageTooLarge = errors.New("Not enough distinct crops for the collage")
+
+// Grid describes a collage of Columns x Rows tiles of TileWidth x TileHeight
+// pixels each, e.g. 3x1 tiles at 1080x1080 for a social media carousel.
+type Grid struct {
+	Columns, Rows         int
+	TileWidth, TileHeight int
+}
+
+// Tiles returns the number of tiles in the grid.
+func (g Grid) Tiles() int {
+	return g.Columns * g.Rows
+}
+
+// Collage returns a distinct crop of img for every tile of grid, found with
+// FindTopCrops, so the crops don't overlap. They are picked greedily best first,
+// not as the set with the best total score. To keep the layout of the image when
+// filled into the tiles row by row, they are ordered into rows top to bottom,
+// Columns crops each, and left to right within a row.
+func Collage(a Analyzer, img image.Image, grid Grid) ([]Crop, error) {
+	if grid.Tiles() <= 0 || grid.TileWidth <= 0 || grid.TileHeight <= 0 {
+		return nil, ErrInvalidDimensions
+	}
+
+	crops, err := a.FindTopCrops(img, grid.TileWidth, grid.TileHeight, grid.Tiles())
+	if err != nil {
+		return nil, err
+	}
+	if len(crops) < grid.Tiles() {
+		return nil, ErrCollageTooLarge
+	}
+
+	center := func(c Crop) image.Point { return c.Min.Add(c.Max) }
+	sort.SliceStable(crops, func(i, j int) bool {
+		return center(crops[i]).Y < center(crops[j]).Y
+	})
+	for row := 0; row < grid.Rows; row++ {
+		tiles := crops[row*grid.Columns : (row+1)*grid.Columns]
+		sort.SliceStable(tiles, func(i, j int) bool {
+			return center(tiles[i]).X < center(tiles[j]).X
+		})
+	}
+	return crops, nil
+}
+
+// DrawCollage renders crops of img, as returned by Collage, into the tiles of
+// grid row by row, resizing each with resizer.
+func DrawCollage(img image.Image, crops []Crop, grid Grid, resizer options.Resizer) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, grid.Columns*grid.TileWidth, grid.Rows*grid.TileHeight))
+	for i, crop := range crops {
+		if i >= grid.Tiles() {
+			break
+		}
+		tile := resizer.Resize(CropImage(img, crop.Rectangle), uint(grid.TileWidth), uint(grid.TileHeight))
+		min := image.Pt(i%grid.Columns*grid.TileWidth, i/grid.Columns*grid.TileHeight)
+		draw.Copy(out, min, tile, tile.Bounds(), draw.Src, nil)
+	}
+	return out
+}
@@ -0,0 +1,33 @@
+package smartcrop
+
+import (
+	"image/color"
+
+	"github.com/third-light/smartcrop/detect"
+)
+
+// ColorSpace selects the color space the detail and saturation detectors work
+// in, see Config.ColorSpace.
+type ColorSpace int
+
+const (
+	// ColorSpaceRGB computes lightness from the gamma-encoded channels and
+	// saturation in the HSL model, as smartcrop.js does.
+	ColorSpaceRGB ColorSpace = iota
+	// ColorSpaceCIELAB computes lightness as CIE L* and saturation from the
+	// CIELAB chroma.
+	ColorSpaceCIELAB
+	// ColorSpaceOKLab computes lightness and saturation in OKLab.
+	ColorSpaceOKLab
+)
+
+// saturation returns the function the saturation detector rates pixels with.
+func (sca *smartcropAnalyzer) saturation() func(color.RGBA) float64 {
+	switch sca.config.ColorSpace {
+	case ColorSpaceCIELAB:
+		return detect.LabSaturation
+	case ColorSpaceOKLab:
+		return detect.OKLabSaturation
+	}
+	return detect.HSLSaturation
+}
@@ -0,0 +1,315 @@
+package smartcrop
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// CompatSmartcropJS2 is the Config.CompatibilityMode matching smartcrop.js 2.x.
+const CompatSmartcropJS2 = "smartcropjs-2.x"
+
+// ErrUnknownCompatibilityMode gets returned when Config.CompatibilityMode is set
+// to a mode this version doesn't know
+var ErrUnknownCompatibilityMode = errors.New("Unknown compatibility mode")
+
+// PresetSmartcropJS is the DefaultConfig of smartcrop.js 2.x, analyzed the way
+// smartcrop.js does, see Config.CompatibilityMode.
+var PresetSmartcropJS = smartcropJSConfig(DefaultConfig)
+
+// smartcropJSConfig returns c with the defaults of smartcrop.js 2.x and
+// CompatSmartcropJS2 set.
+func smartcropJSConfig(c Config) Config {
+	c.SkinColors = [][3]float64{{0.78, 0.57, 0.44}}
+	c.SaturationWeight = 0.1
+	c.MinScale = 1.0
+	c.MaxScale = 1.0
+	c.Prescale = true
+	c.CompatibilityMode = CompatSmartcropJS2
+	return c
+}
+
+// jsPrescaleSize is the size smartcrop.js prescales the shorter side to.
+const jsPrescaleSize = 256
+
+// analyzeCompat implements Analyze for Config.CompatibilityMode.
+func (sca *smartcropAnalyzer) analyzeCompat(img image.Image, width, height int) (CropResult, error) {
+	if sca.config.CompatibilityMode != CompatSmartcropJS2 {
+		return CropResult{}, ErrUnknownCompatibilityMode
+	}
+	if err := sca.checkInput(img.Bounds()); err != nil {
+		return CropResult{}, err
+	}
+	if width == 0 && height == 0 {
+		return CropResult{}, ErrInvalidDimensions
+	}
+
+	// The steps below follow smartcrop.crop and analyse of smartcrop.js in order
+	// and with the same floating point operations. Products are wrapped in
+	// explicit float64 conversions to prevent fused multiply-adds, like in
+	// deterministic.go, and detector output is rounded to 8 bits like the
+	// Uint8ClampedArray it is stored in.
+	b := img.Bounds()
+	cropWidth, cropHeight := 0, 0
+	minScale := sca.config.MinScale
+	prescale := 1.0
+	if width != 0 && height != 0 {
+		scale := math.Min(float64(b.Dx())/float64(width), float64(b.Dy())/float64(height))
+		cropWidth, cropHeight = int(float64(float64(width)*scale)), int(float64(float64(height)*scale))
+		minScale = math.Min(sca.config.MaxScale, math.Max(1/scale, minScale))
+		if sca.config.Prescale {
+			prescale = math.Min(math.Max(jsPrescaleSize/float64(b.Dx()), jsPrescaleSize/float64(b.Dy())), 1)
+			if prescale < 1 {
+				small, err := sca.analysisResize(img, uint(float64(float64(b.Dx())*prescale)), uint(float64(float64(b.Dy())*prescale)))
+				if err != nil {
+					return CropResult{}, err
+				}
+				img = small
+				cropWidth, cropHeight = int(float64(float64(cropWidth)*prescale)), int(float64(float64(cropHeight)*prescale))
+			} else {
+				prescale = 1
+			}
+		}
+	}
+
+	input := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(input, input.Rect, img, img.Bounds().Min, draw.Src)
+	output := sca.jsDetect(input)
+	scoreOutput := jsDownSample(output, sca.jsScoreDownSample())
+
+	topScore := math.Inf(-1)
+	var top *jsCrop
+	for _, crop := range sca.jsCrops(input.Rect.Dx(), input.Rect.Dy(), cropWidth, cropHeight, minScale) {
+		crop := crop
+		crop.score = sca.jsScore(scoreOutput, crop)
+		if crop.score.Total > topScore {
+			top, topScore = &crop, crop.score.Total
+		}
+	}
+
+	heatmap := jsHeatmap(output)
+	if top == nil {
+		if !sca.config.FullImageFallback {
+			return CropResult{}, ErrNoCropFound
+		}
+		sca.observeFallback(FallbackFullImage)
+		return CropResult{Crop: Crop{Rectangle: image.Rect(0, 0, b.Dx(), b.Dy())}, Fallback: true, Heatmap: heatmap}, nil
+	}
+
+	x, y := int(top.x/prescale), int(top.y/prescale)
+	w, h := int(top.width/prescale), int(top.height/prescale)
+	return CropResult{Crop: Crop{Rectangle: image.Rect(x, y, x+w, y+h), Score: top.score}, Heatmap: heatmap}, nil
+}
+
+// jsCrop is a candidate crop of smartcrop.js, whose size needn't be integral.
+type jsCrop struct {
+	x, y, width, height float64
+	score               Score
+}
+
+// jsCrops mirrors generateCrops.
+func (sca *smartcropAnalyzer) jsCrops(width, height, cropWidth, cropHeight int, minScale float64) []jsCrop {
+	minDimension := width
+	if height < minDimension {
+		minDimension = height
+	}
+	if cropWidth == 0 {
+		cropWidth = minDimension
+	}
+	if cropHeight == 0 {
+		cropHeight = minDimension
+	}
+
+	var crops []jsCrop
+	step := float64(sca.config.Step)
+	for scale := sca.config.MaxScale; scale >= minScale; scale -= sca.config.ScaleStep {
+		cw, ch := float64(float64(cropWidth)*scale), float64(float64(cropHeight)*scale)
+		for y := 0.0; y+ch <= float64(height); y += step {
+			for x := 0.0; x+cw <= float64(width); x += step {
+				crops = append(crops, jsCrop{x: x, y: y, width: cw, height: ch})
+			}
+		}
+		if sca.config.ScaleStep <= 0 {
+			break
+		}
+	}
+	return crops
+}
+
+// jsImage is an RGBA image of smartcrop.js, with the skin in the red, the
+// detail in the green and the saturation in the blue channel.
+type jsImage struct {
+	width, height int
+	pix           []uint8
+}
+
+// clampUint8 converts v like storing it in a Uint8ClampedArray does, rounding
+// half to even.
+func clampUint8(v float64) uint8 {
+	if !(v > 0) {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(math.RoundToEven(v))
+}
+
+func jsCIE(r, g, b float64) float64 {
+	return float64(0.5126*b) + float64(0.7152*g) + float64(0.0722*r)
+}
+
+func jsSkinColor(skin [3]float64, r, g, b float64) float64 {
+	mag := math.Sqrt(float64(r*r) + float64(g*g) + float64(b*b))
+	rd := r/mag - skin[0]
+	gd := g/mag - skin[1]
+	bd := b/mag - skin[2]
+	return 1 - math.Sqrt(float64(rd*rd)+float64(gd*gd)+float64(bd*bd))
+}
+
+func jsSaturation(r, g, b float64) float64 {
+	maximum := math.Max(math.Max(r/255, g/255), b/255)
+	minimum := math.Min(math.Min(r/255, g/255), b/255)
+	if maximum == minimum {
+		return 0
+	}
+	l := (maximum + minimum) / 2
+	d := maximum - minimum
+	if l > 0.5 {
+		return d / (2 - maximum - minimum)
+	}
+	return d / (maximum + minimum)
+}
+
+// jsDetect mirrors edgeDetect, skinDetect and saturationDetect. Boosts, which
+// would go in the alpha channel, aren't supported, so it stays 0.
+func (sca *smartcropAnalyzer) jsDetect(in *image.NRGBA) jsImage {
+	c := sca.config
+	w, h := in.Rect.Dx(), in.Rect.Dy()
+	out := jsImage{width: w, height: h, pix: make([]uint8, 4*w*h)}
+	skinColor := [3]float64{0.78, 0.57, 0.44}
+	if len(c.SkinColors) > 0 {
+		skinColor = c.SkinColors[0]
+	}
+	sample := func(p int) float64 {
+		return jsCIE(float64(in.Pix[p]), float64(in.Pix[p+1]), float64(in.Pix[p+2]))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := (y*w + x) * 4
+			var lightness float64
+			if x == 0 || x >= w-1 || y == 0 || y >= h-1 {
+				lightness = sample(p)
+			} else {
+				lightness = float64(sample(p)*4) - sample(p-w*4) - sample(p-4) - sample(p+4) - sample(p+w*4)
+			}
+			out.pix[p+1] = clampUint8(lightness)
+
+			r, g, b := float64(in.Pix[p]), float64(in.Pix[p+1]), float64(in.Pix[p+2])
+			lightness = sample(p) / 255
+			skin := jsSkinColor(skinColor, r, g, b)
+			if skin > c.SkinThreshold && lightness >= c.SkinBrightnessMin && lightness <= c.SkinBrightnessMax {
+				out.pix[p] = clampUint8(float64((skin - c.SkinThreshold) * (255 / (1 - c.SkinThreshold))))
+			}
+			sat := jsSaturation(r, g, b)
+			if sat > c.SaturationThreshold && lightness >= c.SaturationBrightnessMin && lightness <= c.SaturationBrightnessMax {
+				out.pix[p+2] = clampUint8(float64((sat - c.SaturationThreshold) * (255 / (1 - c.SaturationThreshold))))
+			}
+		}
+	}
+	return out
+}
+
+// jsDownSample mirrors downSample, which keeps some of the maximum skin and
+// detail of every block.
+func jsDownSample(in jsImage, factor int) jsImage {
+	w, h := in.width/factor, in.height/factor
+	out := jsImage{width: w, height: h, pix: make([]uint8, 4*w*h)}
+	ifactor2 := 1 / float64(factor*factor)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b, a, mr, mg float64
+			for v := 0; v < factor; v++ {
+				for u := 0; u < factor; u++ {
+					j := ((y*factor+v)*in.width + (x*factor + u)) * 4
+					r += float64(in.pix[j])
+					g += float64(in.pix[j+1])
+					b += float64(in.pix[j+2])
+					a += float64(in.pix[j+3])
+					mr = math.Max(mr, float64(in.pix[j]))
+					mg = math.Max(mg, float64(in.pix[j+1]))
+				}
+			}
+			i := (y*w + x) * 4
+			out.pix[i] = clampUint8(float64(float64(r*ifactor2)*0.5) + float64(mr*0.5))
+			out.pix[i+1] = clampUint8(float64(float64(g*ifactor2)*0.7) + float64(mg*0.3))
+			out.pix[i+2] = clampUint8(float64(b * ifactor2))
+			out.pix[i+3] = clampUint8(float64(a * ifactor2))
+		}
+	}
+	return out
+}
+
+// jsScoreDownSample returns Config.ScoreDownSample, at least 1. smartcrop.js
+// divides by it and has no meaningful result for 0.
+func (sca *smartcropAnalyzer) jsScoreDownSample() int {
+	return maxInt(sca.config.ScoreDownSample, 1)
+}
+
+// jsScore mirrors score.
+func (sca *smartcropAnalyzer) jsScore(output jsImage, crop jsCrop) Score {
+	c := sca.config
+	ds := sca.jsScoreDownSample()
+	var score Score
+	for y := 0; y < output.height*ds; y += ds {
+		for x := 0; x < output.width*ds; x += ds {
+			p := ((y/ds)*output.width + x/ds) * 4
+			i := sca.jsImportance(crop, float64(x), float64(y))
+			detail := float64(output.pix[p+1]) / 255
+			score.Skin += float64(float64(float64(output.pix[p])/255*(detail+c.SkinBias)) * i)
+			score.Detail += float64(detail * i)
+			score.Saturation += float64(float64(float64(output.pix[p+2])/255*(detail+c.SaturationBias)) * i)
+		}
+	}
+	score.Total = (float64(score.Detail*c.DetailWeight) + float64(score.Skin*c.SkinWeight) + float64(score.Saturation*c.SaturationWeight)) / float64(crop.width*crop.height)
+	return score
+}
+
+// jsImportance mirrors importance.
+func (sca *smartcropAnalyzer) jsImportance(crop jsCrop, x, y float64) float64 {
+	c := sca.config
+	if crop.x > x || x >= crop.x+crop.width || crop.y > y || y >= crop.y+crop.height {
+		return c.OutsideImportance
+	}
+	x = (x - crop.x) / crop.width
+	y = (y - crop.y) / crop.height
+	px := float64(math.Abs(0.5-x) * 2)
+	py := float64(math.Abs(0.5-y) * 2)
+	dx := math.Max(px-1.0+c.EdgeRadius, 0)
+	dy := math.Max(py-1.0+c.EdgeRadius, 0)
+	d := float64((float64(dx*dx) + float64(dy*dy)) * c.EdgeWeight)
+	s := 1.41 - math.Sqrt(float64(px*px)+float64(py*py))
+	if c.RuleOfThirds {
+		s += float64(float64(math.Max(0, s+d+0.5)*1.2) * (jsThirds(px) + jsThirds(py)))
+	}
+	return s + d
+}
+
+func jsThirds(x float64) float64 {
+	x = float64((float64(math.Mod(x-1.0/3+1.0, 2.0)*0.5) - 0.5) * 16)
+	return math.Max(1.0-float64(x*x), 0.0)
+}
+
+// jsHeatmap returns the detector output of smartcrop.js as a ScoreMap.
+func jsHeatmap(in jsImage) *ScoreMap {
+	m := newDetectorMap(image.Rect(0, 0, in.width, in.height))
+	skin, detail, sat := m.Plane(ChannelSkin), m.Plane(ChannelDetail), m.Plane(ChannelSaturation)
+	for i := range skin {
+		skin[i] = float32(in.pix[4*i]) / 255
+		detail[i] = float32(in.pix[4*i+1]) / 255
+		sat[i] = float32(in.pix[4*i+2]) / 255
+	}
+	return m
+}
@@ -1,6 +1,20 @@
 package smartcrop
 
+import (
+	"image"
+	"math"
+)
+
+// Config holds the parameters of an analyzer. It isn't comparable, compare
+// configs by their Hash.
 type Config struct {
+	// DisableEdge, DisableSkin and DisableSaturation turn the individual
+	// detectors off. A disabled detector doesn't contribute to the score and its
+	// pass over the image is skipped.
+	DisableEdge       bool
+	DisableSkin       bool
+	DisableSaturation bool
+
 	DetailWeight float64
 
 	SkinBias          float64
@@ -8,6 +22,17 @@ type Config struct {
 	SkinBrightnessMax float64
 	SkinThreshold     float64
 	SkinWeight        float64
+	// SkinColors are the reference colors, as normalized RGB vectors, a pixel is
+	// compared to by the skin detector. The closest one counts. If empty, a single
+	// built-in reference is used. See DiverseSkinColors.
+	SkinColors [][3]float64
+	// SkinDetector selects the skin detector, SkinDetectorRGB or SkinDetectorYCbCr.
+	// If empty, SkinDetectorRGB is used. SkinColors only apply to the former.
+	SkinDetector string
+	// FaceSkinAttenuation reduces SkinWeight by this share for images in which
+	// faces were found, leaving it to the faces to carry the signal. 1 turns the
+	// skin detector off for them, 0 leaves SkinWeight unchanged.
+	FaceSkinAttenuation float64
 
 	SaturationBrightnessMin float64
 	SaturationBrightnessMax float64
@@ -15,6 +40,15 @@ type Config struct {
 	SaturationBias          float64
 	SaturationWeight        float64
 
+	// SharpnessEnabled adds a detector that rates how much in focus each block
+	// of SharpnessBlockSize pixels is, by the variance of the Laplacian, so crops
+	// favour the in-focus subject over colorful but blurry backgrounds. Its
+	// contribution, weighted by SharpnessWeight, is reported as the "sharpness"
+	// channel of the Score.
+	SharpnessEnabled   bool
+	SharpnessWeight    float64
+	SharpnessBlockSize int
+
 	ScoreDownSample   int
 	Step              int
 	ScaleStep         float64
@@ -25,26 +59,280 @@ type Config struct {
 	OutsideImportance float64
 	RuleOfThirds      bool
 
+	// OriginalStep sets Step in terms of the original image instead of the
+	// prescaled analysis copy, so the positional granularity of the crops doesn't
+	// depend on the input size. Values below 1 are a fraction of the smaller
+	// image dimension, larger ones pixels of the original image. 0 keeps Step.
+	// ScaleStep is relative to the crop size already and needs no counterpart.
+	OriginalStep float64
+
+	// CandidateGenerator proposes the candidate crops. If nil, GridCandidates
+	// places them every Step pixels.
+	CandidateGenerator CandidateGenerator
+	// PrunePercentile skips scoring candidates whose summed detector output is
+	// below this percentile, from 0 to 1, of the candidates of the same size,
+	// e.g. 0.5 to only score the better half. Candidates with faces are always
+	// scored. 0 disables pruning.
+	PrunePercentile float64
+
+	// EdgeMargin keeps crops away from the image borders. Values below 1 are a
+	// fraction of the smaller image dimension, larger ones pixels of the original
+	// image. Where the smallest crop wouldn't fit, the margin shrinks as needed.
+	EdgeMargin float64
+
+	// AnchorBias favours crops close to Anchor, e.g. AnchorTop for portraits or
+	// AnchorBottom for product shots. The total score of the closest crop is
+	// raised by AnchorBias of its magnitude, e.g. 0.05 for 5%, decreasing with
+	// distance. 0 disables it.
+	Anchor     Anchor
+	AnchorBias float64
+
+	// MaxPadding enables padding when the requested aspect ratio is so different
+	// from the image's that cropping alone would keep less than PadThreshold of it.
+	// The crop then gets a less extreme aspect ratio, and CropResult.Padding,
+	// making up at most MaxPadding of the result's width or height, fills the
+	// rest. PadFill selects how CropAndResize fills it. 0 disables padding.
+	MaxPadding   float64
+	PadThreshold float64
+	PadFill      PadFill
+
+	// MaxRotation additionally evaluates candidates in the image rotated by up to
+	// MaxRotation degrees either way, in steps of RotationStep, after the
+	// refinement search and local optimization. The angle of the best crop is
+	// returned in CropResult.Angle. 0 disables it.
+	MaxRotation  float64
+	RotationStep float64
+
+	// ScoreFunc, if set, is called for every candidate to adjust its total score,
+	// e.g. to boost crops containing a brand color.
+	ScoreFunc ScoreFunc
+
+	// ProgressFunc, if set, is called as the detection passes and scoring of an
+	// analysis progress, so long running analyses can show progress.
+	ProgressFunc ProgressFunc
+
+	// Explain makes Analyze record the score breakdown, faces and mask weights of
+	// the chosen crop and its runner-ups in CropResult.Explanation.
+	Explain bool
+	// PaletteSize makes Analyze return up to PaletteSize dominant colors of the
+	// chosen crop in CropResult.Palette, e.g. for placeholders shown while the
+	// rendition loads. They are taken from the analysis copy, so they come
+	// without another pass over the full size image. 0 disables it.
+	PaletteSize int
+	// ImageHash makes Analyze return a perceptual hash of the whole image in
+	// CropResult.Hash, computed from the analysis copy, for deduplication and
+	// cache keys. Hashes computed with different prescaling may differ in a few
+	// bits, compare them with HashDistance.
+	ImageHash HashAlgorithm
+
+	// ScoreBlurRadius box blurs the detector output before scoring, so the pixels
+	// sampled every ScoreDownSample steps stand for their neighbourhood. 0 disables it.
+	ScoreBlurRadius int
+
+	// BlockScoring scores the mean of every ScoreDownSample x ScoreDownSample
+	// block instead of its first pixel, including the partial blocks at the
+	// right and bottom edges, which are skipped otherwise and bias crops away
+	// from these edges.
+	BlockScoring bool
+
+	// RefinementLevels enables a coarse-to-fine search: after scoring the Step grid,
+	// the RefinementTopK best candidates are searched again around their position
+	// with half the step, once per level.
+	RefinementLevels int
+	RefinementTopK   int
+
+	// LocalOptimization nudges the winning crop by up to Step/2 pixels and
+	// ScaleStep/2 in scale for as long as that improves its score, so it doesn't
+	// snap to the Step grid.
+	LocalOptimization bool
+
 	Prescale    bool
 	PrescaleMin float64
+	// BoxPrescale shrinks the analysis copy with an area-averaging box filter
+	// instead of the Resizer, which is meant for output quality. It is faster and
+	// doesn't add the ringing of sharper filters, which the edge detector picks
+	// up as detail.
+	BoxPrescale bool
+
+	// MaxAnalysisPixels and MaxCandidates bound the memory and time an analysis
+	// takes. Images with more pixels than MaxAnalysisPixels after prescaling are
+	// scaled down further, and Step is coarsened where it would generate more than
+	// MaxCandidates candidates. CropResult.Coarsened tells when either happened.
+	// 0 disables the limit.
+	MaxAnalysisPixels int
+	MaxCandidates     int
+	// MaxInputPixels makes analyses fail with ErrImageTooLarge for images with
+	// more pixels, before the analysis allocates any copies of them. Use
+	// DecodeLimited to refuse such images before decoding them. 0 disables the
+	// limit.
+	MaxInputPixels int
 
 	FaceDetectEnabled        bool
 	FaceDetectClassifierFile string
+	// FaceDetectScaleFactor, FaceDetectMinNeighbors, FaceDetectMinSize and
+	// FaceDetectMaxSize are passed on to the DetectMultiScale of the gocv
+	// classifier. 0 uses the OpenCV defaults of 1.1 and 3 neighbors and no
+	// size limits.
+	FaceDetectScaleFactor  float64
+	FaceDetectMinNeighbors int
+	FaceDetectMinSize      image.Point
+	FaceDetectMaxSize      image.Point
+	// FaceDetectRawDetections keeps every detection of the gocv classifier
+	// instead of grouping them by FaceDetectMinNeighbors, like a minNeighbors
+	// of 0 does in OpenCV.
+	FaceDetectRawDetections bool
+	// FaceMinConfidence drops faces detected with a lower confidence, see
+	// FaceConfidenceDetector. Faces of detectors not reporting one are kept. 0
+	// disables the check.
+	FaceMinConfidence float64
+	// FaceMinPixels drops faces of fewer pixels in the analysed image, that is
+	// after prescaling. FaceMinAreaFraction leaves faces taking up less than
+	// that share of a candidate crop out of its face score, so small faces
+	// still count for the tighter crops around them. 0 disables either check.
+	FaceMinPixels       int
+	FaceMinAreaFraction float64
+	// MaxFaceFraction is the largest share of the crop area a single face may
+	// cover. Tighter candidates are skipped in favour of the next best one.
+	// 0 disables the check.
+	MaxFaceFraction float64
+	// BodyExtension extends the region each face boosts downwards by
+	// BodyExtension face heights, at the width of the face, so crops including
+	// the shoulders and torso score higher than crops cutting at the chin. Unlike
+	// the face itself, the region below counts with the part of it the crop
+	// covers. 0 disables it.
+	BodyExtension float64
+	// EyeLineWeight adds a term of up to EyeLineWeight for crops placing the eye
+	// line of the faces, taken as the line a third down the face rectangle, on the
+	// upper third line of the crop, falling off linearly to 0 a third of the crop
+	// height away. The faces in the crop are averaged. 0 disables it.
+	EyeLineWeight float64
+	// FacePolicy decides how crops with several faces are chosen, see
+	// FacePolicy.
+	FacePolicy FacePolicy
+	// SensitivePolicy decides how crops avoid the regions found by the
+	// SensitiveDetector, see WithSensitiveDetector.
+	SensitivePolicy SensitivePolicy
+	// TemplatePolicy decides how crops keep the regions matching the templates
+	// passed to WithTemplates. TemplateThreshold is the normalized
+	// cross-correlation, up to 1, a region needs to match a template, 0.8 if 0.
+	// TemplateWeight is the most TemplateBoost adds to a score.
+	TemplatePolicy    TemplatePolicy
+	TemplateThreshold float64
+	TemplateWeight    float64
+	// BarcodePolicy decides whether crops keep or remove the codes found by the
+	// BarcodeDetector, see WithBarcodeDetector.
+	BarcodePolicy BarcodePolicy
+
+	// GrayscaleFastPath analyses *image.Gray inputs without converting them to RGBA
+	// and skips the skin and saturation detectors, which never fire on gray pixels.
+	GrayscaleFastPath bool
+
+	// MinAcceptableScore is the normalized score (see Score.Normalized) at least
+	// one candidate has to reach. Otherwise a centered crop is returned and
+	// CropResult.Fallback is set, unless sensitive regions, codes or included
+	// templates constrain the crop. 0 disables the check.
+	MinAcceptableScore float64
+	// SeamCarvingFallback makes CropAndResize retarget images that fail
+	// MinAcceptableScore by seam carving instead of returning the centered crop.
+	SeamCarvingFallback bool
+	// FullImageFallback returns the whole image, with CropResult.Fallback set,
+	// when no candidate crop is left, e.g. because the scale limits or the edge
+	// margin rule out all of them. Otherwise ErrNoCropFound is returned.
+	FullImageFallback bool
+	// Upscale decides what happens when the requested size exceeds the image,
+	// see UpscalePolicy.
+	Upscale UpscalePolicy
+
+	// AlignTo rounds the returned crop outwards to multiples of AlignTo pixels,
+	// e.g. 2 for video encoders that need even dimensions or 8 or 16 for
+	// lossless JPEG cropping along MCU boundaries. Where rounding outwards would
+	// leave the image, the edge is rounded inwards instead. 0 and 1 disable it.
+	AlignTo int
+
+	// CompatibilityMode makes Analyze and the methods built on it choose crops
+	// like another implementation of the algorithm, for mixed deployments that
+	// need identical crops for the same asset. CompatSmartcropJS2 follows
+	// smartcrop.js 2.x, given the same RGB pixels and, for images whose sides
+	// both exceed 256 pixels, the same prescaled copy; use PresetSmartcropJS for
+	// its defaults. Only the detector thresholds, biases and weights, the first
+	// of SkinColors, ScoreDownSample, Step, the scale range and the importance
+	// fields of the config apply, along with Prescale and FullImageFallback.
+	// Empty disables it.
+	CompatibilityMode string
+	// DocumentMode makes Analyze look for a dominant quadrilateral, such as a
+	// page, receipt or whiteboard, and return the smallest crop of the requested
+	// aspect ratio around it, with its corners in CropResult.Document. Images
+	// without one are analysed as usual.
+	DocumentMode bool
+
+	// DeterministicScoring rounds every intermediate result explicitly and sums the
+	// scores in fixed-point, so the compiler can't fuse multiply-adds and the same
+	// input and config give the same crop on every platform.
+	DeterministicScoring bool
+
+	// Symmetric makes the crop of a mirrored or 90 degree rotated image the
+	// mirrored or rotated crop of the original. Candidates then come from
+	// SymmetricGridCandidates, unless another CandidateGenerator is set, edges
+	// are detected with detect.SymmetricEdges, and the score loops sample
+	// symmetrically and sum in fixed-point, like with DeterministicScoring, so
+	// mirrored crops score exactly alike. It doesn't cover prescaling, which
+	// depends on the Resizer, face detection, the refinement searches and crops
+	// that tie.
+	Symmetric bool
+
+	// LinearLight makes the detectors compute lightness as CIE L* of the
+	// luminance in linear light, instead of from the gamma-encoded values with
+	// coefficients that weigh blue over red. This brings out edges in dark and
+	// red content. It is off by default to keep results compatible.
+	LinearLight bool
+
+	// ColorSpace selects the color space detail and saturation are computed in.
+	// ColorSpaceCIELAB and ColorSpaceOKLab rate saturation by chroma, which
+	// holds up better than HSL saturation under strong color casts such as stage
+	// lighting or sunsets, where HSL rates almost every pixel as saturated. They
+	// override LinearLight.
+	ColorSpace ColorSpace
+
+	// Denoise runs a 3x3 median filter over the analysis copy before the detectors,
+	// so sensor noise and point light sources don't register as detail.
+	Denoise bool
+
+	// GrayWorld and AutoLevels normalize the analysis copy before the detectors,
+	// so tinted and underexposed photos still get sensible skin and saturation
+	// detection. GrayWorld scales the color channels to the same mean, removing a
+	// color cast, and AutoLevels stretches the lightness range to full contrast.
+	// The original pixels and the output crop are unaffected.
+	GrayWorld  bool
+	AutoLevels bool
+
+	// NightDetectEnabled switches to the PresetNight tuning for images whose mean
+	// lightness is below NightLightnessThreshold.
+	NightDetectEnabled      bool
+	NightLightnessThreshold float64
 }
 
 var DefaultConfig = Config{
+	DisableEdge:              false,
+	DisableSkin:              false,
+	DisableSaturation:        false,
 	DetailWeight:             0.2,
 	SkinBias:                 0.01,
 	SkinBrightnessMin:        0.2,
 	SkinBrightnessMax:        1.0,
 	SkinThreshold:            0.8,
 	SkinWeight:               1.8,
+	FaceSkinAttenuation:      0,
 	SaturationBrightnessMin:  0.05,
 	SaturationBrightnessMax:  0.9,
 	SaturationThreshold:      0.4,
 	SaturationBias:           0.2,
 	SaturationWeight:         0.3,
+	SharpnessEnabled:         false,
+	SharpnessWeight:          0.2,
+	SharpnessBlockSize:       8,
 	ScoreDownSample:          8, // step * minscale rounded down to the next power of two should be good
+	ScoreBlurRadius:          0,
+	BlockScoring:             false,
 	Step:                     8,
 	ScaleStep:                0.1,
 	MinScale:                 0.9,
@@ -53,27 +341,93 @@ var DefaultConfig = Config{
 	EdgeWeight:               -20.0,
 	OutsideImportance:        -0.5,
 	RuleOfThirds:             true,
+	OriginalStep:             0,
+	CandidateGenerator:       nil,
+	PrunePercentile:          0,
+	EdgeMargin:               0,
+	Anchor:                   AnchorCenter,
+	AnchorBias:               0,
+	MaxPadding:               0,
+	PadThreshold:             0.5,
+	PadFill:                  PadFillAverage,
+	MaxRotation:              0,
+	RotationStep:             1,
+	ScoreFunc:                nil,
+	ProgressFunc:             nil,
+	Explain:                  false,
+	PaletteSize:              0,
+	ImageHash:                HashNone,
+	RefinementLevels:         0,
+	RefinementTopK:           4,
+	LocalOptimization:        false,
 	Prescale:                 true,
 	PrescaleMin:              400.00,
+	BoxPrescale:              false,
+	MaxAnalysisPixels:        0,
+	MaxCandidates:            0,
+	MaxInputPixels:           0,
 	FaceDetectEnabled:        false,
 	FaceDetectClassifierFile: "",
+	FaceDetectScaleFactor:    0,
+	FaceDetectMinNeighbors:   0,
+	FaceDetectMinSize:        image.Point{},
+	FaceDetectMaxSize:        image.Point{},
+	FaceDetectRawDetections:  false,
+	FaceMinConfidence:        0,
+	FaceMinPixels:            0,
+	FaceMinAreaFraction:      0,
+	MaxFaceFraction:          0,
+	BodyExtension:            0,
+	EyeLineWeight:            0,
+	FacePolicy:               FacePolicyProportional,
+	SensitivePolicy:          SensitiveExclude,
+	TemplatePolicy:           TemplateInclude,
+	TemplateThreshold:        0,
+	TemplateWeight:           0,
+	BarcodePolicy:            BarcodeInclude,
+	GrayscaleFastPath:        true,
+	MinAcceptableScore:       0,
+	SeamCarvingFallback:      false,
+	FullImageFallback:        false,
+	Upscale:                  UpscaleBestEffort,
+	AlignTo:                  0,
+	CompatibilityMode:        "",
+	DocumentMode:             false,
+	DeterministicScoring:     false,
+	Symmetric:                false,
+	LinearLight:              false,
+	ColorSpace:               ColorSpaceRGB,
+	Denoise:                  false,
+	GrayWorld:                false,
+	AutoLevels:               false,
+	NightDetectEnabled:       false,
+	NightLightnessThreshold:  0.2,
 }
 
 // FaceDetectConfig is a tweaked version of the DefaultConfig that has been optimised for
 // smart cropping with face detection enabled.
 var FaceDetectConfig = Config{
+	DisableEdge:              false,
+	DisableSkin:              false,
+	DisableSaturation:        false,
 	DetailWeight:             5.2,
 	SkinBias:                 0.01,
 	SkinBrightnessMin:        0.2,
 	SkinBrightnessMax:        1.0,
 	SkinThreshold:            0.8,
 	SkinWeight:               5.8,
+	FaceSkinAttenuation:      0,
 	SaturationBrightnessMin:  0.05,
 	SaturationBrightnessMax:  0.9,
 	SaturationThreshold:      0.4,
 	SaturationBias:           0.2,
 	SaturationWeight:         5.5,
+	SharpnessEnabled:         false,
+	SharpnessWeight:          0.2,
+	SharpnessBlockSize:       8,
 	ScoreDownSample:          2,
+	ScoreBlurRadius:          0,
+	BlockScoring:             false,
 	Step:                     8,
 	ScaleStep:                0.1,
 	MinScale:                 1.0,
@@ -82,8 +436,80 @@ var FaceDetectConfig = Config{
 	EdgeWeight:               -20.0,
 	OutsideImportance:        -0.5,
 	RuleOfThirds:             true,
+	OriginalStep:             0,
+	CandidateGenerator:       nil,
+	PrunePercentile:          0,
+	EdgeMargin:               0,
+	Anchor:                   AnchorCenter,
+	AnchorBias:               0,
+	MaxPadding:               0,
+	PadThreshold:             0.5,
+	PadFill:                  PadFillAverage,
+	MaxRotation:              0,
+	RotationStep:             1,
+	ScoreFunc:                nil,
+	ProgressFunc:             nil,
+	Explain:                  false,
+	PaletteSize:              0,
+	ImageHash:                HashNone,
+	RefinementLevels:         0,
+	RefinementTopK:           4,
+	LocalOptimization:        false,
 	Prescale:                 false,
 	PrescaleMin:              400.0,
+	BoxPrescale:              false,
+	MaxAnalysisPixels:        0,
+	MaxCandidates:            0,
+	MaxInputPixels:           0,
 	FaceDetectEnabled:        true,
 	FaceDetectClassifierFile: "", // must be filled in by client
+	FaceDetectScaleFactor:    0,
+	FaceDetectMinNeighbors:   0,
+	FaceDetectMinSize:        image.Point{},
+	FaceDetectMaxSize:        image.Point{},
+	FaceDetectRawDetections:  false,
+	FaceMinConfidence:        0,
+	FaceMinPixels:            0,
+	FaceMinAreaFraction:      0,
+	MaxFaceFraction:          0,
+	BodyExtension:            0,
+	EyeLineWeight:            0,
+	FacePolicy:               FacePolicyProportional,
+	SensitivePolicy:          SensitiveExclude,
+	TemplatePolicy:           TemplateInclude,
+	TemplateThreshold:        0,
+	TemplateWeight:           0,
+	BarcodePolicy:            BarcodeInclude,
+	GrayscaleFastPath:        true,
+	MinAcceptableScore:       0,
+	SeamCarvingFallback:      false,
+	FullImageFallback:        false,
+	Upscale:                  UpscaleBestEffort,
+	AlignTo:                  0,
+	CompatibilityMode:        "",
+	DocumentMode:             false,
+	DeterministicScoring:     false,
+	Symmetric:                false,
+	LinearLight:              false,
+	ColorSpace:               ColorSpaceRGB,
+	Denoise:                  false,
+	GrayWorld:                false,
+	AutoLevels:               false,
+	NightDetectEnabled:       false,
+	NightLightnessThreshold:  0.2,
+}
+
+// PresetNight is a version of the DefaultConfig tuned for long-exposure and night
+// photos, where lamps and noise would otherwise dominate the edge and saturation scores.
+var PresetNight = nightTuned(DefaultConfig)
+
+// nightTuned returns c with the brightness thresholds raised and the denoise
+// pre-pass enabled.
+func nightTuned(c Config) Config {
+	c.SkinBrightnessMin = math.Max(c.SkinBrightnessMin, 0.3)
+	c.SaturationBrightnessMin = math.Max(c.SaturationBrightnessMin, 0.15)
+	c.SaturationBrightnessMax = math.Min(c.SaturationBrightnessMax, 0.8)
+	c.Denoise = true
+	c.NightDetectEnabled = false
+	return c
 }
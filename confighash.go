@@ -0,0 +1,57 @@
+package smartcrop
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+)
+
+// unhashedFields are the Config fields that don't affect the crop decision and
+// are left out of Config.Hash.
+var unhashedFields = map[string]bool{
+	"ProgressFunc": true,
+}
+
+// Hash returns a fingerprint of c, so crop decisions stored with it can be told
+// apart from those made with a different config. The fields are hashed by name,
+// in sorted order, and fields at their zero value are left out, so reordering
+// the fields or adding new ones, which are off at their zero value, doesn't
+// change the hash of existing configs.
+//
+// Functions are only told apart by whether they are set, and other
+// implementations of interfaces such as CandidateGenerator by their type and, if
+// they aren't pointers, their value.
+func (c Config) Hash() uint64 {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Name; !unhashedFields[name] && !v.Field(i).IsZero() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s;", name, hashValue(v.FieldByName(name)))
+	}
+	return h.Sum64()
+}
+
+// hashValue formats v for Config.Hash, without addresses, which change from run
+// to run.
+func hashValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Func:
+		return "func"
+	case reflect.Interface:
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr || e.Kind() == reflect.Func {
+			return e.Type().String()
+		}
+		return fmt.Sprintf("%s%+v", e.Type(), e.Interface())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
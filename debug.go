@@ -40,7 +40,7 @@ import (
 	"path/filepath"
 )
 
-func debugOutput(debug bool, img *image.RGBA, debugType string) {
+func debugOutput(debug bool, img image.Image, debugType string) {
 	if debug {
 		writeImage("png", img, "./smartcrop_"+debugType+".png")
 	}
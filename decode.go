@@ -0,0 +1,60 @@
+package smartcrop
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// maxMetadataSize is the size compressed metadata may inflate to, far beyond
+// that of real ICC profiles and XMP packets, so a small file can't expand to
+// gigabytes.
+const maxMetadataSize = 4 << 20
+
+// DecodeLimited decodes an image like image.Decode, but fails with
+// ErrImageTooLarge for images with more than maxPixels pixels. The size is read
+// from the image header, so oversized images, such as decompression bombs
+// declaring huge dimensions in a small file, are refused before their pixels
+// are allocated. A maxPixels of 0 disables the limit. Decoders have to be
+// registered as for image.Decode.
+func DecodeLimited(r io.Reader, maxPixels int64) (image.Image, string, error) {
+	// keep what DecodeConfig reads, to decode the image from the start
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, "", err
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, "", ErrImageTooLarge
+	}
+	return image.Decode(io.MultiReader(&header, r))
+}
+
+// checkInput fails with ErrImageTooLarge for images with the given bounds if
+// they have more than Config.MaxInputPixels pixels.
+func (sca *smartcropAnalyzer) checkInput(bounds image.Rectangle) error {
+	if sca.config.MaxInputPixels > 0 && int64(bounds.Dx())*int64(bounds.Dy()) > int64(sca.config.MaxInputPixels) {
+		return ErrImageTooLarge
+	}
+	return nil
+}
+
+// inflate decompresses the zlib data of metadata, failing with
+// ErrMetadataTooLarge beyond maxMetadataSize.
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := ioutil.ReadAll(io.LimitReader(zr, maxMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxMetadataSize {
+		return nil, ErrMetadataTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,372 @@
+// Package detect exposes the primitives smartcrop analyses images with: the edge,
+// skin and saturation detectors and the importance function that weighs pixels
+// by their position within a crop. They are plain functions on images, so other
+// imaging tools can reuse them without an Analyzer.
+//
+// The detectors return a Map with one value from 0 to 1 per pixel, in steps of
+// 1/255, the resolution smartcrop has always scored crops with. The options
+// of each detector correspond to the fields of smartcrop.Config, and the default
+// options to smartcrop.DefaultConfig.
+package detect
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Map holds one value per pixel of Rect, row by row.
+type Map struct {
+	Rect   image.Rectangle
+	Values []float32
+}
+
+// NewMap returns a zeroed Map over r.
+func NewMap(r image.Rectangle) *Map {
+	return &Map{Rect: r, Values: make([]float32, r.Dx()*r.Dy())}
+}
+
+// At returns the value at x, y, zero outside of Rect.
+func (m *Map) At(x, y int) float32 {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return 0
+	}
+	return m.Values[(y-m.Rect.Min.Y)*m.Rect.Dx()+x-m.Rect.Min.X]
+}
+
+// Edges returns the edge strength of every pixel of img: the Laplacian of its
+// lightness, from 0 to 1. Pixels on the border of img have no edge strength. If
+// lightness is nil, Lightness is used.
+func Edges(img image.Image, lightness func(color.RGBA) float64) *Map {
+	return edges(img, lightness, false)
+}
+
+// SymmetricEdges works like Edges, but sums the neighbours of each pixel in
+// pairs, which makes the result exactly the same for mirrored and rotated
+// images. Rounding may make it differ from Edges by a level.
+func SymmetricEdges(img image.Image, lightness func(color.RGBA) float64) *Map {
+	return edges(img, lightness, true)
+}
+
+func edges(img image.Image, lightness func(color.RGBA) float64, symmetric bool) *Map {
+	if lightness == nil {
+		lightness = Lightness
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	ls := lightnessValues(img, lightness)
+
+	m := NewMap(b)
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			var l float64
+			if symmetric {
+				vertical := ls[x+(y-1)*width] + ls[x+(y+1)*width]
+				horizontal := ls[x-1+y*width] + ls[x+1+y*width]
+				l = ls[y*width+x]*4.0 - (vertical + horizontal)
+			} else {
+				l = ls[y*width+x]*4.0 -
+					ls[x+(y-1)*width] -
+					ls[x-1+y*width] -
+					ls[x+1+y*width] -
+					ls[x+(y+1)*width]
+			}
+			m.Values[y*width+x] = level(l)
+		}
+	}
+	return m
+}
+
+// level returns the response v, from 0 to 255, as a Map value, truncated to the
+// 8-bit levels the detectors' output used to be stored in.
+func level(v float64) float32 {
+	return float32(math.Floor(math.Min(math.Max(v, 0), 255)) / 255.0)
+}
+
+// lightnessValues returns the lightness of every pixel of img, row by row.
+func lightnessValues(img image.Image, lightness func(color.RGBA) float64) []float64 {
+	if gray, ok := img.(*image.Gray); ok {
+		return grayLightness(gray, lightness)
+	}
+	rgba := toRGBA(img)
+	b := rgba.Bounds()
+	ls := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			ls = append(ls, lightness(rgba.RGBAAt(x, y)))
+		}
+	}
+	return ls
+}
+
+// grayLightness returns the lightness of every pixel of img, as lightness returns
+// it for the gray pixel after it has been converted to RGBA.
+func grayLightness(img *image.Gray, lightness func(color.RGBA) float64) []float64 {
+	var table [256]float64
+	for v := range table {
+		table[v] = lightness(color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+	}
+
+	b := img.Bounds()
+	ls := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			ls = append(ls, table[img.GrayAt(x, y).Y])
+		}
+	}
+	return ls
+}
+
+// SkinOptions configures Skin.
+type SkinOptions struct {
+	// Threshold is the least Similarity a pixel needs to count as skin. The
+	// response rises from 0 at Threshold to 1 at full similarity.
+	Threshold float64
+	// BrightnessMin and BrightnessMax limit skin to pixels of this lightness,
+	// from 0 to 1.
+	BrightnessMin float64
+	BrightnessMax float64
+	// Similarity rates how skin-like a pixel is, from 0 to 1. If nil, it is
+	// SkinSimilarity with DefaultSkinColor.
+	Similarity func(color.RGBA) float64
+	// Lightness is the lightness function, Lightness if nil.
+	Lightness func(color.RGBA) float64
+}
+
+// DefaultSkinOptions are the skin detector options of smartcrop.DefaultConfig.
+var DefaultSkinOptions = SkinOptions{
+	Threshold:     0.8,
+	BrightnessMin: 0.2,
+	BrightnessMax: 1.0,
+}
+
+// DefaultSkinColor is the skin reference, as a normalized RGB vector, used when
+// SkinOptions.Similarity is nil.
+var DefaultSkinColor = [3]float64{0.78, 0.57, 0.44}
+
+// Skin returns the skin response of every pixel of img.
+func Skin(img image.Image, o SkinOptions) *Map {
+	similarity := o.Similarity
+	if similarity == nil {
+		references := [][3]float64{DefaultSkinColor}
+		similarity = func(c color.RGBA) float64 {
+			return SkinSimilarity(c, references)
+		}
+	}
+	return threshold(img, similarity, o.Lightness, o.Threshold, o.BrightnessMin, o.BrightnessMax)
+}
+
+// SaturationOptions configures Saturation.
+type SaturationOptions struct {
+	// Threshold is the least saturation a pixel needs to count. The response
+	// rises from 0 at Threshold to 1 at full saturation.
+	Threshold float64
+	// BrightnessMin and BrightnessMax limit the detector to pixels of this
+	// lightness, from 0 to 1.
+	BrightnessMin float64
+	BrightnessMax float64
+	// Lightness is the lightness function, Lightness if nil.
+	Lightness func(color.RGBA) float64
+	// Saturation rates the saturation of a pixel from 0 to 1, HSLSaturation if
+	// nil.
+	Saturation func(color.RGBA) float64
+}
+
+// DefaultSaturationOptions are the saturation detector options of
+// smartcrop.DefaultConfig.
+var DefaultSaturationOptions = SaturationOptions{
+	Threshold:     0.4,
+	BrightnessMin: 0.05,
+	BrightnessMax: 0.9,
+}
+
+// Saturation returns the saturation response of every pixel of img.
+func Saturation(img image.Image, o SaturationOptions) *Map {
+	rate := o.Saturation
+	if rate == nil {
+		rate = HSLSaturation
+	}
+	return threshold(img, rate, o.Lightness, o.Threshold, o.BrightnessMin, o.BrightnessMax)
+}
+
+// threshold maps the rating of every pixel of img above min to the range from 0
+// to 1, for pixels with a lightness between lmin and lmax.
+func threshold(img image.Image, rate, lightness func(color.RGBA) float64, min, lmin, lmax float64) *Map {
+	if lightness == nil {
+		lightness = Lightness
+	}
+	rgba := toRGBA(img)
+	b := rgba.Bounds()
+	m := NewMap(b)
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := rgba.RGBAAt(x, y)
+			l := lightness(c) / 255.0
+			if r := rate(c); r > min && l >= lmin && l <= lmax {
+				m.Values[i] = level((r - min) * (255.0 / (1.0 - min)))
+			}
+			i++
+		}
+	}
+	return m
+}
+
+// Lightness returns the lightness of c from 0 to 255, a weighted sum of its
+// channels as in smartcrop.js. Saturated blue can exceed 255.
+func Lightness(c color.RGBA) float64 {
+	return 0.5126*float64(c.B) + 0.7152*float64(c.G) + 0.0722*float64(c.R)
+}
+
+// srgbLinear maps 8-bit sRGB values to linear light, from 0 to 1.
+var srgbLinear = func() (t [256]float64) {
+	for i := range t {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			t[i] = v / 12.92
+		} else {
+			t[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return t
+}()
+
+// LinearLightness returns the CIE lightness L* of c, scaled to the range of
+// Lightness. The luminance is computed in linear light with the Rec. 709
+// coefficients, so unlike Lightness it weighs red and blue correctly and never
+// exceeds 255.
+func LinearLightness(c color.RGBA) float64 {
+	y := 0.2126*srgbLinear[c.R] + 0.7152*srgbLinear[c.G] + 0.0722*srgbLinear[c.B]
+	var l float64
+	if y > 216.0/24389 {
+		l = 116*math.Cbrt(y) - 16
+	} else {
+		l = y * 24389 / 27
+	}
+	return l * 2.55
+}
+
+// HSLSaturation returns the saturation of c in the HSL model, from 0 to 1.
+func HSLSaturation(c color.RGBA) float64 {
+	cMax, cMin := c.R, c.R
+	for _, v := range []uint8{c.G, c.B} {
+		if v > cMax {
+			cMax = v
+		}
+		if v < cMin {
+			cMin = v
+		}
+	}
+
+	if cMax == cMin {
+		return 0
+	}
+	maximum := float64(cMax) / 255.0
+	minimum := float64(cMin) / 255.0
+
+	l := (maximum + minimum) / 2.0
+	d := maximum - minimum
+
+	if l > 0.5 {
+		return d / (2.0 - maximum - minimum)
+	}
+
+	return d / (maximum + minimum)
+}
+
+// SkinSimilarity rates how close the color of c is to the closest of the
+// references, normalized RGB vectors, from 0 to 1.
+func SkinSimilarity(c color.RGBA, references [][3]float64) float64 {
+	r8, g8, b8 := float64(c.R), float64(c.G), float64(c.B)
+
+	mag := math.Sqrt(r8*r8 + g8*g8 + b8*b8)
+	d := math.Inf(1)
+	for _, ref := range references {
+		rd := r8/mag - ref[0]
+		gd := g8/mag - ref[1]
+		bd := b8/mag - ref[2]
+		d = math.Min(d, math.Sqrt(rd*rd+gd*gd+bd*bd))
+	}
+	return 1.0 - d
+}
+
+// Chroma range of skin, after Chai and Ngan.
+const (
+	skinCbMin = 77
+	skinCbMax = 127
+	skinCrMin = 133
+	skinCrMax = 173
+)
+
+// SkinYCbCr returns 1 for colors whose chroma lies in the classic skin range and
+// 0 otherwise. It is less prone to firing on orange and brown objects such as
+// wood or sand than SkinSimilarity, but doesn't grade its response.
+func SkinYCbCr(c color.RGBA) float64 {
+	_, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+	if cb >= skinCbMin && cb <= skinCbMax && cr >= skinCrMin && cr <= skinCrMax {
+		return 1.0
+	}
+	return 0.0
+}
+
+// ImportanceOptions configures Importance.
+type ImportanceOptions struct {
+	// EdgeRadius is the share of the crop, from its edges inwards, in which
+	// importance falls off by EdgeWeight, usually a negative value.
+	EdgeRadius float64
+	EdgeWeight float64
+	// OutsideImportance is the importance of pixels outside the crop.
+	OutsideImportance float64
+	// RuleOfThirds raises the importance along the lines of thirds.
+	RuleOfThirds bool
+}
+
+// DefaultImportanceOptions are the importance options of smartcrop.DefaultConfig.
+var DefaultImportanceOptions = ImportanceOptions{
+	EdgeRadius:        0.4,
+	EdgeWeight:        -20.0,
+	OutsideImportance: -0.5,
+	RuleOfThirds:      true,
+}
+
+// Importance returns how much the pixel at x, y counts towards the score of crop:
+// most in the center and, with RuleOfThirds, along the lines of thirds, less
+// towards the edges.
+func Importance(crop image.Rectangle, x, y int, o ImportanceOptions) float64 {
+	if crop.Min.X > x || x >= crop.Max.X || crop.Min.Y > y || y >= crop.Max.Y {
+		return o.OutsideImportance
+	}
+
+	xf := float64(x-crop.Min.X) / float64(crop.Dx())
+	yf := float64(y-crop.Min.Y) / float64(crop.Dy())
+
+	px := math.Abs(0.5-xf) * 2.0
+	py := math.Abs(0.5-yf) * 2.0
+
+	dx := math.Max(px-1.0+o.EdgeRadius, 0.0)
+	dy := math.Max(py-1.0+o.EdgeRadius, 0.0)
+	d := (dx*dx + dy*dy) * o.EdgeWeight
+
+	s := 1.41 - math.Sqrt(px*px+py*py)
+	if o.RuleOfThirds {
+		s += (math.Max(0.0, s+d+0.5) * 1.2) * (thirds(px) + thirds(py))
+	}
+
+	return s + d
+}
+
+func thirds(x float64) float64 {
+	x = (math.Mod(x-(1.0/3.0)+1.0, 2.0)*0.5 - 0.5) * 16.0
+	return math.Max(1.0-x*x, 0.0)
+}
+
+// toRGBA returns img as an image.RGBA, converting it if necessary.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+	return out
+}
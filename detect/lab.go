@@ -0,0 +1,72 @@
+package detect
+
+import (
+	"image/color"
+	"math"
+)
+
+// CIELAB returns the CIE L*a*b* coordinates of c under the D65 white point, with
+// L* from 0 to 100.
+func CIELAB(c color.RGBA) (l, a, b float64) {
+	r, g, bl := srgbLinear[c.R], srgbLinear[c.G], srgbLinear[c.B]
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*bl) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*bl
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*bl) / 1.08883
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+// OKLab returns the OKLab coordinates of c, with L from 0 to 1.
+func OKLab(c color.RGBA) (l, a, b float64) {
+	r, g, bl := srgbLinear[c.R], srgbLinear[c.G], srgbLinear[c.B]
+	lc := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*bl)
+	mc := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*bl)
+	sc := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*bl)
+	return 0.2104542553*lc + 0.7936177850*mc - 0.0040720468*sc,
+		1.9779984951*lc - 2.4285922050*mc + 0.4505937099*sc,
+		0.0259040371*lc + 0.7827717662*mc - 0.8086757660*sc
+}
+
+// OKLabLightness returns the OKLab lightness of c, scaled to the range of
+// Lightness.
+func OKLabLightness(c color.RGBA) float64 {
+	l, _, _ := OKLab(c)
+	return l * 255
+}
+
+// maxLabChroma and maxOKLabChroma are the largest chroma of an sRGB color in
+// CIELAB and OKLab, that of one of the corners of the RGB cube.
+var maxLabChroma, maxOKLabChroma = func() (lab, ok float64) {
+	for _, c := range []color.RGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255},
+		{255, 255, 0, 255}, {0, 255, 255, 255}, {255, 0, 255, 255},
+	} {
+		_, a, b := CIELAB(c)
+		lab = math.Max(lab, math.Hypot(a, b))
+		_, a, b = OKLab(c)
+		ok = math.Max(ok, math.Hypot(a, b))
+	}
+	return lab, ok
+}()
+
+// LabSaturation returns the CIELAB chroma of c relative to the most chromatic
+// sRGB color, from 0 to 1. Unlike HSLSaturation it rates dark and pale colors
+// low, so a color cast over a whole image doesn't make every pixel saturated.
+func LabSaturation(c color.RGBA) float64 {
+	_, a, b := CIELAB(c)
+	return math.Min(math.Hypot(a, b)/maxLabChroma, 1)
+}
+
+// OKLabSaturation is LabSaturation in OKLab, whose chroma follows perceived
+// colorfulness more evenly across hues.
+func OKLabSaturation(c color.RGBA) float64 {
+	_, a, b := OKLab(c)
+	return math.Min(math.Hypot(a, b)/maxOKLabChroma, 1)
+}
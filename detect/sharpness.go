@@ -0,0 +1,79 @@
+package detect
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// SharpnessOptions configures Sharpness.
+type SharpnessOptions struct {
+	// BlockSize is the size of the square blocks sharpness is measured over.
+	BlockSize int
+	// Lightness is the lightness function, Lightness if nil.
+	Lightness func(color.RGBA) float64
+}
+
+// DefaultSharpnessOptions are the sharpness detector options of
+// smartcrop.DefaultConfig.
+var DefaultSharpnessOptions = SharpnessOptions{
+	BlockSize: 8,
+}
+
+// Sharpness returns how much in focus every pixel of img is: the variance of the
+// Laplacian of the lightness over the block the pixel lies in. Blocks start at
+// the origin of img. Out-of-focus areas have little high-frequency content and so
+// a low variance. The standard deviations are relative to that of the sharpest
+// block, from 0 to 1, so an image without any texture has no sharpness.
+func Sharpness(img image.Image, o SharpnessOptions) *Map {
+	if o.Lightness == nil {
+		o.Lightness = Lightness
+	}
+	size := o.BlockSize
+	if size < 1 {
+		size = DefaultSharpnessOptions.BlockSize
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	ls := lightnessValues(img, o.Lightness)
+
+	bw, bh := (width+size-1)/size, (height+size-1)/size
+	sums := make([]float64, bw*bh)
+	squares := make([]float64, bw*bh)
+	counts := make([]int, bw*bh)
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			l := ls[y*width+x]*4.0 -
+				ls[x+(y-1)*width] -
+				ls[x-1+y*width] -
+				ls[x+1+y*width] -
+				ls[x+(y+1)*width]
+			i := y/size*bw + x/size
+			sums[i] += l
+			squares[i] += l * l
+			counts[i]++
+		}
+	}
+
+	deviations := make([]float64, bw*bh)
+	var max float64
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		mean := sums[i] / float64(n)
+		deviations[i] = math.Sqrt(math.Max(squares[i]/float64(n)-mean*mean, 0))
+		max = math.Max(max, deviations[i])
+	}
+
+	m := NewMap(b)
+	if max == 0 {
+		return m
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.Values[y*width+x] = float32(deviations[y/size*bw+x/size] / max)
+		}
+	}
+	return m
+}
@@ -0,0 +1,116 @@
+package detect
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Match is a region of an image looking like a template, see MatchTemplate.
+type Match struct {
+	image.Rectangle
+	// Score is the normalized cross-correlation of the region and the template,
+	// from -1 to 1 for a perfect match.
+	Score float64
+}
+
+// MatchTemplate returns the regions of img the size of tmpl whose lightness
+// correlates with that of tmpl by at least threshold, best first. Of overlapping
+// matches only the best one is returned. The template is neither scaled nor
+// rotated, and the search takes time proportional to the product of the pixel
+// counts of img and tmpl, so both should be small.
+func MatchTemplate(img, tmpl image.Image, threshold float64) []Match {
+	b, tb := img.Bounds(), tmpl.Bounds()
+	width, height := b.Dx(), b.Dy()
+	tw, th := tb.Dx(), tb.Dy()
+	if tw == 0 || th == 0 || tw > width || th > height {
+		return nil
+	}
+
+	// the template with its mean subtracted, so the mean of each window
+	// doesn't have to be
+	ts := lightnessValues(tmpl, Lightness)
+	n := float64(len(ts))
+	var tmean float64
+	for _, v := range ts {
+		tmean += v
+	}
+	tmean /= n
+	var tnorm float64
+	for i, v := range ts {
+		ts[i] = v - tmean
+		tnorm += ts[i] * ts[i]
+	}
+	if tnorm == 0 {
+		return nil
+	}
+	tnorm = math.Sqrt(tnorm)
+
+	ls := lightnessValues(img, Lightness)
+	sum, sq := integral(ls, width, height)
+	window := func(s []float64, x, y int) float64 {
+		w := width + 1
+		return s[(y+th)*w+x+tw] - s[y*w+x+tw] - s[(y+th)*w+x] + s[y*w+x]
+	}
+
+	var matches []Match
+	for y := 0; y+th <= height; y++ {
+		for x := 0; x+tw <= width; x++ {
+			s := window(sum, x, y)
+			variance := window(sq, x, y) - s*s/n
+			// flat windows correlate with nothing, and lose precision
+			if variance <= 1e-6*n {
+				continue
+			}
+			var c float64
+			for ty := 0; ty < th; ty++ {
+				row := ls[(y+ty)*width+x : (y+ty)*width+x+tw]
+				trow := ts[ty*tw : (ty+1)*tw]
+				for tx, v := range trow {
+					c += row[tx] * v
+				}
+			}
+			if score := c / (math.Sqrt(variance) * tnorm); score >= threshold {
+				r := image.Rect(x, y, x+tw, y+th).Add(b.Min)
+				matches = append(matches, Match{Rectangle: r, Score: score})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	var best []Match
+	for _, m := range matches {
+		free := true
+		for _, kept := range best {
+			if kept.Overlaps(m.Rectangle) {
+				free = false
+				break
+			}
+		}
+		if free {
+			best = append(best, m)
+		}
+	}
+	return best
+}
+
+// integral returns the summed area tables of the values and their squares, of
+// (width+1)*(height+1) entries with a zero first row and column.
+func integral(values []float64, width, height int) ([]float64, []float64) {
+	w := width + 1
+	sum := make([]float64, w*(height+1))
+	sq := make([]float64, w*(height+1))
+	for y := 0; y < height; y++ {
+		var rowSum, rowSq float64
+		for x := 0; x < width; x++ {
+			v := values[y*width+x]
+			rowSum += v
+			rowSq += v * v
+			sum[(y+1)*w+x+1] = sum[y*w+x+1] + rowSum
+			sq[(y+1)*w+x+1] = sq[y*w+x+1] + rowSq
+		}
+	}
+	return sum, sq
+}
@@ -0,0 +1,94 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// fixedPointScale is the resolution of the fixed-point accumulators used by
+// deterministic scoring.
+const fixedPointScale = 1 << 24
+
+func toFixed(v float64) int64 {
+	return int64(math.Round(v * fixedPointScale))
+}
+
+func fromFixed(v int64) float64 {
+	return float64(v) / fixedPointScale
+}
+
+// The functions below mirror importance and score. Every product is wrapped in an
+// explicit float64 conversion, which the Go spec guarantees to round and thereby
+// prevents fused multiply-add instructions on architectures like arm64.
+
+func (sca *smartcropAnalyzer) importanceDeterministic(crop Crop, x, y int) float64 {
+	if crop.Min.X > x || x >= crop.Max.X || crop.Min.Y > y || y >= crop.Max.Y {
+		return sca.config.OutsideImportance
+	}
+
+	xf := float64(x-crop.Min.X) / float64(crop.Dx())
+	yf := float64(y-crop.Min.Y) / float64(crop.Dy())
+
+	px := float64(math.Abs(0.5-xf) * 2.0)
+	py := float64(math.Abs(0.5-yf) * 2.0)
+	return sca.importanceFromCenter(px, py)
+}
+
+// importanceFromCenter returns the importance of a pixel within a crop, given its
+// distances px and py from the center, relative to half the crop size.
+func (sca *smartcropAnalyzer) importanceFromCenter(px, py float64) float64 {
+	dx := math.Max(px-1.0+sca.config.EdgeRadius, 0.0)
+	dy := math.Max(py-1.0+sca.config.EdgeRadius, 0.0)
+	d := float64(float64(float64(dx*dx)+float64(dy*dy)) * sca.config.EdgeWeight)
+
+	s := 1.41 - math.Sqrt(float64(px*px)+float64(py*py))
+	if sca.config.RuleOfThirds {
+		s += float64(float64(math.Max(0.0, s+d+0.5)*1.2) * (thirdsDeterministic(px) + thirdsDeterministic(py)))
+	}
+
+	return s + d
+}
+
+func thirdsDeterministic(x float64) float64 {
+	x = float64((float64(math.Mod(x-(1.0/3.0)+1.0, 2.0)*0.5) - 0.5) * 16.0)
+	return math.Max(1.0-float64(x*x), 0.0)
+}
+
+func (sca *smartcropAnalyzer) scoreDeterministic(output *ScoreMap, crop Crop, faceRects []image.Rectangle, kernels importanceKernels) Score {
+	width := output.Bounds().Dx()
+	height := output.Bounds().Dy()
+	var skin, detail, saturation, maxImportance int64
+	kernel := kernels.forCrop(sca, crop)
+	samples := sca.sampler(output)
+	detailPlane, skinPlane, satPlane := samples.m.Plane(ChannelDetail), samples.m.Plane(ChannelSkin), samples.m.Plane(ChannelSaturation)
+
+	for y := 0; y <= height-samples.end; y += samples.ds {
+		for x := 0; x <= width-samples.end; x += samples.ds {
+			i, weight := samples.at(x, y)
+			imp := float64(kernel.at(x, y) * weight)
+			det := float64(detailPlane[i])
+
+			skin += toFixed(float64(float64(float64(skinPlane[i])*(det+sca.config.SkinBias)) * imp))
+			detail += toFixed(float64(det * imp))
+			saturation += toFixed(float64(float64(float64(satPlane[i])*(det+sca.config.SaturationBias)) * imp))
+			maxImportance += toFixed(math.Max(imp, 0))
+		}
+	}
+
+	score := Score{
+		Detail:     fromFixed(detail),
+		Skin:       fromFixed(skin),
+		Saturation: fromFixed(saturation),
+		Face:       sca.faceScore(crop, faceRects),
+	}
+	total := float64(score.Detail*sca.config.DetailWeight) +
+		float64(score.Skin*sca.config.SkinWeight) +
+		float64(score.Saturation*sca.config.SaturationWeight) +
+		sca.sharpnessScore(samples, width, height, kernel, &score)
+	score.Total = total/float64(float64(crop.Dx())*float64(crop.Dy())) + score.Face
+	score.Total += float64(math.Abs(score.Total) * sca.anchorBoost(output.Bounds(), crop))
+	score.Total = sca.customScore(output, crop, score)
+
+	sca.normalize(&score, crop, fromFixed(maxImportance))
+	return score
+}
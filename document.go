@@ -0,0 +1,274 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"github.com/third-light/smartcrop/detect"
+)
+
+// Quad is a quadrilateral in an image, such as a photographed page, with the
+// corners in the order top left, top right, bottom right, bottom left.
+type Quad [4]image.Point
+
+// Bounds returns the bounding box of the quad.
+func (q Quad) Bounds() image.Rectangle {
+	r := image.Rectangle{Min: q[0], Max: q[0]}
+	for _, p := range q[1:] {
+		r.Min.X, r.Min.Y = minInt(r.Min.X, p.X), minInt(r.Min.Y, p.Y)
+		r.Max.X, r.Max.Y = maxInt(r.Max.X, p.X), maxInt(r.Max.Y, p.Y)
+	}
+	return r
+}
+
+// area returns the area of the quad, by the shoelace formula.
+func (q Quad) area() float64 {
+	var a float64
+	for i, p := range q {
+		n := q[(i+1)%4]
+		a += float64(p.X*n.Y - n.X*p.Y)
+	}
+	return math.Abs(a) / 2
+}
+
+// minDocumentArea is the share of the image a document has to cover to be
+// found.
+const minDocumentArea = 0.2
+
+// analyzeDocument implements Analyze for Config.DocumentMode. Without a document
+// in img it returns false.
+func (sca *smartcropAnalyzer) analyzeDocument(img image.Image, width, height int) (CropResult, bool, error) {
+	smallimg, prescalefactor, err := sca.prescale(img)
+	if err != nil {
+		return CropResult{}, false, err
+	}
+	q, ok := findDocument(smallimg)
+	if !ok {
+		sca.logger.Log.Println("no document found")
+		return CropResult{}, false, nil
+	}
+	b := img.Bounds()
+	for i, p := range q {
+		q[i] = image.Pt(int(math.Round(float64(p.X)/prescalefactor)), int(math.Round(float64(p.Y)/prescalefactor)))
+	}
+
+	// the smallest crop of the requested aspect ratio around the document
+	box := q.Bounds()
+	w, h := float64(box.Dx()), float64(box.Dy())
+	if aspect := float64(width) / float64(height); w/h < aspect {
+		w = h * aspect
+	} else {
+		h = w / aspect
+	}
+	if s := math.Min(float64(b.Dx())/w, float64(b.Dy())/h); s < 1 {
+		w, h = w*s, h*s
+	}
+	c := box.Min.Add(box.Max).Div(2)
+	r := image.Rect(0, 0, int(w), int(h)).Add(c.Sub(image.Pt(int(w)/2, int(h)/2)))
+	r = r.Add(image.Pt(shift(r.Min.X, r.Max.X, 0, b.Dx()), shift(r.Min.Y, r.Max.Y, 0, b.Dy())))
+
+	return CropResult{Crop: Crop{Rectangle: r}, Document: &q}, true, nil
+}
+
+// findDocument looks for the dominant quadrilateral in the analysis image img,
+// in coordinates starting at 0, 0. The strongest edges are joined into contours
+// and the corners of the contour with the largest bounding box taken as those of
+// the document.
+func findDocument(img image.Image) (Quad, bool) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 3 || height < 3 {
+		return Quad{}, false
+	}
+	edges := detect.Edges(img, nil).Values
+
+	// keep the strongest edges, widened by a pixel to close small gaps
+	sorted := make([]float32, len(edges))
+	copy(sorted, edges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	threshold := math.Max(float64(sorted[len(sorted)*99/100])/2, 0.02)
+	mask := make([]bool, len(edges))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if float64(edges[y*width+x]) < threshold {
+				continue
+			}
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if nx, ny := x+dx, y+dy; nx >= 0 && nx < width && ny >= 0 && ny < height {
+						mask[ny*width+nx] = true
+					}
+				}
+			}
+		}
+	}
+
+	// trace the contours as connected components of edge pixels, keeping the
+	// corners of the one spanning the largest box that runs along them
+	var best Quad
+	var bestBox image.Rectangle
+	labels := make([]int, len(mask))
+	var stack []int
+	label := 0
+	for start := range mask {
+		if !mask[start] || labels[start] != 0 {
+			continue
+		}
+		label++
+		labels[start] = label
+		stack = append(stack[:0], start)
+		p := image.Pt(start%width, start/width)
+		q := Quad{p, p, p, p}
+		box := image.Rectangle{Min: p, Max: p}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%width, i/width
+			// the corners are the points furthest towards them
+			switch {
+			case x+y < q[0].X+q[0].Y:
+				q[0] = image.Pt(x, y)
+			case x+y > q[2].X+q[2].Y:
+				q[2] = image.Pt(x, y)
+			}
+			switch {
+			case x-y > q[1].X-q[1].Y:
+				q[1] = image.Pt(x, y)
+			case x-y < q[3].X-q[3].Y:
+				q[3] = image.Pt(x, y)
+			}
+			box = box.Union(image.Rect(x, y, x+1, y+1))
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || nx >= width || ny < 0 || ny >= height {
+						continue
+					}
+					if n := ny*width + nx; mask[n] && labels[n] == 0 {
+						labels[n] = label
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		if box.Dx()*box.Dy() > bestBox.Dx()*bestBox.Dy() &&
+			q.area() >= minDocumentArea*float64(width*height) &&
+			alongSides(q, labels, label, width, height) {
+			best, bestBox = q, box
+		}
+	}
+	return best, !bestBox.Empty()
+}
+
+// alongSides reports whether the contour of the given label runs along each
+// side of q for most of its length, as it does around a page but not around
+// other shapes.
+func alongSides(q Quad, labels []int, label, width, height int) bool {
+	const reach = 2
+	for i, a := range q {
+		b := q[(i+1)%4]
+		n := maxInt(absInt(b.X-a.X), absInt(b.Y-a.Y))
+		if n == 0 {
+			return false
+		}
+		hits := 0
+		for s := 0; s <= n; s++ {
+			x := a.X + (b.X-a.X)*s/n
+			y := a.Y + (b.Y-a.Y)*s/n
+		search:
+			for dy := -reach; dy <= reach; dy++ {
+				for dx := -reach; dx <= reach; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx >= 0 && nx < width && ny >= 0 && ny < height && labels[ny*width+nx] == label {
+						hits++
+						break search
+					}
+				}
+			}
+		}
+		if float64(hits) < 0.8*float64(n+1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rectify returns the quad q of img mapped onto a width x height image,
+// correcting the perspective of a photographed document. q is in coordinates
+// relative to the image origin, as CropResult.Document.
+func Rectify(img image.Image, q Quad, width, height int) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width <= 0 || height <= 0 {
+		return out
+	}
+	h, ok := homography(
+		[4][2]float64{{0, 0}, {float64(width), 0}, {float64(width), float64(height)}, {0, float64(height)}},
+		[4][2]float64{
+			{float64(q[0].X), float64(q[0].Y)}, {float64(q[1].X), float64(q[1].Y)},
+			{float64(q[2].X), float64(q[2].Y)}, {float64(q[3].X), float64(q[3].Y)},
+		})
+	if !ok {
+		return out
+	}
+
+	src := toRGBA(img)
+	b := src.Bounds()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// sample at pixel centers
+			fx, fy := float64(x)+0.5, float64(y)+0.5
+			w := h[6]*fx + h[7]*fy + 1
+			sx := (h[0]*fx + h[1]*fy + h[2]) / w
+			sy := (h[3]*fx + h[4]*fy + h[5]) / w
+			out.SetRGBA(x, y, bilinear(src, sx-0.5+float64(b.Min.X), sy-0.5+float64(b.Min.Y)))
+		}
+	}
+	return out
+}
+
+// homography returns the projective transform mapping the points from onto the
+// points to, as the first 8 coefficients of its 3x3 matrix, the last one being
+// 1. It fails for degenerate quads.
+func homography(from, to [4][2]float64) ([8]float64, bool) {
+	// two equations per point pair, solved by Gaussian elimination
+	var m [8][9]float64
+	for i := 0; i < 4; i++ {
+		x, y, u, v := from[i][0], from[i][1], to[i][0], to[i][1]
+		m[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		m[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return [8]float64{}, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := 0; row < 8; row++ {
+			if row == col {
+				continue
+			}
+			f := m[row][col] / m[col][col]
+			for k := col; k < 9; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+	var h [8]float64
+	for i := range h {
+		h[i] = m[i][8] / m[i][i]
+	}
+	return h, true
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
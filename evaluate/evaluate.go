@@ -0,0 +1,245 @@
+// Package evaluate measures how well a Config reproduces a corpus of expected
+// crops, and searches for better settings.
+//
+// A corpus is a directory of images, each with a JSON sidecar of the same name
+// listing the crops a human picked for it:
+//
+//	{
+//	  "crops": [
+//	    {"width": 250, "height": 250, "expected": {"x": 120, "y": 0, "width": 284, "height": 284}}
+//	  ]
+//	}
+//
+// width and height are the requested crop size, as passed to FindBestCrop.
+//
+// An optional "labels" object attaches labels to the image, such as
+// {"skin_tone": "V"}, to break a report down by them with Report.By, for example
+// to check that crops are as good for every skin tone in a diverse corpus.
+package evaluate
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the decoders for corpus images
+	_ "image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/options"
+)
+
+// Case is a single expected crop.
+type Case struct {
+	// Name identifies the case in reports: the image file name and crop size.
+	Name          string
+	Image         image.Image
+	Width, Height int
+	Expected      image.Rectangle
+	// Labels are the labels of the image, see Report.By.
+	Labels map[string]string
+}
+
+// SkinToneLabel is the label conventionally holding the skin tone of the people
+// in an image, such as a Fitzpatrick type, for evaluating skin tone fairness.
+const SkinToneLabel = "skin_tone"
+
+// Corpus is a set of cases with their images decoded, so it can be evaluated
+// repeatedly.
+type Corpus struct {
+	Cases []Case
+}
+
+type sidecar struct {
+	Crops []struct {
+		Width    int  `json:"width"`
+		Height   int  `json:"height"`
+		Expected rect `json:"expected"`
+	} `json:"crops"`
+	Labels map[string]string `json:"labels"`
+}
+
+type rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// LoadCorpus reads every image in dir that has a JSON sidecar. Images without
+// one are ignored.
+func LoadCorpus(dir string) (*Corpus, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Corpus{}
+	for _, fi := range files {
+		ext := filepath.Ext(fi.Name())
+		if fi.IsDir() || strings.EqualFold(ext, ".json") {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		data, err := ioutil.ReadFile(strings.TrimSuffix(path, ext) + ".json")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var sc sidecar
+		if err := json.Unmarshal(data, &sc); err != nil {
+			return nil, fmt.Errorf("Failed parsing expected crops for %s: %v", fi.Name(), err)
+		}
+		img, err := decode(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding %s: %v", fi.Name(), err)
+		}
+		for _, cr := range sc.Crops {
+			e := cr.Expected
+			c.Cases = append(c.Cases, Case{
+				Name:     fmt.Sprintf("%s@%dx%d", fi.Name(), cr.Width, cr.Height),
+				Image:    img,
+				Width:    cr.Width,
+				Height:   cr.Height,
+				Expected: image.Rect(e.X, e.Y, e.X+e.Width, e.Y+e.Height),
+				Labels:   sc.Labels,
+			})
+		}
+	}
+	return c, nil
+}
+
+func decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// Result is the outcome of a single case.
+type Result struct {
+	Name   string
+	Crop   image.Rectangle
+	IoU    float64
+	Labels map[string]string
+}
+
+// Report summarizes how a Config performed on a corpus.
+type Report struct {
+	Config  smartcrop.Config
+	Results []Result
+	// MeanIoU, MedianIoU and MinIoU aggregate the intersection over union of
+	// the found and the expected crops. 1 is a perfect match.
+	MeanIoU   float64
+	MedianIoU float64
+	MinIoU    float64
+}
+
+// Run finds the best crop for every case using cfg and compares it to the
+// expected one.
+func (c *Corpus) Run(cfg smartcrop.Config, resizer options.Resizer) (Report, error) {
+	analyzer := smartcrop.NewAnalyzer(cfg, resizer)
+	report := Report{Config: cfg}
+	for _, cs := range c.Cases {
+		crop, err := analyzer.FindBestCrop(cs.Image, cs.Width, cs.Height)
+		if err != nil {
+			return report, fmt.Errorf("Failed cropping %s: %v", cs.Name, err)
+		}
+		report.Results = append(report.Results, Result{
+			Name:   cs.Name,
+			Crop:   crop,
+			IoU:    IoU(crop, cs.Expected),
+			Labels: cs.Labels,
+		})
+	}
+	report.summarize()
+	return report, nil
+}
+
+func (r *Report) summarize() {
+	if len(r.Results) == 0 {
+		return
+	}
+
+	ious := make([]float64, len(r.Results))
+	sum := 0.0
+	for i, res := range r.Results {
+		ious[i] = res.IoU
+		sum += res.IoU
+	}
+	sort.Float64s(ious)
+
+	r.MeanIoU = sum / float64(len(ious))
+	r.MinIoU = ious[0]
+	if n := len(ious); n%2 == 1 {
+		r.MedianIoU = ious[n/2]
+	} else {
+		r.MedianIoU = (ious[n/2-1] + ious[n/2]) / 2
+	}
+}
+
+// By breaks the report down by the values of the given label, returning a
+// report for the cases of each value. Cases without the label are grouped under
+// the empty value.
+func (r Report) By(label string) map[string]Report {
+	groups := make(map[string]Report)
+	for _, res := range r.Results {
+		v := res.Labels[label]
+		g := groups[v]
+		g.Config = r.Config
+		g.Results = append(g.Results, res)
+		groups[v] = g
+	}
+	for v, g := range groups {
+		g.summarize()
+		groups[v] = g
+	}
+	return groups
+}
+
+// IoUSpread returns the difference between the best and the worst mean IoU of
+// groups, as returned by By. The smaller, the more evenly the Config performs
+// across them.
+func IoUSpread(groups map[string]Report) float64 {
+	if len(groups) == 0 {
+		return 0
+	}
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, g := range groups {
+		min = math.Min(min, g.MeanIoU)
+		max = math.Max(max, g.MeanIoU)
+	}
+	return max - min
+}
+
+// String returns a one line summary of the report.
+func (r Report) String() string {
+	return fmt.Sprintf("%d cases: mean IoU %.3f, median %.3f, min %.3f",
+		len(r.Results), r.MeanIoU, r.MedianIoU, r.MinIoU)
+}
+
+// IoU returns the intersection over union of a and b.
+func IoU(a, b image.Rectangle) float64 {
+	in := area(a.Intersect(b))
+	union := area(a) + area(b) - in
+	if union == 0 {
+		return 0
+	}
+	return float64(in) / float64(union)
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
@@ -0,0 +1,74 @@
+package evaluate
+
+import (
+	"sort"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/options"
+)
+
+// Param is a Config value to vary during tuning.
+type Param struct {
+	Name   string
+	Values []float64
+	Set    func(c *smartcrop.Config, v float64)
+}
+
+// DetailWeight varies Config.DetailWeight.
+func DetailWeight(values ...float64) Param {
+	return Param{"DetailWeight", values, func(c *smartcrop.Config, v float64) { c.DetailWeight = v }}
+}
+
+// SkinWeight varies Config.SkinWeight.
+func SkinWeight(values ...float64) Param {
+	return Param{"SkinWeight", values, func(c *smartcrop.Config, v float64) { c.SkinWeight = v }}
+}
+
+// SaturationWeight varies Config.SaturationWeight.
+func SaturationWeight(values ...float64) Param {
+	return Param{"SaturationWeight", values, func(c *smartcrop.Config, v float64) { c.SaturationWeight = v }}
+}
+
+// EdgeWeight varies Config.EdgeWeight.
+func EdgeWeight(values ...float64) Param {
+	return Param{"EdgeWeight", values, func(c *smartcrop.Config, v float64) { c.EdgeWeight = v }}
+}
+
+// FaceSkinAttenuation varies Config.FaceSkinAttenuation.
+func FaceSkinAttenuation(values ...float64) Param {
+	return Param{"FaceSkinAttenuation", values, func(c *smartcrop.Config, v float64) { c.FaceSkinAttenuation = v }}
+}
+
+// Tune runs the corpus with every combination of params applied to base and
+// returns the reports, best mean IoU first.
+func (c *Corpus) Tune(base smartcrop.Config, resizer options.Resizer, params ...Param) ([]Report, error) {
+	var reports []Report
+	var walk func(cfg smartcrop.Config, params []Param) error
+	walk = func(cfg smartcrop.Config, params []Param) error {
+		if len(params) == 0 {
+			report, err := c.Run(cfg, resizer)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, report)
+			return nil
+		}
+		p := params[0]
+		for _, v := range p.Values {
+			next := cfg
+			p.Set(&next, v)
+			if err := walk(next, params[1:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(base, params); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].MeanIoU > reports[j].MeanIoU
+	})
+	return reports, nil
+}
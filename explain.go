@@ -0,0 +1,111 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// explainRunnersUp is the number of runner-up crops an Explanation covers.
+const explainRunnersUp = 4
+
+// Explanation records why Analyze chose its crop, see Config.Explain.
+type Explanation struct {
+	// Candidates is the number of candidate crops scored.
+	Candidates int
+	// Crops explains the chosen crop, followed by the best runner-ups of the
+	// candidate search. All coordinates are those of the original image.
+	Crops []CropExplanation
+	// Masked is set when an importance mask weighted the scores.
+	Masked bool
+}
+
+// CropExplanation breaks down the total score of a crop.
+type CropExplanation struct {
+	Crop
+	// Detail, Skin, Saturation, Sharpness and Face are the weighted
+	// contributions of the detectors and the faces, which add up to the total
+	// before adjustments. Sharpness is 0 without Config.SharpnessEnabled.
+	Detail, Skin, Saturation, Sharpness, Face float64
+	// AnchorBoost is what Config.AnchorBias added to the total, Custom what
+	// Config.ScoreFunc changed.
+	AnchorBoost, Custom float64
+	// Faces are the faces the crop contains at least partly.
+	Faces []image.Rectangle
+	// Mask is the average importance mask weight within the crop, from 0 to 1,
+	// and 1 without a mask.
+	Mask float64
+	// FaceFractionOK is false when a face covers more than
+	// Config.MaxFaceFraction of the crop, which keeps it from being chosen.
+	FaceFractionOK bool
+}
+
+// explanation explains topCrop and the best other candidates of allCrops. All
+// arguments are in analysis coordinates, topCrop is rotated by angle.
+func (sca *smartcropAnalyzer) explanation(o *ScoreMap, topCrop Crop, allCrops []Crop, faceRects []image.Rectangle, mask *image.Gray, angle, prescalefactor float64) *Explanation {
+	e := &Explanation{Candidates: len(allCrops), Masked: mask != nil}
+
+	topFaces, topMask := faceRects, mask
+	if angle != 0 {
+		topFaces = rotateRects(faceRects, o.Bounds(), angle)
+		if mask != nil {
+			topMask = rotateGray(mask, angle)
+		}
+	}
+	e.Crops = append(e.Crops, sca.explainCrop(o, topCrop, topFaces, topMask, prescalefactor))
+
+	for _, crop := range sca.topCrops(allCrops, explainRunnersUp+1, faceRects) {
+		if len(e.Crops) > explainRunnersUp {
+			break
+		}
+		if angle == 0 && crop.Rectangle == topCrop.Rectangle {
+			continue
+		}
+		e.Crops = append(e.Crops, sca.explainCrop(o, crop, faceRects, mask, prescalefactor))
+	}
+	return e
+}
+
+func (sca *smartcropAnalyzer) explainCrop(o *ScoreMap, crop Crop, faceRects []image.Rectangle, mask *image.Gray, prescalefactor float64) CropExplanation {
+	area := float64(crop.Dx()) * float64(crop.Dy())
+	s := crop.Score
+	ce := CropExplanation{
+		Detail:         s.Detail * sca.config.DetailWeight / area,
+		Skin:           s.Skin * sca.config.SkinWeight / area,
+		Saturation:     s.Saturation * sca.config.SaturationWeight / area,
+		Sharpness:      s.Channels[ChannelSharpness] * sca.config.SharpnessWeight / area,
+		Face:           s.Face,
+		Mask:           1,
+		FaceFractionOK: sca.faceFractionOK(crop, faceRects),
+	}
+	base := ce.Detail + ce.Skin + ce.Saturation + ce.Sharpness + ce.Face
+	ce.AnchorBoost = math.Abs(base) * sca.anchorBoost(o.Bounds(), crop)
+	ce.Custom = s.Total - base - ce.AnchorBoost
+
+	for _, r := range faceRects {
+		if r.Overlaps(crop.Rectangle) {
+			ce.Faces = append(ce.Faces, unscale(r, prescalefactor))
+		}
+	}
+	if mask != nil {
+		ce.Mask = meanGray(mask, crop.Rectangle)
+	}
+
+	ce.Crop = crop
+	ce.Rectangle = unscale(crop.Rectangle, prescalefactor).Canon()
+	return ce
+}
+
+// meanGray returns the mean value of m within r, from 0 to 1.
+func meanGray(m *image.Gray, r image.Rectangle) float64 {
+	r = r.Intersect(m.Bounds())
+	if r.Empty() {
+		return 0
+	}
+	var sum int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for _, v := range m.Pix[m.PixOffset(r.Min.X, y):m.PixOffset(r.Max.X, y)] {
+			sum += int(v)
+		}
+	}
+	return float64(sum) / float64(r.Dx()*r.Dy()) / 255
+}
@@ -0,0 +1,51 @@
+//go:build !js && !nogocv
+// +build !js,!nogocv
+
+package smartcrop
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// faceDetector holds the lazily loaded gocv classifier. It is shared by all
+// copies of an analyzer, mu guards loading and using it, as OpenCV classifiers
+// mustn't be used by several threads at once.
+type faceDetector struct {
+	mu          sync.Mutex
+	initialised bool
+	classifier  gocv.CascadeClassifier
+}
+
+// faceDetect runs the cascade classifier over i. The raw detections are grouped
+// here rather than by OpenCV, which doesn't report how many each face was
+// merged from.
+func (sca *smartcropAnalyzer) faceDetect(i image.Image) ([]image.Rectangle, []float64, error) {
+
+	img, err := gocv.ImageToMatRGBA(i)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed converting img to MatRGBA: %v", err)
+	}
+	defer img.Close()
+
+	sca.faceDetector.mu.Lock()
+	defer sca.faceDetector.mu.Unlock()
+	if !sca.faceDetector.initialised {
+		sca.faceDetector.classifier = gocv.NewCascadeClassifier()
+		if !sca.faceDetector.classifier.Load(sca.config.FaceDetectClassifierFile) {
+			return nil, nil, fmt.Errorf("Failed loading classifier file at %s", sca.config.FaceDetectClassifierFile)
+		}
+		sca.faceDetector.initialised = true
+	}
+
+	scale := sca.config.FaceDetectScaleFactor
+	if scale <= 0 {
+		scale = 1.1
+	}
+	raw := sca.faceDetector.classifier.DetectMultiScaleWithParams(img, scale, 0, 0, sca.config.FaceDetectMinSize, sca.config.FaceDetectMaxSize)
+	faceRects, confidences := groupFaces(raw, sca.config.faceDetectMinNeighbors())
+	return faceRects, confidences, nil
+}
@@ -0,0 +1,17 @@
+//go:build js || nogocv
+// +build js nogocv
+
+package smartcrop
+
+import (
+	"image"
+)
+
+// faceDetector is empty when smartcrop is built without gocv.
+type faceDetector struct{}
+
+// faceDetect always fails without gocv, which is the case for the js/wasm target
+// or when building with the nogocv tag.
+func (sca *smartcropAnalyzer) faceDetect(i image.Image) ([]image.Rectangle, []float64, error) {
+	return nil, nil, ErrFaceDetectUnavailable
+}
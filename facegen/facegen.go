@@ -0,0 +1,170 @@
+// Package facegen draws parametric, cartoon-like faces. The generated images are
+// free of any licensing restrictions and come with the ground truth face
+// rectangles, so they can stand in for real photos when testing and benchmarking
+// the face detection pipeline.
+package facegen
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// SkinTones is a range of skin colors from light to dark used by Generate.
+var SkinTones = []color.RGBA{
+	{255, 224, 196, 255},
+	{241, 194, 160, 255},
+	{224, 172, 135, 255},
+	{198, 134, 96, 255},
+	{161, 102, 68, 255},
+	{120, 75, 50, 255},
+	{88, 54, 38, 255},
+}
+
+// Face describes a single face. Width is the width of the head, its height is
+// derived from it.
+type Face struct {
+	Center image.Point
+	Width  int
+	Skin   color.RGBA
+	Hair   color.RGBA
+}
+
+// Bounds returns the rectangle covering the face, excluding the hair.
+func (f Face) Bounds() image.Rectangle {
+	w, h := f.Width/2, f.Width*5/8
+	return image.Rect(f.Center.X-w, f.Center.Y-h, f.Center.X+w, f.Center.Y+h)
+}
+
+// Options configures Generate.
+type Options struct {
+	Width, Height int
+	Faces         int
+	// MinFaceWidth and MaxFaceWidth bound the random face sizes. If zero they
+	// default to 1/8 and 1/3 of the smaller image dimension.
+	MinFaceWidth, MaxFaceWidth int
+	Seed                       int64
+}
+
+// Generate returns an image with a noisy gradient background and opts.Faces
+// non-overlapping faces, along with their bounds. The same options always produce
+// the same image.
+func Generate(opts Options) (*image.RGBA, []image.Rectangle) {
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	drawBackground(img, rnd)
+
+	minDim := opts.Width
+	if opts.Height < minDim {
+		minDim = opts.Height
+	}
+	minW, maxW := opts.MinFaceWidth, opts.MaxFaceWidth
+	if minW == 0 {
+		minW = minDim / 8
+	}
+	if maxW == 0 {
+		maxW = minDim / 3
+	}
+
+	var rects []image.Rectangle
+	for tries := 0; len(rects) < opts.Faces && tries < 100*opts.Faces; tries++ {
+		w := minW
+		if maxW > minW {
+			w += rnd.Intn(maxW - minW)
+		}
+		f := Face{
+			Width: w,
+			Skin:  SkinTones[rnd.Intn(len(SkinTones))],
+			Hair:  color.RGBA{uint8(rnd.Intn(80)), uint8(rnd.Intn(60)), uint8(rnd.Intn(40)), 255},
+		}
+		// leave room for the hair above the face
+		margin := image.Pt(w/2, w*7/8)
+		if opts.Width <= 2*margin.X || opts.Height <= 2*margin.Y {
+			continue
+		}
+		f.Center = image.Pt(margin.X+rnd.Intn(opts.Width-2*margin.X), margin.Y+rnd.Intn(opts.Height-2*margin.Y))
+
+		r := f.Bounds()
+		overlaps := false
+		for _, o := range rects {
+			if r.Overlaps(o.Inset(-w / 4)) {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+		rects = append(rects, DrawFace(img, f))
+	}
+	return img, rects
+}
+
+// DrawFace draws f onto img and returns its bounds.
+func DrawFace(img *image.RGBA, f Face) image.Rectangle {
+	r := f.Bounds()
+	cx, cy := float64(f.Center.X), float64(f.Center.Y)
+	w, h := float64(r.Dx()), float64(r.Dy())
+
+	// hair behind the top of the head, then the head itself
+	fillEllipse(img, cx, cy-h*0.12, w*0.56, h*0.5, f.Hair)
+	fillEllipse(img, cx, cy, w/2, h/2, f.Skin)
+
+	dark := shade(f.Skin, 0.35)
+	for _, side := range []float64{-1, 1} {
+		ex := cx + side*w*0.2
+		ey := cy - h*0.1
+		// brow, eye socket shadow, sclera and iris
+		fillEllipse(img, ex, ey-h*0.11, w*0.13, h*0.025, f.Hair)
+		fillEllipse(img, ex, ey, w*0.13, h*0.07, shade(f.Skin, 0.75))
+		fillEllipse(img, ex, ey, w*0.09, h*0.045, color.RGBA{240, 240, 235, 255})
+		fillEllipse(img, ex, ey, w*0.04, h*0.04, color.RGBA{40, 30, 25, 255})
+	}
+
+	// nose shadow and mouth
+	fillEllipse(img, cx, cy+h*0.1, w*0.06, h*0.1, shade(f.Skin, 0.8))
+	fillEllipse(img, cx, cy+h*0.28, w*0.18, h*0.045, dark)
+
+	return r
+}
+
+func drawBackground(img *image.RGBA, rnd *rand.Rand) {
+	b := img.Bounds()
+	top := color.RGBA{uint8(60 + rnd.Intn(120)), uint8(60 + rnd.Intn(120)), uint8(80 + rnd.Intn(120)), 255}
+	bottom := color.RGBA{uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), uint8(rnd.Intn(120)), 255}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		t := float64(y-b.Min.Y) / float64(b.Dy())
+		for x := b.Min.X; x < b.Max.X; x++ {
+			n := rnd.Float64()*16 - 8
+			img.SetRGBA(x, y, color.RGBA{
+				mix(top.R, bottom.R, t, n),
+				mix(top.G, bottom.G, t, n),
+				mix(top.B, bottom.B, t, n),
+				255,
+			})
+		}
+	}
+}
+
+func fillEllipse(img *image.RGBA, cx, cy, rx, ry float64, c color.RGBA) {
+	b := image.Rect(int(cx-rx), int(cy-ry), int(math.Ceil(cx+rx)), int(math.Ceil(cy+ry))).Intersect(img.Bounds())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dx := (float64(x) + 0.5 - cx) / rx
+			dy := (float64(y) + 0.5 - cy) / ry
+			if dx*dx+dy*dy <= 1 {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+func shade(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{uint8(float64(c.R) * f), uint8(float64(c.G) * f), uint8(float64(c.B) * f), c.A}
+}
+
+func mix(a, b uint8, t, noise float64) uint8 {
+	v := float64(a)*(1-t) + float64(b)*t + noise
+	return uint8(math.Min(math.Max(v, 0), 255))
+}
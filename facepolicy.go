@@ -0,0 +1,55 @@
+package smartcrop
+
+import "image"
+
+// FacePolicy decides how the faces found weigh in the choice of the crop when
+// there are several, see Config.FacePolicy.
+type FacePolicy int
+
+const (
+	// FacePolicyProportional only scores faces by the share of the crop they
+	// take up, which may prefer one large face over several smaller ones. This
+	// is the default.
+	FacePolicyProportional FacePolicy = iota
+	// FacePolicyAll picks the best crop containing every face, if any candidate
+	// does.
+	FacePolicyAll
+	// FacePolicyMaxCount picks the best crop among those containing the most
+	// faces.
+	FacePolicyMaxCount
+)
+
+// faceRank ranks crop under Config.FacePolicy, crops of a higher rank are
+// preferred regardless of their score.
+func (sca *smartcropAnalyzer) faceRank(crop Crop, faceRects []image.Rectangle) int {
+	if sca.config.FacePolicy == FacePolicyProportional {
+		return 0
+	}
+
+	n := 0
+	for _, r := range faceRects {
+		if r.In(crop.Rectangle) {
+			n++
+		}
+	}
+	if sca.config.FacePolicy == FacePolicyAll {
+		if n == len(faceRects) {
+			return 1
+		}
+		return 0
+	}
+	return n
+}
+
+// better reports whether a is preferred over b under Config.FacePolicy: by face
+// rank first and by score among crops of the same rank. Every choice between
+// crops goes through it, so that no step after findTopCrop swaps in a crop
+// cutting off a face the policy keeps.
+func (sca *smartcropAnalyzer) better(a, b Crop, faceRects []image.Rectangle) bool {
+	return preferred(sca.faceRank(a, faceRects), a, sca.faceRank(b, faceRects), b)
+}
+
+// preferred reports whether a of face rank rankA is preferred over b of rankB.
+func preferred(rankA int, a Crop, rankB int, b Crop) bool {
+	return rankA > rankB || rankA == rankB && a.Score.Total > b.Score.Total
+}
@@ -0,0 +1,242 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// FaceDetector finds faces in an image, see WithFaceDetector.
+type FaceDetector interface {
+	DetectFaces(img image.Image) ([]image.Rectangle, error)
+}
+
+// FaceConfidenceDetector is a FaceDetector that also tells how confident it is
+// about each face, for Config.FaceMinConfidence. The confidences are in the
+// order of the faces.
+type FaceConfidenceDetector interface {
+	FaceDetector
+	DetectFacesWithConfidence(img image.Image) ([]image.Rectangle, []float64, error)
+}
+
+// detectFaces finds the faces in img with the configured FaceDetector, or the
+// built-in one if there is none, dropping those below Config.FaceMinConfidence
+// and Config.FaceMinPixels.
+// Faces are drawn onto o unless it is nil. The confidences are nil if the
+// detector doesn't report them.
+func (sca *smartcropAnalyzer) detectFaces(img image.Image, o *image.RGBA) ([]image.Rectangle, []float64, error) {
+	var faceRects []image.Rectangle
+	var confidences []float64
+	var err error
+	switch d := sca.faces.(type) {
+	case nil:
+		faceRects, confidences, err = sca.faceDetect(img)
+	case FaceConfidenceDetector:
+		faceRects, confidences, err = d.DetectFacesWithConfidence(img)
+	default:
+		faceRects, err = d.DetectFaces(img)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if sca.config.FaceMinConfidence > 0 && confidences != nil || sca.config.FaceMinPixels > 0 {
+		var keptRects []image.Rectangle
+		var kept []float64
+		for i, r := range faceRects {
+			if confidences != nil && confidences[i] < sca.config.FaceMinConfidence || r.Dx()*r.Dy() < sca.config.FaceMinPixels {
+				continue
+			}
+			keptRects = append(keptRects, r)
+			if confidences != nil {
+				kept = append(kept, confidences[i])
+			}
+		}
+		faceRects = keptRects
+		if confidences != nil {
+			confidences = kept
+		}
+	}
+
+	// Draw face rects on to output image to see what the algorithm is actually doing
+	if o != nil {
+		for _, r := range faceRects {
+			drawRect(o, color.RGBA{255, 0, 0, 255}, r)
+		}
+	}
+	return faceRects, confidences, nil
+}
+
+// faceDetectMinNeighbors returns the minNeighbors to group detections by: 0
+// with Config.FaceDetectRawDetections, Config.FaceDetectMinNeighbors if set and
+// the OpenCV default of 3 otherwise.
+func (c Config) faceDetectMinNeighbors() int {
+	if c.FaceDetectRawDetections {
+		return 0
+	}
+	if c.FaceDetectMinNeighbors <= 0 {
+		return 3
+	}
+	return c.FaceDetectMinNeighbors
+}
+
+// groupFaces merges the raw detections of a cascade classifier the way OpenCV's
+// groupRectangles does: similar rectangles are averaged, groups of no more than
+// minNeighbors rectangles dropped, and so are faces within a more confident one.
+// The confidence of a face is the number of detections it was merged from. Like
+// groupRectangles, a minNeighbors of 0 or less returns the raw detections
+// unchanged, each with a confidence of 1.
+func groupFaces(raw []image.Rectangle, minNeighbors int) ([]image.Rectangle, []float64) {
+	const eps = 0.2
+
+	if minNeighbors <= 0 {
+		confidences := make([]float64, len(raw))
+		for i := range confidences {
+			confidences[i] = 1
+		}
+		return raw, confidences
+	}
+
+	// partition the detections into classes of similar rectangles
+	labels := make([]int, len(raw))
+	for i := range labels {
+		labels[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if labels[i] != i {
+			labels[i] = root(labels[i])
+		}
+		return labels[i]
+	}
+	for i := range raw {
+		for j := 0; j < i; j++ {
+			if similarRects(raw[i], raw[j], eps) {
+				labels[root(i)] = root(j)
+			}
+		}
+	}
+
+	var sums []image.Rectangle
+	var counts []int
+	class := make(map[int]int)
+	for i, r := range raw {
+		c, ok := class[root(i)]
+		if !ok {
+			c = len(sums)
+			class[root(i)] = c
+			sums = append(sums, image.Rectangle{})
+			counts = append(counts, 0)
+		}
+		sums[c].Min = sums[c].Min.Add(r.Min)
+		sums[c].Max = sums[c].Max.Add(r.Max)
+		counts[c]++
+	}
+
+	var rects []image.Rectangle
+	var weights []int
+	for c, sum := range sums {
+		if counts[c] <= minNeighbors {
+			continue
+		}
+		n := float64(counts[c])
+		x, y := math.Round(float64(sum.Min.X)/n), math.Round(float64(sum.Min.Y)/n)
+		w, h := math.Round(float64(sum.Dx())/n), math.Round(float64(sum.Dy())/n)
+		rects = append(rects, image.Rect(int(x), int(y), int(x+w), int(y+h)))
+		weights = append(weights, counts[c])
+	}
+
+	var faceRects []image.Rectangle
+	var confidences []float64
+	for i, r := range rects {
+		inner := false
+		for j, outer := range rects {
+			if i == j {
+				continue
+			}
+			d := image.Pt(int(math.Round(float64(outer.Dx())*eps)), int(math.Round(float64(outer.Dy())*eps)))
+			if r.In(image.Rectangle{Min: outer.Min.Sub(d), Max: outer.Max.Add(d)}) &&
+				(weights[j] > maxInt(3, weights[i]) || weights[i] < 3) {
+				inner = true
+				break
+			}
+		}
+		if !inner {
+			faceRects = append(faceRects, r)
+			confidences = append(confidences, float64(weights[i]))
+		}
+	}
+	return faceRects, confidences
+}
+
+// similarRects reports whether the corners of a and b are within eps of their
+// size of each other.
+func similarRects(a, b image.Rectangle, eps float64) bool {
+	delta := eps * float64(minInt(a.Dx(), b.Dx())+minInt(a.Dy(), b.Dy())) / 2
+	return math.Abs(float64(a.Min.X-b.Min.X)) <= delta &&
+		math.Abs(float64(a.Min.Y-b.Min.Y)) <= delta &&
+		math.Abs(float64(a.Max.X-b.Max.X)) <= delta &&
+		math.Abs(float64(a.Max.Y-b.Max.Y)) <= delta
+}
+
+// faceFractionOK reports whether no face covers more than Config.MaxFaceFraction
+// of the crop.
+func (sca *smartcropAnalyzer) faceFractionOK(crop Crop, faceRects []image.Rectangle) bool {
+	if sca.config.MaxFaceFraction <= 0 {
+		return true
+	}
+
+	cropRes := float64(crop.Dx() * crop.Dy())
+	for _, r := range faceRects {
+		in := r.Intersect(crop.Rectangle)
+		if float64(in.Dx()*in.Dy())/cropRes > sca.config.MaxFaceFraction {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyFraction returns the share of the crop area taken up by the region
+// Config.BodyExtension adds below face.
+func (sca *smartcropAnalyzer) bodyFraction(crop Crop, face image.Rectangle) float64 {
+	if sca.config.BodyExtension <= 0 {
+		return 0
+	}
+	height := int(math.Round(float64(face.Dy()) * sca.config.BodyExtension))
+	body := image.Rect(face.Min.X, face.Max.Y, face.Max.X, face.Max.Y+height).Intersect(crop.Rectangle)
+	return float64(body.Dx()*body.Dy()) / float64(crop.Dx()*crop.Dy())
+}
+
+// eyeLineScore returns the Config.EyeLineWeight term for the faces in the
+// crop.
+func (sca *smartcropAnalyzer) eyeLineScore(crop Crop, faceRects []image.Rectangle) float64 {
+	if sca.config.EyeLineWeight <= 0 {
+		return 0
+	}
+
+	third := float64(crop.Dy()) / 3
+	line := float64(crop.Min.Y) + third
+	var sum float64
+	var n int
+	for _, r := range faceRects {
+		if !sca.faceCounts(crop, r) {
+			continue
+		}
+		eyes := float64(r.Min.Y) + float64(r.Dy())/3
+		sum += math.Max(1-math.Abs(eyes-line)/third, 0)
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sca.config.EyeLineWeight * sum / float64(n)
+}
+
+// faceCounts reports whether face is within the crop and large enough to count
+// towards its score, see Config.FaceMinAreaFraction.
+func (sca *smartcropAnalyzer) faceCounts(crop Crop, face image.Rectangle) bool {
+	if !face.In(crop.Rectangle) {
+		return false
+	}
+	return float64(face.Dx()*face.Dy()) >= sca.config.FaceMinAreaFraction*float64(crop.Dx()*crop.Dy())
+}
@@ -0,0 +1,25 @@
+package smartcrop
+
+import (
+	"image"
+)
+
+// acceptable reports whether any of the crops reaches Config.MinAcceptableScore.
+func (sca *smartcropAnalyzer) acceptable(cs []Crop) bool {
+	if sca.config.MinAcceptableScore <= 0 {
+		return true
+	}
+	for _, crop := range cs {
+		if crop.Score.Normalized >= sca.config.MinAcceptableScore {
+			return true
+		}
+	}
+	return false
+}
+
+// centerCrop returns a width x height crop centered in bounds.
+func centerCrop(bounds image.Rectangle, width, height int) Crop {
+	x := bounds.Min.X + (bounds.Dx()-width)/2
+	y := bounds.Min.Y + (bounds.Dy()-height)/2
+	return Crop{Rectangle: image.Rect(x, y, x+width, y+height)}
+}
@@ -0,0 +1,55 @@
+package smartcrop
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+)
+
+// FocalPoint returns the center of the crop in image pixels. See the FocalPoint
+// function for the same point in percent.
+func (c Crop) FocalPoint() image.Point {
+	return image.Pt((c.Min.X+c.Max.X)/2, (c.Min.Y+c.Max.Y)/2)
+}
+
+// ImgproxyGravity returns the crop's center as an imgproxy focal point gravity,
+// "fp:x:y", for an image with the given bounds.
+func (c Crop) ImgproxyGravity(bounds image.Rectangle) string {
+	p := FocalPoint(bounds, c.Rectangle)
+	return "fp:" + fraction(p.X/100) + ":" + fraction(p.Y/100)
+}
+
+func fraction(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10000)/10000, 'f', -1, 64)
+}
+
+// ThumborFocal returns the Thumbor filter marking the crop as the focal region,
+// "focal(left x top:right x bottom)".
+func (c Crop) ThumborFocal() string {
+	return fmt.Sprintf("focal(%dx%d:%dx%d)", c.Min.X, c.Min.Y, c.Max.X, c.Max.Y)
+}
+
+// ThumborFocalPoint is the JSON representation of a focal point as stored by
+// Thumbor's smart detection, e.g. in its result storage.
+type ThumborFocalPoint struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Z      float64 `json:"z"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Origin string  `json:"origin"`
+}
+
+// ThumborFocalPoint returns the crop as a Thumbor focal point centered on it, with
+// its normalized score as the weight.
+func (c Crop) ThumborFocalPoint() ThumborFocalPoint {
+	return ThumborFocalPoint{
+		X:      float64(c.Min.X+c.Max.X) / 2,
+		Y:      float64(c.Min.Y+c.Max.Y) / 2,
+		Z:      c.Score.Normalized,
+		Width:  c.Dx(),
+		Height: c.Dy(),
+		Origin: "smartcrop",
+	}
+}
@@ -3,7 +3,13 @@ module github.com/third-light/smartcrop
 go 1.13
 
 require (
+	github.com/davidbyttow/govips/v2 v2.1.0
+	github.com/disintegration/imaging v1.6.2
+	github.com/makiuchi-d/gozxing v0.1.1
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
+	github.com/stretchr/testify v1.7.0 // indirect
 	gocv.io/x/gocv v0.21.0
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 )
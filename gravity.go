@@ -0,0 +1,139 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+	"strconv"
+)
+
+// Position is a point in an image in percent of its width and height, from 0 at
+// the top left to 100 at the bottom right.
+type Position struct {
+	X, Y float64
+}
+
+// String formats p like CSS does, e.g. "37.5% 0%".
+func (p Position) String() string {
+	return percent(p.X) + " " + percent(p.Y)
+}
+
+func percent(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64) + "%"
+}
+
+// FocalPoint returns the center of crop, as a position in the image with the
+// given bounds. This is the format most image CDNs take as a focal point.
+func FocalPoint(bounds, crop image.Rectangle) Position {
+	return Position{
+		X: (float64(crop.Min.X-bounds.Min.X) + float64(crop.Dx())/2) / float64(bounds.Dx()) * 100,
+		Y: (float64(crop.Min.Y-bounds.Min.Y) + float64(crop.Dy())/2) / float64(bounds.Dy()) * 100,
+	}
+}
+
+// CSSPosition returns the position that makes an image with the given bounds,
+// scaled with object-fit: cover or background-size: cover into a box of the
+// crop's aspect ratio, show exactly crop. CSS aligns the point at the given
+// percentage of the image with the same percentage of the box, so this is the
+// crop's offset relative to the space it can move in, not its center. For boxes
+// of other aspect ratios it keeps the crop centered as far as possible.
+func CSSPosition(bounds, crop image.Rectangle) Position {
+	return Position{
+		X: cssOffset(crop.Min.X-bounds.Min.X, bounds.Dx()-crop.Dx()),
+		Y: cssOffset(crop.Min.Y-bounds.Min.Y, bounds.Dy()-crop.Dy()),
+	}
+}
+
+func cssOffset(offset, room int) float64 {
+	if room <= 0 {
+		return 50
+	}
+	return float64(offset) / float64(room) * 100
+}
+
+// ObjectPosition returns the CSS object-position declaration for CSSPosition.
+func ObjectPosition(bounds, crop image.Rectangle) string {
+	return "object-position: " + CSSPosition(bounds, crop).String()
+}
+
+// BackgroundPosition returns the CSS background-position declaration for
+// CSSPosition.
+func BackgroundPosition(bounds, crop image.Rectangle) string {
+	return "background-position: " + CSSPosition(bounds, crop).String()
+}
+
+// Gravity is one of nine positions of a crop in an image, for systems that only
+// take a gravity keyword, such as ImageMagick's -gravity, instead of a
+// rectangle.
+type Gravity int
+
+const (
+	// GravityCenter is a crop in the middle of the image.
+	GravityCenter Gravity = iota
+	GravityNorthWest
+	GravityNorth
+	GravityNorthEast
+	GravityWest
+	GravityEast
+	GravitySouthWest
+	GravitySouth
+	GravitySouthEast
+)
+
+var gravityNames = [...]string{"Center", "NorthWest", "North", "NorthEast", "West", "East", "SouthWest", "South", "SouthEast"}
+
+// String returns the ImageMagick name of g, e.g. "NorthWest".
+func (g Gravity) String() string {
+	if g < 0 || int(g) >= len(gravityNames) {
+		return "Gravity(" + strconv.Itoa(int(g)) + ")"
+	}
+	return gravityNames[g]
+}
+
+// Anchor returns the position in the image g stands for, e.g. AnchorTop for
+// GravityNorth.
+func (g Gravity) Anchor() Anchor {
+	switch g {
+	case GravityNorthWest:
+		return Anchor{0, 0}
+	case GravityNorth:
+		return AnchorTop
+	case GravityNorthEast:
+		return Anchor{1, 0}
+	case GravityWest:
+		return AnchorLeft
+	case GravityEast:
+		return AnchorRight
+	case GravitySouthWest:
+		return Anchor{0, 1}
+	case GravitySouth:
+		return AnchorBottom
+	case GravitySouthEast:
+		return Anchor{1, 1}
+	}
+	return AnchorCenter
+}
+
+// Anchor returns the gravity closest to the crop in an image with the given
+// bounds. Like CSSPosition, it looks at where the crop lies in the room it can
+// move in, split into thirds on each axis, so cropping the image to the crop's
+// size with that gravity returns about the same crop. An axis the crop fills is
+// centered.
+func (c Crop) Anchor(bounds image.Rectangle) Gravity {
+	p := CSSPosition(bounds, c.Rectangle)
+	return [3][3]Gravity{
+		{GravityNorthWest, GravityNorth, GravityNorthEast},
+		{GravityWest, GravityCenter, GravityEast},
+		{GravitySouthWest, GravitySouth, GravitySouthEast},
+	}[third(p.Y)][third(p.X)]
+}
+
+// third returns in which third of 0 to 100 percent v lies.
+func third(v float64) int {
+	switch {
+	case v < 100.0/3:
+		return 0
+	case v > 200.0/3:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,18 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+)
+
+// grayCie returns the same lightness cie() would return for the gray pixel
+// after it has been converted to RGBA.
+func grayCie(c color.Gray) float64 {
+	return cie(color.RGBA{c.Y, c.Y, c.Y, 255})
+}
+
+// edgeDetectGray is the grayscale counterpart of edgeDetect. It reads the
+// image.Gray directly instead of requiring a conversion to image.RGBA first.
+func (sca *smartcropAnalyzer) edgeDetectGray(i *image.Gray, o *ScoreMap) {
+	o.setPlane(ChannelDetail, sca.edges(i).Values)
+}
@@ -0,0 +1,131 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// HashAlgorithm selects the perceptual hash Analyze computes, see
+// Config.ImageHash.
+type HashAlgorithm int
+
+const (
+	// HashNone computes no hash.
+	HashNone HashAlgorithm = iota
+	// HashAverage is the average hash: the image shrunk to 8x8 gray pixels, one
+	// bit per pixel set if it is brighter than the mean. It is cheap and
+	// survives scaling and recompression.
+	HashAverage
+	// HashPerceptual is the DCT based hash: the lowest 8x8 frequencies of the
+	// image shrunk to 32x32 gray pixels, one bit per frequency set if it is
+	// above the median. It also survives gamma and contrast changes.
+	HashPerceptual
+)
+
+// ImageHash returns the 64 bit perceptual hash of img, 0 with HashNone. Similar
+// images have hashes that differ in few bits, see HashDistance.
+func ImageHash(img image.Image, algorithm HashAlgorithm) uint64 {
+	switch algorithm {
+	case HashAverage:
+		values := grayGrid(img, 8)
+		var mean float64
+		for _, v := range values {
+			mean += v
+		}
+		return hashBits(values, mean/float64(len(values)))
+	case HashPerceptual:
+		freqs := dct8(grayGrid(img, 32), 32)
+		// the DC coefficient is the mean brightness, it would dominate the median
+		rest := append([]float64(nil), freqs[1:]...)
+		sort.Float64s(rest)
+		return hashBits(freqs, rest[len(rest)/2])
+	}
+	return 0
+}
+
+// HashDistance returns the number of bits in which two hashes returned by
+// ImageHash differ. Hashes of the same picture are typically less than 10 apart.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// hashBits returns the bits of values above threshold, the first value in the
+// most significant bit.
+func hashBits(values []float64, threshold float64) uint64 {
+	var h uint64
+	for _, v := range values {
+		h <<= 1
+		if v > threshold {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// grayGrid shrinks img to n x n luma values by averaging, row by row.
+func grayGrid(img image.Image, n int) []float64 {
+	b := img.Bounds()
+	if b.Empty() {
+		return make([]float64, n*n)
+	}
+	xs, ys := boxSpans(b.Dx(), n), boxSpans(b.Dy(), n)
+	out := make([]float64, n*n)
+
+	gray, isGray := img.(*image.Gray)
+	var rgba *image.RGBA
+	if !isGray {
+		rgba = toRGBA(img)
+	}
+	for gy, ySpan := range ys {
+		for gx, xSpan := range xs {
+			var sum float64
+			for y := b.Min.Y + ySpan[0]; y < b.Min.Y+ySpan[1]; y++ {
+				for x := b.Min.X + xSpan[0]; x < b.Min.X+xSpan[1]; x++ {
+					if isGray {
+						sum += float64(gray.Pix[gray.PixOffset(x, y)])
+						continue
+					}
+					p := rgba.Pix[rgba.PixOffset(x, y):]
+					sum += 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+				}
+			}
+			out[gy*n+gx] = sum / float64((ySpan[1]-ySpan[0])*(xSpan[1]-xSpan[0]))
+		}
+	}
+	return out
+}
+
+// dct8 returns the lowest 8x8 coefficients of the two-dimensional DCT-II of the
+// n x n values, row by row.
+func dct8(values []float64, n int) []float64 {
+	cosines := make([]float64, 8*n)
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cosines[u*n+x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+	// transform the rows, then the columns of the result
+	rows := make([]float64, n*8)
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += values[y*n+x] * cosines[u*n+x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	out := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y*8+u] * cosines[v*n+y]
+			}
+			out[v*8+u] = sum
+		}
+	}
+	return out
+}
@@ -0,0 +1,181 @@
+package smartcrop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// ColorConverter converts images to sRGB, see WithColorConverter. The icc
+// package implements it for the common matrix-based RGB profiles, such as
+// Adobe RGB and Display P3.
+type ColorConverter interface {
+	// ToSRGB returns img, which is encoded in the color space of the ICC
+	// profile, converted to sRGB. It fails for profiles it doesn't support.
+	ToSRGB(img image.Image, profile []byte) (image.Image, error)
+}
+
+// ICCImage is an image with the ICC profile embedded in its file, as returned
+// by DecodeWithProfile. The analyzer converts the analysis copy of an ICCImage
+// to sRGB with the ColorConverter of WithColorConverter.
+type ICCImage struct {
+	image.Image
+	Profile []byte
+}
+
+// DecodeWithProfile decodes an image like DecodeLimited and returns it as an
+// ICCImage if its file embeds an ICC profile.
+func DecodeWithProfile(r io.Reader, maxPixels int64) (image.Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := DecodeLimited(bytes.NewReader(data), maxPixels)
+	if err != nil {
+		return nil, "", err
+	}
+	// a malformed profile doesn't keep the image from being analyzed
+	if profile, err := ExtractICCProfile(data); err == nil && profile != nil {
+		img = ICCImage{Image: img, Profile: profile}
+	}
+	return img, format, nil
+}
+
+// ExtractICCProfile returns the ICC profile embedded in JPEG, PNG or WebP data,
+// nil if there is none. It fails with ErrMetadataTooLarge for a compressed PNG
+// profile that inflates to more than 4 MiB.
+func ExtractICCProfile(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return jpegICCProfile(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return pngICCProfile(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return webpICCProfile(data)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+var jpegICCHeader = []byte("ICC_PROFILE\x00")
+
+// jpegICCProfile reassembles the profile split over the APP2 segments of JPEG
+// data, each holding its sequence number, the number of segments and its part
+// of the profile.
+func jpegICCProfile(data []byte) ([]byte, error) {
+	var parts [][]byte
+	for pos := 2; ; {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, ErrInvalidImage
+		}
+		marker := data[pos+1]
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		if payload := data[pos+4 : end]; marker == 0xe2 && bytes.HasPrefix(payload, jpegICCHeader) {
+			payload = payload[len(jpegICCHeader):]
+			if len(payload) < 2 {
+				return nil, ErrInvalidImage
+			}
+			seq, count := int(payload[0]), int(payload[1])
+			if parts == nil {
+				parts = make([][]byte, count)
+			}
+			if seq < 1 || seq > len(parts) || count != len(parts) {
+				return nil, ErrInvalidImage
+			}
+			parts[seq-1] = payload[2:]
+		}
+		pos = end
+	}
+	if parts == nil {
+		return nil, nil
+	}
+	var profile []byte
+	for _, part := range parts {
+		if part == nil {
+			return nil, ErrInvalidImage
+		}
+		profile = append(profile, part...)
+	}
+	return profile, nil
+}
+
+// pngICCProfile returns the profile of the iCCP chunk of PNG data, which holds
+// the profile name, the compression method and the compressed profile.
+func pngICCProfile(data []byte) ([]byte, error) {
+	for pos := len(pngSignature); pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		switch string(data[pos+4 : pos+8]) {
+		case "iCCP":
+			chunk := data[pos+8 : pos+8+length]
+			n := bytes.IndexByte(chunk, 0)
+			if n < 0 || n+2 > len(chunk) || chunk[n+1] != 0 {
+				return nil, ErrInvalidImage
+			}
+			return inflate(chunk[n+2:])
+		case "IDAT":
+			// the profile comes before the image data
+			return nil, nil
+		}
+		pos = end
+	}
+	return nil, nil
+}
+
+// webpICCProfile returns the ICCP chunk of WebP data.
+func webpICCProfile(data []byte) ([]byte, error) {
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		// chunks are padded to an even length
+		end := pos + 8 + length + length&1
+		if length < 0 || pos+8+length > len(data) {
+			return nil, ErrInvalidImage
+		}
+		if string(data[pos:pos+4]) == "ICCP" {
+			return data[pos+8 : pos+8+length], nil
+		}
+		pos = end
+	}
+	return nil, nil
+}
+
+// splitProfile returns the image and the ICC profile of an ICCImage, and img
+// itself without a profile otherwise.
+func splitProfile(img image.Image) (image.Image, []byte) {
+	if i, ok := img.(ICCImage); ok {
+		return i.Image, i.Profile
+	}
+	return img, nil
+}
+
+// toSRGB converts img from the color space of profile to sRGB with the
+// ColorConverter, if there are both. Images the converter fails on are analyzed
+// as they are.
+func (sca *smartcropAnalyzer) toSRGB(img image.Image, profile []byte) image.Image {
+	if sca.colors == nil || len(profile) == 0 {
+		return img
+	}
+	converted, err := sca.colors.ToSRGB(img, profile)
+	if err != nil {
+		sca.logger.Log.Printf("analyzing without color conversion: %v\n", err)
+		return img
+	}
+	return converted
+}
@@ -0,0 +1,216 @@
+// Package icc converts images with an embedded ICC profile to sRGB for analysis,
+// so that wide-gamut images, such as Adobe RGB or Display P3 photos, are
+// analyzed with the colors they are displayed with:
+//
+//	analyzer := smartcrop.New(smartcrop.WithColorConverter(icc.Converter{}))
+//	img, _, err := smartcrop.DecodeWithProfile(f, 0)
+//	crop, err := analyzer.FindBestCrop(img, 300, 200)
+//
+// Converter only supports matrix-based RGB profiles, whose color space is given
+// by the XYZ colorants and tone curves of the red, green and blue channels. They
+// cover nearly all camera and display profiles photos are tagged with. Images
+// with other profiles are analyzed unconverted; a ColorConverter built on a full
+// color management library can handle them instead.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/third-light/smartcrop"
+)
+
+var (
+	// ErrInvalidProfile is returned for data that isn't an ICC profile.
+	ErrInvalidProfile = errors.New("Invalid ICC profile")
+	// ErrUnsupportedProfile is returned for profiles that aren't matrix-based
+	// RGB profiles.
+	ErrUnsupportedProfile = errors.New("Unsupported ICC profile")
+)
+
+// Converter is a smartcrop.ColorConverter for matrix-based RGB profiles.
+type Converter struct{}
+
+var _ smartcrop.ColorConverter = Converter{}
+
+// ToSRGB implements smartcrop.ColorConverter. The result is an *image.NRGBA.
+func (Converter) ToSRGB(img image.Image, profile []byte) (image.Image, error) {
+	t, err := parse(profile)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	for y := 0; y < b.Dy(); y++ {
+		row := dst.Pix[y*dst.Stride : y*dst.Stride+4*b.Dx()]
+		for i := 0; i < len(row); i += 4 {
+			t.apply(row[i : i+3 : i+3])
+		}
+	}
+	return dst, nil
+}
+
+// xyzToSRGB converts from the D50 profile connection space to linear sRGB (the
+// inverse of the Bradford adapted sRGB colorants).
+var xyzToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// transform converts 8-bit values of a profile's color space to sRGB.
+type transform struct {
+	// linear maps the values of each channel through its tone curve.
+	linear [3][256]float64
+	// matrix converts linear values to linear sRGB.
+	matrix [3][3]float64
+	// encode maps linear sRGB, in steps of 1/4095, to sRGB values.
+	encode [4096]uint8
+}
+
+func (t *transform) apply(rgb []uint8) {
+	r, g, b := t.linear[0][rgb[0]], t.linear[1][rgb[1]], t.linear[2][rgb[2]]
+	for c := 0; c < 3; c++ {
+		v := t.matrix[c][0]*r + t.matrix[c][1]*g + t.matrix[c][2]*b
+		rgb[c] = t.encode[int(math.Round(math.Max(0, math.Min(1, v))*4095))]
+	}
+}
+
+// parse returns the transform for a matrix-based RGB profile.
+func parse(profile []byte) (*transform, error) {
+	if len(profile) < 132 || string(profile[36:40]) != "acsp" {
+		return nil, ErrInvalidProfile
+	}
+	if string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+		return nil, ErrUnsupportedProfile
+	}
+	tags := make(map[string][]byte)
+	n := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < n; i++ {
+		if 132+12*(i+1) > len(profile) {
+			return nil, ErrInvalidProfile
+		}
+		entry := profile[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) > uint64(len(profile)) {
+			return nil, ErrInvalidProfile
+		}
+		tags[string(entry[:4])] = profile[offset : offset+size]
+	}
+
+	t := &transform{}
+	var colorants [3][3]float64
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag, ok := tags[sig]
+		if !ok {
+			return nil, ErrUnsupportedProfile
+		}
+		if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+			return nil, ErrInvalidProfile
+		}
+		for i := 0; i < 3; i++ {
+			colorants[i][c] = s15Fixed16(tag[8+4*i:])
+		}
+	}
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tag, ok := tags[sig]
+		if !ok {
+			return nil, ErrUnsupportedProfile
+		}
+		curve, err := toneCurve(tag)
+		if err != nil {
+			return nil, err
+		}
+		for v := range t.linear[c] {
+			t.linear[c][v] = curve(float64(v) / 255)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				t.matrix[i][j] += xyzToSRGB[i][k] * colorants[k][j]
+			}
+		}
+	}
+	for i := range t.encode {
+		t.encode[i] = uint8(math.Round(encodeSRGB(float64(i)/4095) * 255))
+	}
+	return t, nil
+}
+
+// toneCurve returns the function of a curveType or parametricCurveType tag,
+// mapping values to linear light.
+func toneCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, ErrInvalidProfile
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, ErrInvalidProfile
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(counts) || len(tag) < 12+4*counts[kind] {
+			return nil, ErrInvalidProfile
+		}
+		// the parameters g, a, b, c, d, e and f, as far as the function has
+		// them, of the general form (a*x+b)^g+e for x >= d and c*x+f below
+		p := [7]float64{1, 1}
+		for i := 0; i < counts[kind]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch kind {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(x float64) float64 {
+			if x < d {
+				return c*x + f
+			}
+			return math.Pow(math.Max(0, a*x+b), g) + e
+		}, nil
+	}
+	return nil, ErrUnsupportedProfile
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// encodeSRGB applies the sRGB transfer function to a linear value.
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
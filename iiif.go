@@ -0,0 +1,85 @@
+package smartcrop
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// IIIFRegion returns the crop as an IIIF Image API region parameter in pixels,
+// "x,y,w,h".
+func (c Crop) IIIFRegion() string {
+	return fmt.Sprintf("%d,%d,%d,%d", c.Min.X, c.Min.Y, c.Dx(), c.Dy())
+}
+
+// IIIFRegionPct returns the crop as an IIIF Image API region parameter in percent
+// of the image with the given bounds, "pct:x,y,w,h".
+func (c Crop) IIIFRegionPct(bounds image.Rectangle) string {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	return "pct:" + strings.Join([]string{
+		iiifNumber(float64(c.Min.X-bounds.Min.X) / w * 100),
+		iiifNumber(float64(c.Min.Y-bounds.Min.Y) / h * 100),
+		iiifNumber(float64(c.Dx()) / w * 100),
+		iiifNumber(float64(c.Dy()) / h * 100),
+	}, ",")
+}
+
+func iiifNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10000)/10000, 'f', -1, 64)
+}
+
+// ParseIIIFSize returns the crop size to pass to FindBestCrop for an IIIF Image
+// API size parameter, e.g. "300,200", for an image with the given bounds. Only
+// "w,h" and "^w,h" ask for a different aspect ratio, all other forms ("max",
+// "full", "w,", ",h", "pct:n", "!w,h" and their "^" variants) keep the image's.
+func ParseIIIFSize(size string, bounds image.Rectangle) (int, int, error) {
+	invalid := fmt.Errorf("Invalid IIIF size %q", size)
+	iw, ih := float64(bounds.Dx()), float64(bounds.Dy())
+	s := strings.TrimPrefix(size, "^")
+
+	switch {
+	case s == "max" || s == "full":
+		return bounds.Dx(), bounds.Dy(), nil
+	case strings.HasPrefix(s, "pct:"):
+		pct, err := strconv.ParseFloat(s[4:], 64)
+		if err != nil || pct <= 0 {
+			return 0, 0, invalid
+		}
+		return int(math.Round(iw * pct / 100)), int(math.Round(ih * pct / 100)), nil
+	}
+
+	fit := strings.HasPrefix(s, "!")
+	parts := strings.Split(strings.TrimPrefix(s, "!"), ",")
+	if len(parts) != 2 {
+		return 0, 0, invalid
+	}
+	var dims [2]int
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil || v <= 0 {
+			return 0, 0, invalid
+		}
+		dims[i] = v
+	}
+	w, h := dims[0], dims[1]
+
+	switch {
+	case w == 0 && h == 0:
+		return 0, 0, invalid
+	case fit && (w == 0 || h == 0):
+		return 0, 0, invalid
+	case fit:
+		scale := math.Min(float64(w)/iw, float64(h)/ih)
+		return int(math.Round(iw * scale)), int(math.Round(ih * scale)), nil
+	case w == 0:
+		return int(math.Round(iw * float64(h) / ih)), h, nil
+	case h == 0:
+		return w, int(math.Round(ih * float64(w) / iw)), nil
+	}
+	return w, h, nil
+}
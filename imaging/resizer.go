@@ -0,0 +1,47 @@
+// Package imaging implements an options.Resizer on top of
+// github.com/disintegration/imaging, for pipelines that already depend on it.
+package imaging
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+	"github.com/third-light/smartcrop/options"
+)
+
+type imagingResizer struct {
+	filter imaging.ResampleFilter
+}
+
+func (r imagingResizer) Resize(img image.Image, width, height uint) image.Image {
+	// a zero width or height preserves the aspect ratio in imaging as well
+	resized := imaging.Resize(img, int(width), int(height), r.filter)
+
+	// imaging always returns NRGBA, keep gray images gray for the grayscale fast
+	// path like the other resizers do
+	if _, ok := img.(*image.Gray); ok {
+		gray := image.NewGray(resized.Bounds())
+		draw.Draw(gray, gray.Bounds(), resized, resized.Bounds().Min, draw.Src)
+		return gray
+	}
+	return resized
+}
+
+// NewResizer creates a new Resizer with the given resampling filter, e.g.
+// imaging.Lanczos or imaging.Box.
+func NewResizer(filter imaging.ResampleFilter) options.Resizer {
+	return imagingResizer{filter: filter}
+}
+
+// NewDefaultResizer creates a new Resizer using imaging.CatmullRom, the bicubic
+// filter the other default resizers use as well.
+func NewDefaultResizer() options.Resizer {
+	return NewResizer(imaging.CatmullRom)
+}
+
+// NewFastResizer creates a new Resizer using imaging.Linear, trading quality for
+// speed.
+func NewFastResizer() options.Resizer {
+	return NewResizer(imaging.Linear)
+}
@@ -0,0 +1,114 @@
+package smartcrop
+
+import "image"
+
+// importanceKernels caches importance values. importance only depends on the crop
+// size and the position relative to the crop, and the score loops sample every
+// ScoreDownSample pixels, so crops of the same size whose origin has the same
+// phase relative to the sampling grid share all their values. The zero value
+// doesn't keep the kernels it computes.
+//
+// An optional mask, in analysis coordinates, multiplies the importance of each
+// pixel.
+type importanceKernels struct {
+	cache map[kernelKey][]float64
+	mask  *image.Gray
+}
+
+type kernelKey struct {
+	width, height  int
+	phaseX, phaseY int
+}
+
+func newImportanceKernels(mask *image.Gray) importanceKernels {
+	return importanceKernels{cache: make(map[kernelKey][]float64), mask: mask}
+}
+
+// importanceKernel holds the importance values of the sampled pixels of a crop.
+type importanceKernel struct {
+	crop           Crop
+	phaseX, phaseY int
+	nx, ds         int
+	outside        float64
+	values         []float64
+	mask           *image.Gray
+}
+
+// forCrop returns the kernel for crop, computing it if necessary.
+func (k importanceKernels) forCrop(sca *smartcropAnalyzer, crop Crop) importanceKernel {
+	ds := sca.config.ScoreDownSample
+	key := kernelKey{
+		width:  crop.Dx(),
+		height: crop.Dy(),
+		phaseX: mod(-crop.Min.X, ds),
+		phaseY: mod(-crop.Min.Y, ds),
+	}
+	nx := (key.width - key.phaseX + ds - 1) / ds
+	ny := (key.height - key.phaseY + ds - 1) / ds
+
+	// with Config.BlockScoring a sample stands for the block it starts, so it
+	// gets the importance of the block center, or of the last pixel of the crop
+	// for blocks the crop cuts off
+	var center int
+	if sca.config.BlockScoring {
+		center = ds / 2
+	}
+
+	values, ok := k.cache[key]
+	if !ok {
+		values = make([]float64, nx*ny)
+		for iy := 0; iy < ny; iy++ {
+			for ix := 0; ix < nx; ix++ {
+				x := minInt(crop.Min.X+key.phaseX+ix*ds+center, crop.Max.X-1)
+				y := minInt(crop.Min.Y+key.phaseY+iy*ds+center, crop.Max.Y-1)
+				values[iy*nx+ix] = sca.uncachedImportance(crop, x, y)
+			}
+		}
+		if k.cache != nil {
+			k.cache[key] = values
+		}
+	}
+
+	return importanceKernel{
+		crop:    crop,
+		phaseX:  key.phaseX,
+		phaseY:  key.phaseY,
+		nx:      nx,
+		ds:      ds,
+		outside: sca.config.OutsideImportance,
+		values:  values,
+		mask:    k.mask,
+	}
+}
+
+// at returns the importance of the sampled pixel x, y.
+func (k importanceKernel) at(x, y int) float64 {
+	if k.mask == nil {
+		return k.value(x, y)
+	}
+	// rounded explicitly for deterministic scoring
+	return float64(k.value(x, y) * float64(k.mask.GrayAt(x, y).Y) / 255)
+}
+
+func (k importanceKernel) value(x, y int) float64 {
+	c := k.crop
+	if c.Min.X > x || x >= c.Max.X || c.Min.Y > y || y >= c.Max.Y {
+		return k.outside
+	}
+	return k.values[(y-c.Min.Y-k.phaseY)/k.ds*k.nx+(x-c.Min.X-k.phaseX)/k.ds]
+}
+
+func (sca *smartcropAnalyzer) uncachedImportance(crop Crop, x, y int) float64 {
+	if sca.config.DeterministicScoring {
+		return sca.importanceDeterministic(crop, x, y)
+	}
+	return sca.importance(crop, x, y)
+}
+
+func mod(a, b int) int {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,96 @@
+package smartcrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// modulePath is the path of the smartcrop module, to find its version in the
+// build info.
+const modulePath = "github.com/third-light/smartcrop"
+
+// Info describes what an analyzer runs, so operators can verify a deployment.
+type Info struct {
+	// Version is the version of the smartcrop module the binary was built
+	// with, "(devel)" if it was built within the module and "unknown" without
+	// build info.
+	Version          string `json:"version"`
+	AlgorithmVersion int    `json:"algorithmVersion"`
+	// Detectors names the enabled detectors, among "edge", "skin",
+	// "saturation", "sharpness", "face", "sensitive", "templates", "barcodes"
+	// and "night".
+	Detectors []string `json:"detectors"`
+	// Models holds the SHA-256 of the model files loaded, by path.
+	Models map[string]string `json:"models,omitempty"`
+	// ConfigHash is Config.Hash in hex.
+	ConfigHash string `json:"configHash"`
+}
+
+// Info describes the analyzer.
+func (sca *smartcropAnalyzer) Info() Info {
+	info := Info{
+		Version:          moduleVersion(),
+		AlgorithmVersion: AlgorithmVersion,
+		Detectors:        []string{},
+		ConfigHash:       fmt.Sprintf("%016x", sca.config.Hash()),
+		Models:           sca.models,
+	}
+	for _, d := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"edge", !sca.config.DisableEdge},
+		{"skin", !sca.config.DisableSkin},
+		{"saturation", !sca.config.DisableSaturation},
+		{"sharpness", sca.config.SharpnessEnabled},
+		{"face", sca.config.FaceDetectEnabled},
+		{"sensitive", sca.sensitive != nil},
+		{"templates", len(sca.templates) > 0},
+		{"barcodes", sca.barcodes != nil},
+		{"night", sca.night != nil},
+	} {
+		if d.enabled {
+			info.Detectors = append(info.Detectors, d.name)
+		}
+	}
+	return info
+}
+
+// moduleVersion returns the version of the smartcrop module in the build info.
+func moduleVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, m := range bi.Deps {
+		if m.Path == modulePath {
+			if m.Replace != nil {
+				return m.Replace.Version
+			}
+			return m.Version
+		}
+	}
+	return "unknown"
+}
+
+// fileHash returns the SHA-256 of the file at path in hex, or the error reading
+// it.
+func fileHash(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "error: " + err.Error()
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,686 @@
+package smartcrop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	// ErrUnsupportedJPEG is returned by CropJPEG for progressive, arithmetic
+	// coded and multi-scan JPEG images.
+	ErrUnsupportedJPEG = errors.New("Only baseline JPEG images with a single scan can be cropped losslessly")
+	// ErrEmptyCrop is returned by CropJPEG when the crop doesn't overlap the
+	// image.
+	ErrEmptyCrop = errors.New("Crop is empty or outside the image")
+)
+
+// CropJPEG copies the JPEG image read from r to w, cropped to crop without
+// decoding and re-encoding the pixels, like jpegtran -crop. The DCT blocks within
+// the crop are copied as they are, so there is no generation loss and it is much
+// faster than a full decode and encode.
+//
+// Blocks can't be split, so the top left corner of the crop is moved up and left
+// to the closest MCU boundary, typically 8 or 16 pixels. CropJPEG returns the
+// rectangle it actually cropped to. Use Config.AlignTo to find crops that need
+// no adjustment. The right and bottom edge are kept as they are.
+//
+// The Huffman tables are optimized for the cropped image and restart markers are
+// dropped. Metadata segments are copied unchanged, including an Exif thumbnail
+// of the full image. Only baseline images with all components in a single scan
+// are supported, others return ErrUnsupportedJPEG.
+func CropJPEG(w io.Writer, r io.Reader, crop image.Rectangle) (image.Rectangle, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return image.Rectangle{}, ErrUnsupportedFormat
+	}
+	j, err := parseJPEG(data)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	out, cropped, err := j.crop(crop)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	_, err = w.Write(out)
+	return cropped, err
+}
+
+// jpegComponent is a color component of a JPEG frame.
+type jpegComponent struct {
+	id, h, v int
+	// td and ta are the DC and AC Huffman tables the scan codes it with
+	td, ta int
+	// blocks holds the coefficients of the blocks within the crop, in zigzag
+	// order, bw blocks per row
+	blocks [][64]int32
+	bw, bh int
+}
+
+// jpegFile is a parsed baseline JPEG image.
+type jpegFile struct {
+	// segments are copied to the output unchanged
+	segments      [][]byte
+	sof, sos      []byte
+	width, height int
+	// comps are in scan order
+	comps      []jpegComponent
+	hmax, vmax int
+	huff       [2][4]*huffTable
+	restart    int
+	scan       []byte
+}
+
+func parseJPEG(data []byte) (*jpegFile, error) {
+	j := &jpegFile{}
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, ErrInvalidImage
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// fill byte
+			pos++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		segment := data[pos:end]
+		payload := segment[4:]
+
+		var err error
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			err = j.parseFrame(segment)
+		case marker == 0xc4:
+			err = j.parseHuffman(payload)
+		case marker == 0xdd:
+			if len(payload) < 2 {
+				return nil, ErrInvalidImage
+			}
+			j.restart = int(binary.BigEndian.Uint16(payload))
+		case marker == 0xda:
+			if err := j.parseScan(segment); err != nil {
+				return nil, err
+			}
+			j.scan = data[end:]
+			return j, nil
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc8:
+			// progressive, lossless, hierarchical and arithmetic coding
+			return nil, ErrUnsupportedJPEG
+		case marker == 0xd9:
+			return nil, ErrInvalidImage
+		default:
+			j.segments = append(j.segments, segment)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos = end
+	}
+}
+
+func (j *jpegFile) parseFrame(segment []byte) error {
+	p := segment[4:]
+	if len(p) < 6 || p[0] != 8 {
+		return ErrUnsupportedJPEG
+	}
+	j.sof = segment
+	j.height = int(binary.BigEndian.Uint16(p[1:]))
+	j.width = int(binary.BigEndian.Uint16(p[3:]))
+	n := int(p[5])
+	if j.width == 0 || j.height == 0 || n == 0 || len(p) < 6+3*n {
+		return ErrInvalidImage
+	}
+	for i := 0; i < n; i++ {
+		c := p[6+3*i:]
+		comp := jpegComponent{id: int(c[0]), h: int(c[1] >> 4), v: int(c[1] & 15)}
+		if comp.h < 1 || comp.h > 4 || comp.v < 1 || comp.v > 4 {
+			return ErrInvalidImage
+		}
+		j.hmax, j.vmax = maxInt(j.hmax, comp.h), maxInt(j.vmax, comp.v)
+		j.comps = append(j.comps, comp)
+	}
+	return nil
+}
+
+func (j *jpegFile) parseHuffman(p []byte) error {
+	for len(p) > 0 {
+		if len(p) < 17 {
+			return ErrInvalidImage
+		}
+		class, id := int(p[0]>>4), int(p[0]&15)
+		if class > 1 || id > 3 {
+			return ErrInvalidImage
+		}
+		var counts [16]int
+		n := 0
+		for i := range counts {
+			counts[i] = int(p[1+i])
+			n += counts[i]
+		}
+		if len(p) < 17+n {
+			return ErrInvalidImage
+		}
+		j.huff[class][id] = newHuffTable(counts, p[17:17+n])
+		p = p[17+n:]
+	}
+	return nil
+}
+
+func (j *jpegFile) parseScan(segment []byte) error {
+	p := segment[4:]
+	if j.sof == nil || len(p) < 1 {
+		return ErrInvalidImage
+	}
+	n := int(p[0])
+	if n != len(j.comps) {
+		// the components are coded in several scans
+		return ErrUnsupportedJPEG
+	}
+	if len(p) < 1+2*n {
+		return ErrInvalidImage
+	}
+	comps := make([]jpegComponent, 0, n)
+	for i := 0; i < n; i++ {
+		id, tables := int(p[1+2*i]), p[2+2*i]
+		found := false
+		for _, c := range j.comps {
+			if c.id == id {
+				c.td, c.ta = int(tables>>4), int(tables&15)
+				if c.td > 3 || c.ta > 3 || j.huff[0][c.td] == nil || j.huff[1][c.ta] == nil {
+					return ErrInvalidImage
+				}
+				comps = append(comps, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrInvalidImage
+		}
+	}
+	j.comps = comps
+	j.sos = segment
+	return nil
+}
+
+// mcuSize returns the size of an MCU in pixels.
+func (j *jpegFile) mcuSize() (int, int) {
+	if len(j.comps) == 1 {
+		// a single component is coded block by block, in its own resolution
+		return 8 * j.hmax / j.comps[0].h, 8 * j.vmax / j.comps[0].v
+	}
+	return 8 * j.hmax, 8 * j.vmax
+}
+
+// blocksPerMCU returns how many blocks of c an MCU holds in each direction.
+func (j *jpegFile) blocksPerMCU(c jpegComponent) (int, int) {
+	if len(j.comps) == 1 {
+		return 1, 1
+	}
+	return c.h, c.v
+}
+
+// crop decodes the coefficients of the MCUs within r and encodes them as a new
+// image, returning it along with the rectangle it covers.
+func (j *jpegFile) crop(r image.Rectangle) ([]byte, image.Rectangle, error) {
+	r = r.Intersect(image.Rect(0, 0, j.width, j.height))
+	if r.Empty() {
+		return nil, image.Rectangle{}, ErrEmptyCrop
+	}
+	mcuW, mcuH := j.mcuSize()
+	r.Min = image.Pt(r.Min.X/mcuW*mcuW, r.Min.Y/mcuH*mcuH)
+	mcusX, mcusY := (j.width+mcuW-1)/mcuW, (j.height+mcuH-1)/mcuH
+	mx0, my0 := r.Min.X/mcuW, r.Min.Y/mcuH
+	nmx, nmy := (r.Dx()+mcuW-1)/mcuW, (r.Dy()+mcuH-1)/mcuH
+
+	for i := range j.comps {
+		c := &j.comps[i]
+		bx, by := j.blocksPerMCU(*c)
+		c.bw, c.bh = nmx*bx, nmy*by
+		c.blocks = make([][64]int32, c.bw*c.bh)
+	}
+
+	br := &jpegBitReader{data: j.scan}
+	preds := make([]int32, len(j.comps))
+	var scratch [64]int32
+	mcu := 0
+decode:
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if my >= my0+nmy {
+				break decode
+			}
+			if j.restart > 0 && mcu > 0 && mcu%j.restart == 0 {
+				if err := br.restart(); err != nil {
+					return nil, image.Rectangle{}, err
+				}
+				for i := range preds {
+					preds[i] = 0
+				}
+			}
+			inside := mx >= mx0 && mx < mx0+nmx && my >= my0
+			for i := range j.comps {
+				c := &j.comps[i]
+				bx, by := j.blocksPerMCU(*c)
+				for y := 0; y < by; y++ {
+					for x := 0; x < bx; x++ {
+						blk := &scratch
+						if inside {
+							blk = &c.blocks[((my-my0)*by+y)*c.bw+(mx-mx0)*bx+x]
+						} else {
+							scratch = [64]int32{}
+						}
+						if err := br.decodeBlock(j.huff[0][c.td], j.huff[1][c.ta], &preds[i], blk); err != nil {
+							return nil, image.Rectangle{}, err
+						}
+					}
+				}
+			}
+			mcu++
+		}
+	}
+
+	// count the symbols first, so the Huffman tables fit the cropped image
+	var freqs [2][4][257]int
+	j.eachBlock(nmx, nmy, func(c *jpegComponent, blk *[64]int32, pred int32) {
+		countBlock(&freqs[0][c.td], &freqs[1][c.ta], blk, pred)
+	})
+	var tables [2][4]*huffTable
+	dht := []byte{0xff, 0xc4, 0, 0}
+	for class := range tables {
+		for id := range tables[class] {
+			used := false
+			for _, c := range j.comps {
+				used = used || (class == 0 && c.td == id) || (class == 1 && c.ta == id)
+			}
+			if !used {
+				continue
+			}
+			counts, values := optimalHuffman(freqs[class][id])
+			tables[class][id] = newHuffTable(counts, values)
+			dht = append(dht, byte(class<<4|id))
+			for _, n := range counts {
+				dht = append(dht, byte(n))
+			}
+			dht = append(dht, values...)
+		}
+	}
+	binary.BigEndian.PutUint16(dht[2:], uint16(len(dht)-2))
+
+	var out bytes.Buffer
+	out.Write([]byte{0xff, 0xd8})
+	for _, s := range j.segments {
+		out.Write(s)
+	}
+	sof := append([]byte{}, j.sof...)
+	binary.BigEndian.PutUint16(sof[5:], uint16(r.Dy()))
+	binary.BigEndian.PutUint16(sof[7:], uint16(r.Dx()))
+	out.Write(sof)
+	out.Write(dht)
+	out.Write(j.sos)
+
+	bw := &jpegBitWriter{out: &out}
+	j.eachBlock(nmx, nmy, func(c *jpegComponent, blk *[64]int32, pred int32) {
+		bw.encodeBlock(tables[0][c.td], tables[1][c.ta], blk, pred)
+	})
+	bw.flush()
+	out.Write([]byte{0xff, 0xd9})
+	return out.Bytes(), r, nil
+}
+
+// eachBlock calls fn for the cropped blocks in coding order, with the DC value
+// of the previous block of the same component.
+func (j *jpegFile) eachBlock(nmx, nmy int, fn func(c *jpegComponent, blk *[64]int32, pred int32)) {
+	preds := make([]int32, len(j.comps))
+	for my := 0; my < nmy; my++ {
+		for mx := 0; mx < nmx; mx++ {
+			for i := range j.comps {
+				c := &j.comps[i]
+				bx, by := j.blocksPerMCU(*c)
+				for y := 0; y < by; y++ {
+					for x := 0; x < bx; x++ {
+						blk := &c.blocks[(my*by+y)*c.bw+mx*bx+x]
+						fn(c, blk, preds[i])
+						preds[i] = blk[0]
+					}
+				}
+			}
+		}
+	}
+}
+
+// huffTable is a JPEG Huffman table, with the lookup tables for both decoding
+// and encoding.
+type huffTable struct {
+	values  []byte
+	mincode [17]int32
+	maxcode [17]int32
+	valptr  [17]int
+	code    [256]uint16
+	size    [256]uint8
+}
+
+func newHuffTable(counts [16]int, values []byte) *huffTable {
+	t := &huffTable{values: values}
+	code, k := int32(0), 0
+	for l := 1; l <= 16; l++ {
+		n := counts[l-1]
+		t.valptr[l] = k
+		t.mincode[l] = code
+		t.maxcode[l] = code + int32(n) - 1
+		for i := 0; i < n && k < len(values); i++ {
+			t.code[values[k]] = uint16(code)
+			t.size[values[k]] = uint8(l)
+			code++
+			k++
+		}
+		if n == 0 {
+			t.maxcode[l] = -1
+		}
+		code <<= 1
+	}
+	return t
+}
+
+// jpegBitReader reads the entropy coded data of a scan.
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  byte
+	n    uint
+}
+
+func (b *jpegBitReader) bit() (int32, error) {
+	if b.n == 0 {
+		if b.pos >= len(b.data) {
+			return 0, ErrInvalidImage
+		}
+		c := b.data[b.pos]
+		b.pos++
+		if c == 0xff {
+			// a stuffed zero byte, anything else is a marker in the middle of the data
+			if b.pos >= len(b.data) || b.data[b.pos] != 0 {
+				return 0, ErrInvalidImage
+			}
+			b.pos++
+		}
+		b.acc, b.n = c, 8
+	}
+	b.n--
+	return int32(b.acc>>b.n) & 1, nil
+}
+
+// receive reads an s bit value and extends its sign.
+func (b *jpegBitReader) receive(s int) (int32, error) {
+	var v int32
+	for i := 0; i < s; i++ {
+		bit, err := b.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	if s > 0 && v < 1<<uint(s-1) {
+		v += -1<<uint(s) + 1
+	}
+	return v, nil
+}
+
+func (b *jpegBitReader) decode(t *huffTable) (int, error) {
+	var code int32
+	for l := 1; l <= 16; l++ {
+		bit, err := b.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | bit
+		if code <= t.maxcode[l] {
+			i := t.valptr[l] + int(code-t.mincode[l])
+			if i >= len(t.values) {
+				return 0, ErrInvalidImage
+			}
+			return int(t.values[i]), nil
+		}
+	}
+	return 0, ErrInvalidImage
+}
+
+// restart skips the restart marker ending an interval.
+func (b *jpegBitReader) restart() error {
+	b.n = 0
+	if b.pos+1 >= len(b.data) || b.data[b.pos] != 0xff || b.data[b.pos+1] < 0xd0 || b.data[b.pos+1] > 0xd7 {
+		return ErrInvalidImage
+	}
+	b.pos += 2
+	return nil
+}
+
+func (b *jpegBitReader) decodeBlock(dc, ac *huffTable, pred *int32, blk *[64]int32) error {
+	s, err := b.decode(dc)
+	if err != nil {
+		return err
+	}
+	diff, err := b.receive(s)
+	if err != nil {
+		return err
+	}
+	*pred += diff
+	blk[0] = *pred
+	for k := 1; k < 64; {
+		rs, err := b.decode(ac)
+		if err != nil {
+			return err
+		}
+		r, s := rs>>4, rs&15
+		if s == 0 {
+			if r != 15 {
+				// end of block
+				break
+			}
+			k += 16
+			continue
+		}
+		k += r
+		if k > 63 {
+			return ErrInvalidImage
+		}
+		if blk[k], err = b.receive(s); err != nil {
+			return err
+		}
+		k++
+	}
+	return nil
+}
+
+// category returns the number of bits needed for v.
+func category(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// countBlock counts the Huffman symbols encoding blk takes.
+func countBlock(dc, ac *[257]int, blk *[64]int32, pred int32) {
+	dc[category(blk[0]-pred)]++
+	run := 0
+	for k := 1; k < 64; k++ {
+		if blk[k] == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			ac[0xf0]++
+		}
+		ac[run<<4|category(blk[k])]++
+		run = 0
+	}
+	if run > 0 {
+		ac[0]++
+	}
+}
+
+// jpegBitWriter writes entropy coded data, stuffing a zero after every 0xff.
+type jpegBitWriter struct {
+	out *bytes.Buffer
+	acc uint32
+	n   uint
+}
+
+func (w *jpegBitWriter) write(bits uint32, n uint) {
+	w.acc = w.acc<<n | bits&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		c := byte(w.acc >> (w.n - 8))
+		w.out.WriteByte(c)
+		if c == 0xff {
+			w.out.WriteByte(0)
+		}
+		w.n -= 8
+	}
+	w.acc &= 1<<w.n - 1
+}
+
+// flush pads the last byte with ones.
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.write(0xff, 8-w.n)
+	}
+}
+
+func (w *jpegBitWriter) symbol(t *huffTable, sym int) {
+	w.write(uint32(t.code[sym]), uint(t.size[sym]))
+}
+
+// value writes v in s bits, negative values as v-1.
+func (w *jpegBitWriter) value(v int32, s int) {
+	if v < 0 {
+		v--
+	}
+	w.write(uint32(v), uint(s))
+}
+
+func (w *jpegBitWriter) encodeBlock(dc, ac *huffTable, blk *[64]int32, pred int32) {
+	diff := blk[0] - pred
+	s := category(diff)
+	w.symbol(dc, s)
+	w.value(diff, s)
+	run := 0
+	for k := 1; k < 64; k++ {
+		if blk[k] == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			w.symbol(ac, 0xf0)
+		}
+		s := category(blk[k])
+		w.symbol(ac, run<<4|s)
+		w.value(blk[k], s)
+		run = 0
+	}
+	if run > 0 {
+		w.symbol(ac, 0)
+	}
+}
+
+// optimalHuffman returns the code length counts and symbols of a Huffman table
+// for the given symbol frequencies, limited to 16 bit codes as in section K.2 of
+// the JPEG specification.
+func optimalHuffman(freq [257]int) ([16]int, []byte) {
+	var size [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	// a reserved symbol ensures no code consists of ones only
+	freq[256] = 1
+	for {
+		c1, c2 := -1, -1
+		for i, f := range freq {
+			if f == 0 {
+				continue
+			}
+			if c1 < 0 || f <= freq[c1] {
+				c1 = i
+			}
+		}
+		for i, f := range freq {
+			if f == 0 || i == c1 {
+				continue
+			}
+			if c2 < 0 || f <= freq[c2] {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		size[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			size[c1]++
+		}
+		others[c1] = c2
+		size[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			size[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, s := range size {
+		if s > 0 {
+			bits[s]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// drop the reserved symbol, which has the longest code
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var counts [16]int
+	copy(counts[:], bits[1:17])
+	var values []byte
+	for s := 1; s <= 32; s++ {
+		for sym := 0; sym < 256; sym++ {
+			if size[sym] == s {
+				values = append(values, byte(sym))
+			}
+		}
+	}
+	return counts, values
+}
@@ -0,0 +1,90 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// limited returns the analyzer to use for candidates in bounds, with Step derived
+// from Config.OriginalStep if set and coarsened as far as necessary to generate
+// at most Config.MaxCandidates of them per pass, or a single one per scale if
+// there are more scales than that. It reports whether Step had to be coarsened.
+func (sca *smartcropAnalyzer) limited(bounds image.Rectangle, cropWidth, cropHeight, realMinScale, prescalefactor float64) (*smartcropAnalyzer, bool) {
+	base := sca.analysisStep(bounds, prescalefactor)
+	max := sca.config.MaxCandidates
+	if max <= 0 {
+		return sca.withStep(base), false
+	}
+
+	step := base
+	n := sca.candidateCount(bounds, cropWidth, cropHeight, realMinScale, step)
+	if n <= max {
+		return sca.withStep(base), false
+	}
+	// with a step beyond the size of bounds, every scale is down to a single
+	// candidate, which is as few as there get
+	limit := maxInt(bounds.Dx(), bounds.Dy())
+	for n > max && step < limit {
+		// the count falls with the square of the step
+		s := int(math.Ceil(float64(step) * math.Sqrt(float64(n)/float64(max))))
+		if s <= step {
+			s = step + 1
+		}
+		step = minInt(s, limit)
+		n = sca.candidateCount(bounds, cropWidth, cropHeight, realMinScale, step)
+	}
+	sca.logger.Log.Printf("more than %d candidates, coarsening step from %d to %d\n", max, base, step)
+	return sca.withStep(step), true
+}
+
+// analysisStep returns the step between candidates in analysis pixels, from
+// Config.OriginalStep if set and Config.Step otherwise.
+func (sca *smartcropAnalyzer) analysisStep(bounds image.Rectangle, prescalefactor float64) int {
+	s := sca.config.OriginalStep
+	switch {
+	case s <= 0:
+		return sca.config.Step
+	case s < 1:
+		return maxInt(1, int(math.Round(s*math.Min(float64(bounds.Dx()), float64(bounds.Dy())))))
+	default:
+		return maxInt(1, int(math.Round(s*prescalefactor)))
+	}
+}
+
+// withStep returns sca with Config.Step set to step, sca itself if it has it
+// already.
+func (sca *smartcropAnalyzer) withStep(step int) *smartcropAnalyzer {
+	if step == sca.config.Step {
+		return sca
+	}
+	c := *sca
+	c.config.Step = step
+	return &c
+}
+
+// candidateCount returns the number of candidates GridCandidates generates in
+// bounds for the given step. Other generators usually generate fewer.
+func (sca *smartcropAnalyzer) candidateCount(bounds image.Rectangle, cropWidth, cropHeight, realMinScale float64, step int) int {
+	cropW, cropH := cropSize(bounds, cropWidth, cropHeight)
+	n := 0
+	for scale := sca.config.MaxScale; scale >= realMinScale; scale -= sca.config.ScaleStep {
+		nx := (float64(bounds.Dx()) - cropW*scale) / float64(step)
+		ny := (float64(bounds.Dy()) - cropH*scale) / float64(step)
+		if nx >= 0 && ny >= 0 {
+			n += (int(nx) + 1) * (int(ny) + 1)
+		}
+	}
+	return n
+}
+
+// pixelLimit returns the factor an image with bounds, already scaled by
+// prescalefactor, has to be scaled by in addition to stay within
+// Config.MaxAnalysisPixels, 1 if it doesn't exceed it.
+func (sca *smartcropAnalyzer) pixelLimit(bounds image.Rectangle, prescalefactor float64) float64 {
+	max := sca.config.MaxAnalysisPixels
+	pixels := float64(bounds.Dx()) * float64(bounds.Dy()) * prescalefactor * prescalefactor
+	if max <= 0 || pixels <= float64(max) {
+		return 1.0
+	}
+	return math.Sqrt(float64(max) / pixels)
+}
@@ -0,0 +1,28 @@
+package smartcrop
+
+import (
+	"image/color"
+
+	"github.com/third-light/smartcrop/detect"
+)
+
+// cieLinear returns the CIE lightness L* of c, scaled to the range of cie(), see
+// detect.LinearLightness.
+func cieLinear(c color.RGBA) float64 {
+	return detect.LinearLightness(c)
+}
+
+// lightness returns the function the detectors compute lightness with, that of
+// Config.ColorSpace if set, cieLinear with Config.LinearLight and cie otherwise.
+func (sca *smartcropAnalyzer) lightness() func(color.RGBA) float64 {
+	switch sca.config.ColorSpace {
+	case ColorSpaceCIELAB:
+		return cieLinear
+	case ColorSpaceOKLab:
+		return detect.OKLabLightness
+	}
+	if sca.config.LinearLight {
+		return cieLinear
+	}
+	return cie
+}
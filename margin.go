@@ -0,0 +1,46 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// cropArea returns the part of bounds all candidate crops have to lie within to
+// keep Config.EdgeMargin. Where even the smallest crop doesn't fit, the margin on
+// that axis is reduced as far as necessary.
+func (sca *smartcropAnalyzer) cropArea(bounds image.Rectangle, cropWidth, cropHeight, realMinScale, prescalefactor float64) image.Rectangle {
+	margin := sca.edgeMargin(bounds, prescalefactor)
+	if margin == 0 {
+		return bounds
+	}
+
+	cropW, cropH := cropSize(bounds, cropWidth, cropHeight)
+	mx := marginFor(margin, bounds.Dx(), cropW*realMinScale)
+	my := marginFor(margin, bounds.Dy(), cropH*realMinScale)
+	return image.Rect(bounds.Min.X+mx, bounds.Min.Y+my, bounds.Max.X-mx, bounds.Max.Y-my)
+}
+
+// edgeMargin returns Config.EdgeMargin in analysis pixels.
+func (sca *smartcropAnalyzer) edgeMargin(bounds image.Rectangle, prescalefactor float64) int {
+	m := sca.config.EdgeMargin
+	switch {
+	case m <= 0:
+		return 0
+	case m < 1:
+		return int(math.Ceil(m * math.Min(float64(bounds.Dx()), float64(bounds.Dy()))))
+	default:
+		return int(math.Ceil(m * prescalefactor))
+	}
+}
+
+// marginFor returns the largest margin up to margin that leaves room for a crop
+// of the given size on an axis of the given length.
+func marginFor(margin, length int, size float64) int {
+	if room := int((float64(length) - size) / 2); room < margin {
+		if room < 0 {
+			return 0
+		}
+		return room
+	}
+	return margin
+}
@@ -0,0 +1,43 @@
+package smartcrop
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// ErrMaskSize is returned when an importance mask doesn't have the size of the
+// image.
+var ErrMaskSize = errors.New("Mask size doesn't match the image size")
+
+// FindBestCropWithMask works like FindBestCrop, but multiplies the importance of
+// each pixel with the corresponding mask value, from 0 for black to 1 for white.
+// This way e.g. a segmentation mask can steer the crop towards the subject. The
+// mask has to be the size of img.
+func (sca *smartcropAnalyzer) FindBestCropWithMask(img image.Image, width, height int, mask *image.Gray) (image.Rectangle, error) {
+	res, err := sca.analyze(img, width, height, mask)
+	return res.Crop.Rectangle, err
+}
+
+// analysisMask scales mask, which covers the original image bounds, to the
+// analysis image bounds. A nil mask stays nil.
+func (sca *smartcropAnalyzer) analysisMask(mask *image.Gray, bounds, analysisBounds image.Rectangle) (*image.Gray, error) {
+	if mask == nil {
+		return nil, nil
+	}
+	if mask.Bounds().Size() != bounds.Size() {
+		return nil, ErrMaskSize
+	}
+
+	var src image.Image = mask
+	if analysisBounds.Size() != bounds.Size() {
+		var err error
+		src, err = sca.analysisResize(mask, uint(analysisBounds.Dx()), uint(analysisBounds.Dy()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := image.NewGray(analysisBounds)
+	draw.Draw(out, analysisBounds, src, src.Bounds().Min, draw.Src)
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package smartcrop
+
+import "time"
+
+// MetricsSink receives measurements of the analyses of an analyzer, see
+// WithMetrics. Implementations must be safe for concurrent use. The metrics
+// package implements it with Prometheus collectors.
+type MetricsSink interface {
+	// Analysis is called when an analysis ends, with its duration and error.
+	Analysis(d time.Duration, err error)
+	// Stage is called when a stage of an analysis ends, with one of the Stage
+	// constants or StagePrescale or StageCandidates as stage.
+	Stage(stage string, d time.Duration)
+	// Candidates is called with the number of candidate crops scored.
+	Candidates(n int)
+	// Faces is called with the number of faces detected.
+	Faces(n int)
+	// Fallback is called when an analysis falls back to a crop not chosen by
+	// its score, FallbackCentered or FallbackFullImage.
+	Fallback(kind string)
+}
+
+// The stages reported to MetricsSink besides the stages reported to
+// Config.ProgressFunc.
+const (
+	StagePrescale   = "prescale"
+	StageCandidates = "candidates"
+)
+
+// The fallbacks reported to MetricsSink.
+const (
+	// FallbackCentered is the centered crop taken when no candidate reaches
+	// Config.MinAcceptableScore.
+	FallbackCentered = "centered"
+	// FallbackFullImage is the full image taken with Config.FullImageFallback
+	// when no candidate is left.
+	FallbackFullImage = "full_image"
+)
+
+// observeStage reports the stage that started at start to the MetricsSink and
+// the Tracer, if there are any.
+func (sca *smartcropAnalyzer) observeStage(stage string, start time.Time) {
+	if sca.metrics == nil && sca.tracer == nil {
+		return
+	}
+	end := time.Now()
+	if sca.metrics != nil {
+		sca.metrics.Stage(stage, end.Sub(start))
+	}
+	if sca.tracer != nil {
+		sca.tracer.Stage(sca.context(), stage, start, end)
+	}
+}
+
+// observeFallback reports a fallback to the MetricsSink, if there is one.
+func (sca *smartcropAnalyzer) observeFallback(kind string) {
+	if sca.metrics != nil {
+		sca.metrics.Fallback(kind)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/third-light/smartcrop/metrics
+
+go 1.13
+
+require (
+	github.com/prometheus/client_golang v1.11.0
+	github.com/third-light/smartcrop v0.4.0
+)
+
+// Within this repository the module is built against the working tree. Modules
+// depending on it ignore the replace and get the smartcrop version required.
+replace github.com/third-light/smartcrop => ../
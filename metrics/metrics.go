@@ -0,0 +1,107 @@
+// Package metrics exports the measurements of smartcrop analyses as Prometheus
+// metrics:
+//
+//	sink, err := metrics.New(prometheus.DefaultRegisterer)
+//	if err != nil {
+//		// ...
+//	}
+//	analyzer := smartcrop.New(smartcrop.WithMetrics(sink))
+//
+// It registers the following metrics:
+//
+//	smartcrop_analyses_total{result="ok"|"error"}  counter
+//	smartcrop_analysis_duration_seconds            histogram
+//	smartcrop_stage_duration_seconds{stage}        histogram, stage as in smartcrop.MetricsSink
+//	smartcrop_candidates                           histogram of candidates scored per pass
+//	smartcrop_faces_detected                       histogram of faces per image
+//	smartcrop_fallbacks_total{kind}                counter, kind as in smartcrop.MetricsSink
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/third-light/smartcrop"
+)
+
+// Sink is a smartcrop.MetricsSink updating Prometheus collectors.
+type Sink struct {
+	analyses   *prometheus.CounterVec
+	duration   prometheus.Histogram
+	stages     *prometheus.HistogramVec
+	candidates prometheus.Histogram
+	faces      prometheus.Histogram
+	fallbacks  *prometheus.CounterVec
+}
+
+var _ smartcrop.MetricsSink = (*Sink)(nil)
+
+// New returns a Sink with its collectors registered with reg.
+func New(reg prometheus.Registerer) (*Sink, error) {
+	s := &Sink{
+		analyses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smartcrop_analyses_total",
+			Help: "Number of analyses, by result.",
+		}, []string{"result"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "smartcrop_analysis_duration_seconds",
+			Help:    "Duration of analyses.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}),
+		stages: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "smartcrop_stage_duration_seconds",
+			Help:    "Duration of the stages of analyses.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"stage"}),
+		candidates: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "smartcrop_candidates",
+			Help:    "Number of candidate crops scored per pass.",
+			Buckets: prometheus.ExponentialBuckets(16, 2, 12),
+		}),
+		faces: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "smartcrop_faces_detected",
+			Help:    "Number of faces detected per image.",
+			Buckets: []float64{0, 1, 2, 3, 5, 10, 20},
+		}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smartcrop_fallbacks_total",
+			Help: "Number of analyses that fell back to a crop not chosen by its score, by kind.",
+		}, []string{"kind"}),
+	}
+	for _, c := range []prometheus.Collector{s.analyses, s.duration, s.stages, s.candidates, s.faces, s.fallbacks} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Analysis implements smartcrop.MetricsSink.
+func (s *Sink) Analysis(d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.analyses.WithLabelValues(result).Inc()
+	s.duration.Observe(d.Seconds())
+}
+
+// Stage implements smartcrop.MetricsSink.
+func (s *Sink) Stage(stage string, d time.Duration) {
+	s.stages.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+// Candidates implements smartcrop.MetricsSink.
+func (s *Sink) Candidates(n int) {
+	s.candidates.Observe(float64(n))
+}
+
+// Faces implements smartcrop.MetricsSink.
+func (s *Sink) Faces(n int) {
+	s.faces.Observe(float64(n))
+}
+
+// Fallback implements smartcrop.MetricsSink.
+func (s *Sink) Fallback(kind string) {
+	s.fallbacks.WithLabelValues(kind).Inc()
+}
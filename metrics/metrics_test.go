@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+// value returns the value of the counter or the sample count of the histogram
+// name with the label value, if given, in reg.
+func value(t *testing.T, reg *prometheus.Registry, name, label string) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum float64
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if label != "" && (len(m.GetLabel()) == 0 || m.GetLabel()[0].GetValue() != label) {
+				continue
+			}
+			if c := m.GetCounter(); c != nil {
+				sum += c.GetValue()
+			}
+			if h := m.GetHistogram(); h != nil {
+				sum += float64(h.GetSampleCount())
+			}
+		}
+	}
+	return sum
+}
+
+func TestSink(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(reg); err == nil {
+		t.Fatal("expected registering the collectors twice to fail")
+	}
+
+	sink.Analysis(time.Millisecond, nil)
+	sink.Analysis(time.Millisecond, errors.New("failed"))
+	sink.Stage(smartcrop.StageEdge, time.Millisecond)
+	sink.Candidates(100)
+	sink.Faces(2)
+	sink.Fallback(smartcrop.FallbackCentered)
+	for _, m := range []struct {
+		name, label string
+		want        float64
+	}{
+		{"smartcrop_analyses_total", "ok", 1},
+		{"smartcrop_analyses_total", "error", 1},
+		{"smartcrop_analysis_duration_seconds", "", 2},
+		{"smartcrop_stage_duration_seconds", smartcrop.StageEdge, 1},
+		{"smartcrop_candidates", "", 1},
+		{"smartcrop_faces_detected", "", 1},
+		{"smartcrop_fallbacks_total", smartcrop.FallbackCentered, 1},
+	} {
+		if v := value(t, reg, m.name, m.label); v != m.want {
+			t.Errorf("%s{%s}: expected %g, got %g", m.name, m.label, m.want, v)
+		}
+	}
+}
+
+func TestSinkAnalyzer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 200; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 90, 255})
+		}
+	}
+
+	analyzer := smartcrop.New(smartcrop.WithResizer(xdraw.NewDefaultResizer()), smartcrop.WithMetrics(sink))
+	if _, err := analyzer.Analyze(img, 100, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := analyzer.Analyze(img, 0, 0); err != smartcrop.ErrInvalidDimensions {
+		t.Fatalf("expected ErrInvalidDimensions, got %v", err)
+	}
+	if ok, failed := value(t, reg, "smartcrop_analyses_total", "ok"), value(t, reg, "smartcrop_analyses_total", "error"); ok != 1 || failed != 1 {
+		t.Errorf("expected one successful and one failed analysis, got %g and %g", ok, failed)
+	}
+	for _, stage := range []string{smartcrop.StagePrescale, smartcrop.StageEdge, smartcrop.StageScore} {
+		if v := value(t, reg, "smartcrop_stage_duration_seconds", stage); v != 1 {
+			t.Errorf("expected the %s stage to be observed once, got %g", stage, v)
+		}
+	}
+	if v := value(t, reg, "smartcrop_candidates", ""); v == 0 {
+		t.Error("expected the candidates to be observed")
+	}
+}
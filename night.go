@@ -0,0 +1,104 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// tunedFor returns the analyzer that should be used for the given analysis image.
+func (sca *smartcropAnalyzer) tunedFor(img image.Image) *smartcropAnalyzer {
+	if sca.night != nil && meanLightness(img) < sca.config.NightLightnessThreshold {
+		sca.logger.Log.Println("low-light image detected, using night tuning")
+		if sca.ctx == nil {
+			return sca.night
+		}
+		// the night analyzer continues the analysis in the context of sca
+		night := *sca.night
+		night.ctx = sca.ctx
+		return &night
+	}
+	return sca
+}
+
+// meanLightness returns the average cie lightness of img in the range [0, 1],
+// sampling every fourth pixel in both directions.
+func meanLightness(img image.Image) float64 {
+	b := img.Bounds()
+	var sum float64
+	var n int
+	for y := b.Min.Y; y < b.Max.Y; y += 4 {
+		for x := b.Min.X; x < b.Max.X; x += 4 {
+			switch i := img.(type) {
+			case *image.Gray:
+				sum += grayCie(i.GrayAt(x, y))
+			case *image.RGBA:
+				sum += cie(i.RGBAAt(x, y))
+			default:
+				sum += cie(color.RGBAModel.Convert(img.At(x, y)).(color.RGBA))
+			}
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n) / 255.0
+}
+
+// denoise returns a copy of img with a 3x3 median filter applied to every channel.
+func denoise(img image.Image) image.Image {
+	if gray, ok := img.(*image.Gray); ok {
+		out := image.NewGray(gray.Bounds())
+		b := gray.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				out.SetGray(x, y, color.Gray{median3x3(b, x, y, func(x, y int) uint8 {
+					return gray.GrayAt(x, y).Y
+				})})
+			}
+		}
+		return out
+	}
+
+	rgba := toRGBA(img)
+	out := image.NewRGBA(rgba.Bounds())
+	b := rgba.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.SetRGBA(x, y, color.RGBA{
+				median3x3(b, x, y, func(x, y int) uint8 { return rgba.RGBAAt(x, y).R }),
+				median3x3(b, x, y, func(x, y int) uint8 { return rgba.RGBAAt(x, y).G }),
+				median3x3(b, x, y, func(x, y int) uint8 { return rgba.RGBAAt(x, y).B }),
+				rgba.RGBAAt(x, y).A,
+			})
+		}
+	}
+	return out
+}
+
+// median3x3 returns the median of the 3x3 neighbourhood around x, y, clamping
+// coordinates to b.
+func median3x3(b image.Rectangle, x, y int, at func(x, y int) uint8) uint8 {
+	var v [9]int
+	i := 0
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			p := image.Pt(clamp(x+dx, b.Min.X, b.Max.X-1), clamp(y+dy, b.Min.Y, b.Max.Y-1))
+			v[i] = int(at(p.X, p.Y))
+			i++
+		}
+	}
+	sort.Ints(v[:])
+	return uint8(v[4])
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,137 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// maxGrayWorldGain limits how much GrayWorld scales a channel, so an image
+	// that is mostly one color, like a close-up of a flower, isn't turned gray.
+	maxGrayWorldGain = 2.0
+	// levelsClip is the share of pixels AutoLevels lets clip at either end of
+	// the lightness range, so a few specular highlights don't prevent stretching.
+	levelsClip = 0.005
+	// minLevelsRange is the least lightness range AutoLevels stretches, flat
+	// images are left alone rather than having their noise amplified.
+	minLevelsRange = 16
+)
+
+// normalizeColors returns a copy of img with the gray world correction and auto
+// levels applied, as enabled.
+func normalizeColors(img image.Image, grayWorld, autoLevels bool) image.Image {
+	var luts [3][256]uint8
+	for c := range luts {
+		for v := range luts[c] {
+			luts[c][v] = uint8(v)
+		}
+	}
+
+	gray, isGray := img.(*image.Gray)
+	var rgba *image.RGBA
+	if !isGray {
+		rgba = toRGBA(img)
+		if grayWorld {
+			grayWorldLUTs(rgba, &luts)
+		}
+	}
+	if autoLevels {
+		var hist [256]int
+		n := 0
+		if isGray {
+			for y := gray.Rect.Min.Y; y < gray.Rect.Max.Y; y++ {
+				for _, v := range gray.Pix[gray.PixOffset(gray.Rect.Min.X, y):gray.PixOffset(gray.Rect.Max.X, y)] {
+					hist[v]++
+					n++
+				}
+			}
+		} else {
+			for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y++ {
+				row := rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, y):rgba.PixOffset(rgba.Rect.Max.X, y)]
+				for i := 0; i < len(row); i += 4 {
+					l := (int(luts[0][row[i]]) + int(luts[1][row[i+1]]) + int(luts[2][row[i+2]])) / 3
+					hist[l]++
+					n++
+				}
+			}
+		}
+		if lo, hi := levelsRange(hist, n); hi-lo >= minLevelsRange {
+			for c := range luts {
+				for v, l := range luts[c] {
+					luts[c][v] = uint8(bounds(math.Round(float64(int(l)-lo) * 255 / float64(hi-lo))))
+				}
+			}
+		}
+	}
+
+	if isGray {
+		out := image.NewGray(gray.Rect)
+		for y := gray.Rect.Min.Y; y < gray.Rect.Max.Y; y++ {
+			src := gray.Pix[gray.PixOffset(gray.Rect.Min.X, y):gray.PixOffset(gray.Rect.Max.X, y)]
+			dst := out.Pix[out.PixOffset(out.Rect.Min.X, y):]
+			for i, v := range src {
+				dst[i] = luts[0][v]
+			}
+		}
+		return out
+	}
+	out := image.NewRGBA(rgba.Rect)
+	for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y++ {
+		src := rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, y):rgba.PixOffset(rgba.Rect.Max.X, y)]
+		dst := out.Pix[out.PixOffset(out.Rect.Min.X, y):]
+		for i := 0; i < len(src); i += 4 {
+			a := src[i+3]
+			for c := 0; c < 3; c++ {
+				// keep the values premultiplied
+				v := luts[c][src[i+c]]
+				if v > a {
+					v = a
+				}
+				dst[i+c] = v
+			}
+			dst[i+3] = a
+		}
+	}
+	return out
+}
+
+// grayWorldLUTs sets luts to scale the channels of img to the same mean.
+func grayWorldLUTs(img *image.RGBA, luts *[3][256]uint8) {
+	var sums [3]float64
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			sums[0] += float64(row[i])
+			sums[1] += float64(row[i+1])
+			sums[2] += float64(row[i+2])
+		}
+	}
+	mean := (sums[0] + sums[1] + sums[2]) / 3
+	for c := range luts {
+		if sums[c] == 0 {
+			continue
+		}
+		gain := math.Max(1/maxGrayWorldGain, math.Min(mean/sums[c], maxGrayWorldGain))
+		for v := range luts[c] {
+			luts[c][v] = uint8(bounds(math.Round(float64(v) * gain)))
+		}
+	}
+}
+
+// levelsRange returns the lightness below which and above which levelsClip of
+// the n pixels counted in hist lie.
+func levelsRange(hist [256]int, n int) (lo, hi int) {
+	clip := int(float64(n) * levelsClip)
+	for sum := 0; lo < 255; lo++ {
+		if sum += hist[lo]; sum > clip {
+			break
+		}
+	}
+	hi = 255
+	for sum := 0; hi > 0; hi-- {
+		if sum += hist[hi]; sum > clip {
+			break
+		}
+	}
+	return lo, hi
+}
@@ -0,0 +1,166 @@
+package smartcrop
+
+import (
+	"image"
+
+	"github.com/third-light/smartcrop/options"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+// Option configures an analyzer created with New.
+type Option func(*settings)
+
+// settings collects the options passed to New.
+type settings struct {
+	config    Config
+	resizer   options.Resizer
+	logger    Logger
+	faces     FaceDetector
+	detectors []Detector
+	cache     Cache
+	sensitive SensitiveDetector
+	templates []image.Image
+	barcodes  BarcodeDetector
+	metrics   MetricsSink
+	tracer    Tracer
+	colors    ColorConverter
+}
+
+// Detector identifies one of the built-in detectors for WithDetectors.
+type Detector int
+
+const (
+	DetectEdge Detector = iota
+	DetectSkin
+	DetectSaturation
+)
+
+// New returns a new Analyzer configured by opts. Without options it uses
+// DefaultConfig, the xdraw default resizer and discards log output.
+// NewAnalyzer, NewDebugAnalyzer and NewAnalyzerWithLogger remain as shorthands.
+func New(opts ...Option) Analyzer {
+	s := settings{config: DefaultConfig}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.resizer == nil {
+		s.resizer = xdraw.NewDefaultResizer()
+	}
+	if s.detectors != nil {
+		s.config.DisableEdge, s.config.DisableSkin, s.config.DisableSaturation = true, true, true
+		for _, d := range s.detectors {
+			switch d {
+			case DetectEdge:
+				s.config.DisableEdge = false
+			case DetectSkin:
+				s.config.DisableSkin = false
+			case DetectSaturation:
+				s.config.DisableSaturation = false
+			}
+		}
+	}
+	return newAnalyzer(s)
+}
+
+// WithConfig sets the config, DefaultConfig if not given.
+func WithConfig(c Config) Option {
+	return func(s *settings) {
+		s.config = c
+	}
+}
+
+// WithResizer sets the resizer used for prescaling and rendering.
+func WithResizer(r options.Resizer) Option {
+	return func(s *settings) {
+		s.resizer = r
+	}
+}
+
+// WithLogger sets the logger, e.g. with DebugMode on.
+func WithLogger(l Logger) Option {
+	return func(s *settings) {
+		s.logger = l
+	}
+}
+
+// WithFaceDetector replaces the built-in gocv face detection with d, which also
+// works in builds without gocv. Faces are still only detected with
+// Config.FaceDetectEnabled on.
+func WithFaceDetector(d FaceDetector) Option {
+	return func(s *settings) {
+		s.faces = d
+	}
+}
+
+// WithDetectors enables exactly the given built-in detectors, overriding the
+// DisableEdge, DisableSkin and DisableSaturation fields of the config regardless
+// of the order of the options.
+func WithDetectors(detectors ...Detector) Option {
+	return func(s *settings) {
+		s.detectors = append([]Detector{}, detectors...)
+	}
+}
+
+// WithCache makes FindBestCrop look up crop decisions in c before analyzing an
+// image and store them in c afterwards, keyed by the image content, the
+// requested size and the config and settings, see CacheKey. Analyzers may share
+// c.
+func WithCache(c Cache) Option {
+	return func(s *settings) {
+		s.cache = c
+	}
+}
+
+// WithSensitiveDetector makes the analyzer keep crops out of the regions d finds,
+// as decided by Config.SensitivePolicy.
+func WithSensitiveDetector(d SensitiveDetector) Option {
+	return func(s *settings) {
+		s.sensitive = d
+	}
+}
+
+// WithTemplates makes the analyzer look for the templates, such as logos, in the
+// image, to keep the regions matching them in the crop as decided by
+// Config.TemplatePolicy. Templates are given at the scale they appear at in the
+// original image.
+func WithTemplates(templates ...image.Image) Option {
+	return func(s *settings) {
+		s.templates = append([]image.Image{}, templates...)
+	}
+}
+
+// WithBarcodeDetector makes the analyzer keep the codes d finds either whole in
+// the crop or out of it, as decided by Config.BarcodePolicy.
+func WithBarcodeDetector(d BarcodeDetector) Option {
+	return func(s *settings) {
+		s.barcodes = d
+	}
+}
+
+// WithMetrics makes the analyzer report the duration and outcome of its analyses
+// and their stages to m.
+func WithMetrics(m MetricsSink) Option {
+	return func(s *settings) {
+		s.metrics = m
+	}
+}
+
+// WithTracer makes the analyzer record every analysis as a span with t, with
+// child spans for its stages. The spans of AnalyzeContext are children of the
+// span in its context.
+func WithTracer(t Tracer) Option {
+	return func(s *settings) {
+		s.tracer = t
+	}
+}
+
+// WithColorConverter makes the analyzer convert an ICCImage to sRGB with c
+// before analyzing it, so that skin, saturation and the other color features
+// are measured as for an sRGB image. Only the prescaled analysis copy is
+// converted. Images without a profile, and those whose profile c fails for, are
+// analyzed as they are.
+func WithColorConverter(c ColorConverter) Option {
+	return func(s *settings) {
+		s.colors = c
+	}
+}
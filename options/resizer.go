@@ -28,11 +28,57 @@
 package options
 
 import (
+	"context"
 	"image"
 )
 
-// Resizer is used to resize images. See the nfnt package for a default implementation using
-// github.com/nfnt/resize.
+// Resizer is used to resize images. See the xdraw package for the recommended implementation
+// using golang.org/x/image/draw, or the nfnt package for one using github.com/nfnt/resize.
 type Resizer interface {
 	Resize(img image.Image, width, height uint) image.Image
 }
+
+// ResizerV2 is a Resizer that can report failures and be canceled. The analyzer
+// uses ResizeCtx instead of Resize when its Resizer implements ResizerV2.
+type ResizerV2 interface {
+	ResizeCtx(ctx context.Context, img image.Image, width, height uint) (image.Image, error)
+}
+
+// V2 returns r as a ResizerV2. If r doesn't implement it, the returned ResizerV2
+// checks the context before calling Resize, which never fails.
+func V2(r Resizer) ResizerV2 {
+	if v2, ok := r.(ResizerV2); ok {
+		return v2
+	}
+	return v1Resizer{r}
+}
+
+// FromV2 returns a Resizer for r, e.g. to pass to smartcrop.NewAnalyzer. It also
+// implements ResizerV2, so the analyzer still gets the errors of r. Its Resize
+// method returns img unchanged when r fails.
+func FromV2(r ResizerV2) Resizer {
+	return v2Resizer{r}
+}
+
+type v1Resizer struct {
+	Resizer
+}
+
+func (r v1Resizer) ResizeCtx(ctx context.Context, img image.Image, width, height uint) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Resize(img, width, height), nil
+}
+
+type v2Resizer struct {
+	ResizerV2
+}
+
+func (r v2Resizer) Resize(img image.Image, width, height uint) image.Image {
+	out, err := r.ResizeCtx(context.Background(), img, width, height)
+	if err != nil {
+		return img
+	}
+	return out
+}
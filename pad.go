@@ -0,0 +1,126 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Padding is the number of pixels to add on each side of a crop to reach the
+// requested aspect ratio, in original image pixels.
+type Padding struct {
+	Left, Top, Right, Bottom int
+}
+
+// PadFill selects how PadImage fills the padding.
+type PadFill int
+
+const (
+	// PadFillAverage fills the padding with the average color of the crop.
+	PadFillAverage PadFill = iota
+	// PadFillBlur extends the edge pixels of the crop outwards and blurs them.
+	PadFillBlur
+)
+
+// paddedTarget returns the crop size to search for instead of width x height when
+// cropping alone would keep less than Config.PadThreshold of the image. The
+// returned size has an aspect ratio between that of the image and the requested
+// one, so that padding makes up at most Config.MaxPadding of the result.
+func (sca *smartcropAnalyzer) paddedTarget(bounds image.Rectangle, width, height int) (int, int, bool) {
+	if sca.config.MaxPadding <= 0 || width == 0 || height == 0 || bounds.Empty() {
+		return width, height, false
+	}
+
+	imgAspect := float64(bounds.Dx()) / float64(bounds.Dy())
+	aspect := float64(width) / float64(height)
+	coverage := math.Min(imgAspect, aspect) / math.Max(imgAspect, aspect)
+	if coverage >= sca.config.PadThreshold {
+		return width, height, false
+	}
+
+	if aspect > imgAspect {
+		// wider than the image: pad left and right
+		cropAspect := math.Max(imgAspect, aspect*(1-sca.config.MaxPadding))
+		return int(math.Round(float64(height) * cropAspect)), height, true
+	}
+	cropAspect := math.Min(imgAspect, aspect/(1-sca.config.MaxPadding))
+	return width, int(math.Round(float64(width) / cropAspect)), true
+}
+
+// padding returns the padding that brings r to the aspect ratio of width x height,
+// split evenly between both sides.
+func padding(r image.Rectangle, width, height int) Padding {
+	aspect := float64(width) / float64(height)
+	var p Padding
+	if float64(r.Dx())/float64(r.Dy()) < aspect {
+		pad := int(math.Round(aspect*float64(r.Dy()))) - r.Dx()
+		p.Left = pad / 2
+		p.Right = pad - p.Left
+	} else {
+		pad := int(math.Round(float64(r.Dx())/aspect)) - r.Dy()
+		p.Top = pad / 2
+		p.Bottom = pad - p.Top
+	}
+	return p
+}
+
+// PadImage returns the part of img inside r surrounded by the padding p, e.g. the
+// crop and padding returned by Analyzer.Analyze. The result starts at (0, 0).
+func PadImage(img image.Image, r image.Rectangle, p Padding, fill PadFill) *image.RGBA {
+	r = r.Intersect(img.Bounds())
+	crop := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(crop, crop.Bounds(), img, r.Min, draw.Src)
+
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx()+p.Left+p.Right, r.Dy()+p.Top+p.Bottom))
+	inner := crop.Bounds().Add(image.Pt(p.Left, p.Top))
+	if inner == out.Bounds() || crop.Bounds().Empty() {
+		draw.Draw(out, inner, crop, image.ZP, draw.Src)
+		return out
+	}
+
+	switch fill {
+	case PadFillBlur:
+		w, h := crop.Bounds().Dx(), crop.Bounds().Dy()
+		for y := 0; y < out.Bounds().Dy(); y++ {
+			for x := 0; x < out.Bounds().Dx(); x++ {
+				out.SetRGBA(x, y, crop.RGBAAt(clamp(x-p.Left, 0, w-1), clamp(y-p.Top, 0, h-1)))
+			}
+		}
+		radius := maxInt(maxInt(p.Left, p.Right), maxInt(p.Top, p.Bottom)) / 4
+		boxBlur(out, maxInt(radius, 1))
+	default:
+		draw.Draw(out, out.Bounds(), image.NewUniform(averageColor(crop)), image.ZP, draw.Src)
+	}
+	draw.Draw(out, inner, crop, image.ZP, draw.Src)
+	return out
+}
+
+func averageColor(img *image.RGBA) color.RGBA {
+	var r, g, b, a, n int
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r += int(img.Pix[i])
+		g += int(img.Pix[i+1])
+		b += int(img.Pix[i+2])
+		a += int(img.Pix[i+3])
+		n++
+	}
+	if n == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,154 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+const (
+	// paletteBits is the number of bits per channel colors are binned at before
+	// clustering, so clustering works on at most 4096 bins instead of every pixel.
+	paletteBits = 4
+	// paletteSeedDistance is the least squared distance, in 8-bit units, between
+	// the bins seeding the clusters, so the palette doesn't start out with shades
+	// of the same color.
+	paletteSeedDistance = 48 * 48
+	// paletteIterations is the number of k-means refinements of the clusters.
+	paletteIterations = 8
+)
+
+// PaletteColor is a color of CropResult.Palette along with its share of the
+// pixels of the crop.
+type PaletteColor struct {
+	color.RGBA
+	Weight float64
+}
+
+// paletteBin accumulates the pixels falling into one bin of the histogram.
+type paletteBin struct {
+	n       int
+	r, g, b int
+}
+
+func (b paletteBin) mean() [3]int {
+	return [3]int{b.r / b.n, b.g / b.n, b.b / b.n}
+}
+
+// palette returns up to k of the most common colors of the pixels of img within r, the
+// dominant color first. Colors are binned in a histogram, which seeds a k-means
+// clustering of the bins. Fully transparent pixels are ignored, the others are
+// counted with their alpha undone.
+func palette(img image.Image, r image.Rectangle, k int) []PaletteColor {
+	rgba := toRGBA(img)
+	r = r.Intersect(rgba.Rect)
+
+	const shift = 8 - paletteBits
+	var hist [1 << (3 * paletteBits)]paletteBin
+	total := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := rgba.Pix[rgba.PixOffset(r.Min.X, y):rgba.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] == 0 {
+				continue
+			}
+			cr, cg, cb := int(row[i]), int(row[i+1]), int(row[i+2])
+			if a := int(row[i+3]); a < 255 {
+				cr, cg, cb = cr*255/a, cg*255/a, cb*255/a
+			}
+			b := &hist[cr>>shift<<(2*paletteBits)|cg>>shift<<paletteBits|cb>>shift]
+			b.n++
+			b.r += cr
+			b.g += cg
+			b.b += cb
+			total++
+		}
+	}
+	if total == 0 || k <= 0 {
+		return nil
+	}
+
+	var bins []paletteBin
+	for _, b := range hist {
+		if b.n > 0 {
+			bins = append(bins, b)
+		}
+	}
+	sort.SliceStable(bins, func(i, j int) bool { return bins[i].n > bins[j].n })
+
+	centers := paletteSeeds(bins, k)
+	assigned := make([]int, len(bins))
+	var clusters []paletteBin
+	for it := 0; it < paletteIterations; it++ {
+		clusters = make([]paletteBin, len(centers))
+		for i, b := range bins {
+			assigned[i] = nearestColor(centers, b.mean())
+			c := &clusters[assigned[i]]
+			c.n += b.n
+			c.r += b.r
+			c.g += b.g
+			c.b += b.b
+		}
+		for i, c := range clusters {
+			if c.n > 0 {
+				centers[i] = c.mean()
+			}
+		}
+	}
+
+	out := make([]PaletteColor, 0, len(clusters))
+	for _, c := range clusters {
+		if c.n == 0 {
+			continue
+		}
+		m := c.mean()
+		out = append(out, PaletteColor{
+			RGBA:   color.RGBA{uint8(m[0]), uint8(m[1]), uint8(m[2]), 255},
+			Weight: float64(c.n) / float64(total),
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Weight > out[j].Weight })
+	return out
+}
+
+// paletteSeeds returns up to k cluster centers, picking the most common bins
+// that are at least paletteSeedDistance from the ones picked before. Images with
+// fewer distinct colors get fewer clusters rather than several shades of one.
+func paletteSeeds(bins []paletteBin, k int) [][3]int {
+	var seeds [][3]int
+	for _, b := range bins {
+		if len(seeds) == k {
+			break
+		}
+		m := b.mean()
+		if len(seeds) == 0 || colorDistance(seeds[nearestColor(seeds, m)], m) >= paletteSeedDistance {
+			seeds = append(seeds, m)
+		}
+	}
+	return seeds
+}
+
+// nearestColor returns the index of the center closest to c.
+func nearestColor(centers [][3]int, c [3]int) int {
+	best, bestDist := 0, -1
+	for i, center := range centers {
+		if d := colorDistance(center, c); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func colorDistance(a, b [3]int) int {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// palette returns the palette of r in img, the analysis copy, if
+// Config.PaletteSize is set.
+func (sca *smartcropAnalyzer) palette(img image.Image, r image.Rectangle) []PaletteColor {
+	if sca.config.PaletteSize <= 0 {
+		return nil
+	}
+	return palette(img, r, sca.config.PaletteSize)
+}
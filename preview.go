@@ -0,0 +1,42 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// AnalyzePreview works like Analyze for an image with the given original bounds,
+// of which preview is a downscaled copy, e.g. an embedded thumbnail or a preview
+// rendition a pipeline already has. Only preview is analyzed, and the result is
+// in original coordinates, except for the Heatmap, so the original never has to
+// be decoded.
+func (sca *smartcropAnalyzer) AnalyzePreview(preview image.Image, original image.Rectangle, width, height int) (CropResult, error) {
+	if original.Empty() || preview.Bounds().Empty() {
+		return CropResult{}, ErrInvalidDimensions
+	}
+	scale := float64(preview.Bounds().Dx()) / float64(original.Dx())
+	preview, _ = atOrigin(preview)
+	res, err := sca.analyzeLevel(preview, original, scale, width, height, nil)
+	if err != nil {
+		return CropResult{}, err
+	}
+	res.translate(original.Min)
+	// the preview may be rounded to whole pixels
+	res.Crop.Rectangle = res.Crop.Rectangle.Intersect(original)
+	res.Crop.AlgorithmVersion = AlgorithmVersion
+	return res, nil
+}
+
+// MapCrop maps r from an image with the bounds from to the same region of the
+// image with the bounds to, e.g. a crop found on a preview to the original or
+// the other way round. The result is rounded to whole pixels and clipped to to.
+func MapCrop(r, from, to image.Rectangle) image.Rectangle {
+	if from.Empty() {
+		return image.Rectangle{}
+	}
+	sx := float64(to.Dx()) / float64(from.Dx())
+	sy := float64(to.Dy()) / float64(from.Dy())
+	x := func(v int) int { return to.Min.X + int(math.Round(float64(v-from.Min.X)*sx)) }
+	y := func(v int) int { return to.Min.Y + int(math.Round(float64(v-from.Min.Y)*sy)) }
+	return image.Rect(x(r.Min.X), y(r.Min.Y), x(r.Max.X), y(r.Max.Y)).Intersect(to)
+}
@@ -0,0 +1,44 @@
+package smartcrop
+
+// ProgressFunc is called while an image is analyzed. stage names the running
+// pass, one of the Stage constants, and fraction tells how much of it is done,
+// from 0 when it starts to 1 when it is finished.
+type ProgressFunc func(stage string, fraction float64)
+
+// The stages reported to Config.ProgressFunc, in the order they run. Stages that
+// are disabled in the config are not reported.
+const (
+	StageDenoise    = "denoise"
+	StageNormalize  = "normalize"
+	StageEdge       = "edge"
+	StageSkin       = "skin"
+	StageSaturation = "saturation"
+	StageSharpness  = "sharpness"
+	StageFace       = "face"
+	StageBlur       = "blur"
+	StageScore      = "score"
+	StageRefine     = "refine"
+	StageOptimize   = "optimize"
+	StageRotate     = "rotate"
+)
+
+// progressSteps is about how often a stage with many steps reports progress.
+const progressSteps = 100
+
+// progress reports progress to Config.ProgressFunc, if set.
+func (sca *smartcropAnalyzer) progress(stage string, fraction float64) {
+	if sca.config.ProgressFunc != nil {
+		sca.config.ProgressFunc(stage, fraction)
+	}
+}
+
+// stepProgress reports progress of step i out of n steps, about progressSteps
+// times per stage.
+func (sca *smartcropAnalyzer) stepProgress(stage string, i, n int) {
+	if sca.config.ProgressFunc == nil || n <= 0 {
+		return
+	}
+	if every := n / progressSteps; every <= 1 || i%every == 0 {
+		sca.config.ProgressFunc(stage, float64(i)/float64(n))
+	}
+}
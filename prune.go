@@ -0,0 +1,86 @@
+package smartcrop
+
+import (
+	"image"
+	"sort"
+)
+
+// prune drops the candidates of cs whose detector energy, the weighted sum of
+// the detector output o within them, is below Config.PrunePercentile of the
+// candidates of the same size. Candidates overlapping a face are kept. The
+// energy of each candidate is looked up in an integral image, which is far
+// cheaper than scoring it.
+func (sca *smartcropAnalyzer) prune(o *ScoreMap, cs []Crop, faceRects []image.Rectangle, mask *image.Gray) []Crop {
+	p := sca.config.PrunePercentile
+	if p <= 0 || len(cs) == 0 {
+		return cs
+	}
+	if p > 1 {
+		p = 1
+	}
+
+	sum := sca.energyIntegral(o, mask)
+	energies := make([]float64, len(cs))
+	bySize := make(map[image.Point][]float64)
+	for i, crop := range cs {
+		energies[i] = sum.at(crop.Rectangle)
+		bySize[crop.Size()] = append(bySize[crop.Size()], energies[i])
+	}
+	thresholds := make(map[image.Point]float64, len(bySize))
+	for size, es := range bySize {
+		sort.Float64s(es)
+		thresholds[size] = es[int(p*float64(len(es)-1))]
+	}
+
+	kept := cs[:0]
+	for i, crop := range cs {
+		if energies[i] >= thresholds[crop.Size()] || overlapsAny(crop.Rectangle, faceRects) {
+			kept = append(kept, crop)
+		}
+	}
+	sca.logger.Log.Printf("pruned %d of %d candidates\n", len(cs)-len(kept), len(cs))
+	return kept
+}
+
+// integral is a summed-area table of an image.
+type integral struct {
+	bounds image.Rectangle
+	stride int
+	sums   []float64
+}
+
+// at returns the sum over r, which has to lie within the bounds.
+func (s integral) at(r image.Rectangle) float64 {
+	x0, y0 := r.Min.X-s.bounds.Min.X, r.Min.Y-s.bounds.Min.Y
+	x1, y1 := r.Max.X-s.bounds.Min.X, r.Max.Y-s.bounds.Min.Y
+	return s.sums[y1*s.stride+x1] - s.sums[y0*s.stride+x1] - s.sums[y1*s.stride+x0] + s.sums[y0*s.stride+x0]
+}
+
+// energyIntegral returns the summed-area table of the detector output o,
+// weighting the channels like score and each pixel by mask, if set.
+func (sca *smartcropAnalyzer) energyIntegral(o *ScoreMap, mask *image.Gray) integral {
+	b := o.Bounds()
+	s := integral{bounds: b, stride: b.Dx() + 1, sums: make([]float64, (b.Dx()+1)*(b.Dy()+1))}
+	energy := sca.energy(o, nil)
+	for y := 0; y < b.Dy(); y++ {
+		var row float64
+		for x := 0; x < b.Dx(); x++ {
+			e := energy[y*b.Dx()+x]
+			if mask != nil {
+				e *= float64(mask.GrayAt(b.Min.X+x, b.Min.Y+y).Y) / 255
+			}
+			row += e
+			s.sums[(y+1)*s.stride+x+1] = s.sums[y*s.stride+x+1] + row
+		}
+	}
+	return s
+}
+
+func overlapsAny(r image.Rectangle, rects []image.Rectangle) bool {
+	for _, o := range rects {
+		if r.Overlaps(o) {
+			return true
+		}
+	}
+	return false
+}
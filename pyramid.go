@@ -0,0 +1,83 @@
+package smartcrop
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// ErrNoPyramidLevel is returned by ImagePyramid.Level when it has no levels.
+var ErrNoPyramidLevel = errors.New("Pyramid has no levels")
+
+// Pyramid is an image available at several resolutions, such as a tiled BigTIFF
+// or DeepZoom asset. AnalyzePyramid only requests the level it needs, so that
+// implementations reading tiles from an io.ReaderAt never have to decode the
+// full resolution image.
+type Pyramid interface {
+	// Bounds returns the bounds of the full resolution image.
+	Bounds() image.Rectangle
+	// Level returns the smallest level downsampled by at least scale, from 0 to
+	// 1, relative to the full resolution, along with its actual scale. It
+	// returns the full resolution image if no smaller level is large enough.
+	Level(scale float64) (image.Image, float64, error)
+}
+
+// AnalyzePyramid works like Analyze for the full resolution image of src, but
+// analyzes the smallest pyramid level that holds at least as many pixels as
+// Config.Prescale, Config.PrescaleMin and Config.MaxAnalysisPixels ask for. The
+// result is in full resolution coordinates, except for the Heatmap.
+func (sca *smartcropAnalyzer) AnalyzePyramid(src Pyramid, width, height int) (CropResult, error) {
+	bounds := src.Bounds()
+	scale := sca.configuredPrescale(bounds)
+	scale *= sca.pixelLimit(bounds, scale)
+
+	img, levelScale, err := src.Level(scale)
+	if err != nil {
+		return CropResult{}, err
+	}
+	sca.logger.Log.Printf("analyzing pyramid level %dx%d, scale %f\n", img.Bounds().Dx(), img.Bounds().Dy(), levelScale)
+
+	img, _ = atOrigin(img)
+	res, err := sca.analyzeLevel(img, bounds, levelScale, width, height, nil)
+	if err != nil {
+		return CropResult{}, err
+	}
+	res.translate(bounds.Min)
+	// the level may be rounded to whole pixels
+	res.Crop.Rectangle = res.Crop.Rectangle.Intersect(bounds)
+	res.Crop.AlgorithmVersion = AlgorithmVersion
+	return res, nil
+}
+
+// ImagePyramid is a Pyramid of already decoded levels, in any order, e.g. the
+// pages of a pyramidal TIFF. The largest level is taken as the full resolution.
+type ImagePyramid []image.Image
+
+// Bounds implements Pyramid.
+func (p ImagePyramid) Bounds() image.Rectangle {
+	var largest image.Rectangle
+	for _, level := range p {
+		if b := level.Bounds(); b.Dx() > largest.Dx() {
+			largest = b
+		}
+	}
+	return largest
+}
+
+// Level implements Pyramid.
+func (p ImagePyramid) Level(scale float64) (image.Image, float64, error) {
+	if len(p) == 0 {
+		return nil, 0, ErrNoPyramidLevel
+	}
+
+	full := float64(p.Bounds().Dx())
+	var best image.Image
+	bestScale := math.Inf(1)
+	for _, level := range p {
+		s := float64(level.Bounds().Dx()) / full
+		if s >= scale && s < bestScale {
+			best, bestScale = level, s
+		}
+	}
+	return best, bestScale, nil
+}
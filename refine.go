@@ -0,0 +1,106 @@
+package smartcrop
+
+import (
+	"image"
+	"sort"
+)
+
+// refine implements the Config.RefinementLevels search within area. It returns cs
+// along with all additionally scored candidates.
+func (sca *smartcropAnalyzer) refine(o *ScoreMap, area image.Rectangle, cs []Crop, faceRects []image.Rectangle, kernels importanceKernels) []Crop {
+	k := sca.config.RefinementTopK
+	if k <= 0 {
+		k = 1
+	}
+
+	seen := make(map[image.Rectangle]bool, len(cs))
+	for _, crop := range cs {
+		seen[crop.Rectangle] = true
+	}
+
+	step := sca.config.Step
+	for level := 0; level < sca.config.RefinementLevels; level++ {
+		half := step / 2
+		if half < 1 {
+			break
+		}
+
+		for _, top := range sca.topCrops(cs, k, faceRects) {
+			for dy := -step + half; dy < step; dy += half {
+				for dx := -step + half; dx < step; dx += half {
+					r := top.Rectangle.Add(image.Pt(dx, dy))
+					if seen[r] || !r.In(area) {
+						continue
+					}
+					seen[r] = true
+
+					crop := Crop{Rectangle: r}
+					crop.Score = sca.score(o, crop, faceRects, kernels)
+					cs = append(cs, crop)
+				}
+			}
+		}
+		step = half
+	}
+
+	return cs
+}
+
+// topCrops returns the k best crops of cs as ranked by better, best first.
+func (sca *smartcropAnalyzer) topCrops(cs []Crop, k int, faceRects []image.Rectangle) []Crop {
+	sorted := make([]Crop, len(cs))
+	copy(sorted, cs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sca.better(sorted[i], sorted[j], faceRects)
+	})
+	if len(sorted) > k {
+		sorted = sorted[:k]
+	}
+	return sorted
+}
+
+// optimize hill-climbs from crop, trying shifts of up to Step/2 pixels and
+// scale changes of ScaleStep/2 and keeping every change that better prefers.
+// The shift distance is halved whenever no neighbour is better. Crops are kept
+// within area.
+func (sca *smartcropAnalyzer) optimize(o *ScoreMap, area image.Rectangle, crop Crop, faceRects []image.Rectangle, mask *image.Gray, cropWidth, cropHeight, realMinScale float64) Crop {
+	cropW, cropH := cropSize(o.Bounds(), cropWidth, cropHeight)
+	minW, maxW := int(cropW*realMinScale), int(cropW*sca.config.MaxScale)
+	aspect := cropH / cropW
+
+	kernels := newImportanceKernels(mask)
+	best := crop
+	for d := sca.config.Step / 2; d >= 1; {
+		var candidates []image.Rectangle
+		for _, p := range []image.Point{{-d, 0}, {d, 0}, {0, -d}, {0, d}} {
+			candidates = append(candidates, best.Rectangle.Add(p))
+		}
+		for _, f := range []float64{1 - sca.config.ScaleStep/2, 1 + sca.config.ScaleStep/2} {
+			w := int(float64(best.Dx()) * f)
+			if w < minW || w > maxW {
+				continue
+			}
+			h := int(float64(w) * aspect)
+			c := best.Min.Add(image.Pt(best.Dx()/2, best.Dy()/2))
+			candidates = append(candidates, image.Rect(c.X-w/2, c.Y-h/2, c.X-w/2+w, c.Y-h/2+h))
+		}
+
+		improved := false
+		for _, r := range candidates {
+			if !r.In(area) {
+				continue
+			}
+			c := Crop{Rectangle: r}
+			c.Score = sca.score(o, c, faceRects, kernels)
+			if sca.better(c, best, faceRects) && sca.faceFractionOK(c, faceRects) {
+				best = c
+				improved = true
+			}
+		}
+		if !improved {
+			d /= 2
+		}
+	}
+
+	return best
+}
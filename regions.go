@@ -0,0 +1,192 @@
+package smartcrop
+
+import (
+	"encoding/xml"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	mwgRegionsNamespace = "http://www.metadataworkinggroup.com/schemas/regions/"
+	stAreaNamespace     = "http://ns.adobe.com/xmp/sType/Area#"
+	iptcExtNamespace    = "http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+)
+
+// ReadRegions returns the regions annotated in the XMP of the JPEG or PNG image
+// read from r, in pixels of an image with the given bounds. Both MWG regions, as
+// written e.g. for tagged faces, and IPTC image regions are read. Circles and
+// polygons are returned as their bounding boxes. An image without XMP has no
+// regions.
+//
+// Use RegionMask to steer FindBestCropWithMask towards the regions.
+func ReadRegions(r io.Reader, bounds image.Rectangle) ([]image.Rectangle, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := extractXMP(data)
+	if err != nil || packet == nil {
+		return nil, err
+	}
+
+	var root xmpNode
+	if err := xml.Unmarshal(packet, &root); err != nil {
+		return nil, err
+	}
+
+	var regions []image.Rectangle
+	root.walk(func(n *xmpNode) {
+		var r image.Rectangle
+		var ok bool
+		switch n.XMLName {
+		case xml.Name{Space: mwgRegionsNamespace, Local: "Area"}:
+			r, ok = mwgArea(n, bounds)
+		case xml.Name{Space: iptcExtNamespace, Local: "RegionBoundary"}:
+			r, ok = iptcBoundary(n, bounds)
+		}
+		if ok && !r.Empty() {
+			regions = append(regions, r.Intersect(bounds))
+		}
+	})
+	return regions, nil
+}
+
+// RegionMask returns an importance mask for FindBestCropWithMask that keeps the
+// full importance inside regions and scales it by background/255 elsewhere.
+func RegionMask(bounds image.Rectangle, regions []image.Rectangle, background uint8) *image.Gray {
+	mask := image.NewGray(bounds)
+	for i := range mask.Pix {
+		mask.Pix[i] = background
+	}
+	for _, r := range regions {
+		r = r.Intersect(bounds)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				mask.Pix[mask.PixOffset(x, y)] = 0xff
+			}
+		}
+	}
+	return mask
+}
+
+// mwgArea converts an MWG area, given by its center and size relative to the
+// image, to pixels.
+func mwgArea(n *xmpNode, bounds image.Rectangle) (image.Rectangle, bool) {
+	if unit := n.value(stAreaNamespace, "unit"); unit != "" && unit != "normalized" {
+		return image.Rectangle{}, false
+	}
+	x, okX := n.number(stAreaNamespace, "x")
+	y, okY := n.number(stAreaNamespace, "y")
+	w, okW := n.number(stAreaNamespace, "w")
+	h, okH := n.number(stAreaNamespace, "h")
+	if !okX || !okY {
+		return image.Rectangle{}, false
+	}
+	if !okW || !okH {
+		// a point, as used for focus areas
+		w, h = 0, 0
+	}
+	bw, bh := float64(bounds.Dx()), float64(bounds.Dy())
+	return regionRect(bounds, (x-w/2)*bw, (y-h/2)*bh, (x+w/2)*bw, (y+h/2)*bh), true
+}
+
+// iptcBoundary converts an IPTC region boundary, a rectangle, circle or polygon in
+// pixels or relative to the image, to pixels.
+func iptcBoundary(n *xmpNode, bounds image.Rectangle) (image.Rectangle, bool) {
+	sx, sy := 1.0, 1.0
+	if n.value(iptcExtNamespace, "rbUnit") == "relative" {
+		sx, sy = float64(bounds.Dx()), float64(bounds.Dy())
+	}
+
+	switch n.value(iptcExtNamespace, "rbShape") {
+	case "rectangle":
+		x, okX := n.number(iptcExtNamespace, "rbX")
+		y, okY := n.number(iptcExtNamespace, "rbY")
+		w, okW := n.number(iptcExtNamespace, "rbW")
+		h, okH := n.number(iptcExtNamespace, "rbH")
+		if !okX || !okY || !okW || !okH {
+			return image.Rectangle{}, false
+		}
+		return regionRect(bounds, x*sx, y*sy, (x+w)*sx, (y+h)*sy), true
+	case "circle":
+		x, okX := n.number(iptcExtNamespace, "rbX")
+		y, okY := n.number(iptcExtNamespace, "rbY")
+		rx, okR := n.number(iptcExtNamespace, "rbRx")
+		if !okX || !okY || !okR {
+			return image.Rectangle{}, false
+		}
+		// the radius is relative to the image width
+		return regionRect(bounds, (x-rx)*sx, y*sy-rx*sx, (x+rx)*sx, y*sy+rx*sx), true
+	case "polygon":
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, v := range n.find(iptcExtNamespace, "rbVertices") {
+			v.walk(func(p *xmpNode) {
+				x, okX := p.number(iptcExtNamespace, "rbX")
+				y, okY := p.number(iptcExtNamespace, "rbY")
+				if okX && okY {
+					minX, minY = math.Min(minX, x), math.Min(minY, y)
+					maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+				}
+			})
+		}
+		if minX > maxX {
+			return image.Rectangle{}, false
+		}
+		return regionRect(bounds, minX*sx, minY*sy, maxX*sx, maxY*sy), true
+	}
+	return image.Rectangle{}, false
+}
+
+func regionRect(bounds image.Rectangle, x0, y0, x1, y1 float64) image.Rectangle {
+	return image.Rect(int(math.Round(x0)), int(math.Round(y0)), int(math.Round(x1)), int(math.Round(y1))).Add(bounds.Min)
+}
+
+// xmpNode is an XML element of an XMP packet. RDF allows simple properties both
+// as attributes and as child elements, value looks at both.
+type xmpNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmpNode  `xml:",any"`
+}
+
+// walk calls fn for n and all its descendants.
+func (n *xmpNode) walk(fn func(*xmpNode)) {
+	fn(n)
+	for i := range n.Children {
+		n.Children[i].walk(fn)
+	}
+}
+
+// find returns the children of n with the given name.
+func (n *xmpNode) find(space, local string) []*xmpNode {
+	var found []*xmpNode
+	for i := range n.Children {
+		if c := &n.Children[i]; c.XMLName.Space == space && c.XMLName.Local == local {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+func (n *xmpNode) value(space, local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	for _, c := range n.find(space, local) {
+		return strings.TrimSpace(c.Text)
+	}
+	return ""
+}
+
+func (n *xmpNode) number(space, local string) (float64, bool) {
+	v, err := strconv.ParseFloat(n.value(space, local), 64)
+	return v, err == nil
+}
@@ -0,0 +1,57 @@
+package smartcrop
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// CropAndResize finds the best crop for the given width and height, crops img to
+// it and resizes the result to exactly width x height using the analyzer's Resizer.
+// A zero width or height keeps the crop's aspect ratio. If the analysis suggests
+// padding, it is added with Config.PadFill, and a rotated crop is taken from the
+// rotated image. With Config.SeamCarvingFallback, an image no crop is acceptable
+// for is retargeted instead, see Retarget.
+func (sca *smartcropAnalyzer) CropAndResize(img image.Image, width, height int) (image.Image, Crop, error) {
+	res, err := sca.Analyze(img, width, height)
+	if err != nil {
+		return nil, Crop{}, err
+	}
+	topCrop := res.Crop
+	if res.Fallback && sca.config.SeamCarvingFallback && width > 0 && height > 0 {
+		out, err := sca.Retarget(img, width, height)
+		return out, topCrop, err
+	}
+	if res.Angle != 0 {
+		img = RotateImage(img, res.Angle)
+	}
+
+	var out image.Image
+	if res.Padding != (Padding{}) {
+		out = PadImage(img, topCrop.Rectangle, res.Padding, sca.config.PadFill)
+	} else {
+		out = CropImage(img, topCrop.Rectangle)
+	}
+	if (width != 0 && out.Bounds().Dx() != width) || (height != 0 && out.Bounds().Dy() != height) {
+		out, err = sca.resize(out, uint(width), uint(height))
+	}
+	return out, topCrop, err
+}
+
+// CropImage returns the part of img inside r, e.g. the result of
+// Analyzer.FindBestCrop. It uses the image's SubImage method when available and
+// copies the pixels into a new image.RGBA otherwise. Like SubImage, the returned
+// image keeps the coordinates of img.
+func CropImage(img image.Image, r image.Rectangle) image.Image {
+	type subImager interface {
+		SubImage(r image.Rectangle) image.Image
+	}
+	if sub, ok := img.(subImager); ok {
+		return sub.SubImage(r)
+	}
+
+	r = r.Intersect(img.Bounds())
+	out := image.NewRGBA(r)
+	draw.Copy(out, r.Min, img, r, draw.Src, nil)
+	return out
+}
@@ -0,0 +1,176 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// FaceAnchoredCrops returns a crop of img for every size, placing the faces found
+// the same way in all of them, so a set of renditions of a portrait keeps the
+// same headroom and horizontal position of the subject. The first size is
+// analyzed as usual. The other crops share its scale and place the faces, or
+// without faces the center of the first crop, at the same horizontal fraction
+// and with the same headroom in pixels, moved only as far as needed to keep the
+// faces and to stay within the image. They may therefore score lower than the crops found for
+// their size alone. Faces are only found with Config.FaceDetectEnabled.
+func FaceAnchoredCrops(a Analyzer, img image.Image, sizes []image.Point) ([]Crop, error) {
+	if len(sizes) == 0 {
+		return nil, nil
+	}
+	for _, size := range sizes {
+		if size.X <= 0 || size.Y <= 0 {
+			return nil, ErrInvalidDimensions
+		}
+	}
+
+	res, err := a.Analyze(img, sizes[0].X, sizes[0].Y)
+	if err != nil {
+		return nil, err
+	}
+	ref := res.Crop.Rectangle
+	bounds := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+
+	// the subject is what the renditions are anchored on
+	subject := image.Rectangle{Min: ref.Min.Add(ref.Max).Div(2)}
+	subject.Max = subject.Min
+	if len(res.Faces) > 0 {
+		subject = res.Faces[0]
+		for _, f := range res.Faces[1:] {
+			subject = subject.Union(f)
+		}
+	}
+	centerX := float64(subject.Min.X+subject.Max.X) / 2
+	fx := (centerX - float64(ref.Min.X)) / float64(ref.Dx())
+	headroom := subject.Min.Y - ref.Min.Y
+
+	// the scale of the first crop relative to the largest one of its aspect ratio
+	refW, refH := fitted(bounds, sizes[0])
+	scale := math.Min(float64(ref.Dx())/refW, float64(ref.Dy())/refH)
+
+	crops := []Crop{res.Crop}
+	for _, size := range sizes[1:] {
+		fw, fh := fitted(bounds, size)
+		w, h := minInt(int(math.Round(fw*scale)), bounds.Dx()), minInt(int(math.Round(fh*scale)), bounds.Dy())
+		x := int(math.Round(centerX - fx*float64(w)))
+		y := subject.Min.Y - headroom
+		if len(res.Faces) == 0 {
+			y = int(math.Round(float64(subject.Min.Y) - float64(subject.Min.Y-ref.Min.Y)/float64(ref.Dy())*float64(h)))
+		}
+		r := image.Rect(x, y, x+w, y+h)
+		// narrower crops may have to give up the position to keep the faces
+		r = r.Sub(image.Pt(shift(subject.Min.X, subject.Max.X, r.Min.X, r.Max.X), shift(subject.Min.Y, subject.Max.Y, r.Min.Y, r.Max.Y)))
+		r = r.Add(image.Pt(shift(r.Min.X, r.Max.X, bounds.Min.X, bounds.Max.X), shift(r.Min.Y, r.Max.Y, bounds.Min.Y, bounds.Max.Y)))
+
+		// a reference no candidate matches exactly is returned with its score
+		crop, err := a.FindConsistentCrop(img, size.X, size.Y, r, 1)
+		if err != nil {
+			return nil, err
+		}
+		crops = append(crops, crop)
+	}
+	return crops, nil
+}
+
+// MultiSizeAnalyzer is an Analyzer that finds the crops for several sizes from
+// a single run of the detectors. The analyzers returned by New and NewAnalyzer
+// implement it.
+type MultiSizeAnalyzer interface {
+	Analyzer
+	// FindBestCrops returns the best crop of img for every size, running the
+	// detectors only once. The crops are scored on an analysis image prescaled
+	// independently of the sizes, and only the candidate search is run for
+	// each size, so they can differ from those of FindBestCrop: there is no
+	// Config.Upscale or padding policy, no local optimization, rotation or
+	// MinAcceptableScore fallback, CompatibilityMode and DocumentMode are
+	// ignored and the Cache isn't used.
+	FindBestCrops(img image.Image, sizes []image.Point) ([]Crop, error)
+}
+
+var _ MultiSizeAnalyzer = &smartcropAnalyzer{}
+
+// FindBestCrops returns the best crop of img for every size, with
+// MultiSizeAnalyzer.FindBestCrops if a implements it, and with a call to
+// Analyze for each size otherwise.
+func FindBestCrops(a Analyzer, img image.Image, sizes []image.Point) ([]Crop, error) {
+	if m, ok := a.(MultiSizeAnalyzer); ok {
+		return m.FindBestCrops(img, sizes)
+	}
+	for _, size := range sizes {
+		if size.X <= 0 || size.Y <= 0 {
+			return nil, ErrInvalidDimensions
+		}
+	}
+	var crops []Crop
+	for _, size := range sizes {
+		res, err := a.Analyze(img, size.X, size.Y)
+		if err != nil {
+			return nil, err
+		}
+		crops = append(crops, res.Crop)
+	}
+	return crops, nil
+}
+
+// FindBestCrops implements MultiSizeAnalyzer.
+func (sca *smartcropAnalyzer) FindBestCrops(img image.Image, sizes []image.Point) ([]Crop, error) {
+	for _, size := range sizes {
+		if size.X <= 0 || size.Y <= 0 {
+			return nil, ErrInvalidDimensions
+		}
+	}
+	if len(sizes) == 0 {
+		return nil, nil
+	}
+
+	img, offset := atOrigin(img)
+	smallimg, prescalefactor, err := sca.prescale(img)
+	if err != nil {
+		return nil, err
+	}
+	analysisImg := sca.toAnalysisImage(smallimg)
+	tuned, err := sca.tunedFor(analysisImg).withBarcodes(img, prescalefactor)
+	if err != nil {
+		return nil, err
+	}
+	o, faceRects, err := tuned.detect(analysisImg)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	crops := make([]Crop, 0, len(sizes))
+	for _, size := range sizes {
+		cropWidth, cropHeight, realMinScale := sca.cropSize(float64(bounds.Dx()), float64(bounds.Dy()), size.X, size.Y, prescalefactor)
+		limited, _ := tuned.limited(analysisImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+		cs, err := limited.analyseDetected(analysisImg, o, faceRects, cropWidth, cropHeight, realMinScale, prescalefactor, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(cs) == 0 {
+			return nil, ErrNoCropFound
+		}
+		crop := limited.findTopCrop(cs, faceRects)
+		crop.Rectangle = sca.align(unscale(crop.Rectangle, prescalefactor).Canon(), bounds).Add(offset)
+		crops = append(crops, crop)
+	}
+	return versioned(crops), nil
+}
+
+// fitted returns the size of the largest crop of the aspect ratio of size that
+// fits into bounds.
+func fitted(bounds image.Rectangle, size image.Point) (float64, float64) {
+	s := math.Min(float64(bounds.Dx())/float64(size.X), float64(bounds.Dy())/float64(size.Y))
+	return float64(size.X) * s, float64(size.Y) * s
+}
+
+// shift returns how far the span min, max has to move to lie within lo, hi, as
+// far as it fits.
+func shift(min, max, lo, hi int) int {
+	switch {
+	case min < lo:
+		return lo - min
+	case max > hi:
+		return hi - max
+	}
+	return 0
+}
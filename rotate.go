@@ -0,0 +1,175 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// rotationAngles returns the angles, in degrees, Config.MaxRotation and
+// Config.RotationStep ask to evaluate besides 0.
+func (sca *smartcropAnalyzer) rotationAngles() []float64 {
+	if sca.config.MaxRotation <= 0 || sca.config.RotationStep <= 0 {
+		return nil
+	}
+	var angles []float64
+	for a := sca.config.RotationStep; a <= sca.config.MaxRotation+1e-9; a += sca.config.RotationStep {
+		angles = append(angles, -a, a)
+	}
+	return angles
+}
+
+// bestRotated rotates the detector output o, the faces and the mask by each of
+// the configured angles and returns the best candidate crop found, in rotated
+// coordinates, its angle and its face rank. Candidates have to lie completely
+// inside the rotated image.
+func (sca *smartcropAnalyzer) bestRotated(o *ScoreMap, area image.Rectangle, faceRects []image.Rectangle, mask *image.Gray, cropWidth, cropHeight, realMinScale float64) (Crop, float64, int, bool) {
+	var best Crop
+	var bestAngle float64
+	bestRank := 0
+	found := false
+	angles := sca.rotationAngles()
+	for i, angle := range angles {
+		sca.stepProgress(StageRotate, i, len(angles))
+		ro := rotateScoreMap(o, angle)
+		var rmask *image.Gray
+		if mask != nil {
+			rmask = rotateGray(mask, angle)
+		}
+		rfaces := rotateRects(faceRects, o.Bounds(), angle)
+
+		kernels := newImportanceKernels(rmask)
+		sca.eachCandidate(ro, area, rfaces, cropWidth, cropHeight, realMinScale, func(r image.Rectangle) bool {
+			if !rotatedInside(o.Bounds(), r, angle) {
+				return true
+			}
+			crop := Crop{Rectangle: r}
+			crop.Score = sca.score(ro, crop, rfaces, kernels)
+			rank := sca.faceRank(crop, rfaces)
+			if (!found || preferred(rank, crop, bestRank, best)) && sca.faceFractionOK(crop, rfaces) {
+				best, bestAngle, bestRank, found = crop, angle, rank, true
+			}
+			return true
+		})
+	}
+	sca.progress(StageRotate, 1)
+	return best, bestAngle, bestRank, found
+}
+
+// RotateImage rotates img by angle degrees, counter-clockwise as displayed, around
+// its center, e.g. by CropResult.Angle. The result has the bounds of img, corners
+// rotated in from outside are transparent.
+func RotateImage(img image.Image, angle float64) *image.RGBA {
+	src := toRGBA(img)
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	source := rotationSource(b, angle)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sx, sy := source(float64(x)+0.5, float64(y)+0.5)
+			out.SetRGBA(x, y, bilinear(src, sx-0.5, sy-0.5))
+		}
+	}
+	return out
+}
+
+// rotationSource returns the function mapping a point of the image rotated by
+// angle degrees around the center of b back to the unrotated image.
+func rotationSource(b image.Rectangle, angle float64) func(x, y float64) (float64, float64) {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx := float64(b.Min.X+b.Max.X) / 2
+	cy := float64(b.Min.Y+b.Max.Y) / 2
+	return func(x, y float64) (float64, float64) {
+		dx, dy := x-cx, y-cy
+		return cx + dx*cos - dy*sin, cy + dx*sin + dy*cos
+	}
+}
+
+// rotatedInside reports whether r, in an image rotated by angle degrees around the
+// center of b, lies completely inside the rotated b.
+func rotatedInside(b image.Rectangle, r image.Rectangle, angle float64) bool {
+	source := rotationSource(b, angle)
+	for _, p := range []image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} {
+		sx, sy := source(float64(p.X), float64(p.Y))
+		if sx < float64(b.Min.X) || sx > float64(b.Max.X) || sy < float64(b.Min.Y) || sy > float64(b.Max.Y) {
+			return false
+		}
+	}
+	return true
+}
+
+// rotateRects moves the centers of rects as if rotated by angle degrees around the
+// center of b, keeping their size.
+func rotateRects(rects []image.Rectangle, b image.Rectangle, angle float64) []image.Rectangle {
+	// the inverse of the rotation maps unrotated to rotated points
+	target := rotationSource(b, -angle)
+	out := make([]image.Rectangle, len(rects))
+	for i, r := range rects {
+		x, y := target(float64(r.Min.X+r.Max.X)/2, float64(r.Min.Y+r.Max.Y)/2)
+		min := image.Pt(int(math.Round(x))-r.Dx()/2, int(math.Round(y))-r.Dy()/2)
+		out[i] = image.Rectangle{Min: min, Max: min.Add(r.Size())}
+	}
+	return out
+}
+
+// rotateScoreMap rotates the detector output o with nearest neighbour sampling.
+func rotateScoreMap(o *ScoreMap, angle float64) *ScoreMap {
+	b := o.Bounds()
+	out := NewScoreMap(b, o.names...)
+	source := rotationSource(b, angle)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sx, sy := source(float64(x)+0.5, float64(y)+0.5)
+			p := image.Pt(int(math.Floor(sx)), int(math.Floor(sy)))
+			if p.In(b) {
+				i, j := out.PixOffset(x, y), o.PixOffset(p.X, p.Y)
+				for c, plane := range o.planes {
+					out.planes[c][i] = plane[j]
+				}
+			}
+		}
+	}
+	return out
+}
+
+// rotateGray rotates an importance mask with nearest neighbour sampling.
+func rotateGray(m *image.Gray, angle float64) *image.Gray {
+	b := m.Bounds()
+	out := image.NewGray(b)
+	source := rotationSource(b, angle)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sx, sy := source(float64(x)+0.5, float64(y)+0.5)
+			p := image.Pt(int(math.Floor(sx)), int(math.Floor(sy)))
+			if p.In(b) {
+				out.SetGray(x, y, m.GrayAt(p.X, p.Y))
+			}
+		}
+	}
+	return out
+}
+
+// bilinear samples img at x, y, where pixel centers lie on integer coordinates.
+// Points outside of img are transparent.
+func bilinear(img *image.RGBA, x, y float64) color.RGBA {
+	b := img.Bounds()
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	var sum [4]float64
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 2; i++ {
+			p := image.Pt(x0+i, y0+j)
+			if !p.In(b) {
+				continue
+			}
+			w := math.Abs(float64(1-i)-fx) * math.Abs(float64(1-j)-fy)
+			c := img.RGBAAt(p.X, p.Y)
+			sum[0] += w * float64(c.R)
+			sum[1] += w * float64(c.G)
+			sum[2] += w * float64(c.B)
+			sum[3] += w * float64(c.A)
+		}
+	}
+	return color.RGBA{uint8(sum[0] + 0.5), uint8(sum[1] + 0.5), uint8(sum[2] + 0.5), uint8(sum[3] + 0.5)}
+}
@@ -0,0 +1,134 @@
+package smartcrop
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"strings"
+)
+
+// Breakdown contains the share of each component of a Score in percent. The
+// shares are based on absolute contributions and add up to 100, unless the score
+// is all zeros.
+type Breakdown struct {
+	Detail     float64
+	Skin       float64
+	Saturation float64
+	Face       float64
+}
+
+// ScoreFunc adjusts the total score of a candidate crop. channels is the detector
+// output and crop is the candidate in its coordinates. score has its components
+// and Total filled in. The returned value replaces Total.
+type ScoreFunc func(channels *ScoreMap, crop image.Rectangle, score Score) float64
+
+// customScore returns the total score of crop after Config.ScoreFunc.
+func (sca *smartcropAnalyzer) customScore(channels *ScoreMap, crop Crop, score Score) float64 {
+	if sca.config.ScoreFunc == nil {
+		return score.Total
+	}
+	return sca.config.ScoreFunc(channels, crop.Rectangle, score)
+}
+
+// normalize fills in the Normalized and Breakdown fields of a score.
+// maxImportance is the sum of all positive importance values the crop sampled,
+// which is what each detector sum would be if the detector fired at full strength
+// on every pixel.
+func (sca *smartcropAnalyzer) normalize(score *Score, crop Crop, maxImportance float64) {
+	area := float64(crop.Dx()) * float64(crop.Dy())
+	detail := score.Detail * sca.config.DetailWeight / area
+	skin := score.Skin * sca.config.SkinWeight / area
+	saturation := score.Saturation * sca.config.SaturationWeight / area
+
+	maxTotal := maxImportance * (sca.config.DetailWeight +
+		sca.config.SkinWeight*(1+sca.config.SkinBias) +
+		sca.config.SaturationWeight*(1+sca.config.SaturationBias)) / area
+	if sca.config.SharpnessEnabled {
+		maxTotal += maxImportance * sca.config.SharpnessWeight / area
+	}
+	if sca.config.FaceDetectEnabled {
+		// face fractions of non-overlapping faces add up to at most 1, the eye
+		// line term to at most its weight
+		maxTotal += 1 + sca.config.EyeLineWeight
+	}
+	if maxTotal > 0 {
+		score.Normalized = math.Min(math.Max(score.Total/maxTotal, 0), 1)
+	}
+
+	sum := math.Abs(detail) + math.Abs(skin) + math.Abs(saturation) + math.Abs(score.Face)
+	if sum > 0 {
+		score.Breakdown = Breakdown{
+			Detail:     math.Abs(detail) / sum * 100,
+			Skin:       math.Abs(skin) / sum * 100,
+			Saturation: math.Abs(saturation) / sum * 100,
+			Face:       math.Abs(score.Face) / sum * 100,
+		}
+	}
+}
+
+// Channel returns the contribution of the named channel, looking at the fixed
+// fields first. Names are matched case-insensitively, an unknown channel is 0.
+func (s Score) Channel(name string) float64 {
+	switch strings.ToLower(name) {
+	case "detail":
+		return s.Detail
+	case "saturation":
+		return s.Saturation
+	case "skin":
+		return s.Skin
+	case "face":
+		return s.Face
+	case "total":
+		return s.Total
+	case "normalized":
+		return s.Normalized
+	}
+	for k, v := range s.Channels {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return 0
+}
+
+// UnmarshalJSON decodes a Score, keeping any unknown numeric field in Channels.
+// This way scores stored by newer versions with additional detectors can still be
+// read, and re-encoded without losing information.
+func (s *Score) UnmarshalJSON(data []byte) error {
+	// decode the known fields using the default rules
+	type plainScore Score
+	var ps plainScore
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for k, raw := range fields {
+		if isScoreField(k) {
+			continue
+		}
+		var v float64
+		if err := json.Unmarshal(raw, &v); err != nil {
+			// not a channel contribution, ignore it
+			continue
+		}
+		if ps.Channels == nil {
+			ps.Channels = make(map[string]float64)
+		}
+		ps.Channels[k] = v
+	}
+
+	*s = Score(ps)
+	return nil
+}
+
+func isScoreField(name string) bool {
+	switch strings.ToLower(name) {
+	case "detail", "saturation", "skin", "face", "total", "normalized", "breakdown", "channels":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+)
+
+// The channels of a ScoreMap written by the built-in detectors.
+const (
+	ChannelDetail     = "detail"
+	ChannelSkin       = "skin"
+	ChannelSaturation = "saturation"
+	ChannelSharpness  = "sharpness"
+)
+
+// ScoreMap is the detector output candidate crops are scored on: one plane of
+// float32 values per named channel, row by row over Rect. The built-in channels
+// range from 0 to 1.
+//
+// A ScoreMap is also an image.Image, rendering detail in the green, skin in the
+// red and saturation in the blue channel, so it can be written out for
+// debugging like any other image.
+type ScoreMap struct {
+	Rect   image.Rectangle
+	names  []string
+	planes [][]float32
+	// blockMap caches the result of blocks, for blockSize
+	blockMap  *ScoreMap
+	blockSize int
+}
+
+// NewScoreMap returns a ScoreMap over r with a zeroed plane for every name.
+func NewScoreMap(r image.Rectangle, names ...string) *ScoreMap {
+	m := &ScoreMap{Rect: r}
+	for _, name := range names {
+		m.AddPlane(name)
+	}
+	return m
+}
+
+// newDetectorMap returns a ScoreMap with the channels of the built-in detectors.
+func newDetectorMap(r image.Rectangle) *ScoreMap {
+	return NewScoreMap(r, ChannelDetail, ChannelSkin, ChannelSaturation)
+}
+
+// Names returns the names of the channels of m, in the order they were added.
+func (m *ScoreMap) Names() []string {
+	return append([]string(nil), m.names...)
+}
+
+// Plane returns the values of the named channel, with the value of x, y at
+// PixOffset(x, y), or nil if m has no such channel. Changes to the returned
+// slice change m.
+func (m *ScoreMap) Plane(name string) []float32 {
+	for i, n := range m.names {
+		if n == name {
+			return m.planes[i]
+		}
+	}
+	return nil
+}
+
+// AddPlane adds a zeroed channel of the given name to m and returns its values,
+// or returns the existing values if m already has it.
+func (m *ScoreMap) AddPlane(name string) []float32 {
+	if p := m.Plane(name); p != nil {
+		return p
+	}
+	p := make([]float32, m.Rect.Dx()*m.Rect.Dy())
+	m.names = append(m.names, name)
+	m.planes = append(m.planes, p)
+	m.blockMap = nil
+	return p
+}
+
+// setPlane replaces the values of the named channel, which are laid out like the
+// planes of m.
+func (m *ScoreMap) setPlane(name string, values []float32) {
+	for i, n := range m.names {
+		if n == name {
+			m.planes[i] = values
+			m.blockMap = nil
+			return
+		}
+	}
+	m.names = append(m.names, name)
+	m.planes = append(m.planes, values)
+	m.blockMap = nil
+}
+
+// PixOffset returns the index of the value of x, y in the planes of m.
+func (m *ScoreMap) PixOffset(x, y int) int {
+	return (y-m.Rect.Min.Y)*m.Rect.Dx() + (x - m.Rect.Min.X)
+}
+
+// Value returns the value of the named channel at x, y, zero if x, y lies outside
+// m or m has no such channel.
+func (m *ScoreMap) Value(name string, x, y int) float32 {
+	p := m.Plane(name)
+	if p == nil || !(image.Point{x, y}.In(m.Rect)) {
+		return 0
+	}
+	return p[m.PixOffset(x, y)]
+}
+
+// blocks returns the mean of every ds x ds block of m, starting at the origin of
+// m, as a map with one value per block. Blocks at the right and bottom edges
+// cover what is left of m and may be smaller. The result is cached, so m must not
+// change once scoring started.
+func (m *ScoreMap) blocks(ds int) *ScoreMap {
+	if ds <= 1 {
+		return m
+	}
+	if m.blockMap != nil && m.blockSize == ds {
+		return m.blockMap
+	}
+
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	bw, bh := (width+ds-1)/ds, (height+ds-1)/ds
+	out := NewScoreMap(image.Rect(0, 0, bw, bh), m.names...)
+	counts := make([]int, bw*bh)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			counts[y/ds*bw+x/ds]++
+		}
+	}
+	for c, plane := range m.planes {
+		sums := make([]float64, bw*bh)
+		for y := 0; y < height; y++ {
+			row := plane[y*width : (y+1)*width]
+			for x, v := range row {
+				sums[y/ds*bw+x/ds] += float64(v)
+			}
+		}
+		for i, sum := range sums {
+			out.planes[c][i] = float32(sum / float64(counts[i]))
+		}
+	}
+
+	m.blockMap, m.blockSize = out, ds
+	return out
+}
+
+// sampler describes the samples the score loops take from a ScoreMap: every ds
+// pixels, up to the last position at least end pixels from the right and bottom
+// edges.
+type sampler struct {
+	m             *ScoreMap
+	ds, end       int
+	width, height int
+	block         bool
+}
+
+// sampler returns the sampler of o, which samples the means of blocks with
+// Config.BlockScoring and single pixels otherwise.
+func (sca *smartcropAnalyzer) sampler(o *ScoreMap) sampler {
+	s := sampler{m: o, ds: sca.config.ScoreDownSample, end: sca.config.ScoreDownSample, width: o.Rect.Dx(), height: o.Rect.Dy()}
+	if sca.config.BlockScoring && s.ds > 1 {
+		s.m, s.end, s.block = o.blocks(s.ds), 1, true
+	}
+	return s
+}
+
+// at returns the index of the sample at x, y in the planes of s.m and its weight,
+// the share of a full block it covers.
+func (s sampler) at(x, y int) (int, float64) {
+	if !s.block {
+		return s.m.PixOffset(x, y), 1
+	}
+	w, h := s.ds, s.ds
+	if x+w > s.width {
+		w = s.width - x
+	}
+	if y+h > s.height {
+		h = s.height - y
+	}
+	return (y/s.ds)*s.m.Rect.Dx() + x/s.ds, float64(w*h) / float64(s.ds*s.ds)
+}
+
+// ColorModel implements image.Image.
+func (m *ScoreMap) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds implements image.Image.
+func (m *ScoreMap) Bounds() image.Rectangle {
+	return m.Rect
+}
+
+// At implements image.Image.
+func (m *ScoreMap) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.RGBA{}
+	}
+	return m.rgbaAt(m.Plane(ChannelSkin), m.Plane(ChannelDetail), m.Plane(ChannelSaturation), m.PixOffset(x, y))
+}
+
+// RGBA renders m like At does, for the cost of a single pass.
+func (m *ScoreMap) RGBA() *image.RGBA {
+	skin, detail, sat := m.Plane(ChannelSkin), m.Plane(ChannelDetail), m.Plane(ChannelSaturation)
+	out := image.NewRGBA(m.Rect)
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		for x := m.Rect.Min.X; x < m.Rect.Max.X; x++ {
+			out.SetRGBA(x, y, m.rgbaAt(skin, detail, sat, m.PixOffset(x, y)))
+		}
+	}
+	return out
+}
+
+func (m *ScoreMap) rgbaAt(r, g, b []float32, i int) color.RGBA {
+	channel := func(p []float32) uint8 {
+		if p == nil {
+			return 0
+		}
+		return uint8(bounds(float64(p[i]) * 255))
+	}
+	return color.RGBA{channel(r), channel(g), channel(b), 255}
+}
@@ -0,0 +1,192 @@
+package smartcrop
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// faceEnergy is added to the seam carving energy of face pixels, so seams go
+// around faces whenever possible.
+const faceEnergy = 1e6
+
+// Retarget shrinks img to exactly width x height by seam carving instead of
+// cropping: img is scaled until one side matches, then the seams of least
+// importance, as seen by the detectors, are removed from the other side. This
+// keeps subjects that no single crop can hold, at the cost of distorting the
+// space between them.
+func (sca *smartcropAnalyzer) Retarget(img image.Image, width, height int) (image.Image, error) {
+	if width <= 0 || height <= 0 {
+		return nil, ErrInvalidDimensions
+	}
+
+	b := img.Bounds()
+	s := math.Max(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	w, h := int(math.Ceil(float64(b.Dx())*s)), int(math.Ceil(float64(b.Dy())*s))
+	if w != b.Dx() || h != b.Dy() {
+		var err error
+		img, err = sca.resize(img, uint(w), uint(h))
+		if err != nil {
+			return nil, err
+		}
+	}
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	tuned := sca.tunedFor(src)
+	o, faceRects, err := tuned.detect(src)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCarver(src, tuned.energy(o, faceRects))
+	for c.w > width {
+		c.removeVerticalSeam()
+	}
+	c.transpose()
+	for c.w > height {
+		c.removeVerticalSeam()
+	}
+	c.transpose()
+	return c.image(), nil
+}
+
+// energy returns the energy of every pixel of the detector output o, row by row,
+// weighting the channels like score does. Face pixels get faceEnergy on top.
+func (sca *smartcropAnalyzer) energy(o *ScoreMap, faceRects []image.Rectangle) []float64 {
+	b := o.Bounds()
+	e := make([]float64, b.Dx()*b.Dy())
+	detail, skin, sat := o.Plane(ChannelDetail), o.Plane(ChannelSkin), o.Plane(ChannelSaturation)
+	for i := range e {
+		e[i] = float64(detail[i])*sca.config.DetailWeight +
+			float64(skin[i])*sca.config.SkinWeight +
+			float64(sat[i])*sca.config.SaturationWeight
+	}
+	for _, r := range faceRects {
+		r = r.Intersect(b)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				e[(y-b.Min.Y)*b.Dx()+x-b.Min.X] += faceEnergy
+			}
+		}
+	}
+	return e
+}
+
+// carver removes seams from an image and its energy map, which are stored row by
+// row with a stride of the original width.
+type carver struct {
+	pix    []uint32
+	energy []float64
+	w, h   int
+	stride int
+	// cost and path are reused between seams
+	cost []float64
+	path []int
+}
+
+func newCarver(img *image.RGBA, energy []float64) *carver {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	c := &carver{
+		pix:    make([]uint32, w*h),
+		energy: energy,
+		w:      w,
+		h:      h,
+		stride: w,
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			c.pix[y*w+x] = uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+		}
+	}
+	return c
+}
+
+// removeVerticalSeam removes the connected top to bottom path of least energy.
+func (c *carver) removeVerticalSeam() {
+	if len(c.cost) < c.w*c.h {
+		c.cost = make([]float64, c.w*c.h)
+		c.path = make([]int, c.h)
+	}
+
+	for x := 0; x < c.w; x++ {
+		c.cost[x] = c.energy[x]
+	}
+	for y := 1; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			above := c.cost[(y-1)*c.w:]
+			best := above[x]
+			if x > 0 && above[x-1] < best {
+				best = above[x-1]
+			}
+			if x < c.w-1 && above[x+1] < best {
+				best = above[x+1]
+			}
+			c.cost[y*c.w+x] = best + c.energy[y*c.stride+x]
+		}
+	}
+
+	// backtrack from the cheapest end point
+	last := (c.h - 1) * c.w
+	x := 0
+	for i := 1; i < c.w; i++ {
+		if c.cost[last+i] < c.cost[last+x] {
+			x = i
+		}
+	}
+	for y := c.h - 1; y >= 0; y-- {
+		c.path[y] = x
+		if y == 0 {
+			break
+		}
+		prev := x
+		for _, nx := range []int{x - 1, x + 1} {
+			if nx >= 0 && nx < c.w && c.cost[(y-1)*c.w+nx] < c.cost[(y-1)*c.w+prev] {
+				prev = nx
+			}
+		}
+		x = prev
+	}
+
+	for y := 0; y < c.h; y++ {
+		row := y * c.stride
+		x := c.path[y]
+		copy(c.pix[row+x:row+c.w-1], c.pix[row+x+1:row+c.w])
+		copy(c.energy[row+x:row+c.w-1], c.energy[row+x+1:row+c.w])
+	}
+	c.w--
+}
+
+// transpose swaps rows and columns, so horizontal seams can be removed as vertical
+// ones.
+func (c *carver) transpose() {
+	pix := make([]uint32, c.w*c.h)
+	energy := make([]float64, c.w*c.h)
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			pix[x*c.h+y] = c.pix[y*c.stride+x]
+			energy[x*c.h+y] = c.energy[y*c.stride+x]
+		}
+	}
+	c.pix, c.energy = pix, energy
+	c.w, c.h = c.h, c.w
+	c.stride = c.w
+	c.cost = nil
+}
+
+func (c *carver) image() *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, c.w, c.h))
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			p := c.pix[y*c.stride+x]
+			i := out.PixOffset(x, y)
+			out.Pix[i] = uint8(p >> 24)
+			out.Pix[i+1] = uint8(p >> 16)
+			out.Pix[i+2] = uint8(p >> 8)
+			out.Pix[i+3] = uint8(p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package smartcrop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// SelfTest checks that the analyzer is usable, so services can fail at startup
+// rather than on the first request. It checks the config for values out of
+// range and runs a small synthetic image through all enabled stages, which
+// loads the face detection classifier. With Config.NightDetectEnabled, a dark
+// version of the image tests the night tuning as well. The analysis isn't
+// reported to the MetricsSink, Tracer or Cache of the analyzer.
+func (sca *smartcropAnalyzer) SelfTest() error {
+	if err := sca.config.validate(); err != nil {
+		return err
+	}
+	if sca.config.FaceDetectEnabled && sca.faces == nil && sca.config.FaceDetectClassifierFile == "" {
+		return errors.New("Config.FaceDetectClassifierFile is required for face detection")
+	}
+
+	c := *sca
+	c.metrics, c.tracer, c.cache = nil, nil, nil
+	images := []image.Image{selfTestImage(255)}
+	if sca.night != nil {
+		images = append(images, selfTestImage(40))
+	}
+	for _, img := range images {
+		if _, err := c.Analyze(img, 32, 32); err != nil {
+			return fmt.Errorf("Self test analysis failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// selfTestImage returns a 96x64 image with a gradient, a skin toned and a
+// saturated patch, at the given brightness from 0 to 255.
+func selfTestImage(brightness int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	scale := func(v int) uint8 { return uint8(v * brightness / 255) }
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			var c color.RGBA
+			switch {
+			case x >= 16 && x < 40 && y >= 16 && y < 48:
+				c = color.RGBA{scale(224), scale(172), scale(140), 255}
+			case x >= 60 && x < 80 && y >= 8 && y < 28:
+				c = color.RGBA{scale(30), scale(60), scale(230), 255}
+			default:
+				g := scale(x * 255 / 95)
+				c = color.RGBA{g, g, g, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// validate returns an error describing the first value of c out of range.
+func (c Config) validate() error {
+	switch {
+	case c.ScoreDownSample < 1:
+		return fmt.Errorf("Config.ScoreDownSample must be at least 1, got %d", c.ScoreDownSample)
+	case c.Step < 1 && c.OriginalStep <= 0:
+		return fmt.Errorf("Config.Step must be at least 1, got %d", c.Step)
+	case c.MinScale <= 0 || c.MinScale > c.MaxScale:
+		return fmt.Errorf("Config.MinScale must be above 0 and at most MaxScale, got %g and %g", c.MinScale, c.MaxScale)
+	case c.ScaleStep <= 0 && c.MinScale < c.MaxScale:
+		return fmt.Errorf("Config.ScaleStep must be above 0, got %g", c.ScaleStep)
+	case c.Prescale && c.PrescaleMin <= 0:
+		return fmt.Errorf("Config.PrescaleMin must be above 0 with Prescale, got %g", c.PrescaleMin)
+	case c.PrunePercentile < 0 || c.PrunePercentile > 1:
+		return fmt.Errorf("Config.PrunePercentile must be between 0 and 1, got %g", c.PrunePercentile)
+	case c.MaxRotation > 0 && c.RotationStep <= 0:
+		return fmt.Errorf("Config.RotationStep must be above 0 with MaxRotation, got %g", c.RotationStep)
+	case c.FaceDetectScaleFactor != 0 && c.FaceDetectScaleFactor <= 1:
+		return fmt.Errorf("Config.FaceDetectScaleFactor must be above 1, got %g", c.FaceDetectScaleFactor)
+	case c.MaxFaceFraction < 0 || c.MaxFaceFraction > 1:
+		return fmt.Errorf("Config.MaxFaceFraction must be between 0 and 1, got %g", c.MaxFaceFraction)
+	case c.FaceMinAreaFraction < 0 || c.FaceMinAreaFraction > 1:
+		return fmt.Errorf("Config.FaceMinAreaFraction must be between 0 and 1, got %g", c.FaceMinAreaFraction)
+	case c.MinAcceptableScore < 0 || c.MinAcceptableScore > 1:
+		return fmt.Errorf("Config.MinAcceptableScore must be between 0 and 1, got %g", c.MinAcceptableScore)
+	case c.AlignTo < 0:
+		return fmt.Errorf("Config.AlignTo must not be negative, got %d", c.AlignTo)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+)
+
+// SensitiveDetector finds regions of an image a crop must not zoom into, such as
+// nudity, see WithSensitiveDetector. It is passed the analysis image, which is
+// prescaled with Config.Prescale, and returns the regions in its coordinates.
+type SensitiveDetector interface {
+	DetectSensitiveRegions(img image.Image) ([]image.Rectangle, error)
+}
+
+// SensitivePolicy decides how the regions found by a SensitiveDetector affect
+// the crop, see Config.SensitivePolicy.
+type SensitivePolicy int
+
+const (
+	// SensitiveExclude skips candidates overlapping a sensitive region. If every
+	// candidate does, the crop widens as with SensitiveWiden. This is the
+	// default.
+	SensitiveExclude SensitivePolicy = iota
+	// SensitiveWiden returns the widest crop of the requested aspect ratio,
+	// centered in the image, for any image with a sensitive region.
+	SensitiveWiden
+)
+
+// detectSensitive returns the sensitive regions of img, none without a
+// SensitiveDetector.
+func (sca *smartcropAnalyzer) detectSensitive(img image.Image) ([]image.Rectangle, error) {
+	if sca.sensitive == nil {
+		return nil, nil
+	}
+	return sca.sensitive.DetectSensitiveRegions(img)
+}
+
+// avoidSensitive applies Config.SensitivePolicy to the scored candidates cs.
+func (sca *smartcropAnalyzer) avoidSensitive(o *ScoreMap, cs []Crop, faceRects []image.Rectangle, kernels importanceKernels, regions []image.Rectangle, cropWidth, cropHeight float64) []Crop {
+	if len(regions) == 0 {
+		return cs
+	}
+
+	if sca.config.SensitivePolicy == SensitiveExclude {
+		var safe []Crop
+		for _, crop := range cs {
+			if !overlapsAny(crop.Rectangle, regions) {
+				safe = append(safe, crop)
+			}
+		}
+		if len(safe) > 0 {
+			return safe
+		}
+	}
+	sca.logger.Log.Println("sensitive regions found, widening the crop")
+	bounds := o.Bounds()
+	s := math.Min(float64(bounds.Dx())/cropWidth, float64(bounds.Dy())/cropHeight)
+	wide := centerCrop(bounds, int(cropWidth*s), int(cropHeight*s))
+	wide.Score = sca.score(o, wide, faceRects, kernels)
+	return []Crop{wide}
+}
+
+// constrained reports whether regions found in the image constrain the crop.
+// Config.LocalOptimization, Config.MaxRotation and the Config.MinAcceptableScore
+// fallback are not applied then, as they could move the crop into a sensitive
+// region or cut through a code or template.
+func (sca *smartcropAnalyzer) constrained() bool {
+	return sca.sensitive != nil || sca.barcodes != nil ||
+		len(sca.templates) > 0 && sca.config.TemplatePolicy == TemplateInclude
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/third-light/smartcrop"
+)
+
+// NewMux returns a ServeMux serving h below /, and /healthz and /info for the
+// analyzer of h.
+func NewMux(h *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", HealthHandler(h.Analyzer))
+	mux.Handle("/info", InfoHandler(h.Analyzer))
+	mux.Handle("/", h)
+	return mux
+}
+
+// HealthHandler returns a handler responding 200 OK while a passes its
+// SelfTest, and 503 Service Unavailable with the error otherwise.
+func HealthHandler(a smartcrop.Analyzer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if err := a.SelfTest(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// InfoHandler returns a handler responding with the Info of a as JSON.
+func InfoHandler(a smartcrop.Analyzer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Info())
+	})
+}
@@ -0,0 +1,225 @@
+// Package server implements an HTTP image proxy that crops images on the fly.
+// A Handler serves the images below an upstream URL, cropped to the size given
+// by the w and h query parameters:
+//
+//	upstream, _ := url.Parse("https://images.example.com/originals/")
+//	h := server.New(upstream, smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()))
+//	http.Handle("/thumbs/", http.StripPrefix("/thumbs/", h))
+//
+// GET /thumbs/photo.jpg?w=300&h=200 then returns the best 300x200 crop of
+// https://images.example.com/originals/photo.jpg. A missing w or h keeps the
+// aspect ratio of the crop.
+//
+// NewMux adds the endpoints operators check a deployment with: /healthz runs
+// the analyzer's SelfTest and /info returns its smartcrop.Info, such as the
+// versions, detectors and config fingerprint.
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decode GIF images
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/third-light/smartcrop"
+	_ "golang.org/x/image/webp" // decode WebP images
+)
+
+// Handler is an http.Handler serving cropped images from an upstream server.
+// Its fields may be changed before it serves the first request.
+type Handler struct {
+	// Upstream is the URL the request paths are resolved against.
+	Upstream *url.URL
+	// Analyzer crops the images.
+	Analyzer smartcrop.Analyzer
+	// Client fetches the upstream images.
+	Client *http.Client
+	// MaxAge sets the max-age of the Cache-Control header of the responses.
+	MaxAge time.Duration
+	// Quality is the quality of JPEG responses.
+	Quality int
+
+	// MaxImageBytes limits the size of the upstream images. 0 means no limit.
+	MaxImageBytes int64
+	// MaxPixels limits the pixels of the upstream images, checked before they
+	// are decoded, and of the requested size. 0 means no limit.
+	MaxPixels int64
+	// Limiter, if set, limits the rate of requests. Requests beyond it are
+	// refused with 429 Too Many Requests.
+	Limiter *RateLimiter
+}
+
+// New returns a Handler serving the images below upstream, cropped by a, with
+// http.DefaultClient, a MaxAge of a day, a Quality of 85 and images limited to
+// 32 MiB and 50 megapixels.
+func New(upstream *url.URL, a smartcrop.Analyzer) *Handler {
+	return &Handler{
+		Upstream:      upstream,
+		Analyzer:      a,
+		Client:        http.DefaultClient,
+		MaxAge:        24 * time.Hour,
+		Quality:       85,
+		MaxImageBytes: 32 << 20,
+		MaxPixels:     50e6,
+	}
+}
+
+// ServeHTTP implements http.Handler. Upstream responses other than 200 are
+// reported as 502 Bad Gateway, except for 404 Not Found. JPEG images are served
+// as JPEG, all others as PNG.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	width, err := dimension(r.URL.Query(), "w")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	height, err := dimension(r.URL.Query(), "h")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if width == 0 && height == 0 {
+		http.Error(w, smartcrop.ErrInvalidDimensions.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.Limiter != nil && !h.Limiter.Allow() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	img, format, status, err := h.fetch(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if h.MaxPixels > 0 && outputPixels(width, height, img.Bounds()) > h.MaxPixels {
+		http.Error(w, "requested size exceeds the pixel limit", http.StatusBadRequest)
+		return
+	}
+	out, _, err := h.Analyzer.CropAndResize(img, width, height)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.MaxAge/time.Second)))
+	if format == "jpeg" {
+		w.Header().Set("Content-Type", "image/jpeg")
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if format == "jpeg" {
+		err = jpeg.Encode(w, out, &jpeg.Options{Quality: h.Quality})
+	} else {
+		err = png.Encode(w, out)
+	}
+	if err != nil {
+		// the headers are sent, so all that is left is to drop the connection
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// fetch returns the decoded upstream image for r and its format, or the status
+// to fail the request with.
+func (h *Handler) fetch(r *http.Request) (image.Image, string, int, error) {
+	// cleaning the path first keeps it below Upstream
+	ref := &url.URL{Path: strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")}
+	src := h.Upstream.ResolveReference(ref)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, src.String(), nil)
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, "", http.StatusBadGateway, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", http.StatusNotFound, errors.New("image not found")
+	default:
+		return nil, "", http.StatusBadGateway, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	body := io.Reader(resp.Body)
+	if h.MaxImageBytes > 0 {
+		if resp.ContentLength > h.MaxImageBytes {
+			return nil, "", http.StatusBadGateway, errImageTooLarge
+		}
+		body = io.LimitReader(resp.Body, h.MaxImageBytes+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, "", http.StatusBadGateway, err
+	}
+	if h.MaxImageBytes > 0 && int64(len(data)) > h.MaxImageBytes {
+		return nil, "", http.StatusBadGateway, errImageTooLarge
+	}
+
+	img, format, err := smartcrop.DecodeLimited(bytes.NewReader(data), h.MaxPixels)
+	if err != nil {
+		return nil, "", http.StatusUnprocessableEntity, err
+	}
+	return img, format, http.StatusOK, nil
+}
+
+var errImageTooLarge = errors.New("image exceeds the size limit")
+
+// dimension returns the size in the query parameter name, 0 if it is missing.
+func dimension(q url.Values, name string) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, s)
+	}
+	return v, nil
+}
+
+// outputPixels returns about how many pixels a width x height response for an
+// image with the given bounds has, estimating a missing width or height from the
+// aspect ratio of the image.
+func outputPixels(width, height int, bounds image.Rectangle) int64 {
+	w, h := float64(width), float64(height)
+	switch {
+	case width == 0:
+		w = h * float64(bounds.Dx()) / float64(bounds.Dy())
+	case height == 0:
+		h = w * float64(bounds.Dy()) / float64(bounds.Dx())
+	}
+	return int64(w * h)
+}
+
+// errorStatus returns the status for an error of the analysis.
+func errorStatus(err error) int {
+	switch err {
+	case smartcrop.ErrInvalidDimensions, smartcrop.ErrTargetTooLarge:
+		return http.StatusBadRequest
+	case smartcrop.ErrNoCropFound, smartcrop.ErrImageTooLarge:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+// testImage returns a 200x100 image with a bright square on the right.
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.RGBA{90, 90, 90, 255}
+			if x >= 150 && x < 180 && y >= 35 && y < 65 {
+				c = color.RGBA{240, 60, 30, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// upstream serves the test image as /originals/photo.jpg and photo.png, and
+// records the paths requested.
+func upstream(t *testing.T) (*httptest.Server, *[]string) {
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/originals/photo.jpg":
+			w.Write(jpg.Bytes())
+		case "/originals/photo.png":
+			w.Write(pngData.Bytes())
+		case "/originals/text.jpg":
+			w.Write([]byte("not an image"))
+		case "/originals/broken.jpg":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv, &paths
+}
+
+func newHandler(t *testing.T, srv *httptest.Server) *Handler {
+	u, err := url.Parse(srv.URL + "/originals/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(u, smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()))
+	h.Client = srv.Client()
+	return h
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestServeHTTP(t *testing.T) {
+	srv, _ := upstream(t)
+	defer srv.Close()
+	h := newHandler(t, srv)
+
+	for _, tc := range []struct {
+		target, contentType string
+		width, height       int
+	}{
+		{"/photo.jpg?w=100&h=50", "image/jpeg", 100, 50},
+		{"/photo.png?w=80&h=80", "image/png", 80, 80},
+		{"/photo.png?h=40", "image/png", 0, 40},
+	} {
+		rec := serve(h, http.MethodGet, tc.target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", tc.target, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s: expected %s, got %s", tc.target, tc.contentType, ct)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+			t.Errorf("%s: unexpected Cache-Control %q", tc.target, cc)
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.target, err)
+		}
+		if b := img.Bounds(); (tc.width != 0 && b.Dx() != tc.width) || b.Dy() != tc.height {
+			t.Errorf("%s: expected a %dx%d image, got %v", tc.target, tc.width, tc.height, b)
+		}
+	}
+
+	rec := serve(h, http.MethodHead, "/photo.jpg?w=100&h=50")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("expected the headers only for HEAD, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestServeHTTPPathTraversal(t *testing.T) {
+	srv, paths := upstream(t)
+	defer srv.Close()
+	h := newHandler(t, srv)
+
+	for _, target := range []string{"/../photo.jpg?w=10&h=10", "/a/../../../photo.jpg?w=10&h=10", "/%2e%2e/photo.jpg?w=10&h=10"} {
+		*paths = nil
+		rec := serve(h, http.MethodGet, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, rec.Code, rec.Body)
+		}
+		if len(*paths) != 1 || (*paths)[0] != "/originals/photo.jpg" {
+			t.Fatalf("%s: expected the request to stay below the upstream path, got %v", target, *paths)
+		}
+	}
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	srv, _ := upstream(t)
+	defer srv.Close()
+	h := newHandler(t, srv)
+
+	for _, tc := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodPost, "/photo.jpg?w=10&h=10", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/photo.jpg", http.StatusBadRequest},
+		{http.MethodGet, "/photo.jpg?w=abc&h=10", http.StatusBadRequest},
+		{http.MethodGet, "/photo.jpg?w=10&h=-1", http.StatusBadRequest},
+		{http.MethodGet, "/photo.jpg?w=0&h=0", http.StatusBadRequest},
+		{http.MethodGet, "/missing.jpg?w=10&h=10", http.StatusNotFound},
+		{http.MethodGet, "/broken.jpg?w=10&h=10", http.StatusBadGateway},
+		{http.MethodGet, "/text.jpg?w=10&h=10", http.StatusUnprocessableEntity},
+	} {
+		rec := serve(h, tc.method, tc.target)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: expected %d, got %d", tc.method, tc.target, tc.status, rec.Code)
+		}
+	}
+	if allow := serve(h, http.MethodPost, "/photo.jpg?w=10&h=10").Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("expected the allowed methods, got %q", allow)
+	}
+
+	failing := *h
+	for err, status := range map[error]int{
+		smartcrop.ErrNoCropFound:    http.StatusUnprocessableEntity,
+		errors.New("out of memory"): http.StatusInternalServerError,
+	} {
+		failing.Analyzer = failingAnalyzer{h.Analyzer, err}
+		if rec := serve(&failing, http.MethodGet, "/photo.jpg?w=10&h=10"); rec.Code != status {
+			t.Errorf("%v: expected %d, got %d", err, status, rec.Code)
+		}
+	}
+}
+
+// failingAnalyzer fails to crop and self-test with err.
+type failingAnalyzer struct {
+	smartcrop.Analyzer
+	err error
+}
+
+func (a failingAnalyzer) CropAndResize(img image.Image, width, height int) (image.Image, smartcrop.Crop, error) {
+	return nil, smartcrop.Crop{}, a.err
+}
+
+func (a failingAnalyzer) SelfTest() error {
+	return a.err
+}
+
+func TestServeHTTPLimits(t *testing.T) {
+	srv, _ := upstream(t)
+	defer srv.Close()
+
+	h := newHandler(t, srv)
+	h.MaxImageBytes = 100
+	if rec := serve(h, http.MethodGet, "/photo.png?w=10&h=10"); rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), errImageTooLarge.Error()) {
+		t.Errorf("expected the image to exceed the byte limit, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// the image has 20000 pixels
+	h = newHandler(t, srv)
+	h.MaxPixels = 10000
+	if rec := serve(h, http.MethodGet, "/photo.png?w=10&h=10"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the image to exceed the pixel limit, got %d: %s", rec.Code, rec.Body)
+	}
+	h.MaxPixels = 30000
+	if rec := serve(h, http.MethodGet, "/photo.png?w=10&h=10"); rec.Code != http.StatusOK {
+		t.Errorf("expected the image within the pixel limit, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, http.MethodGet, "/photo.png?w=300"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected the requested size to exceed the pixel limit, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestServeHTTPRateLimit(t *testing.T) {
+	srv, _ := upstream(t)
+	defer srv.Close()
+
+	h := newHandler(t, srv)
+	h.Limiter = NewRateLimiter(1e-9, 2)
+	for i := 0; i < 2; i++ {
+		if rec := serve(h, http.MethodGet, "/photo.png?w=10&h=10"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	rec := serve(h, http.MethodGet, "/photo.png?w=10&h=10")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After, got %d", rec.Code)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(1, 2)
+	if !l.Allow() || !l.Allow() {
+		t.Fatal("expected a full bucket to allow a burst")
+	}
+	if l.Allow() {
+		t.Fatal("expected an empty bucket to refuse")
+	}
+
+	// a token is added every second, up to the burst
+	l.last = l.last.Add(-time.Second)
+	if !l.Allow() || l.Allow() {
+		t.Fatal("expected one token after a second")
+	}
+	l.last = l.last.Add(-time.Hour)
+	if !l.Allow() || !l.Allow() || l.Allow() {
+		t.Fatal("expected the bucket to refill up to the burst")
+	}
+}
+
+func TestHealthAndInfo(t *testing.T) {
+	srv, _ := upstream(t)
+	defer srv.Close()
+	h := newHandler(t, srv)
+	mux := NewMux(h)
+
+	rec := serve(mux, http.MethodGet, "/healthz")
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok\n" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected a healthy analyzer, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(HealthHandler(failingAnalyzer{h.Analyzer, errors.New("broken")}), http.MethodGet, "/healthz"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "broken") {
+		t.Fatalf("expected 503 for a failing self-test, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(mux, http.MethodGet, "/info")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var info smartcrop.Info
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if want := h.Analyzer.Info(); info.AlgorithmVersion != want.AlgorithmVersion || info.ConfigHash != want.ConfigHash || len(info.Detectors) != len(want.Detectors) {
+		t.Fatalf("expected %+v, got %+v", want, info)
+	}
+
+	// everything else goes to the handler
+	if rec := serve(mux, http.MethodGet, "/photo.png?w=10&h=10"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the image, got %d", rec.Code)
+	}
+}
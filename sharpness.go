@@ -0,0 +1,49 @@
+package smartcrop
+
+import (
+	"image"
+	"time"
+
+	"github.com/third-light/smartcrop/detect"
+)
+
+// sharpnessStage runs the sharpness detector over i if Config.SharpnessEnabled
+// is set.
+func (sca *smartcropAnalyzer) sharpnessStage(i image.Image, o *ScoreMap) {
+	if !sca.config.SharpnessEnabled {
+		return
+	}
+	now := time.Now()
+	sca.progress(StageSharpness, 0)
+	o.setPlane(ChannelSharpness, detect.Sharpness(i, detect.SharpnessOptions{
+		BlockSize: sca.config.SharpnessBlockSize,
+		Lightness: sca.lightness(),
+	}).Values)
+	sca.progress(StageSharpness, 1)
+	sca.logger.Log.Println("Time elapsed sharpness:", time.Since(now))
+	sca.observeStage(StageSharpness, now)
+}
+
+// sharpnessScore records the importance weighted sharpness within the crop in
+// the "sharpness" channel of score and returns its weighted contribution to the
+// total, before it is divided by the crop area. The sum is kept in fixed point,
+// so it is the same with DeterministicScoring.
+func (sca *smartcropAnalyzer) sharpnessScore(samples sampler, width, height int, kernel importanceKernel, score *Score) float64 {
+	plane := samples.m.Plane(ChannelSharpness)
+	if !sca.config.SharpnessEnabled || plane == nil {
+		return 0
+	}
+	var sum int64
+	for y := 0; y <= height-samples.end; y += samples.ds {
+		for x := 0; x <= width-samples.end; x += samples.ds {
+			i, weight := samples.at(x, y)
+			sum += toFixed(float64(float64(plane[i]) * float64(kernel.at(x, y)*weight)))
+		}
+	}
+	sharpness := fromFixed(sum)
+	if score.Channels == nil {
+		score.Channels = make(map[string]float64)
+	}
+	score.Channels[ChannelSharpness] = sharpness
+	return float64(sharpness * sca.config.SharpnessWeight)
+}
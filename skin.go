@@ -0,0 +1,62 @@
+package smartcrop
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/third-light/smartcrop/detect"
+)
+
+// Skin detectors selectable via Config.SkinDetector.
+const (
+	// SkinDetectorRGB compares the normalized RGB vector of a pixel to the
+	// references in Config.SkinColors. This is the default.
+	SkinDetectorRGB = "rgb"
+	// SkinDetectorYCbCr accepts pixels whose chroma lies in the classic skin
+	// range. It is less prone to firing on orange and brown objects such as
+	// wood or sand, but doesn't grade its response.
+	SkinDetectorYCbCr = "ycbcr"
+)
+
+// DiverseSkinColors covers a range of skin tones from light to dark, for use as
+// Config.SkinColors. The default reference on its own favours medium tones.
+var DiverseSkinColors = [][3]float64{
+	{0.78, 0.57, 0.44},
+	{0.65, 0.57, 0.50},
+	{0.72, 0.56, 0.45},
+	{0.77, 0.52, 0.37},
+	{0.80, 0.49, 0.35},
+}
+
+// skinColors returns the skin references configured for the analyzer.
+func (sca *smartcropAnalyzer) skinColors() [][3]float64 {
+	if len(sca.config.SkinColors) > 0 {
+		return sca.config.SkinColors
+	}
+	return [][3]float64{detect.DefaultSkinColor}
+}
+
+// skinScorer returns the function rating how skin-like a pixel is, from 0 to 1,
+// for the configured skin detector.
+func (sca *smartcropAnalyzer) skinScorer() func(c color.RGBA) float64 {
+	if sca.config.SkinDetector == SkinDetectorYCbCr {
+		return detect.SkinYCbCr
+	}
+	skinColors := sca.skinColors()
+	return func(c color.RGBA) float64 {
+		return detect.SkinSimilarity(c, skinColors)
+	}
+}
+
+// withFaces returns the analyzer to score the crops of an image with the given
+// faces, with SkinWeight reduced by Config.FaceSkinAttenuation if there are
+// any.
+func (sca *smartcropAnalyzer) withFaces(faceRects []image.Rectangle) *smartcropAnalyzer {
+	if len(faceRects) == 0 || sca.config.FaceSkinAttenuation <= 0 {
+		return sca
+	}
+	c := *sca
+	c.config.SkinWeight *= 1 - math.Min(sca.config.FaceSkinAttenuation, 1)
+	return &c
+}
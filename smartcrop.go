@@ -32,6 +32,7 @@ Jonas Wagner's smartcrop.js https://github.com/jwagner/smartcrop.js
 package smartcrop
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"image"
@@ -41,25 +42,58 @@ import (
 	"math"
 	"time"
 
+	"github.com/third-light/smartcrop/detect"
 	"github.com/third-light/smartcrop/options"
-
-	"gocv.io/x/gocv"
 	"golang.org/x/image/draw"
 )
 
 var (
 	// ErrInvalidDimensions gets returned when the supplied dimensions are invalid
 	ErrInvalidDimensions = errors.New("Expect either a height or width")
-
-	skinColor = [3]float64{0.78, 0.57, 0.44}
+	// ErrFaceDetectUnavailable gets returned when face detection is enabled but
+	// smartcrop was built without gocv
+	ErrFaceDetectUnavailable = errors.New("Face detection is not available in this build")
+	// ErrNoCropFound gets returned when no candidate crop is left, unless
+	// Config.FullImageFallback is set
+	ErrNoCropFound = errors.New("No candidate crop satisfies the constraints")
+	// ErrTargetTooLarge gets returned when the requested size exceeds the image
+	// and Config.Upscale is UpscaleError
+	ErrTargetTooLarge = errors.New("Requested size exceeds the image")
+	// ErrImageTooLarge gets returned when an image has more pixels than
+	// Config.MaxInputPixels or the limit passed to DecodeLimited
+	ErrImageTooLarge = errors.New("Image exceeds the pixel limit")
+	// ErrMetadataTooLarge gets returned when compressed metadata embedded in an
+	// image, such as the ICC profile or XMP packet of a PNG, inflates to more
+	// than 4 MiB
+	ErrMetadataTooLarge = errors.New("Embedded metadata exceeds the size limit")
 )
 
 // Analyzer interface analyzes its struct and returns the best possible crop with the given
 // width and height returns an error if invalid
+//
+// The analyzers returned by NewAnalyzer and NewAnalyzerWithLogger are safe for
+// concurrent use by multiple goroutines, provided their Resizer and the
+// Config.ScoreFunc and Config.ProgressFunc callbacks are. All state of an
+// analysis is allocated per call, and the face detection classifier, which is
+// loaded once and shared, is used by one analysis at a time. Debug analyzers
+// write their images to fixed file names and should not be shared.
 type Analyzer interface {
 	FindBestCrop(img image.Image, width, height int) (image.Rectangle, error)
+	FindBestCropWithMask(img image.Image, width, height int, mask *image.Gray) (image.Rectangle, error)
 	FindAllCrops(img image.Image, width, height int) ([]Crop, error)
-	FindFaces(img image.Image) []image.Rectangle
+	FindTopCrops(img image.Image, width, height, k int) ([]Crop, error)
+	FindConsistentCrop(img image.Image, width, height int, reference image.Rectangle, minIoU float64) (Crop, error)
+	AnalyzePyramid(src Pyramid, width, height int) (CropResult, error)
+	AnalyzePreview(preview image.Image, original image.Rectangle, width, height int) (CropResult, error)
+	ForEachCrop(img image.Image, width, height int, fn func(Crop) bool) error
+	FindFaces(img image.Image) ([]image.Rectangle, error)
+	FindFacesWithConfidence(img image.Image) ([]image.Rectangle, []float64, error)
+	CropAndResize(img image.Image, width, height int) (image.Image, Crop, error)
+	Retarget(img image.Image, width, height int) (image.Image, error)
+	Analyze(img image.Image, width, height int) (CropResult, error)
+	AnalyzeContext(ctx context.Context, img image.Image, width, height int) (CropResult, error)
+	SelfTest() error
+	Info() Info
 }
 
 // Score contains values that classify matches
@@ -69,18 +103,67 @@ type Score struct {
 	Skin       float64
 	Face       float64
 	Total      float64
+
+	// Normalized is Total scaled to the range [0, 1] relative to the best score
+	// the crop could possibly reach, so it can be compared across images.
+	Normalized float64
+	// Breakdown tells how much each component contributed to Total.
+	Breakdown Breakdown
+
+	// Channels holds the contributions of detectors beyond the fixed fields above,
+	// keyed by channel name.
+	Channels map[string]float64 `json:",omitempty"`
 }
 
 // Crop contains results
 type Crop struct {
 	image.Rectangle
 	Score Score
+	// AlgorithmVersion is the AlgorithmVersion the crop was chosen by, 0 if it
+	// predates versioning. The analyzer sets it to the current AlgorithmVersion.
+	AlgorithmVersion int `json:",omitempty"`
 }
 
 func (c Crop) String() string {
 	return fmt.Sprintf("%d,%d - %d,%d (%f)", c.Min.X, c.Min.Y, c.Max.X, c.Max.Y, c.Score.Total)
 }
 
+// CropResult contains the best crop along with additional analysis results, all in
+// original image coordinates.
+type CropResult struct {
+	Crop Crop
+	// Faces holds the detected faces when Config.FaceDetectEnabled is on.
+	Faces []image.Rectangle
+	// Fallback is set when no candidate reached Config.MinAcceptableScore and a
+	// centered crop was returned instead.
+	Fallback bool
+	// Heatmap is the detector output the crops were scored on, which renders
+	// with detail in the green, skin in the red and saturation in the blue
+	// channel. Unlike the other fields it is in analysis coordinates, i.e. at the
+	// prescaled size.
+	Heatmap *ScoreMap
+	// Padding is set when Config.MaxPadding allowed a crop with a less extreme
+	// aspect ratio than requested. The crop needs this padding to reach it.
+	Padding Padding
+	// Angle is set when Config.MaxRotation found a better crop in the image
+	// rotated by Angle degrees, see RotateImage. The crop is then in the
+	// coordinates of the rotated image, the faces still in those of img.
+	Angle float64
+	// Coarsened is set when Config.MaxAnalysisPixels or Config.MaxCandidates made
+	// the analysis coarser than configured.
+	Coarsened bool
+	// Explanation tells why the crop was chosen when Config.Explain is on.
+	Explanation *Explanation
+	// Palette holds the dominant colors of the crop when Config.PaletteSize is
+	// set, the most common first.
+	Palette []PaletteColor
+	// Hash is the perceptual hash of the image selected by Config.ImageHash.
+	Hash uint64
+	// Document holds the corners of the document the crop was fitted to by
+	// Config.DocumentMode, see Rectify.
+	Document *Quad
+}
+
 // Logger contains a logger.
 type Logger struct {
 	DebugMode bool
@@ -90,9 +173,43 @@ type Logger struct {
 type smartcropAnalyzer struct {
 	logger Logger
 	options.Resizer
-	config                Config
-	faceDetectInitialised bool
-	faceDetectClassifier  gocv.CascadeClassifier
+	config Config
+	*faceDetector
+	// faces replaces faceDetector if set, see WithFaceDetector.
+	faces FaceDetector
+	// sensitive finds the regions to keep crops out of, see
+	// WithSensitiveDetector.
+	sensitive SensitiveDetector
+	// templates are kept in the crop, see WithTemplates.
+	templates []image.Image
+	// barcodes finds the codes crops keep whole, see WithBarcodeDetector.
+	// codes holds those of the image analysed, in analysis coordinates.
+	barcodes BarcodeDetector
+	codes    []image.Rectangle
+	// metrics receives the measurements of analyses, see WithMetrics, and
+	// tracer records them as spans, see WithTracer.
+	metrics MetricsSink
+	tracer  Tracer
+	// colors converts images with an ICC profile to sRGB for analysis, see
+	// WithColorConverter.
+	colors ColorConverter
+
+	// night is used instead of the analyzer itself for low-light images when
+	// Config.NightDetectEnabled is set.
+	night *smartcropAnalyzer
+
+	// ctx is passed to a ResizerV2, see AnalyzeContext. nil means
+	// context.Background().
+	ctx context.Context
+
+	// cache holds the decisions of FindBestCrop if set, see WithCache, under
+	// keys carrying configHash, the Hash of config, and analyzerHash.
+	cache        Cache
+	configHash   uint64
+	analyzerHash uint64
+
+	// models is Info.Models, hashed once by newAnalyzer.
+	models map[string]string
 }
 
 // NewDebugAnalyzer returns a new Analyzer using the given Resizer with debugging turned on.
@@ -115,89 +232,378 @@ func NewAnalyzer(c Config, resizer options.Resizer) Analyzer {
 
 // NewAnalyzerWithLogger returns a new analyzer with the given Resizer and Logger.
 func NewAnalyzerWithLogger(c Config, resizer options.Resizer, logger Logger) Analyzer {
+	return newAnalyzer(settings{config: c, resizer: resizer, logger: logger})
+}
+
+func newAnalyzer(s settings) *smartcropAnalyzer {
+	logger := s.logger
 	if logger.Log == nil {
 		logger.Log = log.New(ioutil.Discard, "", 0)
 	}
-	return &smartcropAnalyzer{Resizer: resizer, logger: logger, config: c}
+	detector := &faceDetector{}
+	sca := &smartcropAnalyzer{Resizer: s.resizer, logger: logger, config: s.config, faceDetector: detector, faces: s.faces, sensitive: s.sensitive, templates: s.templates, barcodes: s.barcodes, metrics: s.metrics, tracer: s.tracer, colors: s.colors}
+	if s.config.NightDetectEnabled {
+		sca.night = &smartcropAnalyzer{Resizer: s.resizer, logger: logger, config: nightTuned(s.config), faceDetector: detector, faces: s.faces, sensitive: s.sensitive, templates: s.templates, barcodes: s.barcodes, metrics: s.metrics, tracer: s.tracer, colors: s.colors}
+	}
+	if s.cache != nil {
+		sca.cache, sca.configHash, sca.analyzerHash = s.cache, s.config.Hash(), analyzerHash(s)
+	}
+	if s.config.FaceDetectEnabled && s.faces == nil && s.config.FaceDetectClassifierFile != "" {
+		sca.models = map[string]string{s.config.FaceDetectClassifierFile: fileHash(s.config.FaceDetectClassifierFile)}
+	}
+	return sca
 }
 
-func (sca *smartcropAnalyzer) preprocessForAnalysis(img image.Image, width, height int) (*image.RGBA, float64, float64, float64, float64) {
-	// resize image for faster processing
-	scale := math.Min(float64(img.Bounds().Dx())/float64(width), float64(img.Bounds().Dy())/float64(height))
-	var rgbaImg *image.RGBA
-	var prescalefactor = 1.0
-
-	if sca.config.Prescale {
-		if f := sca.config.PrescaleMin / math.Min(float64(img.Bounds().Dx()), float64(img.Bounds().Dy())); f < 1.0 {
-			prescalefactor = f
-		}
-		sca.logger.Log.Println(prescalefactor)
-
-		smallimg := sca.Resize(
-			img,
-			uint(float64(img.Bounds().Dx())*prescalefactor),
-			0)
+func (sca *smartcropAnalyzer) preprocessForAnalysis(img image.Image, width, height int) (image.Image, float64, float64, float64, float64, error) {
+	return sca.preprocessLevel(img, 1.0, width, height)
+}
 
-		rgbaImg = toRGBA(smallimg)
-	} else {
-		rgbaImg = toRGBA(img)
+// preprocessLevel prepares img, which is the original image downsampled by
+// levelScale, for analysis. The returned prescale factor relates analysis to
+// original image coordinates.
+func (sca *smartcropAnalyzer) preprocessLevel(img image.Image, levelScale float64, width, height int) (image.Image, float64, float64, float64, float64, error) {
+	// resize image for faster processing
+	smallimg, prescalefactor, err := sca.prescale(img)
+	if err != nil {
+		return nil, 0, 0, 0, 0, err
 	}
+	prescalefactor *= levelScale
+	analysisImg := sca.toAnalysisImage(smallimg)
 
 	if sca.logger.DebugMode {
-		writeImage("png", rgbaImg, "./smartcrop_prescale.png")
+		writeImage("png", analysisImg, "./smartcrop_prescale.png")
 	}
 
+	sca.logger.Log.Printf("original resolution: %dx%d\n", img.Bounds().Dx(), img.Bounds().Dy())
+	cropWidth, cropHeight, realMinScale := sca.cropSize(float64(img.Bounds().Dx())/levelScale, float64(img.Bounds().Dy())/levelScale, width, height, prescalefactor)
+	return analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, nil
+}
+
+// cropSize returns the size of the largest width x height crop of an original
+// image of dx x dy pixels in analysis pixels, along with the smallest scale of
+// the candidates.
+func (sca *smartcropAnalyzer) cropSize(dx, dy float64, width, height int, prescalefactor float64) (float64, float64, float64) {
+	scale := math.Min(dx/float64(width), dy/float64(height))
 	cropWidth, cropHeight := chop(float64(width)*scale*prescalefactor), chop(float64(height)*scale*prescalefactor)
 	realMinScale := math.Min(sca.config.MaxScale, math.Max(1.0/scale, sca.config.MinScale))
 
-	sca.logger.Log.Printf("original resolution: %dx%d\n", img.Bounds().Dx(), img.Bounds().Dy())
 	sca.logger.Log.Printf("scale: %f, cropw: %f, croph: %f, minscale: %f\n", scale, cropWidth, cropHeight, realMinScale)
+	return cropWidth, cropHeight, realMinScale
+}
+
+// prescale shrinks img according to Config.Prescale, Config.PrescaleMin and
+// Config.MaxAnalysisPixels and returns it along with the factor it was scaled by.
+// The prescaled copy of an ICCImage is converted to sRGB.
+func (sca *smartcropAnalyzer) prescale(img image.Image) (image.Image, float64, error) {
+	img, profile := splitProfile(img)
+	if err := sca.checkInput(img.Bounds()); err != nil {
+		return nil, 0, err
+	}
+	now := time.Now()
+	defer sca.observeStage(StagePrescale, now)
+	prescalefactor := sca.configuredPrescale(img.Bounds())
+	if limit := sca.pixelLimit(img.Bounds(), prescalefactor); limit < 1.0 {
+		sca.logger.Log.Printf("more than %d analysis pixels, scaling down by %f\n", sca.config.MaxAnalysisPixels, limit)
+		prescalefactor *= limit
+	} else if !sca.config.Prescale {
+		return sca.toSRGB(img, profile), 1.0, nil
+	}
+	sca.logger.Log.Println(prescalefactor)
 
-	return rgbaImg, cropWidth, cropHeight, realMinScale, prescalefactor
+	smallimg, err := sca.analysisResize(
+		img,
+		uint(float64(img.Bounds().Dx())*prescalefactor),
+		0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sca.toSRGB(smallimg, profile), prescalefactor, nil
 }
 
-func (sca *smartcropAnalyzer) FindFaces(img image.Image) []image.Rectangle {
+// analysisResize resizes img for analysis, with boxResize if Config.BoxPrescale
+// is set and the Resizer otherwise.
+func (sca *smartcropAnalyzer) analysisResize(img image.Image, width, height uint) (image.Image, error) {
+	if !sca.config.BoxPrescale {
+		return sca.resize(img, width, height)
+	}
+	b := img.Bounds()
+	if height == 0 {
+		height = uint(math.Max(1, math.Round(float64(width)*float64(b.Dy())/float64(b.Dx()))))
+	} else if width == 0 {
+		width = uint(math.Max(1, math.Round(float64(height)*float64(b.Dx())/float64(b.Dy()))))
+	}
+	return boxResize(img, int(width), int(height)), nil
+}
 
-	var faceRects []image.Rectangle
-	if sca.config.FaceDetectEnabled {
-		now := time.Now()
-		var faceOut *image.RGBA
-		if sca.logger.DebugMode {
-			// Copy current output image so we can draw face rects on to new output
-			faceOut = image.NewRGBA(img.Bounds())
-			draw.Copy(faceOut, image.Pt(0, 0), img, img.Bounds(), draw.Src, nil)
-		}
-		faceRects = sca.faceDetect(img, faceOut)
-		sca.logger.Log.Println("Time elapsed face:", time.Since(now))
-		debugOutput(sca.logger.DebugMode, faceOut, "facedetect")
+// resize resizes img with the analyzer's Resizer, preferring ResizeCtx if it is
+// an options.ResizerV2.
+func (sca *smartcropAnalyzer) resize(img image.Image, width, height uint) (image.Image, error) {
+	return options.V2(sca.Resizer).ResizeCtx(sca.context(), img, width, height)
+}
+
+// context returns the context of the analysis, see AnalyzeContext.
+func (sca *smartcropAnalyzer) context() context.Context {
+	if sca.ctx == nil {
+		return context.Background()
+	}
+	return sca.ctx
+}
+
+// configuredPrescale returns the factor Config.Prescale and Config.PrescaleMin
+// ask to scale an image with bounds by.
+func (sca *smartcropAnalyzer) configuredPrescale(bounds image.Rectangle) float64 {
+	if !sca.config.Prescale {
+		return 1.0
+	}
+	if f := sca.config.PrescaleMin / math.Min(float64(bounds.Dx()), float64(bounds.Dy())); f < 1.0 {
+		return f
+	}
+	return 1.0
+}
+
+// unscale maps r from prescaled back to original image coordinates.
+func unscale(r image.Rectangle, prescalefactor float64) image.Rectangle {
+	if prescalefactor == 1.0 {
+		return r
+	}
+	return image.Rect(
+		int(chop(float64(r.Min.X)/prescalefactor)),
+		int(chop(float64(r.Min.Y)/prescalefactor)),
+		int(chop(float64(r.Max.X)/prescalefactor)),
+		int(chop(float64(r.Max.Y)/prescalefactor)),
+	)
+}
+
+// toAnalysisImage returns img unchanged when it can be handled by the grayscale
+// fast path, otherwise it converts it to an image.RGBA.
+func (sca *smartcropAnalyzer) toAnalysisImage(img image.Image) image.Image {
+	if gray, ok := img.(*image.Gray); ok && sca.config.GrayscaleFastPath {
+		return gray
+	}
+	return toRGBA(img)
+}
+
+// FindFaces returns the faces detected in img, in original image coordinates. It
+// runs on the prescaled image if Config.Prescale is set and returns no faces if
+// Config.FaceDetectEnabled is off.
+func (sca *smartcropAnalyzer) FindFaces(img image.Image) ([]image.Rectangle, error) {
+	faceRects, _, err := sca.FindFacesWithConfidence(img)
+	return faceRects, err
+}
+
+// FindFacesWithConfidence is FindFaces also returning the confidence of each
+// face, or nil confidences if the FaceDetector doesn't report them. The
+// built-in detector reports the number of raw detections merged into a face.
+func (sca *smartcropAnalyzer) FindFacesWithConfidence(img image.Image) ([]image.Rectangle, []float64, error) {
+	if !sca.config.FaceDetectEnabled {
+		return nil, nil, nil
+	}
+
+	img, offset := atOrigin(img)
+	smallimg, prescalefactor, err := sca.prescale(img)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return faceRects
+	now := time.Now()
+	var faceOut *image.RGBA
+	if sca.logger.DebugMode {
+		// Copy current output image so we can draw face rects on to new output
+		faceOut = image.NewRGBA(smallimg.Bounds())
+		draw.Copy(faceOut, image.Pt(0, 0), smallimg, smallimg.Bounds(), draw.Src, nil)
+	}
+	faceRects, confidences, err := sca.detectFaces(smallimg, faceOut)
+	if err != nil {
+		return nil, nil, err
+	}
+	sca.logger.Log.Println("Time elapsed face:", time.Since(now))
+	debugOutput(sca.logger.DebugMode, faceOut, "facedetect")
+
+	for i, r := range faceRects {
+		faceRects[i] = unscale(r, prescalefactor).Add(offset)
+	}
+	return faceRects, confidences, nil
 }
 
 func (sca *smartcropAnalyzer) FindBestCrop(img image.Image, width, height int) (image.Rectangle, error) {
+	if sca.cache == nil {
+		res, err := sca.Analyze(img, width, height)
+		return res.Crop.Rectangle, err
+	}
+
+	key := sca.cacheKey(img, width, height)
+	if crop, ok := sca.cache.Get(key); ok {
+		return crop, nil
+	}
+	res, err := sca.Analyze(img, width, height)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	sca.cache.Set(key, res.Crop.Rectangle)
+	return res.Crop.Rectangle, nil
+}
+
+// Analyze returns the best crop for the given width and height, together with the
+// faces found along the way.
+func (sca *smartcropAnalyzer) Analyze(img image.Image, width, height int) (CropResult, error) {
+	return sca.analyze(img, width, height, nil)
+}
+
+// AnalyzeContext works like Analyze and passes ctx to the Resizer if it is an
+// options.ResizerV2, so the analysis fails with its error once ctx is done.
+func (sca *smartcropAnalyzer) AnalyzeContext(ctx context.Context, img image.Image, width, height int) (CropResult, error) {
+	c := *sca
+	c.ctx = ctx
+	return c.Analyze(img, width, height)
+}
+
+// analyze implements Analyze, with mask weighting the importance of each pixel
+// if it isn't nil.
+func (sca *smartcropAnalyzer) analyze(img image.Image, width, height int, mask *image.Gray) (CropResult, error) {
+	if sca.metrics == nil && sca.tracer == nil {
+		res, err := sca.analyzeModes(img, width, height, mask)
+		res.Crop.AlgorithmVersion = AlgorithmVersion
+		return res, err
+	}
+	start := time.Now()
+	a, end := sca, func(error) {}
+	if sca.tracer != nil {
+		a, end = sca.traced()
+	}
+	res, err := a.analyzeModes(img, width, height, mask)
+	end(err)
+	if sca.metrics != nil {
+		sca.metrics.Analysis(time.Since(start), err)
+	}
+	res.Crop.AlgorithmVersion = AlgorithmVersion
+	return res, err
+}
+
+// analyzeModes implements analyze for the analysis mode of the config.
+func (sca *smartcropAnalyzer) analyzeModes(img image.Image, width, height int, mask *image.Gray) (CropResult, error) {
+	if moved, offset := atOrigin(img); offset != (image.Point{}) {
+		res, err := sca.analyzeModes(moved, width, height, mask)
+		res.translate(offset)
+		return res, err
+	}
+	if sca.config.CompatibilityMode != "" {
+		return sca.analyzeCompat(img, width, height)
+	}
+	if sca.config.DocumentMode && width > 0 && height > 0 {
+		if res, ok, err := sca.analyzeDocument(img, width, height); ok || err != nil {
+			return res, err
+		}
+	}
+	return sca.analyzeLevel(img, img.Bounds(), 1.0, width, height, mask)
+}
+
+// analyzeLevel implements analyze for img, the original image with the given
+// bounds downsampled by levelScale. The result is in original coordinates.
+func (sca *smartcropAnalyzer) analyzeLevel(img image.Image, bounds image.Rectangle, levelScale float64, width, height int, mask *image.Gray) (CropResult, error) {
 	if width == 0 && height == 0 {
-		return image.Rectangle{}, ErrInvalidDimensions
+		return CropResult{}, ErrInvalidDimensions
 	}
 
-	rgbaImg, cropWidth, cropHeight, realMinScale, prescalefactor := sca.preprocessForAnalysis(img, width, height)
+	if full, err := sca.upscale(bounds, width, height); full || err != nil {
+		return CropResult{Crop: Crop{Rectangle: image.Rect(0, 0, bounds.Dx(), bounds.Dy())}}, err
+	}
 
-	allCrops, processedImg := sca.analyse(rgbaImg, cropWidth, cropHeight, realMinScale)
-	topCrop := sca.findTopCrop(allCrops)
+	targetWidth, targetHeight := width, height
+	width, height, padded := sca.paddedTarget(bounds, width, height)
 
-	if sca.logger.DebugMode {
-		sca.drawDebugCrop(topCrop, processedImg)
-		debugOutput(true, processedImg, "final")
+	analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, err := sca.preprocessLevel(img, levelScale, width, height)
+	if err != nil {
+		return CropResult{}, err
 	}
+	mask, err = sca.analysisMask(mask, img.Bounds(), analysisImg.Bounds())
+	if err != nil {
+		return CropResult{}, err
+	}
+	hash := ImageHash(analysisImg, sca.config.ImageHash)
 
-	if sca.config.Prescale == true {
-		topCrop.Min.X = int(chop(float64(topCrop.Min.X) / prescalefactor))
-		topCrop.Min.Y = int(chop(float64(topCrop.Min.Y) / prescalefactor))
-		topCrop.Max.X = int(chop(float64(topCrop.Max.X) / prescalefactor))
-		topCrop.Max.Y = int(chop(float64(topCrop.Max.Y) / prescalefactor))
+	tuned, coarsened := sca.tunedFor(analysisImg).limited(analysisImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+	if prescalefactor < levelScale*sca.configuredPrescale(img.Bounds()) {
+		coarsened = true
+	}
+	// img is already downsampled by levelScale
+	tuned, err = tuned.withBarcodes(img, prescalefactor/levelScale)
+	if err != nil {
+		return CropResult{}, err
+	}
+	allCrops, faceRects, processedImg, err := tuned.analyse(analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, mask)
+	if err != nil {
+		return CropResult{}, err
+	}
+	if len(allCrops) == 0 {
+		if !sca.config.FullImageFallback {
+			return CropResult{}, ErrNoCropFound
+		}
+		sca.logger.Log.Println("no candidate crops, falling back to the full image")
+		sca.observeFallback(FallbackFullImage)
+		full := Crop{Rectangle: processedImg.Bounds()}
+		full.Score = sca.score(processedImg, full, faceRects, importanceKernels{mask: mask})
+		colors := sca.palette(analysisImg, full.Rectangle)
+		full.Rectangle = sca.align(unscale(full.Rectangle, prescalefactor).Canon(), bounds)
+		for i, r := range faceRects {
+			faceRects[i] = unscale(r, prescalefactor)
+		}
+		return CropResult{Crop: full, Faces: faceRects, Fallback: true, Heatmap: processedImg, Coarsened: coarsened, Palette: colors, Hash: hash}, nil
+	}
+	topCrop := sca.findTopCrop(allCrops, faceRects)
+	if sca.config.LocalOptimization && !sca.constrained() {
+		area := sca.cropArea(processedImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+		now := time.Now()
+		sca.progress(StageOptimize, 0)
+		topCrop = sca.optimize(processedImg, area, topCrop, faceRects, mask, cropWidth, cropHeight, realMinScale)
+		sca.progress(StageOptimize, 1)
+		sca.observeStage(StageOptimize, now)
 	}
 
-	return topCrop.Canon(), nil
+	var angle float64
+	if sca.config.MaxRotation > 0 && !sca.constrained() {
+		now := time.Now()
+		area := sca.cropArea(processedImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+		rotated, a, rank, ok := tuned.bestRotated(processedImg, area, faceRects, mask, cropWidth, cropHeight, realMinScale)
+		if ok && preferred(rank, rotated, sca.faceRank(topCrop, faceRects), topCrop) {
+			topCrop, angle = rotated, a
+		}
+		sca.logger.Log.Println("Time elapsed rotation:", time.Since(now))
+		sca.observeStage(StageRotate, now)
+	}
+
+	fallback := false
+	// the centered crop would ignore the regions constraining the candidates
+	if !sca.acceptable(allCrops) && !sca.constrained() {
+		centered := centerCrop(processedImg.Bounds(), topCrop.Dx(), topCrop.Dy())
+		centered.Score = sca.score(processedImg, centered, faceRects, importanceKernels{mask: mask})
+		// the fallback must not drop faces Config.FacePolicy keeps
+		if sca.faceRank(centered, faceRects) >= sca.faceRank(topCrop, faceRects) {
+			sca.logger.Log.Println("no crop reached MinAcceptableScore, falling back to a centered crop")
+			sca.observeFallback(FallbackCentered)
+			topCrop, angle = centered, 0
+			fallback = true
+		}
+	}
+
+	var explanation *Explanation
+	if sca.config.Explain {
+		explanation = tuned.explanation(processedImg, topCrop, allCrops, faceRects, mask, angle, prescalefactor)
+	}
+
+	if sca.logger.DebugMode {
+		debugOutput(true, sca.drawDebugCrop(topCrop, processedImg), "final")
+	}
+
+	colors := sca.palette(analysisImg, topCrop.Rectangle)
+	topCrop.Rectangle = sca.align(unscale(topCrop.Rectangle, prescalefactor).Canon(), bounds)
+	for i, r := range faceRects {
+		faceRects[i] = unscale(r, prescalefactor)
+	}
+	res := CropResult{Crop: topCrop, Faces: faceRects, Fallback: fallback, Heatmap: processedImg, Angle: angle, Coarsened: coarsened, Explanation: explanation, Palette: colors, Hash: hash}
+	if padded {
+		res.Padding = padding(topCrop.Rectangle, targetWidth, targetHeight)
+	}
+	return res, nil
 }
 
 func (sca *smartcropAnalyzer) FindAllCrops(img image.Image, width, height int) ([]Crop, error) {
@@ -205,21 +611,66 @@ func (sca *smartcropAnalyzer) FindAllCrops(img image.Image, width, height int) (
 		return []Crop{}, ErrInvalidDimensions
 	}
 
-	rgbaImg, cropWidth, cropHeight, realMinScale, prescalefactor := sca.preprocessForAnalysis(img, width, height)
-
-	allCrops, _ := sca.analyse(rgbaImg, cropWidth, cropHeight, realMinScale)
+	img, offset := atOrigin(img)
+	allCrops, _, prescalefactor, err := sca.scoredCrops(img, width, height)
+	if err != nil {
+		return nil, err
+	}
 
 	for i, crop := range allCrops {
-		if sca.config.Prescale == true {
-			allCrops[i].Min.X = int(chop(float64(crop.Min.X) / prescalefactor))
-			allCrops[i].Min.Y = int(chop(float64(crop.Min.Y) / prescalefactor))
-			allCrops[i].Max.X = int(chop(float64(crop.Max.X) / prescalefactor))
-			allCrops[i].Max.Y = int(chop(float64(crop.Max.Y) / prescalefactor))
-		}
-		crop.Rectangle = crop.Canon()
+		allCrops[i].Rectangle = unscale(crop.Rectangle, prescalefactor).Canon().Add(offset)
 	}
 
-	return allCrops, nil
+	return versioned(allCrops), nil
+}
+
+// scoredCrops returns all scored candidates for the given width and height and
+// the faces found, both in analysis coordinates, along with the prescale factor.
+func (sca *smartcropAnalyzer) scoredCrops(img image.Image, width, height int) ([]Crop, []image.Rectangle, float64, error) {
+	analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, err := sca.preprocessForAnalysis(img, width, height)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	tuned, _ := sca.tunedFor(analysisImg).limited(analysisImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+	tuned, err = tuned.withBarcodes(img, prescalefactor)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	allCrops, faceRects, _, err := tuned.analyse(analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, nil)
+	return allCrops, faceRects, prescalefactor, err
+}
+
+// ForEachCrop scores the candidate crops one at a time and passes them to fn, in
+// original image coordinates, until fn returns false. Unlike FindAllCrops it
+// doesn't hold all candidates in memory, and it doesn't run the refinement search.
+func (sca *smartcropAnalyzer) ForEachCrop(img image.Image, width, height int, fn func(Crop) bool) error {
+	if width == 0 && height == 0 {
+		return ErrInvalidDimensions
+	}
+
+	img, offset := atOrigin(img)
+	analysisImg, cropWidth, cropHeight, realMinScale, prescalefactor, err := sca.preprocessForAnalysis(img, width, height)
+	if err != nil {
+		return err
+	}
+
+	tuned, _ := sca.tunedFor(analysisImg).limited(analysisImg.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+	o, faceRects, err := tuned.detect(analysisImg)
+	if err != nil {
+		return err
+	}
+	tuned = tuned.withFaces(faceRects)
+
+	area := tuned.cropArea(o.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+	kernels := newImportanceKernels(nil)
+	tuned.eachCandidate(o, area, faceRects, cropWidth, cropHeight, realMinScale, func(r image.Rectangle) bool {
+		crop := Crop{Rectangle: r, AlgorithmVersion: AlgorithmVersion}
+		crop.Score = tuned.score(o, crop, faceRects, kernels)
+		crop.Rectangle = unscale(r, prescalefactor).Canon().Add(offset)
+		return fn(crop)
+	})
+	return nil
 }
 
 func chop(x float64) float64 {
@@ -229,98 +680,154 @@ func chop(x float64) float64 {
 	return math.Floor(x)
 }
 
-func thirds(x float64) float64 {
-	x = (math.Mod(x-(1.0/3.0)+1.0, 2.0)*0.5 - 0.5) * 16.0
-	return math.Max(1.0-x*x, 0.0)
-}
-
 func bounds(l float64) float64 {
 	return math.Min(math.Max(l, 0.0), 255)
 }
 
 func (sca *smartcropAnalyzer) importance(crop Crop, x, y int) float64 {
-	if crop.Min.X > x || x >= crop.Max.X || crop.Min.Y > y || y >= crop.Max.Y {
-		return sca.config.OutsideImportance
-	}
-
-	xf := float64(x-crop.Min.X) / float64(crop.Dx())
-	yf := float64(y-crop.Min.Y) / float64(crop.Dy())
-
-	px := math.Abs(0.5-xf) * 2.0
-	py := math.Abs(0.5-yf) * 2.0
-
-	dx := math.Max(px-1.0+sca.config.EdgeRadius, 0.0)
-	dy := math.Max(py-1.0+sca.config.EdgeRadius, 0.0)
-	d := (dx*dx + dy*dy) * sca.config.EdgeWeight
+	return detect.Importance(crop.Rectangle, x, y, detect.ImportanceOptions{
+		EdgeRadius:        sca.config.EdgeRadius,
+		EdgeWeight:        sca.config.EdgeWeight,
+		OutsideImportance: sca.config.OutsideImportance,
+		RuleOfThirds:      sca.config.RuleOfThirds,
+	})
+}
 
-	s := 1.41 - math.Sqrt(px*px+py*py)
-	if sca.config.RuleOfThirds {
-		s += (math.Max(0.0, s+d+0.5) * 1.2) * (thirds(px) + thirds(py))
+func (sca *smartcropAnalyzer) score(output *ScoreMap, crop Crop, faceRects []image.Rectangle, kernels importanceKernels) Score {
+	if sca.config.Symmetric {
+		return sca.scoreSymmetric(output, crop, faceRects, kernels.mask)
+	}
+	if sca.config.DeterministicScoring {
+		return sca.scoreDeterministic(output, crop, faceRects, kernels)
 	}
 
-	return s + d
-}
-
-func (sca *smartcropAnalyzer) score(output *image.RGBA, crop Crop, faceRects []image.Rectangle) Score {
 	width := output.Bounds().Dx()
 	height := output.Bounds().Dy()
 	score := Score{}
+	var maxImportance float64
+	kernel := kernels.forCrop(sca, crop)
+	samples := sca.sampler(output)
+	detail, skin, sat := samples.m.Plane(ChannelDetail), samples.m.Plane(ChannelSkin), samples.m.Plane(ChannelSaturation)
 
 	// same loops but with downsampling
 	//for y := 0; y < height; y++ {
 	//for x := 0; x < width; x++ {
-	for y := 0; y <= height-sca.config.ScoreDownSample; y += sca.config.ScoreDownSample {
-		for x := 0; x <= width-sca.config.ScoreDownSample; x += sca.config.ScoreDownSample {
-
-			c := output.RGBAAt(x, y)
-			r8 := float64(c.R)
-			g8 := float64(c.G)
-			b8 := float64(c.B)
+	for y := 0; y <= height-samples.end; y += samples.ds {
+		for x := 0; x <= width-samples.end; x += samples.ds {
 
-			imp := sca.importance(crop, int(x), int(y))
-			det := g8 / 255.0
+			i, weight := samples.at(x, y)
+			imp := kernel.at(x, y) * weight
+			det := float64(detail[i])
 
-			score.Skin += r8 / 255.0 * (det + sca.config.SkinBias) * imp
+			score.Skin += float64(skin[i]) * (det + sca.config.SkinBias) * imp
 			score.Detail += det * imp
-			score.Saturation += b8 / 255.0 * (det + sca.config.SaturationBias) * imp
+			score.Saturation += float64(sat[i]) * (det + sca.config.SaturationBias) * imp
+			maxImportance += math.Max(imp, 0)
 		}
 	}
 
+	score.Face = sca.faceScore(crop, faceRects)
+	sharpness := sca.sharpnessScore(samples, width, height, kernel, &score)
+
+	score.Total = (score.Detail*sca.config.DetailWeight + score.Skin*sca.config.SkinWeight + score.Saturation*sca.config.SaturationWeight + sharpness)
+	score.Total = score.Total / (float64(crop.Dx()) * float64(crop.Dy()))
+	score.Total = score.Total + score.Face
+	// scores can be negative, so the boost is relative to the magnitude
+	score.Total += math.Abs(score.Total) * sca.anchorBoost(output.Bounds(), crop)
+	score.Total = sca.customScore(output, crop, score)
+
+	sca.normalize(&score, crop, maxImportance)
+	return score
+}
+
+func (sca *smartcropAnalyzer) faceScore(crop Crop, faceRects []image.Rectangle) float64 {
+	var face float64
 	if sca.config.FaceDetectEnabled {
 		// Score for face is based on the proportion of the crop taken up by a face
 		cropRes := crop.Bounds().Dx() * crop.Bounds().Dy()
 		for _, r := range faceRects {
-			if r.In(crop.Rectangle) {
+			if sca.faceCounts(crop, r) {
 				faceRes := r.Bounds().Dx() * r.Bounds().Dy()
-				score.Face += float64(faceRes) / float64(cropRes)
+				face += float64(faceRes) / float64(cropRes)
+				face += sca.bodyFraction(crop, r)
 			}
 		}
+		face += sca.eyeLineScore(crop, faceRects)
 	}
-
-	score.Total = (score.Detail*sca.config.DetailWeight + score.Skin*sca.config.SkinWeight + score.Saturation*sca.config.SaturationWeight)
-	score.Total = score.Total / (float64(crop.Dx()) * float64(crop.Dy()))
-	score.Total = score.Total + score.Face
-
-	return score
+	return face
 }
 
-func (sca *smartcropAnalyzer) analyse(img *image.RGBA, cropWidth, cropHeight, realMinScale float64) ([]Crop, *image.RGBA) {
-	o := image.NewRGBA(img.Bounds())
+// detect runs the detectors over img and returns their output along with the
+// faces found.
+func (sca *smartcropAnalyzer) detect(img image.Image) (*ScoreMap, []image.Rectangle, error) {
+	o := newDetectorMap(img.Bounds())
 
-	now := time.Now()
-	sca.edgeDetect(img, o)
-	sca.logger.Log.Println("Time elapsed edge:", time.Since(now))
-	debugOutput(sca.logger.DebugMode, o, "edge")
+	var now time.Time
+	if sca.config.Denoise {
+		now = time.Now()
+		sca.progress(StageDenoise, 0)
+		img = denoise(img)
+		sca.progress(StageDenoise, 1)
+		sca.logger.Log.Println("Time elapsed denoise:", time.Since(now))
+		sca.observeStage(StageDenoise, now)
+	}
+	if sca.config.GrayWorld || sca.config.AutoLevels {
+		now = time.Now()
+		sca.progress(StageNormalize, 0)
+		img = normalizeColors(img, sca.config.GrayWorld, sca.config.AutoLevels)
+		sca.progress(StageNormalize, 1)
+		sca.logger.Log.Println("Time elapsed normalize:", time.Since(now))
+		sca.observeStage(StageNormalize, now)
+	}
 
-	now = time.Now()
-	sca.skinDetect(img, o)
-	sca.logger.Log.Println("Time elapsed skin:", time.Since(now))
-	debugOutput(sca.logger.DebugMode, o, "edge-skin")
+	switch i := img.(type) {
+	case *image.Gray:
+		// skin and saturation are always zero for gray pixels, so only the
+		// edge detector has to run
+		if !sca.config.DisableEdge {
+			now = time.Now()
+			sca.progress(StageEdge, 0)
+			sca.edgeDetectGray(i, o)
+			sca.progress(StageEdge, 1)
+			sca.logger.Log.Println("Time elapsed edge:", time.Since(now))
+			sca.observeStage(StageEdge, now)
+			debugOutput(sca.logger.DebugMode, o, "edge")
+		}
+		sca.sharpnessStage(i, o)
+	default:
+		rgbaImg := toRGBA(img)
+
+		if !sca.config.DisableEdge {
+			now = time.Now()
+			sca.progress(StageEdge, 0)
+			sca.edgeDetect(rgbaImg, o)
+			sca.progress(StageEdge, 1)
+			sca.logger.Log.Println("Time elapsed edge:", time.Since(now))
+			sca.observeStage(StageEdge, now)
+			debugOutput(sca.logger.DebugMode, o, "edge")
+		}
 
-	now = time.Now()
-	sca.saturationDetect(img, o)
-	sca.logger.Log.Println("Time elapsed sat:", time.Since(now))
-	debugOutput(sca.logger.DebugMode, o, "edge-skin-saturation")
+		if !sca.config.DisableSkin {
+			now = time.Now()
+			sca.progress(StageSkin, 0)
+			sca.skinDetect(rgbaImg, o)
+			sca.progress(StageSkin, 1)
+			sca.logger.Log.Println("Time elapsed skin:", time.Since(now))
+			sca.observeStage(StageSkin, now)
+			debugOutput(sca.logger.DebugMode, o, "edge-skin")
+		}
+
+		if !sca.config.DisableSaturation {
+			now = time.Now()
+			sca.progress(StageSaturation, 0)
+			sca.saturationDetect(rgbaImg, o)
+			sca.progress(StageSaturation, 1)
+			sca.logger.Log.Println("Time elapsed sat:", time.Since(now))
+			sca.observeStage(StageSaturation, now)
+			debugOutput(sca.logger.DebugMode, o, "edge-skin-saturation")
+		}
+		sca.sharpnessStage(rgbaImg, o)
+	}
 
 	var faceRects []image.Rectangle
 	if sca.config.FaceDetectEnabled {
@@ -332,251 +839,231 @@ func (sca *smartcropAnalyzer) analyse(img *image.RGBA, cropWidth, cropHeight, re
 			faceOut = image.NewRGBA(img.Bounds())
 			draw.Copy(faceOut, image.Pt(0, 0), img, img.Bounds(), draw.Src, nil)
 		}
-		faceRects = sca.faceDetect(img, faceOut)
+		var err error
+		sca.progress(StageFace, 0)
+		faceRects, _, err = sca.detectFaces(img, faceOut)
+		if err != nil {
+			return nil, nil, err
+		}
+		sca.progress(StageFace, 1)
 		sca.logger.Log.Println("Time elapsed face:", time.Since(now))
+		sca.observeStage(StageFace, now)
+		if sca.metrics != nil {
+			sca.metrics.Faces(len(faceRects))
+		}
 		debugOutput(sca.logger.DebugMode, faceOut, "facedetect")
 	}
 
-	now = time.Now()
-	cs := sca.crops(o, cropWidth, cropHeight, realMinScale)
+	if sca.config.ScoreBlurRadius > 0 {
+		now = time.Now()
+		sca.progress(StageBlur, 0)
+		blurScoreMap(o, sca.config.ScoreBlurRadius)
+		sca.progress(StageBlur, 1)
+		sca.logger.Log.Println("Time elapsed blur:", time.Since(now))
+		sca.observeStage(StageBlur, now)
+		debugOutput(sca.logger.DebugMode, o, "blurred")
+	}
+
+	return o, faceRects, nil
+}
+
+func (sca *smartcropAnalyzer) analyse(img image.Image, cropWidth, cropHeight, realMinScale, prescalefactor float64, mask *image.Gray) ([]Crop, []image.Rectangle, *ScoreMap, error) {
+	o, faceRects, err := sca.detect(img)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cs, err := sca.analyseDetected(img, o, faceRects, cropWidth, cropHeight, realMinScale, prescalefactor, mask)
+	return cs, faceRects, o, err
+}
+
+// analyseDetected implements analyse for the detector output o and the faces
+// found in img.
+func (sca *smartcropAnalyzer) analyseDetected(img image.Image, o *ScoreMap, faceRects []image.Rectangle, cropWidth, cropHeight, realMinScale, prescalefactor float64, mask *image.Gray) ([]Crop, error) {
+	sca = sca.withFaces(faceRects)
+
+	now := time.Now()
+	area := sca.cropArea(o.Bounds(), cropWidth, cropHeight, realMinScale, prescalefactor)
+	cs := sca.crops(o, area, faceRects, cropWidth, cropHeight, realMinScale)
 	sca.logger.Log.Println("Time elapsed crops:", time.Since(now), len(cs))
+	sca.observeStage(StageCandidates, now)
+
+	if sca.config.PrunePercentile > 0 {
+		now = time.Now()
+		cs = sca.prune(o, cs, faceRects, mask)
+		sca.logger.Log.Println("Time elapsed prune:", time.Since(now), len(cs))
+	}
 
 	// evaluate the scores for each candidate crop, and update the Score field of each crop object
 	now = time.Now()
+	kernels := newImportanceKernels(mask)
 	for i, crop := range cs {
+		sca.stepProgress(StageScore, i, len(cs))
 		nowIn := time.Now()
-		cs[i].Score = sca.score(o, crop, faceRects)
+		cs[i].Score = sca.score(o, crop, faceRects, kernels)
 		sca.logger.Log.Println("Time elapsed single-score:", time.Since(nowIn))
 	}
+	sca.progress(StageScore, 1)
 	sca.logger.Log.Println("Time elapsed score:", time.Since(now))
-
-	return cs, o
-}
-
-func (sca *smartcropAnalyzer) findTopCrop(cs []Crop) Crop {
-	var topCrop Crop
-	topScore := -1.0
-	for _, crop := range cs {
-		if crop.Score.Total > topScore {
-			topCrop = crop
-			topScore = crop.Score.Total
-		}
+	sca.observeStage(StageScore, now)
+	if sca.metrics != nil {
+		sca.metrics.Candidates(len(cs))
 	}
-	return topCrop
-}
 
-func saturation(c color.RGBA) float64 {
-	cMax, cMin := uint8(0), uint8(255)
-	if c.R > cMax {
-		cMax = c.R
-	}
-	if c.R < cMin {
-		cMin = c.R
-	}
-	if c.G > cMax {
-		cMax = c.G
-	}
-	if c.G < cMin {
-		cMin = c.G
-	}
-	if c.B > cMax {
-		cMax = c.B
+	if sca.config.RefinementLevels > 0 {
+		now = time.Now()
+		sca.progress(StageRefine, 0)
+		cs = sca.refine(o, area, cs, faceRects, kernels)
+		sca.progress(StageRefine, 1)
+		sca.logger.Log.Println("Time elapsed refine:", time.Since(now), len(cs))
+		sca.observeStage(StageRefine, now)
 	}
-	if c.B < cMin {
-		cMin = c.B
+
+	if len(sca.templates) > 0 {
+		matched, err := sca.matchTemplates(img, prescalefactor)
+		if err != nil {
+			return nil, err
+		}
+		cs = sca.preserveTemplates(cs, matched)
 	}
+	cs = sca.keepCodesWhole(cs)
 
-	if cMax == cMin {
-		return 0
+	regions, err := sca.detectSensitive(img)
+	if err != nil {
+		return nil, err
 	}
-	maximum := float64(cMax) / 255.0
-	minimum := float64(cMin) / 255.0
+	cs = sca.avoidSensitive(o, cs, faceRects, kernels, regions, cropWidth, cropHeight)
 
-	l := (maximum + minimum) / 2.0
-	d := maximum - minimum
+	return cs, nil
+}
 
-	if l > 0.5 {
-		return d / (2.0 - maximum - minimum)
+func (sca *smartcropAnalyzer) findTopCrop(cs []Crop, faceRects []image.Rectangle) Crop {
+	var topCrop Crop
+	topScore, topRank := -1.0, -1
+	for _, crop := range cs {
+		if rank := sca.faceRank(crop, faceRects); (topRank < 0 || preferred(rank, crop, topRank, topCrop)) && sca.faceFractionOK(crop, faceRects) {
+			topCrop = crop
+			topScore, topRank = crop.Score.Total, rank
+		}
 	}
-
-	return d / (maximum + minimum)
+	if topScore == -1.0 && sca.config.MaxFaceFraction > 0 {
+		// every candidate is too tight around a face, ignore the constraint
+		sca.logger.Log.Println("no crop satisfies MaxFaceFraction, ignoring it")
+		for _, crop := range cs {
+			if rank := sca.faceRank(crop, faceRects); topRank < 0 || preferred(rank, crop, topRank, topCrop) {
+				topCrop = crop
+				topScore, topRank = crop.Score.Total, rank
+			}
+		}
+	}
+	return topCrop
 }
 
 func cie(c color.RGBA) float64 {
-	return 0.5126*float64(c.B) + 0.7152*float64(c.G) + 0.0722*float64(c.R)
+	return detect.Lightness(c)
 }
 
-func skinCol(c color.RGBA) float64 {
-	r8, g8, b8 := float64(c.R), float64(c.G), float64(c.B)
-
-	mag := math.Sqrt(r8*r8 + g8*g8 + b8*b8)
-	rd := r8/mag - skinColor[0]
-	gd := g8/mag - skinColor[1]
-	bd := b8/mag - skinColor[2]
-
-	d := math.Sqrt(rd*rd + gd*gd + bd*bd)
-	return 1.0 - d
+func (sca *smartcropAnalyzer) edgeDetect(i *image.RGBA, o *ScoreMap) {
+	o.setPlane(ChannelDetail, sca.edges(i).Values)
 }
 
-func makeCies(img *image.RGBA) []float64 {
-	width := img.Bounds().Dx()
-	height := img.Bounds().Dy()
-	cies := make([]float64, width*height, width*height)
-	i := 0
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			cies[i] = cie(img.RGBAAt(x, y))
-			i++
-		}
+// edges runs the edge detector on img, its symmetric variant with
+// Config.Symmetric.
+func (sca *smartcropAnalyzer) edges(img image.Image) *detect.Map {
+	if sca.config.Symmetric {
+		return detect.SymmetricEdges(img, sca.lightness())
 	}
-
-	return cies
+	return detect.Edges(img, sca.lightness())
 }
 
-func (sca *smartcropAnalyzer) edgeDetect(i *image.RGBA, o *image.RGBA) {
-	width := i.Bounds().Dx()
-	height := i.Bounds().Dy()
-	cies := makeCies(i)
-
-	var lightness float64
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			if x == 0 || x >= width-1 || y == 0 || y >= height-1 {
-				//lightness = cie((*i).At(x, y))
-				lightness = 0
-			} else {
-				lightness = cies[y*width+x]*4.0 -
-					cies[x+(y-1)*width] -
-					cies[x-1+y*width] -
-					cies[x+1+y*width] -
-					cies[x+(y+1)*width]
-			}
-
-			nc := color.RGBA{0, uint8(bounds(lightness)), 0, 255}
-			o.SetRGBA(x, y, nc)
-		}
-	}
+func (sca *smartcropAnalyzer) skinDetect(i *image.RGBA, o *ScoreMap) {
+	o.setPlane(ChannelSkin, detect.Skin(i, detect.SkinOptions{
+		Threshold:     sca.config.SkinThreshold,
+		BrightnessMin: sca.config.SkinBrightnessMin,
+		BrightnessMax: sca.config.SkinBrightnessMax,
+		Similarity:    sca.skinScorer(),
+		Lightness:     sca.lightness(),
+	}).Values)
 }
 
-func (sca *smartcropAnalyzer) skinDetect(i *image.RGBA, o *image.RGBA) {
-	width := i.Bounds().Dx()
-	height := i.Bounds().Dy()
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			lightness := cie(i.RGBAAt(x, y)) / 255.0
-			skin := skinCol(i.RGBAAt(x, y))
-
-			c := o.RGBAAt(x, y)
-			if skin > sca.config.SkinThreshold && lightness >= sca.config.SkinBrightnessMin && lightness <= sca.config.SkinBrightnessMax {
-				r := (skin - sca.config.SkinThreshold) * (255.0 / (1.0 - sca.config.SkinThreshold))
-				nc := color.RGBA{uint8(bounds(r)), c.G, c.B, 255}
-				o.SetRGBA(x, y, nc)
-			} else {
-				nc := color.RGBA{0, c.G, c.B, 255}
-				o.SetRGBA(x, y, nc)
-			}
-		}
-	}
+func (sca *smartcropAnalyzer) saturationDetect(i *image.RGBA, o *ScoreMap) {
+	o.setPlane(ChannelSaturation, detect.Saturation(i, detect.SaturationOptions{
+		Threshold:     sca.config.SaturationThreshold,
+		BrightnessMin: sca.config.SaturationBrightnessMin,
+		BrightnessMax: sca.config.SaturationBrightnessMax,
+		Lightness:     sca.lightness(),
+		Saturation:    sca.saturation(),
+	}).Values)
 }
 
-func (sca *smartcropAnalyzer) saturationDetect(i *image.RGBA, o *image.RGBA) {
-	width := i.Bounds().Dx()
-	height := i.Bounds().Dy()
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			lightness := cie(i.RGBAAt(x, y)) / 255.0
-			saturation := saturation(i.RGBAAt(x, y))
-
-			c := o.RGBAAt(x, y)
-			if saturation > sca.config.SaturationThreshold && lightness >= sca.config.SaturationBrightnessMin && lightness <= sca.config.SaturationBrightnessMax {
-				b := (saturation - sca.config.SaturationThreshold) * (255.0 / (1.0 - sca.config.SaturationThreshold))
-				nc := color.RGBA{c.R, c.G, uint8(bounds(b)), 255}
-				o.SetRGBA(x, y, nc)
-			} else {
-				nc := color.RGBA{c.R, c.G, 0, 255}
-				o.SetRGBA(x, y, nc)
-			}
-		}
-	}
+// crops returns the candidate crops of i that lie within area.
+func (sca *smartcropAnalyzer) crops(o *ScoreMap, area image.Rectangle, faceRects []image.Rectangle, cropWidth, cropHeight, realMinScale float64) []Crop {
+	res := []Crop{}
+	sca.eachCandidate(o, area, faceRects, cropWidth, cropHeight, realMinScale, func(r image.Rectangle) bool {
+		res = append(res, Crop{Rectangle: r})
+		return true
+	})
+	return res
 }
 
-func (sca *smartcropAnalyzer) faceDetect(i image.Image, o *image.RGBA) []image.Rectangle {
+// eachCandidate calls fn for every candidate crop of the detector output o that
+// Config.CandidateGenerator proposes within area, until fn returns false.
+func (sca *smartcropAnalyzer) eachCandidate(o *ScoreMap, area image.Rectangle, faceRects []image.Rectangle, cropWidth, cropHeight, realMinScale float64, fn func(r image.Rectangle) bool) {
+	cropW, cropH := cropSize(o.Bounds(), cropWidth, cropHeight)
 
-	img, err := gocv.ImageToMatRGBA(i)
-	if err != nil {
-		if sca.logger.DebugMode {
-			sca.logger.Log.Printf("failed converting img to MatRGBA: %v", err)
-		}
-		return nil
+	var scales []float64
+	for scale := sca.config.MaxScale; scale >= realMinScale; scale -= sca.config.ScaleStep {
+		scales = append(scales, scale)
 	}
-	defer img.Close()
 
-	if !sca.faceDetectInitialised {
-		sca.faceDetectClassifier = gocv.NewCascadeClassifier()
-		if !sca.faceDetectClassifier.Load(sca.config.FaceDetectClassifierFile) {
-			panic(fmt.Errorf("Failed loading classifier file at %s", sca.config.FaceDetectClassifierFile))
-		}
-		sca.faceDetectInitialised = true
+	generator := sca.config.CandidateGenerator
+	if generator == nil && sca.config.Symmetric {
+		generator = SymmetricGridCandidates{}
+	} else if generator == nil {
+		generator = GridCandidates{}
 	}
-
-	faceRects := sca.faceDetectClassifier.DetectMultiScale(img)
-
-	// Draw face rects on to output image to see what the algorithm is actually doing
-	// o might be nil - when not in debug mode
-	if o != nil {
-		boxColor := color.RGBA{255, 0, 0, 255}
-		for _, r := range faceRects {
-			drawRect(o, boxColor, r)
+	generator.Candidates(CandidateSpace{
+		Area:     area,
+		Width:    cropW,
+		Height:   cropH,
+		Scales:   scales,
+		Step:     sca.config.Step,
+		Faces:    faceRects,
+		Channels: o,
+	}, func(r image.Rectangle) bool {
+		if !r.In(area) {
+			return true
 		}
-	}
-
-	return faceRects
+		return fn(r)
+	})
 }
 
-func (sca *smartcropAnalyzer) crops(i image.Image, cropWidth, cropHeight, realMinScale float64) []Crop {
-	res := []Crop{}
-	width := i.Bounds().Dx()
-	height := i.Bounds().Dy()
-
-	minDimension := math.Min(float64(width), float64(height))
-	var cropW, cropH float64
-
-	if cropWidth != 0.0 {
-		cropW = cropWidth
-	} else {
+// cropSize returns the crop size at scale 1, substituting the smaller image
+// dimension for a missing width or height.
+func cropSize(bounds image.Rectangle, cropWidth, cropHeight float64) (float64, float64) {
+	minDimension := math.Min(float64(bounds.Dx()), float64(bounds.Dy()))
+	cropW, cropH := cropWidth, cropHeight
+	if cropW == 0.0 {
 		cropW = minDimension
 	}
-	if cropHeight != 0.0 {
-		cropH = cropHeight
-	} else {
+	if cropH == 0.0 {
 		cropH = minDimension
 	}
-
-	for scale := sca.config.MaxScale; scale >= realMinScale; scale -= sca.config.ScaleStep {
-		for y := 0; float64(y)+cropH*scale <= float64(height); y += sca.config.Step {
-			for x := 0; float64(x)+cropW*scale <= float64(width); x += sca.config.Step {
-				res = append(res, Crop{
-					Rectangle: image.Rect(x, y, x+int(cropW*scale), y+int(cropH*scale)),
-				})
-			}
-		}
-	}
-
-	return res
+	return cropW, cropH
 }
 
-func (sca *smartcropAnalyzer) drawDebugCrop(topCrop Crop, o *image.RGBA) {
-	width := o.Bounds().Dx()
-	height := o.Bounds().Dy()
+// drawDebugCrop renders o with the importance of topCrop added to the red and
+// green channels.
+func (sca *smartcropAnalyzer) drawDebugCrop(topCrop Crop, o *ScoreMap) *image.RGBA {
+	out := o.RGBA()
+	width := out.Bounds().Dx()
+	height := out.Bounds().Dy()
 
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			r, g, b, _ := o.At(x, y).RGBA()
-			r8 := float64(r >> 8)
-			g8 := float64(g >> 8)
-			b8 := uint8(b >> 8)
+			c := out.RGBAAt(x, y)
+			r8 := float64(c.R)
+			g8 := float64(c.G)
+			b8 := c.B
 
 			imp := sca.importance(topCrop, x, y)
 
@@ -587,9 +1074,10 @@ func (sca *smartcropAnalyzer) drawDebugCrop(topCrop Crop, o *image.RGBA) {
 			}
 
 			nc := color.RGBA{uint8(bounds(r8)), uint8(bounds(g8)), b8, 255}
-			o.SetRGBA(x, y, nc)
+			out.SetRGBA(x, y, nc)
 		}
 	}
+	return out
 }
 
 // toRGBA converts an image.Image to an image.RGBA
@@ -599,6 +1087,55 @@ func toRGBA(img image.Image) *image.RGBA {
 		return img.(*image.RGBA)
 	}
 	out := image.NewRGBA(img.Bounds())
-	draw.Copy(out, image.Pt(0, 0), img, img.Bounds(), draw.Src, nil)
+	draw.Copy(out, img.Bounds().Min, img, img.Bounds(), draw.Src, nil)
 	return out
 }
+
+// atOrigin returns img moved to bounds starting at 0, 0, which the analysis
+// works in, along with the offset mapping results back to the coordinates of
+// img. The pixels of an *image.RGBA, *image.NRGBA or *image.Gray are shared,
+// other images are copied.
+func atOrigin(img image.Image) (image.Image, image.Point) {
+	b := img.Bounds()
+	if b.Min == (image.Point{}) || b.Empty() {
+		return img, image.Point{}
+	}
+	r := image.Rect(0, 0, b.Dx(), b.Dy())
+	switch m := img.(type) {
+	case ICCImage:
+		moved, offset := atOrigin(m.Image)
+		return ICCImage{Image: moved, Profile: m.Profile}, offset
+	case *image.RGBA:
+		return &image.RGBA{Pix: m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], Stride: m.Stride, Rect: r}, b.Min
+	case *image.NRGBA:
+		return &image.NRGBA{Pix: m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], Stride: m.Stride, Rect: r}, b.Min
+	case *image.Gray:
+		return &image.Gray{Pix: m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], Stride: m.Stride, Rect: r}, b.Min
+	}
+	out := image.NewRGBA(r)
+	draw.Copy(out, image.Point{}, img, b, draw.Src, nil)
+	return out, b.Min
+}
+
+// translate moves the results in original image coordinates by offset.
+func (res *CropResult) translate(offset image.Point) {
+	res.Crop.Rectangle = res.Crop.Add(offset)
+	for i, r := range res.Faces {
+		res.Faces[i] = r.Add(offset)
+	}
+	if res.Explanation != nil {
+		for i, ce := range res.Explanation.Crops {
+			res.Explanation.Crops[i].Rectangle = ce.Add(offset)
+			for j, r := range ce.Faces {
+				ce.Faces[j] = r.Add(offset)
+			}
+		}
+	}
+	if res.Document != nil {
+		q := *res.Document
+		for i, p := range q {
+			q[i] = p.Add(offset)
+		}
+		res.Document = &q
+	}
+}
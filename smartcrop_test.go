@@ -28,19 +28,33 @@
 package smartcrop
 
 import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
-	_ "image/jpeg"
-	_ "image/png"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/third-light/smartcrop/detect"
+	"github.com/third-light/smartcrop/facegen"
 	"github.com/third-light/smartcrop/nfnt"
+	"github.com/third-light/smartcrop/options"
 )
 
 var (
@@ -60,19 +74,3039 @@ func allCrops(img image.Image, width, height int) ([]Crop, error) {
 	return analyzer.FindAllCrops(img, width, height)
 }
 
-func faces(img image.Image) []image.Rectangle {
+func faces(img image.Image) ([]image.Rectangle, error) {
 	cfg := FaceDetectConfig
 	cfg.FaceDetectClassifierFile = faceDetectClassifier
 	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
 	return analyzer.FindFaces(img)
 }
 
-type SubImager interface {
-	SubImage(r image.Rectangle) image.Image
+func TestFace(t *testing.T) {
+	fi, _ := os.Open(faceTestFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rects, err := faces(img)
+	if err == ErrFaceDetectUnavailable {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(rects, func(i, j int) bool {
+		return rects[i].Min.X < rects[j].Min.X
+	})
+	expected := []image.Rectangle{
+		image.Rect(877, 492, 1518, 1133),
+		image.Rect(1427, 271, 1937, 781),
+		image.Rect(2207, 997, 2233, 1023),
+		image.Rect(2234, 1396, 2336, 1498),
+	}
+	matched := false
+	if len(rects) == len(expected) {
+		matched = true
+		for i, r := range rects {
+			if r != expected[i] {
+				matched = false
+				break
+			}
+		}
+	}
+	if !matched {
+		t.Fatalf("expected %v, got %v", expected, rects)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topCrop, err := smartCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := image.Rect(120, 0, 404, 284)
+	if topCrop != expected {
+		t.Fatalf("expected %v, got %v", expected, topCrop)
+	}
+
+	// test top 3 from allCrops
+	allCrops, err := allCrops(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sort by score desc
+	sort.Slice(allCrops, func(i, j int) bool {
+		return allCrops[i].Score.Total > allCrops[j].Score.Total
+	})
+	expectedTop3 := []image.Rectangle{
+		image.Rect(120, 0, 404, 284),
+		image.Rect(112, 0, 396, 284),
+		image.Rect(128, 8, 383, 263),
+	}
+	for i, gotCrop := range allCrops[:3] {
+		if gotCrop.Rectangle != expectedTop3[i] {
+			t.Fatalf("failed on allCrops in pos %d: expected %v, got %v", i, expectedTop3[i], gotCrop.Rectangle)
+		}
+		if n := gotCrop.Score.Normalized; n <= 0 || n > 1 {
+			t.Fatalf("failed on allCrops in pos %d: normalized score %f out of range", i, n)
+		}
+		b := gotCrop.Score.Breakdown
+		if sum := b.Detail + b.Skin + b.Saturation + b.Face; math.Abs(sum-100) > 1e-9 {
+			t.Fatalf("failed on allCrops in pos %d: breakdown adds up to %f", i, sum)
+		}
+	}
+
+	cropImage := CropImage(img, topCrop)
+	if cropImage.Bounds() != topCrop {
+		t.Fatalf("expected cropped image bounds %v, got %v", topCrop, cropImage.Bounds())
+	}
+	writeImage("jpeg", cropImage, "./smartcrop.jpg")
+}
+
+func TestCropSubImage(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the crops of a sub-image are found as in a copy at the origin, in the
+	// coordinates of img
+	r := image.Rect(30, 20, 330, 220)
+	sub := CropImage(img, r)
+	moved := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(moved, moved.Bounds(), sub, r.Min, draw.Src)
+	for _, prescale := range []bool{true, false} {
+		cfg := DefaultConfig
+		cfg.Prescale = prescale
+		analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+		want, err := analyzer.FindBestCrop(moved, 100, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := analyzer.FindBestCrop(sub, 100, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want.Add(r.Min) {
+			t.Fatalf("prescale %v: expected %v, got %v", prescale, want.Add(r.Min), got)
+		}
+		crops, err := analyzer.FindAllCrops(sub, 100, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, crop := range crops {
+			if !crop.In(r) {
+				t.Fatalf("prescale %v: crop %v outside of %v", prescale, crop.Rectangle, r)
+			}
+		}
+	}
+}
+
+func TestForEachCrop(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	all, err := analyzer.FindAllCrops(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	err = analyzer.ForEachCrop(img, 250, 250, func(c Crop) bool {
+		if i >= len(all) || c.Rectangle != all[i].Rectangle || c.Score.Total != all[i].Score.Total {
+			t.Fatalf("crop %d: expected %v, got %v", i, all[i], c)
+		}
+		i++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(all) {
+		t.Fatalf("expected %d crops, got %d", len(all), i)
+	}
+
+	i = 0
+	analyzer.ForEachCrop(img, 250, 250, func(c Crop) bool {
+		i++
+		return i < 3
+	})
+	if i != 3 {
+		t.Fatalf("expected to stop after 3 crops, got %d", i)
+	}
+}
+
+func TestGravity(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	crop := image.Rect(100, 0, 400, 300)
+
+	if p := FocalPoint(bounds, crop); p != (Position{62.5, 50}) {
+		t.Fatalf("expected focal point 62.5%% 50%%, got %v", p)
+	}
+	if s := ObjectPosition(bounds, crop); s != "object-position: 100% 50%" {
+		t.Fatalf("unexpected object-position %q", s)
+	}
+	if s := BackgroundPosition(bounds, image.Rect(25, 40, 325, 265)); s != "background-position: 25% 53.33%" {
+		t.Fatalf("unexpected background-position %q", s)
+	}
+}
+
+func TestFocalPointFormats(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	crop := Crop{Rectangle: image.Rect(100, 0, 400, 300), Score: Score{Normalized: 0.5}}
+
+	if p := crop.FocalPoint(); p != image.Pt(250, 150) {
+		t.Fatalf("unexpected focal point %v", p)
+	}
+	if g := crop.ImgproxyGravity(bounds); g != "fp:0.625:0.5" {
+		t.Fatalf("unexpected imgproxy gravity %q", g)
+	}
+	if f := crop.ThumborFocal(); f != "focal(100x0:400x300)" {
+		t.Fatalf("unexpected thumbor filter %q", f)
+	}
+	data, err := json.Marshal(crop.ThumborFocalPoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"x":250,"y":150,"z":0.5,"width":300,"height":300,"origin":"smartcrop"}`; string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestIIIF(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	crop := Crop{Rectangle: image.Rect(100, 30, 400, 255)}
+	if r := crop.IIIFRegion(); r != "100,30,300,225" {
+		t.Fatalf("unexpected region %q", r)
+	}
+	if r := crop.IIIFRegionPct(bounds); r != "pct:25,10,75,75" {
+		t.Fatalf("unexpected region %q", r)
+	}
+
+	for size, expected := range map[string]image.Point{
+		"max":     {400, 300},
+		"200,":    {200, 150},
+		",150":    {200, 150},
+		"pct:50":  {200, 150},
+		"!200,50": {67, 50},
+		"150,150": {150, 150},
+		"^500,":   {500, 375},
+	} {
+		w, h, err := ParseIIIFSize(size, bounds)
+		if err != nil {
+			t.Fatalf("%s: %v", size, err)
+		}
+		if w != expected.X || h != expected.Y {
+			t.Fatalf("%s: expected %v, got %dx%d", size, expected, w, h)
+		}
+	}
+	for _, size := range []string{"", ",", "!200,", "a,b", "pct:-1"} {
+		if _, _, err := ParseIIIFSize(size, bounds); err == nil {
+			t.Fatalf("%q: expected an error", size)
+		}
+	}
+}
+
+func TestDeterministicScoring(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.DeterministicScoring = true
+	topCrop, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := image.Rect(120, 0, 404, 284)
+	if topCrop != expected {
+		t.Fatalf("expected %v, got %v", expected, topCrop)
+	}
+}
+
+func TestMinAcceptableScore(t *testing.T) {
+	// a flat image has no detail, so no crop is acceptable
+	img := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{90, 120, 150, 255}), image.ZP, draw.Src)
+
+	cfg := DefaultConfig
+	cfg.MinAcceptableScore = 0.1
+	res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Fallback {
+		t.Fatal("expected centered fallback")
+	}
+	expected := image.Rect(100, 0, 500, 400)
+	if res.Crop.Rectangle != expected {
+		t.Fatalf("expected %v, got %v", expected, res.Crop.Rectangle)
+	}
+
+	// the centered crop would cut through the sensitive region
+	regions := fixedRegions{image.Rect(110, 180, 130, 220)}
+	res, err = New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithSensitiveDetector(regions)).Analyze(img, 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fallback || res.Crop.Overlaps(regions[0]) {
+		t.Fatalf("expected a crop avoiding %v without fallback, got %v", regions[0], res.Crop.Rectangle)
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	// two subjects at the far ends of a flat image, no 1:1 crop holds both
+	img := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{90, 120, 150, 255}), image.ZP, draw.Src)
+	red := image.NewUniform(color.RGBA{220, 30, 30, 255})
+	draw.Draw(img, image.Rect(20, 100, 120, 200), red, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(480, 100, 580, 200), red, image.ZP, draw.Src)
+
+	out, err := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).Retarget(img, 300, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds().Dx() != 300 || out.Bounds().Dy() != 300 {
+		t.Fatalf("expected a 300x300 image, got %v", out.Bounds())
+	}
+
+	reds := 0
+	for y := out.Bounds().Min.Y; y < out.Bounds().Max.Y; y++ {
+		for x := out.Bounds().Min.X; x < out.Bounds().Max.X; x++ {
+			if r, _, _, _ := out.At(x, y).RGBA(); r>>8 == 220 {
+				reds++
+			}
+		}
+	}
+	// allow for seams through the edges of the squares
+	if reds < 2*100*100*9/10 {
+		t.Fatalf("expected both subjects to survive, found %d red pixels", reds)
+	}
+}
+
+func TestRefinement(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	coarse, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.RefinementLevels = 3
+	refined, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refined.Crop.Score.Total < coarse.Crop.Score.Total {
+		t.Fatalf("expected refined score %f to be at least %f", refined.Crop.Score.Total, coarse.Crop.Score.Total)
+	}
+
+	cfg.RefinementLevels = 0
+	cfg.LocalOptimization = true
+	optimized, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if optimized.Crop.Score.Total < coarse.Crop.Score.Total {
+		t.Fatalf("expected optimized score %f to be at least %f", optimized.Crop.Score.Total, coarse.Crop.Score.Total)
+	}
+}
+
+func TestSkinColors(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.SkinColors = DiverseSkinColors
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+
+	for _, tone := range facegen.SkinTones {
+		if l := cie(tone) / 255.0; l < cfg.SkinBrightnessMin || l > cfg.SkinBrightnessMax {
+			// excluded by brightness, regardless of the color model
+			continue
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 160, 160))
+		face := facegen.Face{Center: image.Pt(80, 90), Width: 100, Skin: tone, Hair: color.RGBA{30, 20, 10, 255}}
+		facegen.DrawFace(img, face)
+
+		o := newDetectorMap(img.Bounds())
+		analyzer.skinDetect(img, o)
+		// a point on the cheek should be detected with high confidence for every tone
+		if r := o.RGBA().RGBAAt(55, 110).R; r < 200 {
+			t.Errorf("skin tone %v: expected a strong skin response, got %d", tone, r)
+		}
+	}
+}
+
+func TestSkinDetectorYCbCr(t *testing.T) {
+	orange := color.RGBA{240, 170, 90, 255}
+	detect := func(cfg Config, c color.RGBA) uint8 {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+		o := newDetectorMap(img.Bounds())
+		NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer).skinDetect(img, o)
+		return o.RGBA().RGBAAt(4, 4).R
+	}
+
+	if detect(DefaultConfig, orange) == 0 {
+		t.Fatalf("expected the rgb detector to mistake %v for skin", orange)
+	}
+
+	cfg := DefaultConfig
+	cfg.SkinDetector = SkinDetectorYCbCr
+	if r := detect(cfg, orange); r != 0 {
+		t.Errorf("ycbcr detector: expected no skin response for %v, got %d", orange, r)
+	}
+	for _, tone := range facegen.SkinTones {
+		if l := cie(tone) / 255.0; l < cfg.SkinBrightnessMin || l > cfg.SkinBrightnessMax {
+			continue
+		}
+		if detect(cfg, tone) == 0 {
+			t.Errorf("ycbcr detector: skin tone %v not detected", tone)
+		}
+	}
+}
+
+func TestDisabledDetectors(t *testing.T) {
+	img, _ := facegen.Generate(facegen.Options{Width: 600, Height: 400, Faces: 2, Seed: 1})
+
+	// the detectors are on in configs not derived from DefaultConfig, too
+	if got := New(WithConfig(Config{})).Info().Detectors; strings.Join(got, ",") != "edge,skin,saturation" {
+		t.Fatalf("expected the detectors of the zero config to be on, got %v", got)
+	}
+
+	cfg := DefaultConfig
+	cfg.DisableSkin = true
+	cfg.DisableSaturation = true
+	crops, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindAllCrops(img, 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, crop := range crops {
+		if crop.Score.Skin != 0 || crop.Score.Saturation != 0 {
+			t.Fatalf("expected no skin or saturation score, got %+v", crop.Score)
+		}
+	}
+}
+
+func TestCropAndResize(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	out, topCrop, err := analyzer.CropAndResize(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := image.Rect(120, 0, 404, 284)
+	if topCrop.Rectangle != expected {
+		t.Fatalf("expected %v, got %v", expected, topCrop.Rectangle)
+	}
+	if out.Bounds().Dx() != 250 || out.Bounds().Dy() != 250 {
+		t.Fatalf("expected 250x250 output, got %v", out.Bounds())
+	}
+}
+
+func TestCropImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	r := image.Rect(10, 20, 60, 50)
+
+	// hide the SubImage method to force the copying fallback
+	cropped := CropImage(struct{ image.Image }{img}, r)
+	if cropped.Bounds() != r {
+		t.Fatalf("expected bounds %v, got %v", r, cropped.Bounds())
+	}
+	if _, ok := cropped.(*image.RGBA); !ok {
+		t.Fatalf("expected *image.RGBA, got %T", cropped)
+	}
+}
+
+func TestCropGray(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	cfg := DefaultConfig
+	cfg.GrayscaleFastPath = false
+	expected, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(gray, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.GrayscaleFastPath = true
+	topCrop, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(gray, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topCrop != expected {
+		t.Fatalf("expected %v, got %v", expected, topCrop)
+	}
+}
+
+func TestNightDetect(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.NightDetectEnabled = true
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+
+	dark := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(dark, dark.Bounds(), image.NewUniform(color.RGBA{20, 20, 30, 255}), image.ZP, draw.Src)
+	if a := analyzer.tunedFor(dark); a != analyzer.night {
+		t.Fatal("expected night tuning for dark image")
+	}
+
+	light := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(light, light.Bounds(), image.NewUniform(color.RGBA{200, 190, 180, 255}), image.ZP, draw.Src)
+	if a := analyzer.tunedFor(light); a != analyzer {
+		t.Fatal("expected default tuning for light image")
+	}
+
+	if _, err := analyzer.FindBestCrop(dark, 32, 32); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEdgeMargin(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+
+	cfg := DefaultConfig
+	cfg.EdgeMargin = 10
+	cfg.RefinementLevels = 2
+	cfg.LocalOptimization = true
+	topCrop, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := b.Inset(10)
+	if !topCrop.In(inner) {
+		t.Fatalf("expected %v to lie within %v", topCrop, inner)
+	}
+
+	// with MinScale 1 the crop spans the full height, so only the horizontal
+	// margin can be kept
+	cfg.MinScale = 1.0
+	topCrop, err = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topCrop.Min.Y != b.Min.Y || !topCrop.In(image.Rect(b.Min.X+10, b.Min.Y, b.Max.X-10, b.Max.Y)) {
+		t.Fatalf("expected %v to span the height of %v and keep the horizontal margin", topCrop, b)
+	}
+}
+
+func TestAnchorBias(t *testing.T) {
+	// uniform detector output, so only the position tells crops apart
+	o := newDetectorMap(image.Rect(0, 0, 400, 600))
+	for i := range o.Plane(ChannelDetail) {
+		o.Plane(ChannelDetail)[i] = 1
+	}
+	top := Crop{Rectangle: image.Rect(0, 0, 400, 400)}
+	bottom := Crop{Rectangle: image.Rect(0, 200, 400, 600)}
+
+	scores := func(anchor Anchor, bias float64) (float64, float64) {
+		cfg := DefaultConfig
+		cfg.Anchor = anchor
+		cfg.AnchorBias = bias
+		analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		return analyzer.score(o, top, nil, importanceKernels{}).Total, analyzer.score(o, bottom, nil, importanceKernels{}).Total
+	}
+
+	if t0, b0 := scores(AnchorTop, 0); math.Abs(t0-b0) > 1e-12 {
+		t.Fatalf("expected equal scores without bias, got %f and %f", t0, b0)
+	}
+	if t1, b1 := scores(AnchorTop, 0.05); t1 <= b1 {
+		t.Fatalf("AnchorTop: expected top crop to score higher, got %f and %f", t1, b1)
+	}
+	if t2, b2 := scores(AnchorBottom, 0.05); b2 <= t2 {
+		t.Fatalf("AnchorBottom: expected bottom crop to score higher, got %f and %f", t2, b2)
+	}
+}
+
+func TestScoreFunc(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	calls := 0
+	cfg.ScoreFunc = func(channels *ScoreMap, crop image.Rectangle, score Score) float64 {
+		calls++
+		if !crop.In(channels.Bounds()) {
+			t.Fatalf("crop %v outside of the channels %v", crop, channels.Bounds())
+		}
+		// favour the rightmost crops over everything else
+		return score.Total + float64(crop.Max.X)
+	}
+	topCrop, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 {
+		t.Fatal("expected ScoreFunc to be called")
+	}
+	if b := img.Bounds(); b.Max.X-topCrop.Max.X >= DefaultConfig.Step {
+		t.Fatalf("expected a crop at the right edge of %v, got %v", b, topCrop)
+	}
+}
+
+func TestFindBestCropWithMask(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+
+	// only the left third matters
+	mask := image.NewGray(b)
+	draw.Draw(mask, image.Rect(b.Min.X, b.Min.Y, b.Min.X+b.Dx()/3, b.Max.Y), image.White, image.ZP, draw.Src)
+
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	topCrop, err := analyzer.FindBestCropWithMask(img, 250, 250, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topCrop.Min.X != b.Min.X {
+		t.Fatalf("expected crop at the left edge, got %v", topCrop)
+	}
+
+	_, err = analyzer.FindBestCropWithMask(img, 250, 250, image.NewGray(image.Rect(0, 0, 10, 10)))
+	if err != ErrMaskSize {
+		t.Fatalf("expected ErrMaskSize, got %v", err)
+	}
+}
+
+func TestPadding(t *testing.T) {
+	img, _ := facegen.Generate(facegen.Options{Width: 400, Height: 600, Faces: 1, Seed: 3})
+
+	// plain cropping to a 3:1 banner keeps a sliver of the portrait
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	res, err := analyzer.Analyze(img, 300, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Padding != (Padding{}) {
+		t.Fatalf("expected no padding, got %+v", res.Padding)
+	}
+
+	cfg := DefaultConfig
+	cfg.MaxPadding = 0.5
+	analyzer = NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	res, err = analyzer.Analyze(img, 300, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, p := res.Crop.Rectangle, res.Padding
+	if aspect := float64(r.Dx()) / float64(r.Dy()); math.Abs(aspect-1.5) > 0.05 {
+		t.Fatalf("expected a 3:2 crop, got %v", r)
+	}
+	if p.Top != 0 || p.Bottom != 0 || p.Left+p.Right != 3*r.Dy()-r.Dx() {
+		t.Fatalf("expected padding to 3:1 on the left and right of %v, got %+v", r, p)
+	}
+
+	for _, fill := range []PadFill{PadFillAverage, PadFillBlur} {
+		out := PadImage(img, r, p, fill)
+		if out.Bounds().Dx() != 3*r.Dy() || out.Bounds().Dy() != r.Dy() {
+			t.Fatalf("expected a padded image of %dx%d, got %v", 3*r.Dy(), r.Dy(), out.Bounds())
+		}
+		if out.RGBAAt(p.Left, 0) != img.RGBAAt(r.Min.X, r.Min.Y) {
+			t.Fatal("expected the crop to be copied unchanged")
+		}
+	}
+
+	out, _, err := analyzer.CropAndResize(img, 300, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds().Dx() != 300 || out.Bounds().Dy() != 100 {
+		t.Fatalf("expected a 300x100 image, got %v", out.Bounds())
+	}
+}
+
+func TestRotation(t *testing.T) {
+	// a textured band through the center, rising 4 degrees to the right
+	img := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	tan := math.Tan(4 * math.Pi / 180)
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{120, 120, 120, 255}
+			if d := float64(y-200) + float64(x-200)*tan; math.Abs(d) < 12 && (x/2+y/2)%2 == 0 {
+				c = color.RGBA{230, 60, 40, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	cfg := DefaultConfig
+	cfg.MaxRotation = 6
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	res, err := analyzer.Analyze(img, 300, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotating clockwise straightens the band
+	if res.Angle != -4 {
+		t.Fatalf("expected an angle of -4, got %f", res.Angle)
+	}
+
+	out, _, err := analyzer.CropAndResize(img, 300, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds().Dx() != 300 || out.Bounds().Dy() != 40 {
+		t.Fatalf("expected a 300x40 image, got %v", out.Bounds())
+	}
+
+	// the centered fallback is taken from the unrotated image
+	cfg.MinAcceptableScore = 1e9
+	res, err = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 300, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Fallback || res.Angle != 0 {
+		t.Fatalf("expected an unrotated fallback, got fallback %v at an angle of %f", res.Fallback, res.Angle)
+	}
+
+	// a quarter turn counter-clockwise moves the right edge to the top
+	sq := image.NewRGBA(image.Rect(0, 0, 9, 9))
+	sq.SetRGBA(8, 4, color.RGBA{255, 255, 255, 255})
+	if c := RotateImage(sq, 90).RGBAAt(4, 0); c.R != 255 {
+		t.Fatalf("expected the pixel to be rotated to the top, got %v", c)
+	}
+}
+
+func TestWriteXMP(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	crop := Crop{Rectangle: image.Rect(8, 0, 56, 48), Score: Score{Total: 0.25, Normalized: 0.5}}
+
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"jpeg": jpg.Bytes(), "png": pngData.Bytes()} {
+		var once, twice bytes.Buffer
+		if err := WriteXMP(&once, bytes.NewReader(data), crop); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := WriteXMP(&twice, bytes.NewReader(once.Bytes()), crop); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := bytes.Count(twice.Bytes(), []byte(`smartcrop:Width="48"`)); n != 1 {
+			t.Fatalf("%s: expected one XMP packet, found %d", name, n)
+		}
+		decoded, _, err := image.Decode(&twice)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Fatalf("%s: unexpected bounds %v", name, decoded.Bounds())
+		}
+	}
+
+	if err := WriteXMP(ioutil.Discard, strings.NewReader("GIF89a"), crop); err != ErrUnsupportedFormat {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestReadRegions(t *testing.T) {
+	packet := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+    xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#"
+    xmlns:Iptc4xmpExt="http://iptc.org/std/Iptc4xmpExt/2008-02-29/">
+   <mwg-rs:Regions rdf:parseType="Resource">
+    <mwg-rs:RegionList>
+     <rdf:Bag>
+      <rdf:li rdf:parseType="Resource">
+       <mwg-rs:Type>Face</mwg-rs:Type>
+       <mwg-rs:Area stArea:x="0.25" stArea:y="0.5" stArea:w="0.1" stArea:h="0.2" stArea:unit="normalized"/>
+      </rdf:li>
+     </rdf:Bag>
+    </mwg-rs:RegionList>
+   </mwg-rs:Regions>
+   <Iptc4xmpExt:ImageRegion>
+    <rdf:Bag>
+     <rdf:li rdf:parseType="Resource">
+      <Iptc4xmpExt:RegionBoundary rdf:parseType="Resource">
+       <Iptc4xmpExt:rbShape>rectangle</Iptc4xmpExt:rbShape>
+       <Iptc4xmpExt:rbUnit>pixel</Iptc4xmpExt:rbUnit>
+       <Iptc4xmpExt:rbX>300</Iptc4xmpExt:rbX>
+       <Iptc4xmpExt:rbY>20</Iptc4xmpExt:rbY>
+       <Iptc4xmpExt:rbW>50</Iptc4xmpExt:rbW>
+       <Iptc4xmpExt:rbH>40</Iptc4xmpExt:rbH>
+      </Iptc4xmpExt:RegionBoundary>
+     </rdf:li>
+    </rdf:Bag>
+   </Iptc4xmpExt:ImageRegion>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`)
+
+	bounds := image.Rect(0, 0, 400, 200)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(bounds), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := jpegWithXMP(buf.Bytes(), packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regions, err := ReadRegions(bytes.NewReader(data), bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []image.Rectangle{image.Rect(80, 80, 120, 120), image.Rect(300, 20, 350, 60)}
+	if len(regions) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, regions)
+	}
+	for i := range expected {
+		if regions[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, regions)
+		}
+	}
+
+	mask := RegionMask(bounds, regions, 64)
+	if mask.GrayAt(100, 100).Y != 0xff || mask.GrayAt(0, 0).Y != 64 {
+		t.Fatal("unexpected mask values")
+	}
+
+	// a crop written to the image keeps the regions
+	for i := 0; i < 2; i++ {
+		var withCrop bytes.Buffer
+		if err := WriteXMP(&withCrop, bytes.NewReader(data), Crop{Rectangle: image.Rect(100, 0, 300, 200)}); err != nil {
+			t.Fatal(err)
+		}
+		data = withCrop.Bytes()
+	}
+	if n := bytes.Count(data, []byte(`smartcrop:Width="200"`)); n != 1 {
+		t.Fatalf("expected the crop once, found %d", n)
+	}
+	if regions, err := ReadRegions(bytes.NewReader(data), bounds); err != nil || len(regions) != len(expected) {
+		t.Fatalf("expected the regions to be kept, got %v, %v", regions, err)
+	}
+
+	if regions, err := ReadRegions(bytes.NewReader(buf.Bytes()), bounds); err != nil || regions != nil {
+		t.Fatalf("expected no regions, got %v, %v", regions, err)
+	}
+
+	// a compressed packet inflating beyond the limit, as a decompression bomb would
+	buf.Reset()
+	if err := png.Encode(&buf, image.NewRGBA(bounds)); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(make([]byte, maxMetadataSize+1))
+	zw.Close()
+	text := append(append([]byte{}, pngXMPKeyword...), 1, 0, 0, 0)
+	bomb := appendPNGChunk(append([]byte{}, buf.Bytes()[:33]...), "iTXt", append(text, compressed.Bytes()...))
+	bomb = append(bomb, buf.Bytes()[33:]...)
+	if _, err := ReadRegions(bytes.NewReader(bomb), bounds); err != ErrMetadataTooLarge {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.RefinementLevels = 1
+	var stages []string
+	last := map[string]float64{}
+	scoreCalls := 0
+	cfg.ProgressFunc = func(stage string, fraction float64) {
+		if fraction < last[stage] || fraction > 1 {
+			t.Fatalf("%s: fraction %f after %f", stage, fraction, last[stage])
+		}
+		if _, ok := last[stage]; !ok {
+			stages = append(stages, stage)
+		}
+		last[stage] = fraction
+		if stage == StageScore {
+			scoreCalls++
+		}
+	}
+	if _, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 250, 250); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{StageEdge, StageSkin, StageSaturation, StageScore, StageRefine}
+	if strings.Join(stages, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected stages %v, got %v", expected, stages)
+	}
+	for stage, fraction := range last {
+		if fraction != 1 {
+			t.Fatalf("%s finished at %f", stage, fraction)
+		}
+	}
+	if scoreCalls < 10 {
+		t.Fatalf("expected intermediate score progress, got %d calls", scoreCalls)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.Prescale = false
+	cfg.MaxAnalysisPixels = 100000
+	cfg.MaxCandidates = 200
+	cfg.ScoreFunc = func(channels *ScoreMap, crop image.Rectangle, score Score) float64 {
+		if p := channels.Bounds().Dx() * channels.Bounds().Dy(); p > cfg.MaxAnalysisPixels {
+			t.Fatalf("analysed %d pixels", p)
+		}
+		return score.Total
+	}
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+
+	crops, err := analyzer.FindAllCrops(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crops) > cfg.MaxCandidates {
+		t.Fatalf("expected at most %d candidates, got %d", cfg.MaxCandidates, len(crops))
+	}
+
+	res, err := analyzer.Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Coarsened {
+		t.Fatal("expected the analysis to be coarsened")
+	}
+	// unscaling from the analysis size may be off by a pixel
+	if d := res.Crop.Dx() - res.Crop.Dy(); !res.Crop.In(img.Bounds()) || d < -1 || d > 1 {
+		t.Fatalf("unexpected crop %v", res.Crop)
+	}
+
+	res, err = NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Coarsened {
+		t.Fatal("expected the default config not to coarsen the analysis")
+	}
+}
+
+func TestLimitsFewerCandidatesThanScales(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	cfg := DefaultConfig
+	cfg.MaxCandidates = 1
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := analyzer.FindBestCrop(img, 100, 100)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected FindBestCrop to return with MaxCandidates 1")
+	}
+}
+
+func TestFindTopCrops(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	// three highlights of decreasing size
+	highlights := []image.Rectangle{
+		image.Rect(560, 60, 640, 140),
+		image.Rect(80, 70, 140, 130),
+		image.Rect(1000, 80, 1040, 120),
+	}
+	for _, r := range highlights {
+		draw.Draw(img, r, &image.Uniform{color.RGBA{20, 40, 230, 255}}, image.Point{}, draw.Src)
+	}
+
+	crops, err := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).FindTopCrops(img, 200, 200, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crops) < len(highlights) {
+		t.Fatalf("expected at least %d crops, got %v", len(highlights), crops)
+	}
+	for i, crop := range crops {
+		for _, other := range crops[i+1:] {
+			if crop.Overlaps(other.Rectangle) {
+				t.Fatalf("crops %v and %v overlap", crop, other)
+			}
+		}
+		if i > 0 && crop.Score.Total > crops[i-1].Score.Total {
+			t.Fatalf("crops not ordered by score: %v", crops)
+		}
+	}
+	for i, r := range highlights {
+		if !r.In(crops[i].Rectangle) {
+			t.Fatalf("expected crop %d, %v, to contain %v", i, crops[i], r)
+		}
+	}
+}
+
+func TestCollage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	highlights := []image.Rectangle{
+		image.Rect(80, 70, 140, 130),
+		image.Rect(560, 60, 640, 140),
+		image.Rect(1000, 80, 1040, 120),
+	}
+	for _, r := range highlights {
+		draw.Draw(img, r, &image.Uniform{color.RGBA{20, 40, 230, 255}}, image.Point{}, draw.Src)
+	}
+
+	resizer := nfnt.NewDefaultResizer()
+	analyzer := NewAnalyzer(DefaultConfig, resizer)
+	grid := Grid{Columns: 3, Rows: 1, TileWidth: 100, TileHeight: 100}
+	crops, err := Collage(analyzer, img, grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crops) != grid.Tiles() {
+		t.Fatalf("expected %d crops, got %v", grid.Tiles(), crops)
+	}
+	for i, r := range highlights {
+		if !r.In(crops[i].Rectangle) {
+			t.Fatalf("expected tile %d, %v, to contain %v", i, crops[i], r)
+		}
+	}
+
+	out := DrawCollage(img, crops, grid, resizer)
+	if out.Bounds() != image.Rect(0, 0, 300, 100) {
+		t.Fatalf("unexpected collage bounds %v", out.Bounds())
+	}
+
+	grid.Columns = 20
+	if _, err := Collage(analyzer, img, grid); err != ErrCollageTooLarge {
+		t.Fatalf("expected ErrCollageTooLarge, got %v", err)
+	}
+
+	// the tiles are filled row by row
+	img = image.NewRGBA(image.Rect(0, 0, 400, 400))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	highlights = []image.Rectangle{
+		image.Rect(60, 70, 100, 110),
+		image.Rect(290, 50, 330, 90),
+		image.Rect(70, 300, 110, 340),
+		image.Rect(300, 280, 340, 320),
+	}
+	for _, r := range highlights {
+		draw.Draw(img, r, &image.Uniform{color.RGBA{20, 40, 230, 255}}, image.Point{}, draw.Src)
+	}
+	cfg := DefaultConfig
+	cfg.MinScale, cfg.MaxScale = 0.45, 0.45
+	grid = Grid{Columns: 2, Rows: 2, TileWidth: 100, TileHeight: 100}
+	crops, err = Collage(NewAnalyzer(cfg, resizer), img, grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range highlights {
+		if !r.In(crops[i].Rectangle) {
+			t.Fatalf("expected tile %d, %v, to contain %v", i, crops[i], r)
+		}
+	}
+}
+
+func TestConcurrentAnalyze(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.RefinementLevels = 1
+	cfg.LocalOptimization = true
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	expected, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			crop, err := analyzer.FindBestCrop(img, 250, 250)
+			if err == nil && crop != expected {
+				err = fmt.Errorf("expected %v, got %v", expected, crop)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type fixedFaces []image.Rectangle
+
+func (f fixedFaces) DetectFaces(img image.Image) ([]image.Rectangle, error) {
+	return f, nil
+}
+
+func TestNew(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.DisableSkin = true
+	expected, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crop, err := New(WithResizer(nfnt.NewDefaultResizer()), WithDetectors(DetectEdge, DetectSaturation), WithConfig(DefaultConfig)).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crop != expected {
+		t.Fatalf("expected %v, got %v", expected, crop)
+	}
+
+	cfg = DefaultConfig
+	cfg.FaceDetectEnabled = true
+	cfg.Prescale = false
+	faces := fixedFaces{image.Rect(10, 20, 60, 80)}
+	found, err := New(WithConfig(cfg), WithFaceDetector(faces)).FindFaces(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != faces[0] {
+		t.Fatalf("expected %v, got %v", faces, found)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.Explain = true
+	cfg.AnchorBias = 0.1
+	cfg.SharpnessEnabled = true
+	cfg.ScoreFunc = func(channels *ScoreMap, crop image.Rectangle, score Score) float64 {
+		return score.Total + 1
+	}
+	mask := image.NewGray(img.Bounds())
+	for i := range mask.Pix {
+		mask.Pix[i] = 0xff
+	}
+	res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer).analyze(img, 250, 250, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := res.Explanation
+	if e == nil {
+		t.Fatal("expected an explanation")
+	}
+	if e.Candidates == 0 || !e.Masked || len(e.Crops) != explainRunnersUp+1 {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	if e.Crops[0].Rectangle != res.Crop.Rectangle {
+		t.Fatalf("expected the chosen crop %v first, got %v", res.Crop, e.Crops[0])
+	}
+	for _, c := range e.Crops {
+		sum := c.Detail + c.Skin + c.Saturation + c.Sharpness + c.Face + c.AnchorBoost + c.Custom
+		if math.Abs(sum-c.Score.Total) > 1e-9 || math.Abs(c.Custom-1) > 1e-9 {
+			t.Fatalf("components of %v don't add up: %+v", c.Crop, c)
+		}
+		if c.Sharpness <= 0 || c.AnchorBoost <= 0 || c.Mask != 1 {
+			t.Fatalf("unexpected adjustments %+v", c)
+		}
+	}
+
+	res, err = NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Explanation != nil {
+		t.Fatal("expected no explanation without Config.Explain")
+	}
+}
+
+func TestCandidateGenerators(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 300))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	subject := image.Rect(560, 60, 640, 140)
+	draw.Draw(img, subject, &image.Uniform{color.RGBA{20, 40, 230, 255}}, image.Point{}, draw.Src)
+
+	count := func(a Analyzer) int {
+		n := 0
+		if err := a.ForEachCrop(img, 200, 200, func(Crop) bool {
+			n++
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	cfg := DefaultConfig
+	cfg.Prescale = false
+	grid := count(New(WithConfig(cfg)))
+
+	cfg.CandidateGenerator = ThirdsCandidates{}
+	thirds := New(WithConfig(cfg))
+	if n := count(thirds); n == 0 || n >= grid {
+		t.Fatalf("expected fewer than %d thirds candidates, got %d", grid, n)
+	}
+	crop, err := thirds.FindBestCrop(img, 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !subject.In(crop) {
+		t.Fatalf("expected %v to contain %v", crop, subject)
+	}
+
+	cfg.CandidateGenerator = FaceCandidates{}
+	cfg.FaceDetectEnabled = true
+	face := image.Rect(100, 100, 160, 160)
+	faces := New(WithConfig(cfg), WithFaceDetector(fixedFaces{face}))
+	n := 0
+	if err := faces.ForEachCrop(img, 200, 200, func(c Crop) bool {
+		if !face.In(c.Rectangle) {
+			t.Fatalf("expected candidate %v to contain the face %v", c, face)
+		}
+		n++
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n >= grid {
+		t.Fatalf("expected fewer than %d face candidates, got %d", grid, n)
+	}
+}
+
+func TestPrunePercentile(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	all, err := analyzer.FindAllCrops(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.PrunePercentile = 0.5
+	analyzer = NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	pruned, err := analyzer.FindAllCrops(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) == 0 || len(pruned) > len(all)*6/10 {
+		t.Fatalf("expected about half of %d candidates, got %d", len(all), len(pruned))
+	}
+	crop, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crop != expected {
+		t.Fatalf("expected pruning to keep the best crop %v, got %v", expected, crop)
+	}
+}
+
+type recordingPyramid struct {
+	ImagePyramid
+	requested, returned float64
+}
+
+func (p *recordingPyramid) Level(scale float64) (image.Image, float64, error) {
+	img, s, err := p.ImagePyramid.Level(scale)
+	p.requested, p.returned = scale, s
+	return img, s, err
+}
+
+func TestAnalyzePyramid(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resizer := nfnt.NewDefaultResizer()
+	b := img.Bounds()
+	src := &recordingPyramid{ImagePyramid: ImagePyramid{
+		resizer.Resize(img, uint(b.Dx()/4), 0),
+		img,
+		resizer.Resize(img, uint(b.Dx()/2), 0),
+	}}
+	if src.Bounds() != b {
+		t.Fatalf("expected the full resolution bounds %v, got %v", b, src.Bounds())
+	}
+
+	cfg := DefaultConfig
+	cfg.Prescale = false
+	cfg.MaxAnalysisPixels = b.Dx() * b.Dy() / 5
+	res, err := NewAnalyzer(cfg, resizer).AnalyzePyramid(src, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(src.requested-math.Sqrt(0.2)) > 0.01 || math.Abs(src.returned-0.5) > 0.01 {
+		t.Fatalf("expected the half resolution level for scale %f, got %f", src.requested, src.returned)
+	}
+	if h := res.Heatmap.Bounds(); h.Dx()*h.Dy() > cfg.MaxAnalysisPixels {
+		t.Fatalf("expected at most %d analysis pixels, got %v", cfg.MaxAnalysisPixels, h)
+	}
+
+	full, err := NewAnalyzer(cfg, resizer).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Crop.In(b) || !res.Crop.Overlaps(full.Crop.Rectangle) {
+		t.Fatalf("expected a crop close to %v, got %v", full.Crop, res.Crop)
+	}
+	if d := res.Crop.Dx() - res.Crop.Dy(); d < -2 || d > 2 {
+		t.Fatalf("expected a square crop, got %v", res.Crop)
+	}
+}
+
+func TestLinearLight(t *testing.T) {
+	if l := cieLinear(color.RGBA{255, 255, 255, 255}); math.Abs(l-255) > 1e-9 {
+		t.Fatalf("expected white to have lightness 255, got %f", l)
+	}
+	if l := cieLinear(color.RGBA{0, 0, 0, 255}); l != 0 {
+		t.Fatalf("expected black to have lightness 0, got %f", l)
+	}
+
+	// a dark red square on black
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(16, 16, 48, 48), &image.Uniform{color.RGBA{60, 0, 0, 255}}, image.Point{}, draw.Src)
+
+	maxEdge := func(linear bool) uint8 {
+		cfg := DefaultConfig
+		cfg.LinearLight = linear
+		sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		o := newDetectorMap(img.Bounds())
+		sca.edgeDetect(img, o)
+		heatmap := o.RGBA()
+		var max uint8
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				if g := heatmap.RGBAAt(x, y).G; g > max {
+					max = g
+				}
+			}
+		}
+		return max
+	}
+	gamma, linear := maxEdge(false), maxEdge(true)
+	if linear < 3*gamma || linear < 16 {
+		t.Fatalf("expected a much stronger edge in linear light, got %d vs %d", linear, gamma)
+	}
+}
+
+func TestScoreMap(t *testing.T) {
+	m := NewScoreMap(image.Rect(0, 0, 4, 3), ChannelDetail, ChannelSkin)
+	if names := m.Names(); len(names) != 2 || names[0] != ChannelDetail || names[1] != ChannelSkin {
+		t.Fatalf("unexpected channels %v", names)
+	}
+	if m.Plane(ChannelSaturation) != nil {
+		t.Fatal("expected no saturation channel")
+	}
+
+	m.Plane(ChannelDetail)[m.PixOffset(1, 2)] = 0.5
+	m.Plane(ChannelSkin)[m.PixOffset(1, 2)] = 2
+	logo := m.AddPlane("logo")
+	logo[m.PixOffset(3, 0)] = 0.25
+	if &m.AddPlane("logo")[0] != &logo[0] {
+		t.Fatal("expected AddPlane to return the existing channel")
+	}
+	if v := m.Value("logo", 3, 0); v != 0.25 {
+		t.Fatalf("expected 0.25, got %f", v)
+	}
+	if v := m.Value("logo", 4, 0); v != 0 {
+		t.Fatalf("expected 0 outside the map, got %f", v)
+	}
+
+	// values beyond 8 bits of precision survive, the renderer clamps
+	m.Plane(ChannelDetail)[0] = 1e-4
+	if v := m.Value(ChannelDetail, 0, 0); v != 1e-4 {
+		t.Fatalf("expected 1e-4, got %g", v)
+	}
+	if c := m.RGBA().RGBAAt(1, 2); c != (color.RGBA{255, 127, 0, 255}) {
+		t.Fatalf("unexpected rendering %v", c)
+	}
+	if c := m.At(1, 2); c != (color.RGBA{255, 127, 0, 255}) {
+		t.Fatalf("unexpected color %v", c)
+	}
+
+	// ScoreFunc sees the detector output
+	cfg := DefaultConfig
+	var channels *ScoreMap
+	cfg.ScoreFunc = func(c *ScoreMap, crop image.Rectangle, score Score) float64 {
+		channels = c
+		return score.Total
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 32, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channels != res.Heatmap || len(channels.Names()) != 3 {
+		t.Fatalf("expected ScoreFunc to get the heatmap with three channels, got %v", channels.Names())
+	}
+}
+
+func TestBlockScoring(t *testing.T) {
+	// detail in a stripe along the right edge, which isn't a multiple of
+	// ScoreDownSample wide, and in the mirrored map along the left edge
+	const width, height = 203, 120
+	right := newDetectorMap(image.Rect(0, 0, width, height))
+	left := newDetectorMap(right.Bounds())
+	for y := 0; y < height; y++ {
+		for x := width - 7; x < width; x++ {
+			right.Plane(ChannelDetail)[right.PixOffset(x, y)] = 1
+			left.Plane(ChannelDetail)[left.PixOffset(width-1-x, y)] = 1
+		}
+	}
+	rightCrop := Crop{Rectangle: image.Rect(width-120, 0, width, height)}
+	leftCrop := Crop{Rectangle: image.Rect(0, 0, 120, height)}
+
+	ratio := func(block bool) float64 {
+		cfg := DefaultConfig
+		cfg.BlockScoring = block
+		sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		return sca.score(right, rightCrop, nil, importanceKernels{}).Detail / sca.score(left, leftCrop, nil, importanceKernels{}).Detail
+	}
+	if r := ratio(false); r > 0.5 {
+		t.Fatalf("expected point sampling to miss most of the right edge, got a ratio of %f", r)
+	}
+	if r := ratio(true); math.Abs(r-1) > 0.1 {
+		t.Fatalf("expected mirrored maps to score alike, got a ratio of %f", r)
+	}
+
+	blocks := right.blocks(8)
+	if b := blocks.Bounds(); b != image.Rect(0, 0, 26, 15) {
+		t.Fatalf("unexpected block map bounds %v", b)
+	}
+	// 4 of 8 columns of the last full block, all 3 of the partial one
+	if v := blocks.Value(ChannelDetail, 24, 0); v != 0.5 {
+		t.Fatalf("expected a block mean of 0.5, got %f", v)
+	}
+	if v := blocks.Value(ChannelDetail, 25, 14); v != 1 {
+		t.Fatalf("expected a block mean of 1, got %f", v)
+	}
+
+	// the crop of a mirrored image is the mirrored crop, up to the grid
+	fi, err := os.Open(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	cfg.BlockScoring = true
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	for _, w := range []int{img.Bounds().Dx(), img.Bounds().Dx() - 3, img.Bounds().Dx() - 5} {
+		src := image.NewRGBA(image.Rect(0, 0, w, img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+		mirrored := image.NewRGBA(src.Bounds())
+		for y := 0; y < src.Bounds().Dy(); y++ {
+			for x := 0; x < w; x++ {
+				mirrored.SetRGBA(w-1-x, y, src.RGBAAt(x, y))
+			}
+		}
+
+		crop, err := analyzer.FindBestCrop(src, 250, 250)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mirroredCrop, err := analyzer.FindBestCrop(mirrored, 250, 250)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := crop.Min.X - (w - mirroredCrop.Max.X); d < -cfg.Step || d > cfg.Step || crop.Dx() != mirroredCrop.Dx() {
+			t.Fatalf("width %d: expected %v mirrored, got %v", w, crop, mirroredCrop)
+		}
+	}
+}
+
+func TestDetectPackage(t *testing.T) {
+	cfg := DefaultConfig
+	if o := detect.DefaultSkinOptions; o.Threshold != cfg.SkinThreshold || o.BrightnessMin != cfg.SkinBrightnessMin || o.BrightnessMax != cfg.SkinBrightnessMax {
+		t.Fatalf("skin options %+v don't match DefaultConfig", o)
+	}
+	if o := detect.DefaultSaturationOptions; o.Threshold != cfg.SaturationThreshold || o.BrightnessMin != cfg.SaturationBrightnessMin || o.BrightnessMax != cfg.SaturationBrightnessMax {
+		t.Fatalf("saturation options %+v don't match DefaultConfig", o)
+	}
+	if o := detect.DefaultImportanceOptions; o.EdgeRadius != cfg.EdgeRadius || o.EdgeWeight != cfg.EdgeWeight || o.OutsideImportance != cfg.OutsideImportance || o.RuleOfThirds != cfg.RuleOfThirds {
+		t.Fatalf("importance options %+v don't match DefaultConfig", o)
+	}
+
+	// the package functions see the same as the analyzer, also for images that
+	// don't start at the origin
+	fi, err := os.Open(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := toRGBA(img)
+	sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	o := newDetectorMap(rgba.Bounds())
+	sca.edgeDetect(rgba, o)
+	sca.skinDetect(rgba, o)
+	sca.saturationDetect(rgba, o)
+
+	sub := rgba.SubImage(image.Rect(100, 50, 300, 250))
+	edges := detect.Edges(sub, nil)
+	skin := detect.Skin(sub, detect.DefaultSkinOptions)
+	saturation := detect.Saturation(sub, detect.DefaultSaturationOptions)
+	for y := 51; y < 249; y++ {
+		for x := 101; x < 299; x++ {
+			if edges.At(x, y) != o.Value(ChannelDetail, x, y) || skin.At(x, y) != o.Value(ChannelSkin, x, y) || saturation.At(x, y) != o.Value(ChannelSaturation, x, y) {
+				t.Fatalf("at %d,%d: detect and the analyzer disagree", x, y)
+			}
+		}
+	}
+
+	crop := Crop{Rectangle: image.Rect(40, 30, 240, 180)}
+	for _, p := range []image.Point{{0, 0}, {40, 30}, {100, 100}, {239, 179}} {
+		if got, expected := detect.Importance(crop.Rectangle, p.X, p.Y, detect.DefaultImportanceOptions), sca.importance(crop, p.X, p.Y); got != expected {
+			t.Fatalf("at %v: expected importance %f, got %f", p, expected, got)
+		}
+	}
+}
+
+// failingResizer is an options.ResizerV2 that always fails.
+type failingResizer struct{}
+
+func (failingResizer) ResizeCtx(ctx context.Context, img image.Image, width, height uint) (image.Image, error) {
+	return nil, errors.New("resize failed")
+}
+
+func TestResizerV2(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+
+	// the error of a ResizerV2 ends the analysis
+	analyzer := NewAnalyzer(DefaultConfig, options.FromV2(failingResizer{}))
+	if _, err := analyzer.Analyze(img, 100, 100); err == nil || err.Error() != "resize failed" {
+		t.Fatalf("expected the resizer's error, got %v", err)
+	}
+	// Resize falls back to the original image
+	if out := options.FromV2(failingResizer{}).Resize(img, 10, 10); out != image.Image(img) {
+		t.Fatal("expected Resize to return the original image on failure")
+	}
+
+	// plain Resizers still see a canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	analyzer = NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	if _, err := analyzer.AnalyzeContext(ctx, img, 100, 100); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	res, err := analyzer.AnalyzeContext(context.Background(), img, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := analyzer.Analyze(img, 100, 100); res.Crop.Rectangle != expected.Crop.Rectangle {
+		t.Fatalf("expected %v, got %v", expected.Crop, res.Crop)
+	}
+}
+
+func TestAnalyzePreview(t *testing.T) {
+	from, to := image.Rect(0, 0, 100, 50), image.Rect(10, 20, 410, 220)
+	if r := MapCrop(image.Rect(25, 10, 75, 50), from, to); r != image.Rect(110, 60, 310, 220) {
+		t.Fatalf("unexpected mapped crop %v", r)
+	}
+	if r := MapCrop(image.Rect(110, 60, 310, 220), to, from); r != image.Rect(25, 10, 75, 50) {
+		t.Fatalf("unexpected mapped crop %v", r)
+	}
+	if r := MapCrop(image.Rect(90, 0, 120, 50), from, to); r != image.Rect(370, 20, 410, 220) {
+		t.Fatalf("expected the mapped crop to be clipped, got %v", r)
+	}
+
+	fi, err := os.Open(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a master twice the size of the test image, which is its preview
+	original := image.Rect(0, 0, 2*img.Bounds().Dx(), 2*img.Bounds().Dy())
+
+	cfg := DefaultConfig
+	cfg.Prescale = false
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	res, err := analyzer.AnalyzePreview(img, original, 500, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := MapCrop(expected, img.Bounds(), original); res.Crop.Rectangle != r {
+		t.Fatalf("expected %v, got %v", r, res.Crop.Rectangle)
+	}
+}
+
+func TestBoxPrescale(t *testing.T) {
+	checker := image.NewGray(image.Rect(10, 10, 18, 14))
+	for y := checker.Rect.Min.Y; y < checker.Rect.Max.Y; y++ {
+		for x := checker.Rect.Min.X; x < checker.Rect.Max.X; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetGray(x, y, color.Gray{200})
+			}
+		}
+	}
+	small, ok := boxResize(checker, 4, 2).(*image.Gray)
+	if !ok || small.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Fatalf("expected a 4x2 gray image, got %T %v", small, small.Bounds())
+	}
+	for i, v := range small.Pix {
+		if v != 100 {
+			t.Fatalf("expected 2x2 blocks of a checker to average to 100, got %d at %d", v, i)
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 9, 3))
+	for x := 0; x < 9; x++ {
+		for y := 0; y < 3; y++ {
+			rgba.SetRGBA(x, y, color.RGBA{uint8(30 * (x / 3)), 90, 0, 255})
+		}
+	}
+	out := boxResize(rgba, 3, 1).(*image.RGBA)
+	for x, want := range []uint8{0, 30, 60} {
+		if c := out.RGBAAt(x, 0); c != (color.RGBA{want, 90, 0, 255}) {
+			t.Errorf("expected the mean of column block %d to be %d, got %v", x, want, c)
+		}
+	}
+
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig
+	config.BoxPrescale = true
+	analyzer := NewAnalyzer(config, nfnt.NewDefaultResizer())
+	small2, factor, err := analyzer.(*smartcropAnalyzer).prescale(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := int(float64(img.Bounds().Dx()) * factor); small2.Bounds().Dx() != w {
+		t.Errorf("expected the analysis copy to be %d wide, got %v", w, small2.Bounds())
+	}
+	topCrop, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topCrop.Dx() != topCrop.Dy() || !topCrop.In(img.Bounds()) {
+		t.Errorf("expected a square crop within the image, got %v", topCrop)
+	}
+}
+
+func TestColorSpace(t *testing.T) {
+	if l, a, b := detect.CIELAB(color.RGBA{255, 255, 255, 255}); math.Abs(l-100) > 0.01 || math.Abs(a) > 0.01 || math.Abs(b) > 0.01 {
+		t.Errorf("expected white to be L*a*b* 100,0,0, got %f,%f,%f", l, a, b)
+	}
+	if l, a, b := detect.OKLab(color.RGBA{255, 255, 255, 255}); math.Abs(l-1) > 0.001 || math.Abs(a) > 0.001 || math.Abs(b) > 0.001 {
+		t.Errorf("expected white to be OKLab 1,0,0, got %f,%f,%f", l, a, b)
+	}
+	for _, rate := range []func(color.RGBA) float64{detect.LabSaturation, detect.OKLabSaturation} {
+		if s := rate(color.RGBA{128, 128, 128, 255}); s > 1e-3 {
+			t.Errorf("expected gray to be unsaturated, got %f", s)
+		}
+		// a dim pixel under an orange cast is saturated in HSL, but not colorful
+		cast := color.RGBA{90, 50, 30, 255}
+		if s, hsl := rate(cast), detect.HSLSaturation(cast); s >= DefaultConfig.SaturationThreshold || hsl < DefaultConfig.SaturationThreshold {
+			t.Errorf("expected the cast to rate %f below the threshold and %f in HSL above", s, hsl)
+		}
+		if s := rate(color.RGBA{255, 0, 255, 255}); s < 0.7 {
+			t.Errorf("expected magenta to be saturated, got %f", s)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetRGBA(x, y, color.RGBA{90 + uint8(x), 50, 30, 255})
+		}
+	}
+	saturated := func(cs ColorSpace) int {
+		cfg := DefaultConfig
+		cfg.ColorSpace = cs
+		sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		o := newDetectorMap(img.Bounds())
+		sca.saturationDetect(img, o)
+		n := 0
+		for _, v := range o.Plane(ChannelSaturation) {
+			if v > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if rgb, lab, ok := saturated(ColorSpaceRGB), saturated(ColorSpaceCIELAB), saturated(ColorSpaceOKLab); rgb != 64*64 || lab >= rgb || ok >= rgb {
+		t.Errorf("expected the chroma based detectors to rate fewer cast pixels saturated, got %d in RGB, %d in CIELAB and %d in OKLab", rgb, lab, ok)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	// quadrants of black, skin, gray and white, tinted blue
+	tinted := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	quadrants := []color.RGBA{{0, 0, 0, 255}, {200, 146, 112, 255}, {128, 128, 128, 255}, {255, 255, 255, 255}}
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			c := quadrants[y/20*2+x/20]
+			tinted.SetRGBA(x, y, color.RGBA{uint8(int(c.R) * 3 / 4), uint8(int(c.G) * 3 / 4), c.B, 255})
+		}
+	}
+	balanced := normalizeColors(tinted, true, false).(*image.RGBA)
+	var sums [3]int
+	for i := 0; i < len(balanced.Pix); i += 4 {
+		sums[0] += int(balanced.Pix[i])
+		sums[1] += int(balanced.Pix[i+1])
+		sums[2] += int(balanced.Pix[i+2])
+	}
+	for c := 1; c < 3; c++ {
+		if d := math.Abs(float64(sums[c]-sums[0])) / float64(sums[0]); d > 0.02 {
+			t.Errorf("expected equal channel means after GrayWorld, got sums %v", sums)
+		}
+	}
+
+	// the same quadrants, underexposed
+	dark := image.NewRGBA(tinted.Rect)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			c := quadrants[y/20*2+x/20]
+			dark.SetRGBA(x, y, color.RGBA{uint8(int(c.R) / 5), uint8(int(c.G) / 5), uint8(int(c.B) / 5), 255})
+		}
+	}
+	before := dark.RGBAAt(30, 30)
+	leveled := normalizeColors(dark, false, true).(*image.RGBA)
+	if c := leveled.RGBAAt(30, 30); c.R < 250 || c.G < 250 || c.B < 250 {
+		t.Errorf("expected AutoLevels to stretch the highlights to white, got %v", c)
+	}
+	if dark.RGBAAt(30, 30) != before {
+		t.Error("expected normalizeColors to leave its input unchanged")
+	}
+	if g := normalizeColors(image.NewGray(image.Rect(0, 0, 4, 4)), true, true); g.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Errorf("expected a flat gray image to keep its bounds, got %v", g.Bounds())
+	}
+
+	skin := func(cfg Config) float32 {
+		sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		o, _, err := sca.detect(dark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o.Value(ChannelSkin, 30, 10)
+	}
+	cfg := DefaultConfig
+	cfg.AutoLevels = true
+	if plain, leveled := skin(DefaultConfig), skin(cfg); plain != 0 || leveled == 0 {
+		t.Errorf("expected underexposed skin to be detected with AutoLevels only, got %f and %f", plain, leveled)
+	}
+}
+
+func TestSharpness(t *testing.T) {
+	// in-focus texture on the left, a smooth saturated gradient on the right
+	img := image.NewRGBA(image.Rect(0, 0, 256, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 256; x++ {
+			if x < 128 {
+				v := uint8(128)
+				if (x/2+y/2)%2 == 0 {
+					v = 136
+				}
+				img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{255, uint8(150 + (x-128)/8), 0, 255})
+			}
+		}
+	}
+	m := detect.Sharpness(img, detect.DefaultSharpnessOptions)
+	if l, r := m.At(40, 40), m.At(200, 40); l < 0.5 || r > 0.05 {
+		t.Errorf("expected the texture to be sharp and the gradient not, got %f and %f", l, r)
+	}
+
+	plain, err := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).FindBestCrop(img, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	cfg.SharpnessEnabled = true
+	cfg.SharpnessWeight = 5
+	res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topCrop := res.Crop
+	if center := (topCrop.Min.X + topCrop.Max.X) / 2; center >= 128 || center >= (plain.Min.X+plain.Max.X)/2 {
+		t.Errorf("expected the crop to move to the in-focus half, got %v instead of %v", topCrop.Rectangle, plain)
+	}
+	if s := topCrop.Score.Channel(ChannelSharpness); s <= 0 {
+		t.Errorf("expected a sharpness contribution, got %f", s)
+	}
+
+	cfg.DeterministicScoring = true
+	if c, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 100, 100); err != nil || c != topCrop.Rectangle {
+		t.Errorf("expected deterministic scoring to agree, got %v, %v", c, err)
+	}
+}
+
+func TestFindConsistentCrop(t *testing.T) {
+	fi, err := os.Open(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	best, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the best crop is consistent with itself
+	crop, err := analyzer.FindConsistentCrop(img, 250, 250, best, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crop.Rectangle != best {
+		t.Errorf("expected the best crop %v, got %v", best, crop.Rectangle)
+	}
+
+	// a reference at the other end of the image pulls the crop along
+	reference := image.Rect(img.Bounds().Dx()-best.Dx(), 0, img.Bounds().Dx(), best.Dy())
+	if iou(reference, best) > 0.5 {
+		reference = image.Rect(0, 0, best.Dx(), best.Dy())
+	}
+	crop, err = analyzer.FindConsistentCrop(img, 250, 250, reference, 0.7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := iou(crop.Rectangle, reference); v < 0.7 {
+		t.Errorf("expected an IoU of at least 0.7 with %v, got %v with %f", reference, crop.Rectangle, v)
+	}
+
+	// a reference no candidate comes close to is kept
+	tiny := image.Rect(10, 10, 20, 20)
+	crop, err = analyzer.FindConsistentCrop(img, 250, 250, tiny, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crop.Rectangle != tiny {
+		t.Errorf("expected the reference %v, got %v", tiny, crop.Rectangle)
+	}
+}
+
+func TestOriginalStep(t *testing.T) {
+	cfg := DefaultConfig
+	sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	bounds := image.Rect(0, 0, 400, 300)
+	if s := sca.analysisStep(bounds, 0.4); s != cfg.Step {
+		t.Errorf("expected Step %d without OriginalStep, got %d", cfg.Step, s)
+	}
+
+	cfg.OriginalStep = 24
+	sca = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	for _, tc := range []struct {
+		prescalefactor float64
+		step           int
+	}{{1, 24}, {0.4, 10}, {0.01, 1}} {
+		if s := sca.analysisStep(bounds, tc.prescalefactor); s != tc.step {
+			t.Errorf("expected a step of %d at %f, got %d", tc.step, tc.prescalefactor, s)
+		}
+	}
+	limited, coarsened := sca.limited(bounds, 100, 100, 1, 0.4)
+	if limited.config.Step != 10 || coarsened {
+		t.Errorf("expected the analyzer to use a step of 10 without coarsening, got %d, %t", limited.config.Step, coarsened)
+	}
+
+	cfg.OriginalStep = 0.05
+	sca = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	if s := sca.analysisStep(bounds, 0.4); s != 15 {
+		t.Errorf("expected a step of 5%% of 300 pixels, got %d", s)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	if _, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 100, 100); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// noCandidates is a CandidateGenerator that proposes nothing.
+type noCandidates struct{}
+
+func (noCandidates) Candidates(s CandidateSpace, fn func(r image.Rectangle) bool) {}
+
+func TestNoCropFound(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	cfg := DefaultConfig
+	cfg.CandidateGenerator = noCandidates{}
+	if _, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 50, 50); err != ErrNoCropFound {
+		t.Errorf("expected ErrNoCropFound, got %v", err)
+	}
+
+	cfg.FullImageFallback = true
+	res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 50, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Crop.Rectangle != img.Bounds() || !res.Fallback {
+		t.Errorf("expected a full image fallback, got %v, fallback %t", res.Crop.Rectangle, res.Fallback)
+	}
+}
+
+func TestUpscalePolicy(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for _, tc := range []struct {
+		policy        UpscalePolicy
+		width, height int
+		crop          image.Rectangle
+		err           error
+	}{
+		{UpscaleBestEffort, 4000, 4000, image.Rect(0, 0, 500, 500), nil},
+		{UpscaleBestEffort, 4000, 1000, image.Rect(0, 0, 1000, 250), nil},
+		{UpscaleError, 4000, 4000, image.Rectangle{}, ErrTargetTooLarge},
+		{UpscaleError, 1200, 0, image.Rectangle{}, ErrTargetTooLarge},
+		{UpscaleError, 400, 400, image.Rect(0, 0, 500, 500), nil},
+		{UpscaleFullImage, 4000, 4000, image.Rect(0, 0, 1000, 500), nil},
+		{UpscaleFullImage, 400, 400, image.Rect(0, 0, 500, 500), nil},
+	} {
+		cfg := DefaultConfig
+		cfg.Upscale = tc.policy
+		crop, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, tc.width, tc.height)
+		if err != tc.err {
+			t.Errorf("policy %d, %dx%d: expected error %v, got %v", tc.policy, tc.width, tc.height, tc.err, err)
+			continue
+		}
+		if err == nil && crop.Size() != tc.crop.Size() {
+			t.Errorf("policy %d, %dx%d: expected a %v crop, got %v", tc.policy, tc.width, tc.height, tc.crop.Size(), crop)
+		}
+	}
+}
+
+func TestAlignTo(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.AlignTo = 8
+	sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	bounds := image.Rect(0, 0, 203, 101)
+	for _, tc := range []struct {
+		r, aligned image.Rectangle
+	}{
+		{image.Rect(3, 5, 61, 70), image.Rect(0, 0, 64, 72)},
+		{image.Rect(16, 8, 32, 24), image.Rect(16, 8, 32, 24)},
+		// rounded inwards at the right and bottom image edges
+		{image.Rect(150, 50, 203, 101), image.Rect(144, 48, 200, 96)},
+	} {
+		if a := sca.align(tc.r, bounds); a != tc.aligned {
+			t.Errorf("expected %v to align to %v, got %v", tc.r, tc.aligned, a)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 203, 101))
+	for _, n := range []int{2, 16} {
+		cfg.AlignTo = n
+		crop, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).FindBestCrop(img, 77, 33)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crop.Min.X%n != 0 || crop.Min.Y%n != 0 || crop.Max.X%n != 0 || crop.Max.Y%n != 0 || !crop.In(img.Bounds()) {
+			t.Errorf("expected a crop aligned to %d within the image, got %v", n, crop)
+		}
+	}
+}
+
+func TestCropJPEG(t *testing.T) {
+	fi, err := os.Open(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grayImg := image.NewGray(img.Bounds())
+	draw.Draw(grayImg, grayImg.Bounds(), img, img.Bounds().Min, draw.Src)
+	var gray bytes.Buffer
+	if err := jpeg.Encode(&gray, grayImg, nil); err != nil {
+		t.Fatal(err)
+	}
+	var ycc bytes.Buffer
+	if err := jpeg.Encode(&ycc, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		data          []byte
+		crop, cropped image.Rectangle
+	}{
+		{"ycbcr", ycc.Bytes(), image.Rect(100, 37, 301, 250), image.Rect(96, 32, 301, 250)},
+		{"ycbcr aligned", ycc.Bytes(), image.Rect(32, 48, 160, 112), image.Rect(32, 48, 160, 112)},
+		{"gray", gray.Bytes(), image.Rect(101, 37, 303, 250), image.Rect(96, 32, 303, 250)},
+	} {
+		var out bytes.Buffer
+		cropped, err := CropJPEG(&out, bytes.NewReader(tc.data), tc.crop)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if cropped != tc.cropped {
+			t.Errorf("%s: expected the crop to be aligned to %v, got %v", tc.name, tc.cropped, cropped)
+		}
+		got, err := jpeg.Decode(&out)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		want, err := jpeg.Decode(bytes.NewReader(tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds().Size() != cropped.Size() {
+			t.Fatalf("%s: expected a %v image, got %v", tc.name, cropped.Size(), got.Bounds())
+		}
+		// the blocks are copied as they are, so the pixels decode the same
+		for y := 0; y < cropped.Dy(); y++ {
+			for x := 0; x < cropped.Dx(); x++ {
+				if a, b := got.At(x, y), want.At(cropped.Min.X+x, cropped.Min.Y+y); a != b {
+					t.Fatalf("%s: at %d,%d expected %v, got %v", tc.name, x, y, b, a)
+				}
+			}
+		}
+	}
+
+	if _, err := CropJPEG(ioutil.Discard, bytes.NewReader(ycc.Bytes()), image.Rect(5000, 0, 5100, 100)); err != ErrEmptyCrop {
+		t.Errorf("expected ErrEmptyCrop, got %v", err)
+	}
+	if _, err := CropJPEG(ioutil.Discard, strings.NewReader("\x89PNG\r\n\x1a\n"), image.Rect(0, 0, 8, 8)); err != ErrUnsupportedFormat {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			// shades of red on three quarters, blue on the rest
+			c := color.RGBA{200 + uint8((x+y)%16), 20, 30, 255}
+			if x >= 150 {
+				c = color.RGBA{10, 40, 180 + uint8(y%8), 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	res, err := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).Analyze(img, 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Palette != nil {
+		t.Errorf("expected no palette by default, got %v", res.Palette)
+	}
+
+	cfg := DefaultConfig
+	cfg.PaletteSize = 3
+	res, err = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the shades of red are too close to make up two colors
+	if len(res.Palette) != 2 {
+		t.Fatalf("expected two colors, got %v", res.Palette)
+	}
+	dominant, second := res.Palette[0], res.Palette[1]
+	if dominant.R < 190 || dominant.B > 60 || math.Abs(dominant.Weight-0.75) > 0.05 {
+		t.Errorf("expected red covering three quarters to dominate, got %v", dominant)
+	}
+	if second.B < 170 || second.R > 40 {
+		t.Errorf("expected blue second, got %v", second)
+	}
+	var sum float64
+	for _, c := range res.Palette {
+		sum += c.Weight
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("expected the weights to sum to 1, got %f", sum)
+	}
+}
+
+func TestImageHash(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small := nfnt.NewDefaultResizer().Resize(img, uint(img.Bounds().Dx()/3), uint(img.Bounds().Dy()/3))
+	b := img.Bounds()
+	bright := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	mirrored := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			lift := func(v uint32) uint8 { return uint8(math.Min(float64(v>>8)*1.1+10, 255)) }
+			bright.SetRGBA(x, y, color.RGBA{lift(r), lift(g), lift(bl), 255})
+			mirrored.Set(b.Dx()-1-x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	for _, algorithm := range []HashAlgorithm{HashAverage, HashPerceptual} {
+		h := ImageHash(img, algorithm)
+		if d := HashDistance(h, ImageHash(small, algorithm)); d > 6 {
+			t.Errorf("algorithm %d: expected a downscaled copy to hash alike, %d bits differ", algorithm, d)
+		}
+		if d := HashDistance(h, ImageHash(bright, algorithm)); d > 6 {
+			t.Errorf("algorithm %d: expected a brightened copy to hash alike, %d bits differ", algorithm, d)
+		}
+		if d := HashDistance(h, ImageHash(mirrored, algorithm)); d < 12 {
+			t.Errorf("algorithm %d: expected the mirrored image to hash differently, only %d bits differ", algorithm, d)
+		}
+
+		cfg := DefaultConfig
+		cfg.ImageHash = algorithm
+		res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := HashDistance(h, res.Hash); d > 6 {
+			t.Errorf("algorithm %d: expected Analyze to hash the analysis copy alike, %d bits differ", algorithm, d)
+		}
+	}
+	if ImageHash(img, HashNone) != 0 {
+		t.Error("expected no hash with HashNone")
+	}
+}
+
+func TestCache(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyses := 0
+	cfg := DefaultConfig
+	cfg.ProgressFunc = func(stage string, fraction float64) {
+		if stage == StageEdge && fraction == 0 {
+			analyses++
+		}
+	}
+	cache := NewLRUCache(2)
+	analyzer := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithCache(cache))
+
+	first, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := analyzer.FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analyses != 1 || first != second {
+		t.Errorf("expected the second crop from the cache, got %v and %v after %d analyses", first, second, analyses)
+	}
+
+	if _, err := analyzer.FindBestCrop(img, 100, 250); err != nil {
+		t.Fatal(err)
+	}
+	changed := image.NewRGBA(img.Bounds())
+	draw.Draw(changed, changed.Bounds(), img, img.Bounds().Min, draw.Src)
+	changed.SetRGBA(10, 10, color.RGBA{255, 0, 255, 255})
+	if _, err := analyzer.FindBestCrop(changed, 250, 250); err != nil {
+		t.Fatal(err)
+	}
+	other := cfg
+	other.SkinWeight *= 2
+	if _, err := New(WithConfig(other), WithResizer(nfnt.NewDefaultResizer()), WithCache(cache)).FindBestCrop(img, 250, 250); err != nil {
+		t.Fatal(err)
+	}
+	if analyses != 4 {
+		t.Errorf("expected another size, image and config to miss the cache, got %d analyses", analyses)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected the cache to hold 2 crops, got %d", cache.Len())
+	}
+
+	// the first crop was evicted as the least recently used
+	if _, err := analyzer.FindBestCrop(img, 250, 250); err != nil {
+		t.Fatal(err)
+	}
+	if analyses != 5 {
+		t.Errorf("expected the evicted crop to be analyzed again, got %d analyses", analyses)
+	}
+}
+
+func TestCacheSharedByAnalyzers(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	draw.Draw(img, image.Rect(0, 0, 100, 200), image.NewUniform(color.RGBA{220, 30, 30, 255}), image.ZP, draw.Src)
+	cache := NewLRUCache(10)
+	newAnalyzer := func(opts ...Option) Analyzer {
+		return New(append([]Option{WithResizer(nfnt.NewDefaultResizer()), WithCache(cache)}, opts...)...)
+	}
+	left, err := newAnalyzer().FindBestCrop(img, 100, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the crop furthest right wins with this ScoreFunc
+	cfg := DefaultConfig
+	cfg.ScoreFunc = func(channels *ScoreMap, crop image.Rectangle, score Score) float64 {
+		return float64(crop.Min.X)
+	}
+	right, err := newAnalyzer(WithConfig(cfg)).FindBestCrop(img, 100, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if right == left {
+		t.Fatalf("expected the analyzer with a ScoreFunc not to get the crop %v of the other from the cache", left)
+	}
+	if _, err := newAnalyzer(WithFaceDetector(fixedFaces{})).FindBestCrop(img, 100, 200); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 3 {
+		t.Errorf("expected a crop per analyzer, got %d", cache.Len())
+	}
+
+	if _, err := newAnalyzer().FindBestCrop(img, 100, 200); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 3 {
+		t.Errorf("expected analyzers with the same settings to share crops, got %d", cache.Len())
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	cfg := DefaultConfig
+	if cfg.Hash() != DefaultConfig.Hash() {
+		t.Error("expected copies of a config to hash alike")
+	}
+	if DefaultConfig.Hash() == FaceDetectConfig.Hash() {
+		t.Error("expected DefaultConfig and FaceDetectConfig to hash differently")
+	}
+
+	cfg.ProgressFunc = func(string, float64) {}
+	if cfg.Hash() != DefaultConfig.Hash() {
+		t.Error("expected ProgressFunc not to change the hash")
+	}
+	cfg.SkinWeight += 0.1
+	if cfg.Hash() == DefaultConfig.Hash() {
+		t.Error("expected SkinWeight to change the hash")
+	}
+
+	a, b := DefaultConfig, DefaultConfig
+	a.ScoreFunc = func(*ScoreMap, image.Rectangle, Score) float64 { return 0 }
+	b.ScoreFunc = func(*ScoreMap, image.Rectangle, Score) float64 { return 1 }
+	if a.Hash() == DefaultConfig.Hash() || a.Hash() != b.Hash() {
+		t.Error("expected ScoreFunc to change the hash by being set only")
+	}
+}
+
+func TestCropJSON(t *testing.T) {
+	crop := Crop{Rectangle: image.Rect(10, 20, 110, 70), Score: Score{Detail: 1, Total: 2}}
+	data, err := json.Marshal(crop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Crop
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Rectangle != crop.Rectangle || decoded.Score.Total != 2 {
+		t.Errorf("expected %v to round trip, got %v", crop, decoded)
+	}
+	if bytes.Contains(data, []byte("AlgorithmVersion")) || decoded.AlgorithmVersion != 0 {
+		t.Errorf("expected a crop without a version to stay without one, got %s", data)
+	}
+
+	// crops decoded with another version keep it when re-encoded
+	if err := json.Unmarshal([]byte(`{"Min":{"X":0,"Y":0},"Max":{"X":5,"Y":5},"AlgorithmVersion":99}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(decoded); !bytes.Contains(data, []byte(`"AlgorithmVersion":99`)) {
+		t.Errorf("expected the stored version to be kept, got %s", data)
+	}
+	if xmp := CropXMP(decoded); !bytes.Contains(xmp, []byte(`smartcrop:AlgorithmVersion="99"`)) {
+		t.Errorf("expected the stored version in the XMP packet, got %s", xmp)
+	}
+
+	// crops returned by the analyzer are stamped with the current version
+	analyzer := New(WithConfig(DefaultConfig))
+	res, err := analyzer.Analyze(image.NewRGBA(image.Rect(0, 0, 200, 100)), 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Crop.AlgorithmVersion != AlgorithmVersion {
+		t.Errorf("expected version %d, got %d", AlgorithmVersion, res.Crop.AlgorithmVersion)
+	}
+	crops, err := analyzer.FindAllCrops(image.NewRGBA(image.Rect(0, 0, 200, 100)), 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crops) == 0 || crops[0].AlgorithmVersion != AlgorithmVersion {
+		t.Errorf("expected all crops to have version %d", AlgorithmVersion)
+	}
+}
+
+func TestCompatibilityMode(t *testing.T) {
+	for v, want := range map[float64]uint8{-3: 0, 0.5: 0, 1.5: 2, 2.5: 2, 2.6: 3, 254.5: 254, 300: 255, math.NaN(): 0} {
+		if got := clampUint8(v); got != want {
+			t.Errorf("clampUint8(%v): expected %d, got %d", v, want, got)
+		}
+	}
+
+	// downsampling keeps half of the maximum skin and 30% of the maximum detail,
+	// 12.5+50 rounds to even
+	block := jsImage{width: 2, height: 2, pix: []uint8{
+		100, 10, 40, 0, 0, 10, 40, 0,
+		0, 10, 40, 0, 0, 250, 40, 0,
+	}}
+	if got := jsDownSample(block, 2).pix; got[0] != 62 || got[1] != 124 || got[2] != 40 {
+		t.Errorf("expected the downsampled block to be 62, 124, 40, got %v", got[:3])
+	}
+
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewAnalyzer(PresetSmartcropJS, nfnt.NewDefaultResizer()).Analyze(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the crop size follows from the prescaling of smartcrop.crop
+	b := img.Bounds()
+	scale := math.Min(float64(b.Dx())/250, float64(b.Dy())/250)
+	prescale := math.Min(math.Max(256/float64(b.Dx()), 256/float64(b.Dy())), 1)
+	side := int(float64(int(float64(int(250*scale))*prescale)) / prescale)
+	if res.Crop.Dx() != side || res.Crop.Dy() != side {
+		t.Errorf("expected a %dx%d crop, got %v", side, side, res.Crop)
+	}
+	if res.Crop.Score.Total <= 0 || res.Heatmap == nil {
+		t.Errorf("expected a scored crop and heatmap, got %+v", res.Crop.Score)
+	}
+	if !res.Crop.In(image.Rect(0, 0, b.Dx(), b.Dy())) {
+		t.Errorf("expected the crop within the image, got %v", res.Crop)
+	}
+
+	// a ScoreDownSample of 0 scores every pixel instead of dividing by 0
+	cfg := PresetSmartcropJS
+	cfg.ScoreDownSample = 0
+	if _, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 250, 250); err != nil {
+		t.Errorf("expected a crop without downsampling, got %v", err)
+	}
+
+	cfg = PresetSmartcropJS
+	cfg.CompatibilityMode = "smartcropjs-0.x"
+	if _, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 250, 250); err != ErrUnknownCompatibilityMode {
+		t.Errorf("expected ErrUnknownCompatibilityMode, got %v", err)
+	}
+}
+
+// compatPixel returns the color of x, y in the image of seed for
+// TestCompatibilityVectors, like pixel in testdata/smartcropjs/record.js.
+func compatPixel(seed, width, height, x, y int) color.RGBA {
+	noise := (x*31 + y*17 + seed*101 + (x*y)%97) % 23
+	sx, sy := (seed*37)%(width-60), (seed*53)%(height-60)
+	if x >= sx && x < sx+50 && y >= sy && y < sy+50 {
+		return color.RGBA{uint8(190 + noise), uint8(136 + noise), uint8(102 + noise), 255}
+	}
+	px, py := (seed*71+width/2)%(width-40), (seed*29+height/3)%(height-40)
+	if x >= px && x < px+30 && y >= py && y < py+30 {
+		return color.RGBA{240, uint8(30 + noise), 10, 255}
+	}
+	g := x*160/width + y*60/height
+	return color.RGBA{uint8(g), uint8(g + noise%5), uint8(255 - g), 255}
+}
+
+func TestCompatibilityVectors(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/smartcropjs/vectors.json")
+	if os.IsNotExist(err) {
+		t.Skip("no vectors recorded, see testdata/smartcropjs/record.js")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []struct {
+		Seed, Width, Height, CropWidth, CropHeight int
+		X, Y, W, H                                 int
+		Score                                      float64
+	}
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer := NewAnalyzer(PresetSmartcropJS, nfnt.NewDefaultResizer())
+	for _, v := range vectors {
+		img := image.NewRGBA(image.Rect(0, 0, v.Width, v.Height))
+		for y := 0; y < v.Height; y++ {
+			for x := 0; x < v.Width; x++ {
+				img.SetRGBA(x, y, compatPixel(v.Seed, v.Width, v.Height, x, y))
+			}
+		}
+		res, err := analyzer.Analyze(img, v.CropWidth, v.CropHeight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := image.Rect(v.X, v.Y, v.X+v.W, v.Y+v.H); res.Crop.Rectangle != want || res.Crop.Score.Total != v.Score {
+			t.Errorf("seed %d, %dx%d to %dx%d: expected %v (%g) like smartcrop.js, got %v (%g)",
+				v.Seed, v.Width, v.Height, v.CropWidth, v.CropHeight, want, v.Score, res.Crop.Rectangle, res.Crop.Score.Total)
+		}
+	}
+}
+
+func TestSymmetric(t *testing.T) {
+	mirror := func(img *image.RGBA) *image.RGBA {
+		b := img.Bounds()
+		out := image.NewRGBA(b)
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				out.SetRGBA(b.Dx()-1-x, y, img.RGBAAt(x, y))
+			}
+		}
+		return out
+	}
+	// rotate turns img by 90 degrees clockwise
+	rotate := func(img *image.RGBA) *image.RGBA {
+		b := img.Bounds()
+		out := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				out.SetRGBA(b.Dy()-1-y, x, img.RGBAAt(x, y))
+			}
+		}
+		return out
+	}
+
+	cfg := DefaultConfig
+	cfg.Symmetric = true
+	cfg.Prescale = false
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer())
+	for seed := int64(1); seed <= 8; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		img := image.NewRGBA(image.Rect(0, 0, 150+rng.Intn(40), 110+rng.Intn(30)))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{40, 60, 50, 255}), image.Point{}, draw.Src)
+		for i := 0; i < 12; i++ {
+			x, y := rng.Intn(img.Bounds().Dx()), rng.Intn(img.Bounds().Dy())
+			c := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+			if i%3 == 0 {
+				c = color.RGBA{200, 145, 112, 255}
+			}
+			draw.Draw(img, image.Rect(x, y, x+5+rng.Intn(30), y+5+rng.Intn(30)), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+		w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+		for _, size := range [][2]int{{100, 100}, {120, 60}} {
+			crop, err := analyzer.FindBestCrop(img, size[0], size[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mirrored, err := analyzer.FindBestCrop(mirror(img), size[0], size[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := image.Rect(w-crop.Max.X, crop.Min.Y, w-crop.Min.X, crop.Max.Y); mirrored != want {
+				t.Errorf("seed %d, %v: expected the mirrored crop %v, got %v", seed, size, want, mirrored)
+			}
+
+			rotated, err := analyzer.FindBestCrop(rotate(img), size[1], size[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := image.Rect(h-crop.Max.Y, crop.Min.X, h-crop.Min.Y, crop.Max.X); rotated != want {
+				t.Errorf("seed %d, %v: expected the rotated crop %v, got %v", seed, size, want, rotated)
+			}
+		}
+	}
+}
+
+func TestFaceAnchoredCrops(t *testing.T) {
+	img, faces := facegen.Generate(facegen.Options{Width: 600, Height: 400, Faces: 1, Seed: 4})
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	cfg.Prescale = false
+	// crops smaller than the image, so there is room to keep the face in place
+	cfg.MinScale, cfg.MaxScale = 0.7, 0.7
+	analyzer := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithFaceDetector(fixedFaces(faces)))
+
+	sizes := []image.Point{{300, 300}, {400, 300}, {200, 300}}
+	crops, err := FaceAnchoredCrops(analyzer, img, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crops) != len(sizes) {
+		t.Fatalf("expected %d crops, got %d", len(sizes), len(crops))
+	}
+	face := faces[0]
+	first := crops[0].Rectangle
+	for i, crop := range crops {
+		if !face.In(crop.Rectangle) {
+			t.Errorf("size %v: expected %v to contain the face %v", sizes[i], crop, face)
+		}
+		if got, want := float64(crop.Dx())/float64(crop.Dy()), float64(sizes[i].X)/float64(sizes[i].Y); math.Abs(got-want) > 0.01 {
+			t.Errorf("size %v: expected aspect ratio %f, got %f", sizes[i], want, got)
+		}
+		// unless the image edges are in the way
+		if crop.Min.Y > 0 && crop.Max.Y < img.Bounds().Dy() && face.Min.Y-crop.Min.Y != face.Min.Y-first.Min.Y {
+			t.Errorf("size %v: expected the headroom of the first crop, %d, got %d", sizes[i], face.Min.Y-first.Min.Y, face.Min.Y-crop.Min.Y)
+		}
+		fx := func(r image.Rectangle) float64 { return float64(face.Min.X+face.Max.X-2*r.Min.X) / 2 / float64(r.Dx()) }
+		if crop.Min.X > 0 && crop.Max.X < img.Bounds().Dx() && math.Abs(fx(crop.Rectangle)-fx(first)) > 0.01 {
+			t.Errorf("size %v: expected the face at %f of the width, got %f", sizes[i], fx(first), fx(crop.Rectangle))
+		}
+	}
+
+	if _, err := FaceAnchoredCrops(analyzer, img, []image.Point{{300, 0}}); err != ErrInvalidDimensions {
+		t.Errorf("expected ErrInvalidDimensions, got %v", err)
+	}
+}
+
+func TestBodyExtension(t *testing.T) {
+	face := image.Rect(100, 50, 140, 100)
+	// both contain the face, only the second the region below it
+	chin := Crop{Rectangle: image.Rect(60, 0, 180, 110)}
+	torso := Crop{Rectangle: image.Rect(60, 40, 180, 150)}
+
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	if a, b := sca.faceScore(chin, []image.Rectangle{face}), sca.faceScore(torso, []image.Rectangle{face}); a != b {
+		t.Fatalf("expected equal face scores without BodyExtension, got %f and %f", a, b)
+	}
+
+	cfg.BodyExtension = 2
+	sca = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	a, b := sca.faceScore(chin, []image.Rectangle{face}), sca.faceScore(torso, []image.Rectangle{face})
+	if b <= a {
+		t.Fatalf("expected the crop including the torso to score higher, got %f and %f", a, b)
+	}
+	// the face and the 50 of its 100 pixels of body the crop covers
+	if want := float64(40*50+40*50) / (120 * 110); math.Abs(b-want) > 1e-9 {
+		t.Errorf("expected %f, got %f", want, b)
+	}
+}
+
+func TestEyeLineWeight(t *testing.T) {
+	// eye line at y=60
+	face := image.Rect(100, 45, 145, 90)
+	onThird := Crop{Rectangle: image.Rect(40, 0, 220, 180)}
+	below := Crop{Rectangle: image.Rect(40, 40, 220, 220)}
+
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	if s := sca.eyeLineScore(onThird, []image.Rectangle{face}); s != 0 {
+		t.Fatalf("expected no eye line term without EyeLineWeight, got %f", s)
+	}
+
+	cfg.EyeLineWeight = 0.5
+	sca = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	if s := sca.eyeLineScore(onThird, []image.Rectangle{face}); s != 0.5 {
+		t.Errorf("expected the full weight with the eyes on the third line, got %f", s)
+	}
+	// the line is at y=100, 40 of the 60 pixels of falloff away
+	if s, want := sca.eyeLineScore(below, []image.Rectangle{face}), 0.5/3; math.Abs(s-want) > 1e-9 {
+		t.Errorf("expected %f with the eyes above the third line, got %f", want, s)
+	}
+	if a, b := sca.faceScore(onThird, []image.Rectangle{face}), sca.faceScore(below, []image.Rectangle{face}); a <= b {
+		t.Errorf("expected the crop with the eyes on the third line to score higher, got %f and %f", a, b)
+	}
+}
+
+func TestFacePolicy(t *testing.T) {
+	big := image.Rect(10, 10, 90, 90)
+	small := []image.Rectangle{image.Rect(110, 10, 130, 30), image.Rect(140, 10, 160, 30), image.Rect(170, 10, 190, 30)}
+	faceRects := append([]image.Rectangle{big}, small...)
+	one := Crop{Rectangle: image.Rect(0, 0, 100, 100), Score: Score{Total: 10}}
+	three := Crop{Rectangle: image.Rect(100, 0, 200, 100), Score: Score{Total: 5}}
+	all := Crop{Rectangle: image.Rect(0, 0, 200, 100), Score: Score{Total: 1}}
+
+	for _, test := range []struct {
+		policy FacePolicy
+		cs     []Crop
+		want   Crop
+	}{
+		{FacePolicyProportional, []Crop{one, three, all}, one},
+		{FacePolicyAll, []Crop{one, three, all}, all},
+		// without a crop containing every face the score decides
+		{FacePolicyAll, []Crop{one, three}, one},
+		{FacePolicyMaxCount, []Crop{one, three}, three},
+		{FacePolicyMaxCount, []Crop{one, three, all}, all},
+	} {
+		cfg := DefaultConfig
+		cfg.FaceDetectEnabled = true
+		cfg.FacePolicy = test.policy
+		analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		if got := analyzer.findTopCrop(test.cs, faceRects); got.Rectangle != test.want.Rectangle {
+			t.Errorf("policy %d: expected %v, got %v", test.policy, test.want.Rectangle, got.Rectangle)
+		}
+		if got := analyzer.topCrops(test.cs, 1, faceRects)[0]; got.Rectangle != test.want.Rectangle {
+			t.Errorf("policy %d: expected topCrops to rank %v first, got %v", test.policy, test.want.Rectangle, got.Rectangle)
+		}
+	}
+}
+
+func TestFacePolicyOptimize(t *testing.T) {
+	o := newDetectorMap(image.Rect(0, 0, 300, 100))
+	faceRects := []image.Rectangle{image.Rect(5, 40, 25, 60)}
+	start := Crop{Rectangle: image.Rect(0, 0, 100, 100)}
+
+	for _, policy := range []FacePolicy{FacePolicyProportional, FacePolicyAll} {
+		cfg := DefaultConfig
+		cfg.FaceDetectEnabled = true
+		cfg.FacePolicy = policy
+		cfg.MaxScale, cfg.MinScale = 1, 1
+		// crops further right score better
+		cfg.ScoreFunc = func(channels *ScoreMap, crop image.Rectangle, score Score) float64 {
+			return float64(crop.Min.X)
+		}
+		analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+		crop := start
+		crop.Score = analyzer.score(o, crop, faceRects, importanceKernels{})
+		got := analyzer.optimize(o, o.Bounds(), crop, faceRects, nil, 100, 100, 1)
+		if kept := faceRects[0].In(got.Rectangle); kept != (policy == FacePolicyAll) {
+			t.Errorf("policy %d: unexpected optimized crop %v", policy, got.Rectangle)
+		}
+	}
+}
+
+// confidentFaces is a FaceConfidenceDetector reporting the faces of
+// fixedFaces with the given confidences.
+type confidentFaces struct {
+	fixedFaces
+	confidences []float64
+}
+
+func (f confidentFaces) DetectFacesWithConfidence(img image.Image) ([]image.Rectangle, []float64, error) {
+	return f.fixedFaces, f.confidences, nil
+}
+
+func TestGroupFaces(t *testing.T) {
+	raw := []image.Rectangle{
+		// a face found at 5 scales and offsets
+		image.Rect(100, 100, 150, 150), image.Rect(102, 100, 152, 150), image.Rect(98, 101, 150, 151),
+		image.Rect(101, 99, 153, 151), image.Rect(99, 100, 149, 150),
+		// a false positive found twice
+		image.Rect(300, 20, 320, 40), image.Rect(301, 20, 321, 40),
+		// a weaker detection within the face
+		image.Rect(110, 110, 130, 130), image.Rect(111, 110, 131, 130),
+	}
+	faceRects, confidences := groupFaces(raw, 1)
+	if len(faceRects) != 2 {
+		t.Fatalf("expected the weak detection within the face to be dropped, got %v", faceRects)
+	}
+	if want := image.Rect(100, 100, 151, 150); faceRects[0] != want || confidences[0] != 5 {
+		t.Errorf("expected %v with confidence 5, got %v with %f", want, faceRects[0], confidences[0])
+	}
+	if confidences[1] != 2 {
+		t.Errorf("expected confidence 2, got %f", confidences[1])
+	}
+
+	faceRects, confidences = groupFaces(raw, DefaultConfig.faceDetectMinNeighbors())
+	if len(faceRects) != 1 || confidences[0] != 5 {
+		t.Errorf("expected only the face with more than 3 neighbors, got %v %v", faceRects, confidences)
+	}
+
+	// the raw detections are kept as they are
+	cfg := DefaultConfig
+	cfg.FaceDetectRawDetections = true
+	faceRects, confidences = groupFaces(raw, cfg.faceDetectMinNeighbors())
+	if len(faceRects) != len(raw) || faceRects[7] != raw[7] || confidences[7] != 1 {
+		t.Errorf("expected the raw detections, got %v %v", faceRects, confidences)
+	}
+}
+
+func TestFaceMinConfidence(t *testing.T) {
+	faces := confidentFaces{fixedFaces{image.Rect(10, 20, 60, 80), image.Rect(100, 20, 150, 80)}, []float64{12, 4}}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	cfg.Prescale = false
+	cfg.FaceMinConfidence = 5
+	for _, test := range []struct {
+		detector FaceDetector
+		want     int
+	}{
+		{faces, 1},
+		// without confidences every face is kept
+		{faces.fixedFaces, 2},
+	} {
+		analyzer := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithFaceDetector(test.detector))
+		faceRects, confidences, err := analyzer.FindFacesWithConfidence(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(faceRects) != test.want || confidences != nil && len(confidences) != test.want {
+			t.Errorf("expected %d faces, got %v with confidences %v", test.want, faceRects, confidences)
+		}
+	}
+}
+
+func TestFaceMinArea(t *testing.T) {
+	face := image.Rect(40, 40, 60, 60)
+	wide := Crop{Rectangle: image.Rect(0, 0, 100, 100)}
+	tight := Crop{Rectangle: image.Rect(10, 10, 90, 90)}
+
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	cfg.FaceMinAreaFraction = 0.05
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	// the face takes up 4% of the wide crop and 6.25% of the tight one
+	if s := analyzer.faceScore(wide, []image.Rectangle{face}); s != 0 {
+		t.Errorf("expected the face not to count for the wide crop, got %f", s)
+	}
+	if s := analyzer.faceScore(tight, []image.Rectangle{face}); s != 0.0625 {
+		t.Errorf("expected the face to count for the tight crop, got %f", s)
+	}
+
+	cfg.Prescale = false
+	cfg.FaceMinPixels = 401
+	faces := fixedFaces{face, image.Rect(100, 20, 150, 80)}
+	faceRects, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithFaceDetector(faces)).FindFaces(image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(faceRects) != 1 || faceRects[0] != faces[1] {
+		t.Errorf("expected only the face of more than 400 pixels, got %v", faceRects)
+	}
+}
+
+func TestFaceSkinAttenuation(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	cfg.FaceSkinAttenuation = 0.75
+	sca := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	if w := sca.withFaces(nil).config.SkinWeight; w != cfg.SkinWeight {
+		t.Errorf("expected SkinWeight %f without faces, got %f", cfg.SkinWeight, w)
+	}
+	if w, want := sca.withFaces([]image.Rectangle{image.Rect(0, 0, 10, 10)}).config.SkinWeight, cfg.SkinWeight/4; math.Abs(w-want) > 1e-9 {
+		t.Errorf("expected SkinWeight %f with faces, got %f", want, w)
+	}
+	if sca.config.SkinWeight != cfg.SkinWeight {
+		t.Error("expected the analyzer itself to be unchanged")
+	}
+}
+
+type fixedRegions []image.Rectangle
+
+func (f fixedRegions) DetectSensitiveRegions(img image.Image) ([]image.Rectangle, error) {
+	return f, nil
+}
+
+func TestSensitiveDetector(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.Prescale = false
+	best, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer())).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	center := best.Min.Add(best.Max).Div(2)
+	regions := fixedRegions{image.Rect(center.X-5, center.Y-5, center.X+5, center.Y+5)}
+
+	crop, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithSensitiveDetector(regions)).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crop.Overlaps(regions[0]) {
+		t.Errorf("expected the crop %v to avoid the sensitive region %v", crop, regions[0])
+	}
+
+	cfg.SensitivePolicy = SensitiveWiden
+	crop, err = New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithSensitiveDetector(regions)).FindBestCrop(img, 250, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := minInt(img.Bounds().Dx(), img.Bounds().Dy())
+	if crop.Dx() != side || crop.Dy() != side {
+		t.Errorf("expected the widest crop of %dx%d, got %v", side, side, crop)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	cfg := DefaultConfig
+	cfg.Prescale = false
+	best, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer())).FindBestCrop(rgba, 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a mark on the side of the image the best crop leaves out
+	b := rgba.Bounds()
+	logo := image.Rect(b.Min.X+10, b.Min.Y+b.Dy()/2-12, b.Min.X+34, b.Min.Y+b.Dy()/2+12)
+	if best.Min.X-b.Min.X < b.Max.X-best.Max.X {
+		logo = logo.Add(image.Pt(b.Dx()-44, 0))
+	}
+	if logo.In(best) {
+		t.Fatalf("expected the best crop %v to leave out %v", best, logo)
+	}
+	tmpl := rgba.SubImage(logo)
+
+	matches := detect.MatchTemplate(rgba, tmpl, 0.99)
+	if len(matches) == 0 || matches[0].Rectangle != logo {
+		t.Fatalf("expected the template to match at %v, got %v", logo, matches)
+	}
+
+	cfg.TemplateThreshold = 0.99
+	crop, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithTemplates(tmpl)).FindBestCrop(rgba, 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !logo.In(crop) {
+		t.Errorf("expected the crop %v to contain the template at %v", crop, logo)
+	}
+}
+
+type fixedCodes []image.Rectangle
+
+func (f fixedCodes) DetectBarcodes(img image.Image) ([]image.Rectangle, error) {
+	return f, nil
+}
+
+func TestBarcodePolicy(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	best, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer())).FindBestCrop(img, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a code across the left or right edge of the best crop
+	code := image.Rect(best.Min.X-20, best.Min.Y+20, best.Min.X+20, best.Min.Y+60)
+	if best.Min.X < 20 {
+		code = code.Add(image.Pt(best.Dx(), 0))
+	}
+	code = code.Add(img.Bounds().Min)
+	if !code.In(img.Bounds()) {
+		t.Fatalf("expected the code %v to lie within the image", code)
+	}
+
+	for _, policy := range []BarcodePolicy{BarcodeInclude, BarcodeExclude} {
+		cfg.BarcodePolicy = policy
+		crop, err := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithBarcodeDetector(fixedCodes{code})).FindBestCrop(img, 100, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := code.Sub(img.Bounds().Min)
+		if policy == BarcodeInclude && !code.In(crop) {
+			t.Errorf("expected the crop %v to contain the code %v", crop, code)
+		}
+		if policy == BarcodeExclude && crop.Overlaps(code) {
+			t.Errorf("expected the crop %v to leave out the code %v", crop, code)
+		}
+	}
 }
 
-func TestFace(t *testing.T) {
-	fi, _ := os.Open(faceTestFile)
+func TestDocumentMode(t *testing.T) {
+	page := Quad{{80, 50}, {330, 70}, {320, 260}, {60, 240}}
+	inside := func(x, y int) bool {
+		for i, p := range page {
+			n := page[(i+1)%4]
+			if (n.X-p.X)*(y-p.Y)-(n.Y-p.Y)*(x-p.X) < 0 {
+				return false
+			}
+		}
+		return true
+	}
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			v := uint8(50 + rnd.Intn(20))
+			switch {
+			case inside(x, y) && y%20 < 3 && x%40 > 10:
+				// lines of text
+				v = 30
+			case inside(x, y):
+				v = 235
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	cfg := DefaultConfig
+	cfg.DocumentMode = true
+	res, err := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(img, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Document == nil {
+		t.Fatal("expected a document to be found")
+	}
+	for i, p := range res.Document {
+		if d := p.Sub(page[i]); d.X*d.X+d.Y*d.Y > 36 {
+			t.Errorf("expected corner %d near %v, got %v", i, page[i], p)
+		}
+	}
+	if !res.Document.Bounds().In(res.Crop.Rectangle) || res.Crop.Dx() != res.Crop.Dy() {
+		t.Errorf("expected a square crop around the document, got %v", res.Crop.Rectangle)
+	}
+
+	flat := Rectify(img, *res.Document, 100, 80)
+	var light int
+	for i := 0; i < len(flat.Pix); i += 4 {
+		if flat.Pix[i] > 200 {
+			light++
+		}
+	}
+	if light < 100*80*3/4 {
+		t.Errorf("expected the rectified page to be mostly paper, got %d light pixels", light)
+	}
+
+	// a plain photo has no document
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+	photo, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = NewAnalyzer(cfg, nfnt.NewDefaultResizer()).Analyze(photo, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Document != nil {
+		t.Errorf("expected no document in the photo, got %v", *res.Document)
+	}
+}
+
+func TestFindBestCrops(t *testing.T) {
+	fi, _ := os.Open(testFile)
 	defer fi.Close()
 
 	img, _, err := image.Decode(fi)
@@ -80,32 +3114,30 @@ func TestFace(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	rects := faces(img)
-	sort.Slice(rects, func(i, j int) bool {
-		return rects[i].Min.X < rects[j].Min.X
-	})
-	expected := []image.Rectangle{
-		image.Rect(877, 492, 1518, 1133),
-		image.Rect(1427, 271, 1937, 781),
-		image.Rect(2207, 997, 2233, 1023),
-		image.Rect(2234, 1396, 2336, 1498),
-	}
-	matched := false
-	if len(rects) == len(expected) {
-		matched = true
-		for i, r := range rects {
-			if r != expected[i] {
-				matched = false
-				break
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	sizes := []image.Point{{250, 250}, {160, 90}}
+	// an Analyzer that isn't a MultiSizeAnalyzer is analyzed once per size
+	for _, a := range []Analyzer{analyzer, struct{ Analyzer }{analyzer}} {
+		crops, err := FindBestCrops(a, img, sizes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(crops) != len(sizes) {
+			t.Fatalf("expected %d crops, got %v", len(sizes), crops)
+		}
+		for i, size := range sizes {
+			// within a pixel of rounding
+			if d := crops[i].Dx()*size.Y - crops[i].Dy()*size.X; d > size.X || d < -size.X {
+				t.Errorf("expected crop %d, %v, to have the aspect ratio of %v", i, crops[i], size)
 			}
 		}
 	}
-	if !matched {
-		t.Fatalf("expected %v, got %v", expected, rects)
+	if _, err := FindBestCrops(struct{ Analyzer }{analyzer}, img, []image.Point{{0, 10}}); err != ErrInvalidDimensions {
+		t.Fatalf("expected ErrInvalidDimensions, got %v", err)
 	}
 }
 
-func TestCrop(t *testing.T) {
+func TestSprite(t *testing.T) {
 	fi, _ := os.Open(testFile)
 	defer fi.Close()
 
@@ -114,41 +3146,514 @@ func TestCrop(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	topCrop, err := smartCrop(img, 250, 250)
+	resizer := nfnt.NewDefaultResizer()
+	analyzer := NewAnalyzer(DefaultConfig, resizer)
+	sizes := []image.Point{{250, 250}, {160, 90}, {60, 120}}
+	out, renditions, err := Sprite(analyzer, img, sizes, resizer)
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := image.Rect(120, 0, 404, 284)
-	if topCrop != expected {
-		t.Fatalf("expected %v, got %v", expected, topCrop)
+	if out.Bounds() != image.Rect(0, 0, 470, 250) {
+		t.Fatalf("unexpected sprite bounds %v", out.Bounds())
+	}
+	for i, size := range sizes {
+		crop, err := analyzer.FindBestCrop(img, size.X, size.Y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renditions[i].Crop != crop {
+			t.Fatalf("expected rendition %d to show %v, got %v", i, crop, renditions[i].Crop)
+		}
 	}
 
-	// test top 3 from allCrops
-	allCrops, err := allCrops(img, 250, 250)
+	data, err := json.Marshal(renditions[1])
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Sort by score desc
-	sort.Slice(allCrops, func(i, j int) bool {
-		return allCrops[i].Score.Total > allCrops[j].Score.Total
-	})
-	expectedTop3 := []image.Rectangle{
-		image.Rect(120, 0, 404, 284),
-		image.Rect(112, 0, 396, 284),
-		image.Rect(128, 8, 383, 263),
+	expected := fmt.Sprintf(`{"x":250,"y":0,"width":160,"height":90,"crop":{"x":%d,"y":%d,"width":%d,"height":%d}}`,
+		renditions[1].Crop.Min.X, renditions[1].Crop.Min.Y, renditions[1].Crop.Dx(), renditions[1].Crop.Dy())
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
 	}
-	for i, gotCrop := range allCrops[:3] {
-		if gotCrop.Rectangle != expectedTop3[i] {
-			t.Fatalf("failed on allCrops in pos %d: expected %v, got %v", i, expectedTop3[i], gotCrop.Rectangle)
+}
+
+func TestGenerateSrcSet(t *testing.T) {
+	fi, _ := os.Open(testFile)
+	defer fi.Close()
+
+	img, _, err := image.Decode(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer())
+	set, err := GenerateSrcSet(analyzer, img, image.Pt(16, 9), []int{320, 640, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crop, err := analyzer.FindBestCrop(img, 640, 360)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range set {
+		if e.Crop != crop {
+			t.Fatalf("expected every variant to show %v, got %v", crop, e)
+		}
+	}
+	if set[2].Height != 56 {
+		t.Fatalf("expected a height of 56 at 100w, got %d", set[2].Height)
+	}
+
+	expected := "photos/gopher-320x180.jpg 320w, photos/gopher-640x360.jpg 640w, photos/gopher-100x56.jpg 100w"
+	if s := set.String("photos/gopher.jpg"); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+	if _, err := GenerateSrcSet(analyzer, img, image.Pt(16, 9), []int{320, 0}); err != ErrInvalidDimensions {
+		t.Fatalf("expected ErrInvalidDimensions, got %v", err)
+	}
+}
+
+func TestCropAnchor(t *testing.T) {
+	bounds := image.Rect(0, 0, 900, 300)
+	tests := []struct {
+		crop     image.Rectangle
+		expected Gravity
+	}{
+		{image.Rect(0, 0, 300, 300), GravityWest},
+		{image.Rect(290, 0, 590, 300), GravityCenter},
+		{image.Rect(600, 0, 900, 300), GravityEast},
+		{image.Rect(0, 0, 300, 100), GravityNorthWest},
+		{image.Rect(300, 200, 600, 300), GravitySouth},
+		{image.Rect(580, 180, 880, 280), GravitySouthEast},
+	}
+	for _, test := range tests {
+		if g := (Crop{Rectangle: test.crop}).Anchor(bounds); g != test.expected {
+			t.Errorf("expected %v for %v, got %v", test.expected, test.crop, g)
+		}
+	}
+	if s := GravityNorthEast.String(); s != "NorthEast" {
+		t.Fatalf("expected NorthEast, got %s", s)
+	}
+	if a := GravitySouthWest.Anchor(); a != (Anchor{0, 1}) {
+		t.Fatalf("expected the bottom left anchor, got %v", a)
+	}
+}
+
+type recordedMetrics struct {
+	mu         sync.Mutex
+	analyses   int
+	stages     map[string]int
+	candidates int
+	faces      int
+	fallbacks  []string
+}
+
+func (m *recordedMetrics) Analysis(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.analyses++
+}
+
+func (m *recordedMetrics) Stage(stage string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stages[stage]++
+}
+
+func (m *recordedMetrics) Candidates(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.candidates += n
+}
+
+func (m *recordedMetrics) Faces(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faces += n
+}
+
+func (m *recordedMetrics) Fallback(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallbacks = append(m.fallbacks, kind)
+}
+
+func TestMetrics(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(250, 50, 350, 150), &image.Uniform{color.RGBA{20, 40, 230, 255}}, image.Point{}, draw.Src)
+
+	m := &recordedMetrics{stages: make(map[string]int)}
+	cfg := DefaultConfig
+	cfg.FaceDetectEnabled = true
+	analyzer := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithMetrics(m),
+		WithFaceDetector(fixedFaces{image.Rect(260, 60, 300, 100)}))
+	if _, err := analyzer.FindBestCrop(img, 100, 100); err != nil {
+		t.Fatal(err)
+	}
+	if m.analyses != 1 || m.candidates == 0 || m.faces != 1 || len(m.fallbacks) != 0 {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+	for _, stage := range []string{StagePrescale, StageEdge, StageSkin, StageSaturation, StageFace, StageCandidates, StageScore} {
+		if m.stages[stage] != 1 {
+			t.Fatalf("expected stage %s to be reported once, got %v", stage, m.stages)
+		}
+	}
+
+	cfg = DefaultConfig
+	cfg.MinAcceptableScore = 1
+	analyzer = New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()), WithMetrics(m))
+	if _, err := analyzer.FindBestCrop(img, 100, 100); err != nil {
+		t.Fatal(err)
+	}
+	if m.analyses != 2 || len(m.fallbacks) != 1 || m.fallbacks[0] != FallbackCentered {
+		t.Fatalf("expected a centered fallback, got %+v", m)
+	}
+}
+
+type spanKey struct{}
+
+type recordedTracer struct {
+	mu     sync.Mutex
+	spans  []string
+	stages map[string]string
+}
+
+func (r *recordedTracer) StartAnalysis(ctx context.Context) (context.Context, func(error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := fmt.Sprintf("analysis %d", len(r.spans))
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		name = parent + "/" + name
+	}
+	r.spans = append(r.spans, name)
+	return context.WithValue(ctx, spanKey{}, name), func(error) {}
+}
+
+func (r *recordedTracer) Stage(ctx context.Context, stage string, start, end time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages[stage], _ = ctx.Value(spanKey{}).(string)
+}
+
+func TestTracer(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+
+	tracer := &recordedTracer{stages: make(map[string]string)}
+	analyzer := New(WithResizer(nfnt.NewDefaultResizer()), WithTracer(tracer))
+	ctx := context.WithValue(context.Background(), spanKey{}, "request")
+	if _, err := analyzer.AnalyzeContext(ctx, img, 100, 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracer.spans) != 1 || tracer.spans[0] != "request/analysis 0" {
+		t.Fatalf("expected an analysis span below the request, got %v", tracer.spans)
+	}
+	for _, stage := range []string{StagePrescale, StageEdge, StageSkin, StageSaturation, StageCandidates, StageScore} {
+		if parent, ok := tracer.stages[stage]; !ok || parent != tracer.spans[0] {
+			t.Fatalf("expected a span for stage %s below the analysis, got %v", stage, tracer.stages)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.NightDetectEnabled = true
+	m := &recordedMetrics{stages: make(map[string]int)}
+	if err := New(WithConfig(cfg), WithMetrics(m)).SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	if m.analyses != 0 {
+		t.Fatalf("expected the self test not to be reported, got %d analyses", m.analyses)
+	}
+
+	cfg = DefaultConfig
+	cfg.MinScale = 1.2
+	if err := New(WithConfig(cfg)).SelfTest(); err == nil || !strings.Contains(err.Error(), "MinScale") {
+		t.Fatalf("expected an error about MinScale, got %v", err)
+	}
+
+	cfg = FaceDetectConfig
+	cfg.FaceDetectClassifierFile = ""
+	if err := New(WithConfig(cfg)).SelfTest(); err == nil {
+		t.Fatal("expected the self test to fail without a classifier")
+	}
+	if err := New(WithConfig(cfg), WithFaceDetector(fixedFaces{})).SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	cfg := FaceDetectConfig
+	cfg.FaceDetectClassifierFile = "./resources/haarcascade_frontalface_default.xml"
+	info := New(WithConfig(cfg), WithBarcodeDetector(fixedCodes{})).Info()
+	if info.AlgorithmVersion != AlgorithmVersion || info.ConfigHash != fmt.Sprintf("%016x", cfg.Hash()) {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if strings.Join(info.Detectors, ",") != "edge,skin,saturation,face,barcodes" {
+		t.Fatalf("unexpected detectors %v", info.Detectors)
+	}
+	if h := info.Models[cfg.FaceDetectClassifierFile]; len(h) != 64 {
+		t.Fatalf("expected the SHA-256 of the classifier, got %v", info.Models)
+	}
+
+	// the classifier is hashed once, by New
+	dir, err := ioutil.TempDir("", "smartcrop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := info.Models[cfg.FaceDetectClassifierFile]
+	data, err := ioutil.ReadFile(cfg.FaceDetectClassifierFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.FaceDetectClassifierFile = filepath.Join(dir, "classifier.xml")
+	if err := ioutil.WriteFile(cfg.FaceDetectClassifierFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := New(WithConfig(cfg))
+	if err := os.Remove(cfg.FaceDetectClassifierFile); err != nil {
+		t.Fatal(err)
+	}
+	if h := a.Info().Models[cfg.FaceDetectClassifierFile]; h != want {
+		t.Fatalf("expected the hash taken by New, got %q", h)
+	}
+}
+
+func TestDecodeLimited(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := DecodeLimited(bytes.NewReader(buf.Bytes()), 5000)
+	if err != nil || format != "png" || img.Bounds() != image.Rect(0, 0, 100, 50) {
+		t.Fatalf("expected the 100x50 png, got %v, %s, %v", img, format, err)
+	}
+	if _, _, err := DecodeLimited(bytes.NewReader(buf.Bytes()), 4999); err != ErrImageTooLarge {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+
+	cfg := DefaultConfig
+	cfg.MaxInputPixels = 4999
+	analyzer := New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()))
+	if _, err := analyzer.FindBestCrop(img, 50, 50); err != ErrImageTooLarge {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+	cfg.MaxInputPixels = 5000
+	analyzer = New(WithConfig(cfg), WithResizer(nfnt.NewDefaultResizer()))
+	if _, err := analyzer.FindBestCrop(img, 50, 50); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type recordedConverter struct {
+	profile []byte
+	bounds  image.Rectangle
+	err     error
+}
+
+func (c *recordedConverter) ToSRGB(img image.Image, profile []byte) (image.Image, error) {
+	c.profile, c.bounds = profile, img.Bounds()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return img, nil
+}
+
+func TestColorConverter(t *testing.T) {
+	profile := []byte("not really an ICC profile")
+	src := image.NewRGBA(image.Rect(0, 0, 1200, 600))
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(profile)
+	zw.Close()
+	// the iCCP chunk goes after the 8 byte signature and the 25 byte IHDR chunk
+	pngData := append([]byte{}, buf.Bytes()[:33]...)
+	pngData = appendPNGChunk(pngData, "iCCP", append([]byte("test\x00\x00"), compressed.Bytes()...))
+	pngData = append(pngData, buf.Bytes()[33:]...)
+
+	buf.Reset()
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	// a profile split over two APP2 segments, the second written first
+	jpegData := append([]byte{}, buf.Bytes()[:2]...)
+	for _, part := range []struct {
+		seq  byte
+		data []byte
+	}{{2, profile[10:]}, {1, profile[:10]}} {
+		seg := append(append([]byte("ICC_PROFILE\x00"), part.seq, 2), part.data...)
+		jpegData = append(jpegData, 0xff, 0xe2, byte((len(seg)+2)>>8), byte(len(seg)+2))
+		jpegData = append(jpegData, seg...)
+	}
+	jpegData = append(jpegData, buf.Bytes()[2:]...)
+
+	for _, data := range [][]byte{pngData, jpegData} {
+		got, err := ExtractICCProfile(data)
+		if err != nil || !bytes.Equal(got, profile) {
+			t.Fatalf("expected the profile, got %q, %v", got, err)
+		}
+		img, _, err := DecodeWithProfile(bytes.NewReader(data), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := img.(ICCImage); !ok {
+			t.Fatalf("expected an ICCImage, got %T", img)
+		}
+
+		converter := &recordedConverter{}
+		analyzer := New(WithColorConverter(converter), WithResizer(nfnt.NewDefaultResizer()))
+		if _, err := analyzer.FindBestCrop(img, 100, 100); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(converter.profile, profile) {
+			t.Fatalf("expected the converter to get the profile, got %q", converter.profile)
+		}
+		if converter.bounds.Dx() >= 1200 {
+			t.Fatalf("expected the prescaled image to be converted, got %v", converter.bounds)
+		}
+
+		// images are analyzed unconverted if the conversion fails
+		converter.err = errors.New("unsupported profile")
+		if _, err := analyzer.FindBestCrop(img, 100, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf.Reset()
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ExtractICCProfile(buf.Bytes()); got != nil || err != nil {
+		t.Fatalf("expected no profile, got %q, %v", got, err)
+	}
+
+	// a profile inflating beyond the limit, as a decompression bomb would
+	compressed.Reset()
+	zw = zlib.NewWriter(&compressed)
+	zw.Write(make([]byte, maxMetadataSize+1))
+	zw.Close()
+	bomb := append([]byte{}, buf.Bytes()[:33]...)
+	bomb = appendPNGChunk(bomb, "iCCP", append([]byte("test\x00\x00"), compressed.Bytes()...))
+	bomb = append(bomb, buf.Bytes()[33:]...)
+	if _, err := ExtractICCProfile(bomb); err != ErrMetadataTooLarge {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+	img, _, err := DecodeWithProfile(bytes.NewReader(buf.Bytes()), 0)
+	if _, ok := img.(ICCImage); ok || err != nil {
+		t.Fatalf("expected a plain image, got %T, %v", img, err)
+	}
+}
+
+func TestMaxFaceFraction(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.MaxFaceFraction = 0.3
+	analyzer := NewAnalyzer(cfg, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+
+	faceRects := []image.Rectangle{image.Rect(10, 10, 60, 60)}
+	tight := Crop{Rectangle: image.Rect(0, 0, 70, 70), Score: Score{Total: 2}}
+	loose := Crop{Rectangle: image.Rect(0, 0, 100, 100), Score: Score{Total: 1}}
+
+	if got := analyzer.findTopCrop([]Crop{tight, loose}, faceRects); got.Rectangle != loose.Rectangle {
+		t.Fatalf("expected %v, got %v", loose, got)
+	}
+	if got := analyzer.findTopCrop([]Crop{tight}, faceRects); got.Rectangle != tight.Rectangle {
+		t.Fatalf("expected fallback to %v, got %v", tight, got)
+	}
+}
+
+func TestScoreJSON(t *testing.T) {
+	data := []byte(`{"Detail":1.5,"Skin":0.5,"Total":2,"Sharpness":0.25,"Channels":{"Logo":1},"Note":"x"}`)
+
+	var score Score
+	if err := json.Unmarshal(data, &score); err != nil {
+		t.Fatal(err)
+	}
+	if score.Detail != 1.5 || score.Skin != 0.5 || score.Total != 2 {
+		t.Fatalf("unexpected fixed fields: %+v", score)
+	}
+	if score.Channel("sharpness") != 0.25 || score.Channel("Logo") != 1 {
+		t.Fatalf("unexpected channels: %v", score.Channels)
+	}
+	if _, ok := score.Channels["Note"]; ok {
+		t.Fatal("expected non-numeric field to be ignored")
+	}
+}
+
+func TestSyntheticFaces(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		img, rects := facegen.Generate(facegen.Options{Width: 600, Height: 400, Faces: 1, Seed: seed})
+		if len(rects) != 1 {
+			t.Fatalf("seed %d: expected 1 face, got %d", seed, len(rects))
+		}
+
+		topCrop, err := smartCrop(img, 150, 150)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rects[0].In(topCrop) {
+			t.Errorf("seed %d: expected crop %v to contain face %v", seed, topCrop, rects[0])
+		}
+	}
+}
+
+func BenchmarkFaceDetect(b *testing.B) {
+	img, _ := facegen.Generate(facegen.Options{Width: 1200, Height: 800, Faces: 4, Seed: 1})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := faces(img); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestImportanceKernels(t *testing.T) {
+	analyzer := NewAnalyzer(DefaultConfig, nfnt.NewDefaultResizer()).(*smartcropAnalyzer)
+	kernels := newImportanceKernels(nil)
+
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 100, 80),
+		image.Rect(8, 16, 108, 96),
+		image.Rect(3, 5, 103, 85),
+		image.Rect(11, 2, 61, 52),
+	} {
+		crop := Crop{Rectangle: r}
+		kernel := kernels.forCrop(analyzer, crop)
+		for y := 0; y < 120; y += DefaultConfig.ScoreDownSample {
+			for x := 0; x < 120; x += DefaultConfig.ScoreDownSample {
+				if got, expected := kernel.at(x, y), analyzer.importance(crop, x, y); got != expected {
+					t.Fatalf("crop %v at %d,%d: expected %f, got %f", r, x, y, expected, got)
+				}
+			}
 		}
 	}
+}
+
+func TestBoxBlur(t *testing.T) {
+	o := image.NewRGBA(image.Rect(0, 0, 9, 9))
+	o.SetRGBA(4, 4, color.RGBA{90, 180, 9, 255})
+	boxBlur(o, 1)
 
-	sub, ok := img.(SubImager)
-	if ok {
-		cropImage := sub.SubImage(topCrop)
-		writeImage("jpeg", cropImage, "./smartcrop.jpg")
-	} else {
-		t.Error(errors.New("No SubImage support"))
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 9; x++ {
+			expected := color.RGBA{0, 0, 0, 0}
+			if x >= 3 && x <= 5 && y >= 3 && y <= 5 {
+				expected = color.RGBA{10, 20, 1, 0}
+			}
+			if x == 4 && y == 4 {
+				expected.A = 255
+			}
+			if got := o.RGBAAt(x, y); got != expected {
+				t.Fatalf("at %d,%d: expected %v, got %v", x, y, expected, got)
+			}
+		}
 	}
 }
 
@@ -196,7 +3701,7 @@ func BenchmarkEdge(b *testing.B) {
 	rgbaImg := toRGBA(img)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		o := image.NewRGBA(img.Bounds())
+		o := newDetectorMap(img.Bounds())
 		analyzer.edgeDetect(rgbaImg, o)
 	}
 }
@@ -226,14 +3731,8 @@ func BenchmarkImageDir(b *testing.B) {
 			}
 			fmt.Printf("Top crop: %+v\n", topCrop)
 
-			sub, ok := img.(SubImager)
-			if ok {
-				cropImage := sub.SubImage(topCrop)
-				// cropImage := sub.SubImage(image.Rect(topCrop.X, topCrop.Y, topCrop.Width+topCrop.X, topCrop.Height+topCrop.Y))
-				writeImage("jpeg", cropImage, "/tmp/smartcrop/smartcrop-"+file.Name())
-			} else {
-				b.Error(errors.New("No SubImage support"))
-			}
+			cropImage := CropImage(img, topCrop)
+			writeImage("jpeg", cropImage, "/tmp/smartcrop/smartcrop-"+file.Name())
 		}
 	}
 	// fmt.Println("average time/image:", b.t)
@@ -0,0 +1,76 @@
+package smartcrop
+
+import (
+	"image"
+)
+
+// boxBlur blurs the R, G and B channels of o in place with a box filter of the
+// given radius, so that scoring samples reflect their neighbourhood instead of
+// single pixels. Alpha is left untouched.
+func boxBlur(o *image.RGBA, radius int) {
+	if radius <= 0 {
+		return
+	}
+	width := o.Bounds().Dx()
+	height := o.Bounds().Dy()
+	tmp := make([]uint8, len(o.Pix))
+
+	// horizontal pass from o.Pix into tmp, vertical pass back into o.Pix
+	blurPass(o.Pix, tmp, width, height, 4, o.Stride, radius)
+	blurPass(tmp, o.Pix, height, width, o.Stride, 4, radius)
+}
+
+// blurPass runs a one dimensional box filter over lines of n pixels. step is the
+// distance between two pixels of a line, lineStep the distance between lines.
+func blurPass(src, dst []uint8, n, lines, step, lineStep, radius int) {
+	for l := 0; l < lines; l++ {
+		base := l * lineStep
+		for c := 0; c < 3; c++ {
+			var sum int
+			// prime the window for pixel 0, clamping at the edges
+			for i := -radius; i <= radius; i++ {
+				sum += int(src[base+clamp(i, 0, n-1)*step+c])
+			}
+			for i := 0; i < n; i++ {
+				dst[base+i*step+c] = uint8(sum / (2*radius + 1))
+				sum -= int(src[base+clamp(i-radius, 0, n-1)*step+c])
+				sum += int(src[base+clamp(i+radius+1, 0, n-1)*step+c])
+			}
+		}
+		// keep alpha
+		for i := 0; i < n; i++ {
+			dst[base+i*step+3] = src[base+i*step+3]
+		}
+	}
+}
+
+// blurScoreMap is boxBlur for every channel of a ScoreMap.
+func blurScoreMap(o *ScoreMap, radius int) {
+	if radius <= 0 {
+		return
+	}
+	width := o.Bounds().Dx()
+	height := o.Bounds().Dy()
+	tmp := make([]float32, width*height)
+
+	for _, plane := range o.planes {
+		blurPlanePass(plane, tmp, width, height, 1, width, radius)
+		blurPlanePass(tmp, plane, height, width, width, 1, radius)
+	}
+}
+
+// blurPlanePass is blurPass for a single channel of float32 values.
+func blurPlanePass(src, dst []float32, n, lines, step, lineStep, radius int) {
+	for l := 0; l < lines; l++ {
+		base := l * lineStep
+		var sum float64
+		for i := -radius; i <= radius; i++ {
+			sum += float64(src[base+clamp(i, 0, n-1)*step])
+		}
+		for i := 0; i < n; i++ {
+			dst[base+i*step] = float32(sum / float64(2*radius+1))
+			sum -= float64(src[base+clamp(i-radius, 0, n-1)*step])
+			sum += float64(src[base+clamp(i+radius+1, 0, n-1)*step])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package smartcrop
+
+import (
+	"encoding/json"
+	"image"
+
+	"github.com/third-light/smartcrop/options"
+	"golang.org/x/image/draw"
+)
+
+// SpriteRendition locates one rendition in a sprite sheet made by Sprite: the
+// rendition occupies Width x Height pixels at X, Y of the sheet and shows Crop
+// of the source image.
+type SpriteRendition struct {
+	X, Y, Width, Height int
+	Crop                image.Rectangle
+}
+
+type spriteRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// MarshalJSON encodes r with its position in the sheet and the crop of the
+// source image as x, y, width and height, as CSS sprites and client side
+// players expect them.
+func (r SpriteRendition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		spriteRect
+		Crop spriteRect `json:"crop"`
+	}{
+		spriteRect{r.X, r.Y, r.Width, r.Height},
+		spriteRect{r.Crop.Min.X, r.Crop.Min.Y, r.Crop.Dx(), r.Crop.Dy()},
+	})
+}
+
+// Sprite renders a rendition of img for every size into a single sprite sheet,
+// left to right in the order of sizes and aligned at the top, and returns it with
+// the location of each rendition. The crops are found with FindBestCrops, so img
+// is analyzed once for all of them if a is a MultiSizeAnalyzer, and resized with
+// resizer.
+func Sprite(a Analyzer, img image.Image, sizes []image.Point, resizer options.Resizer) (*image.RGBA, []SpriteRendition, error) {
+	if len(sizes) == 0 {
+		return nil, nil, ErrInvalidDimensions
+	}
+	crops, err := FindBestCrops(a, img, sizes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	renditions := make([]SpriteRendition, len(sizes))
+	width, height := 0, 0
+	for i, size := range sizes {
+		renditions[i] = SpriteRendition{X: width, Width: size.X, Height: size.Y, Crop: crops[i].Rectangle}
+		width += size.X
+		height = maxInt(height, size.Y)
+	}
+
+	origin := img.Bounds().Min
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for _, r := range renditions {
+		tile := resizer.Resize(CropImage(img, r.Crop.Add(origin)), uint(r.Width), uint(r.Height))
+		draw.Copy(out, image.Pt(r.X, r.Y), tile, tile.Bounds(), draw.Src, nil)
+	}
+	return out, renditions, nil
+}
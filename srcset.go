@@ -0,0 +1,74 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SrcSetEntry is one responsive variant returned by GenerateSrcSet: Crop of the
+// source image resized to Width x Height.
+type SrcSetEntry struct {
+	Width, Height int
+	Crop          image.Rectangle
+}
+
+// Descriptor returns the width descriptor of the variant in a srcset, e.g.
+// "640w".
+func (e SrcSetEntry) Descriptor() string {
+	return strconv.Itoa(e.Width) + "w"
+}
+
+// Filename suggests a file name for the variant, the name of the source with
+// its size before the extension, e.g. "photo-640x360.jpg" for "photo.jpg".
+func (e SrcSetEntry) Filename(source string) string {
+	ext := path.Ext(source)
+	return strings.TrimSuffix(source, ext) + "-" + strconv.Itoa(e.Width) + "x" + strconv.Itoa(e.Height) + ext
+}
+
+// SrcSet is a set of responsive variants of an image.
+type SrcSet []SrcSetEntry
+
+// String returns the value of an HTML srcset attribute listing the variants
+// under the file names suggested for source, e.g.
+// "photo-320x180.jpg 320w, photo-640x360.jpg 640w".
+func (s SrcSet) String(source string) string {
+	parts := make([]string, len(s))
+	for i, e := range s {
+		parts[i] = e.Filename(source) + " " + e.Descriptor()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GenerateSrcSet returns a variant of img for every width, all of the aspect
+// ratio aspect.X:aspect.Y. The crop is found once, for the largest width, and
+// shared by all variants, so they differ only in resolution and show the same
+// part of the image. Variants wider than the crop upscale it.
+func GenerateSrcSet(a Analyzer, img image.Image, aspect image.Point, widths []int) (SrcSet, error) {
+	if aspect.X <= 0 || aspect.Y <= 0 || len(widths) == 0 {
+		return nil, ErrInvalidDimensions
+	}
+	set := make(SrcSet, len(widths))
+	largest := 0
+	for i, w := range widths {
+		if w <= 0 {
+			return nil, ErrInvalidDimensions
+		}
+		h := maxInt(int(math.Round(float64(w)*float64(aspect.Y)/float64(aspect.X))), 1)
+		set[i] = SrcSetEntry{Width: w, Height: h}
+		if w > set[largest].Width {
+			largest = i
+		}
+	}
+
+	crop, err := a.FindBestCrop(img, set[largest].Width, set[largest].Height)
+	if err != nil {
+		return nil, err
+	}
+	for i := range set {
+		set[i].Crop = crop
+	}
+	return set, nil
+}
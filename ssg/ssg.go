@@ -0,0 +1,161 @@
+// Package ssg picks crops for static site generators such as Hugo, which
+// process the same images on every build. Its API is meant to stay stable and
+// only uses smartcrop's DefaultConfig, which doesn't detect faces. smartcrop
+// still links gocv unless it is built with the nogocv tag, so to build without
+// OpenCV installed, pass -tags nogocv to go build:
+//
+//	cropper := ssg.New()
+//	cropper.Load(cacheFile) // results of the previous build, if any
+//	res, err := cropper.Crop("content/posts/gopher.jpg", 300, 150)
+//	// res.Crop, res.FocalPoint or res.Anchor, e.g. "TopLeft"
+//	cropper.Save(cacheFile)
+//
+// Results are cached by the size requested, the content hash of the file and
+// the AlgorithmVersion and Config.Hash of the analyzer, so unchanged images are
+// only read, not analyzed, again, and a cache saved before an upgrade or a
+// change to the config isn't used.
+package ssg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	_ "image/gif"  // decode GIF images
+	_ "image/jpeg" // decode JPEG images
+	_ "image/png"  // decode PNG images
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+	_ "golang.org/x/image/webp" // decode WebP images
+)
+
+// Result is the crop chosen for an image.
+type Result struct {
+	// Crop is the crop in pixels of the image.
+	Crop image.Rectangle `json:"crop"`
+	// FocalPoint is the center of the crop in percent of the image size.
+	FocalPoint smartcrop.Position `json:"focalPoint"`
+	// Anchor is the one of Hugo's nine anchors, "TopLeft" to "BottomRight",
+	// closest to where the crop lies in the image, for generators that only
+	// take an anchor.
+	Anchor string `json:"anchor"`
+}
+
+// Cropper picks crops and caches them. It is safe for concurrent use.
+type Cropper struct {
+	analyzer smartcrop.Analyzer
+	info     smartcrop.Info
+
+	mu      sync.Mutex
+	results map[key]Result
+}
+
+type key struct {
+	Hash             string `json:"hash"`
+	AlgorithmVersion int    `json:"algorithmVersion"`
+	ConfigHash       string `json:"configHash"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+}
+
+// New returns a Cropper using smartcrop.DefaultConfig.
+func New() *Cropper {
+	return NewWithAnalyzer(smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()))
+}
+
+// NewWithAnalyzer returns a Cropper using a.
+func NewWithAnalyzer(a smartcrop.Analyzer) *Cropper {
+	return &Cropper{analyzer: a, info: a.Info(), results: make(map[key]Result)}
+}
+
+// Crop returns the crop for a width x height rendition of the image file at
+// path, from the cache if the same content was cropped at that size before.
+func (c *Cropper) Crop(path string, width, height int) (Result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Result{}, err
+	}
+	sum := sha256.Sum256(data)
+	k := key{
+		Hash:             hex.EncodeToString(sum[:]),
+		AlgorithmVersion: c.info.AlgorithmVersion,
+		ConfigHash:       c.info.ConfigHash,
+		Width:            width,
+		Height:           height,
+	}
+
+	c.mu.Lock()
+	res, ok := c.results[k]
+	c.mu.Unlock()
+	if ok {
+		return res, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, err
+	}
+	crop, err := c.analyzer.FindBestCrop(img, width, height)
+	if err != nil {
+		return Result{}, err
+	}
+	bounds := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	res = Result{
+		Crop:       crop,
+		FocalPoint: smartcrop.FocalPoint(bounds, crop),
+		Anchor:     hugoAnchors[smartcrop.Crop{Rectangle: crop}.Anchor(bounds)],
+	}
+
+	c.mu.Lock()
+	c.results[k] = res
+	c.mu.Unlock()
+	return res, nil
+}
+
+// hugoAnchors are the names of the gravities among Hugo's anchors.
+var hugoAnchors = map[smartcrop.Gravity]string{
+	smartcrop.GravityCenter:    "Center",
+	smartcrop.GravityNorthWest: "TopLeft",
+	smartcrop.GravityNorth:     "Top",
+	smartcrop.GravityNorthEast: "TopRight",
+	smartcrop.GravityWest:      "Left",
+	smartcrop.GravityEast:      "Right",
+	smartcrop.GravitySouthWest: "BottomLeft",
+	smartcrop.GravitySouth:     "Bottom",
+	smartcrop.GravitySouthEast: "BottomRight",
+}
+
+type entry struct {
+	key
+	Result Result `json:"result"`
+}
+
+// Save writes the cached results to w as JSON, to be restored by Load.
+func (c *Cropper) Save(w io.Writer) error {
+	c.mu.Lock()
+	entries := make([]entry, 0, len(c.results))
+	for k, res := range c.results {
+		entries = append(entries, entry{k, res})
+	}
+	c.mu.Unlock()
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// Load adds the results written by Save to the cache.
+func (c *Cropper) Load(r io.Reader) error {
+	var entries []entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		c.results[e.key] = e.Result
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package ssg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+// countingAnalyzer counts the crops it is asked for.
+type countingAnalyzer struct {
+	smartcrop.Analyzer
+	calls int
+}
+
+func (a *countingAnalyzer) FindBestCrop(img image.Image, width, height int) (image.Rectangle, error) {
+	a.calls++
+	return a.Analyzer.FindBestCrop(img, width, height)
+}
+
+func newCounting(cfg smartcrop.Config) *countingAnalyzer {
+	return &countingAnalyzer{Analyzer: smartcrop.NewAnalyzer(cfg, xdraw.NewDefaultResizer())}
+}
+
+// writeImage writes a 200x100 PNG with a bright square at x to path.
+func writeImage(t *testing.T, path string, x int) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for py := 0; py < 100; py++ {
+		for px := 0; px < 200; px++ {
+			c := color.RGBA{90, 90, 90, 255}
+			if px >= x && px < x+30 && py >= 35 && py < 65 {
+				c = color.RGBA{240, 60, 30, 255}
+			}
+			img.SetRGBA(px, py, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "image.png")
+	writeImage(t, path, 150)
+
+	a := newCounting(smartcrop.DefaultConfig)
+	c := NewWithAnalyzer(a)
+	res, err := c.Crop(path, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Crop.Dx() != res.Crop.Dy() || res.Anchor != "Right" {
+		t.Fatalf("expected a square crop on the right, got %+v", res)
+	}
+
+	// a touched file with the same content comes from the cache
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := c.Crop(path, 100, 100); err != nil || again != res || a.calls != 1 {
+		t.Fatalf("expected %+v from the cache, got %+v after %d analyses (%v)", res, again, a.calls, err)
+	}
+
+	// other sizes and content are analyzed
+	if _, err := c.Crop(path, 100, 50); err != nil || a.calls != 2 {
+		t.Fatalf("expected another size to be analyzed, got %d analyses (%v)", a.calls, err)
+	}
+	writeImage(t, path, 20)
+	if res, err := c.Crop(path, 100, 100); err != nil || a.calls != 3 || res.Anchor != "Left" {
+		t.Fatalf("expected changed content to be analyzed, got %+v after %d analyses (%v)", res, a.calls, err)
+	}
+
+	if _, err := c.Crop(filepath.Join(dir, "missing.png"), 100, 100); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "image.png")
+	writeImage(t, path, 150)
+
+	c := NewWithAnalyzer(newCounting(smartcrop.DefaultConfig))
+	res, err := c.Crop(path, 100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := c.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next build starts with the saved results
+	a := newCounting(smartcrop.DefaultConfig)
+	loaded := NewWithAnalyzer(a)
+	if err := loaded.Load(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := loaded.Crop(path, 100, 100); err != nil || again != res || a.calls != 0 {
+		t.Fatalf("expected %+v from the saved cache, got %+v after %d analyses (%v)", res, again, a.calls, err)
+	}
+
+	// results saved with another config aren't used
+	cfg := smartcrop.DefaultConfig
+	cfg.DetailWeight *= 2
+	a = newCounting(cfg)
+	other := NewWithAnalyzer(a)
+	if err := other.Load(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Crop(path, 100, 100); err != nil || a.calls != 1 {
+		t.Fatalf("expected the image to be analyzed again with another config, got %d analyses (%v)", a.calls, err)
+	}
+
+	if err := other.Load(bytes.NewReader([]byte("{"))); err == nil {
+		t.Fatal("expected an error for malformed JSON")
+	}
+}
@@ -0,0 +1,59 @@
+package smartcrop
+
+import (
+	"image"
+	"sort"
+)
+
+// FindTopCrops returns up to k crops for the given width and height that don't
+// overlap each other, best first. They are picked greedily from all candidates
+// by score, so for a wide panorama they are its k best highlights rather than
+// variations of the single best crop. Fewer crops are returned when no more
+// fit.
+func (sca *smartcropAnalyzer) FindTopCrops(img image.Image, width, height, k int) ([]Crop, error) {
+	if width == 0 && height == 0 {
+		return []Crop{}, ErrInvalidDimensions
+	}
+
+	img, offset := atOrigin(img)
+	allCrops, faceRects, prescalefactor, err := sca.scoredCrops(img, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	top := sca.nonOverlapping(allCrops, faceRects, k)
+	for i, crop := range top {
+		top[i].Rectangle = unscale(crop.Rectangle, prescalefactor).Canon().Add(offset)
+	}
+	return versioned(top), nil
+}
+
+// nonOverlapping picks up to k crops of cs that don't overlap, best first.
+func (sca *smartcropAnalyzer) nonOverlapping(cs []Crop, faceRects []image.Rectangle, k int) []Crop {
+	sorted := make([]Crop, len(cs))
+	copy(sorted, cs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Score.Total > sorted[j].Score.Total
+	})
+
+	var picked []Crop
+	for _, crop := range sorted {
+		if len(picked) >= k {
+			break
+		}
+		if !sca.faceFractionOK(crop, faceRects) {
+			continue
+		}
+		free := true
+		for _, p := range picked {
+			if p.Overlaps(crop.Rectangle) {
+				free = false
+				break
+			}
+		}
+		if free {
+			picked = append(picked, crop)
+		}
+	}
+	return picked
+}
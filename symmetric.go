@@ -0,0 +1,94 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// symmetricSamples returns the positions along a side of n pixels the score
+// loops sample every ds pixels, along with their mirror images, in increasing
+// order.
+func symmetricSamples(n, ds int) []int {
+	seen := make(map[int]bool)
+	var samples []int
+	for p := 0; p <= n-ds; p += ds {
+		for _, q := range []int{p, n - 1 - p} {
+			if !seen[q] {
+				seen[q] = true
+				samples = append(samples, q)
+			}
+		}
+	}
+	sort.Ints(samples)
+	return samples
+}
+
+// scoreSymmetric implements score for Config.Symmetric. It samples the detector
+// output on the grid of score and on its mirror image, weighs the samples by the
+// importance of the pixel centers and sums in fixed point, so the score of a
+// crop in a mirrored or rotated image is exactly the score of the crop it
+// corresponds to.
+func (sca *smartcropAnalyzer) scoreSymmetric(output *ScoreMap, crop Crop, faceRects []image.Rectangle, mask *image.Gray) Score {
+	c := sca.config
+	b := output.Bounds()
+	detailPlane, skinPlane, satPlane := output.Plane(ChannelDetail), output.Plane(ChannelSkin), output.Plane(ChannelSaturation)
+	sharpnessPlane := output.Plane(ChannelSharpness)
+	if !c.SharpnessEnabled {
+		sharpnessPlane = nil
+	}
+
+	var skin, detail, saturation, sharpness, maxImportance int64
+	xs, ys := symmetricSamples(b.Dx(), c.ScoreDownSample), symmetricSamples(b.Dy(), c.ScoreDownSample)
+	for _, y := range ys {
+		for _, x := range xs {
+			imp := sca.centerImportance(crop, x, y)
+			if mask != nil {
+				imp = float64(imp * float64(mask.GrayAt(x, y).Y) / 255)
+			}
+			i := output.PixOffset(x, y)
+			det := float64(detailPlane[i])
+
+			skin += toFixed(float64(float64(float64(skinPlane[i])*(det+c.SkinBias)) * imp))
+			detail += toFixed(float64(det * imp))
+			saturation += toFixed(float64(float64(float64(satPlane[i])*(det+c.SaturationBias)) * imp))
+			if sharpnessPlane != nil {
+				sharpness += toFixed(float64(float64(sharpnessPlane[i]) * imp))
+			}
+			maxImportance += toFixed(math.Max(imp, 0))
+		}
+	}
+
+	score := Score{
+		Detail:     fromFixed(detail),
+		Skin:       fromFixed(skin),
+		Saturation: fromFixed(saturation),
+		Face:       sca.faceScore(crop, faceRects),
+	}
+	total := float64(score.Detail*c.DetailWeight) +
+		float64(score.Skin*c.SkinWeight) +
+		float64(score.Saturation*c.SaturationWeight)
+	if sharpnessPlane != nil {
+		score.Channels = map[string]float64{ChannelSharpness: fromFixed(sharpness)}
+		total += float64(fromFixed(sharpness) * c.SharpnessWeight)
+	}
+	score.Total = total/float64(float64(crop.Dx())*float64(crop.Dy())) + score.Face
+	score.Total += float64(math.Abs(score.Total) * sca.anchorBoost(b, crop))
+	score.Total = sca.customScore(output, crop, score)
+
+	sca.normalize(&score, crop, fromFixed(maxImportance))
+	return score
+}
+
+// centerImportance returns the importance of the center of pixel x, y within
+// crop. The distances from the crop center are computed from integers, so
+// pixels mirrored about the crop center get exactly the same importance.
+func (sca *smartcropAnalyzer) centerImportance(crop Crop, x, y int) float64 {
+	if crop.Min.X > x || x >= crop.Max.X || crop.Min.Y > y || y >= crop.Max.Y {
+		return sca.config.OutsideImportance
+	}
+	// twice the distance of the pixel center from the crop center, over the size
+	px := math.Abs(float64(2*(x-crop.Min.X)+1-crop.Dx())) / float64(crop.Dx())
+	py := math.Abs(float64(2*(y-crop.Min.Y)+1-crop.Dy())) / float64(crop.Dy())
+	return sca.importanceFromCenter(px, py)
+}
@@ -0,0 +1,92 @@
+package smartcrop
+
+import (
+	"image"
+	"math"
+
+	"github.com/third-light/smartcrop/detect"
+)
+
+// TemplatePolicy decides how the regions matching the templates passed to
+// WithTemplates affect the crop, see Config.TemplatePolicy.
+type TemplatePolicy int
+
+const (
+	// TemplateInclude only considers the candidates containing the most matched
+	// regions, all of them where possible. This is the default.
+	TemplateInclude TemplatePolicy = iota
+	// TemplateBoost adds up to Config.TemplateWeight to the score of a candidate
+	// for the share of the matched regions it contains.
+	TemplateBoost
+)
+
+// defaultTemplateThreshold is the correlation a region needs to match a
+// template if Config.TemplateThreshold isn't set.
+const defaultTemplateThreshold = 0.8
+
+// matchTemplates returns the regions of the analysis image img that match one of
+// the templates, which are scaled by prescalefactor first.
+func (sca *smartcropAnalyzer) matchTemplates(img image.Image, prescalefactor float64) ([]image.Rectangle, error) {
+	threshold := sca.config.TemplateThreshold
+	if threshold <= 0 {
+		threshold = defaultTemplateThreshold
+	}
+
+	var regions []image.Rectangle
+	for _, t := range sca.templates {
+		if prescalefactor != 1.0 {
+			w := math.Max(1, math.Round(float64(t.Bounds().Dx())*prescalefactor))
+			h := math.Max(1, math.Round(float64(t.Bounds().Dy())*prescalefactor))
+			var err error
+			t, err = sca.analysisResize(t, uint(w), uint(h))
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, m := range detect.MatchTemplate(img, t, threshold) {
+			regions = append(regions, m.Rectangle)
+		}
+	}
+	return regions, nil
+}
+
+// preserveTemplates applies Config.TemplatePolicy to the scored candidates cs.
+func (sca *smartcropAnalyzer) preserveTemplates(cs []Crop, regions []image.Rectangle) []Crop {
+	if len(regions) == 0 {
+		return cs
+	}
+
+	if sca.config.TemplatePolicy == TemplateBoost {
+		for i, crop := range cs {
+			var included float64
+			for _, r := range regions {
+				in := r.Intersect(crop.Rectangle)
+				included += float64(in.Dx()*in.Dy()) / float64(r.Dx()*r.Dy())
+			}
+			cs[i].Score.Total += sca.config.TemplateWeight * included / float64(len(regions))
+		}
+		return cs
+	}
+
+	most := 0
+	counts := make([]int, len(cs))
+	for i, crop := range cs {
+		for _, r := range regions {
+			if r.In(crop.Rectangle) {
+				counts[i]++
+			}
+		}
+		most = maxInt(most, counts[i])
+	}
+	if most == 0 {
+		sca.logger.Log.Println("no candidate contains a matched template")
+		return cs
+	}
+	var kept []Crop
+	for i, crop := range cs {
+		if counts[i] == most {
+			kept = append(kept, crop)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,74 @@
+// record.js records the crops smartcrop.js 2.x picks for the synthetic images
+// of TestCompatibilityVectors, to check CompatibilityMode against:
+//
+//	npm install smartcrop@2
+//	node record.js > vectors.json
+//
+// pixel must stay in sync with compatPixel in smartcrop_test.go.
+'use strict';
+
+const smartcrop = require('smartcrop');
+
+// pixel returns the color of x, y in the image of seed: a gradient with a
+// textured skin colored square and a saturated square.
+function pixel(seed, width, height, x, y) {
+  const noise = (x * 31 + y * 17 + seed * 101 + ((x * y) % 97)) % 23;
+  const sx = (seed * 37) % (width - 60);
+  const sy = (seed * 53) % (height - 60);
+  if (x >= sx && x < sx + 50 && y >= sy && y < sy + 50) {
+    return [190 + noise, 136 + noise, 102 + noise];
+  }
+  const px = (seed * 71 + Math.floor(width / 2)) % (width - 40);
+  const py = (seed * 29 + Math.floor(height / 3)) % (height - 40);
+  if (x >= px && x < px + 30 && y >= py && y < py + 30) {
+    return [240, 30 + noise, 10];
+  }
+  const g = Math.floor((x * 160) / width) + Math.floor((y * 60) / height);
+  return [g, g + (noise % 5), 255 - g];
+}
+
+function image(seed, width, height) {
+  const data = new Uint8ClampedArray(width * height * 4);
+  for (let y = 0; y < height; y++) {
+    for (let x = 0; x < width; x++) {
+      const [r, g, b] = pixel(seed, width, height, x, y);
+      const i = (y * width + x) * 4;
+      data[i] = r;
+      data[i + 1] = g;
+      data[i + 2] = b;
+      data[i + 3] = 255;
+    }
+  }
+  return { width, height, data };
+}
+
+// the images are at most 256 pixels on their shorter side, so smartcrop.js
+// never resamples them
+const imageOperations = {
+  open: img => Promise.resolve(img),
+  resample: () => Promise.reject(new Error('unexpected resample')),
+  getData: img => Promise.resolve(img),
+};
+
+async function main() {
+  const vectors = [];
+  for (let seed = 1; seed <= 4; seed++) {
+    for (const [width, height] of [[240, 180], [200, 200], [256, 160]]) {
+      for (const [cropWidth, cropHeight] of [[100, 100], [160, 90], [90, 160]]) {
+        const img = image(seed, width, height);
+        const { topCrop } = await smartcrop.crop(img, { width: cropWidth, height: cropHeight, imageOperations });
+        vectors.push({
+          seed, width, height, cropWidth, cropHeight,
+          x: topCrop.x, y: topCrop.y, w: topCrop.width, h: topCrop.height,
+          score: topCrop.score.total,
+        });
+      }
+    }
+  }
+  process.stdout.write(JSON.stringify(vectors, null, 1) + '\n');
+}
+
+main().catch(err => {
+  console.error(err);
+  process.exit(1);
+});
@@ -0,0 +1,29 @@
+package smartcrop
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer records analyses as trace spans, see WithTracer. Implementations must
+// be safe for concurrent use. The tracing package implements it with
+// OpenTelemetry.
+type Tracer interface {
+	// StartAnalysis starts the span of an analysis as a child of the span in
+	// ctx, the context passed to AnalyzeContext or context.Background(). It
+	// returns the context holding the new span and the function ending it with
+	// the error of the analysis.
+	StartAnalysis(ctx context.Context) (context.Context, func(err error))
+	// Stage records a stage of the analysis in ctx that ran from start to end,
+	// named like the stages reported to MetricsSink.
+	Stage(ctx context.Context, stage string, start, end time.Time)
+}
+
+// traced returns a copy of sca whose context holds the span of a new analysis,
+// along with the function ending it.
+func (sca *smartcropAnalyzer) traced() (*smartcropAnalyzer, func(error)) {
+	c := *sca
+	var end func(error)
+	c.ctx, end = sca.tracer.StartAnalysis(sca.context())
+	return &c, end
+}
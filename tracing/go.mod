@@ -0,0 +1,13 @@
+module github.com/third-light/smartcrop/tracing
+
+go 1.15
+
+require (
+	github.com/third-light/smartcrop v0.4.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+)
+
+// Within this repository the module is built against the working tree. Modules
+// depending on it ignore the replace and get the smartcrop version required.
+replace github.com/third-light/smartcrop => ../
@@ -0,0 +1,53 @@
+// Package tracing records smartcrop analyses as OpenTelemetry spans:
+//
+//	analyzer := smartcrop.New(smartcrop.WithTracer(tracing.New(otel.GetTracerProvider())))
+//	res, err := analyzer.AnalyzeContext(ctx, img, 300, 200)
+//
+// Every analysis gets a span named smartcrop.Analyze, a child of the span in the
+// context passed to AnalyzeContext, with a child span per stage named after it,
+// e.g. smartcrop.prescale or smartcrop.edge.
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/third-light/smartcrop"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer spans are created with.
+const instrumentationName = "github.com/third-light/smartcrop"
+
+// Tracer is a smartcrop.Tracer creating OpenTelemetry spans.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ smartcrop.Tracer = (*Tracer)(nil)
+
+// New returns a Tracer creating spans with a tracer of tp.
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+// StartAnalysis implements smartcrop.Tracer. Failed analyses have the status
+// codes.Error and their error recorded.
+func (t *Tracer) StartAnalysis(ctx context.Context) (context.Context, func(error)) {
+	ctx, span := t.tracer.Start(ctx, "smartcrop.Analyze")
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// Stage implements smartcrop.Tracer. The span is created once the stage is done,
+// with its start and end time.
+func (t *Tracer) Stage(ctx context.Context, stage string, start, end time.Time) {
+	_, span := t.tracer.Start(ctx, "smartcrop."+stage, trace.WithTimestamp(start))
+	span.End(trace.WithTimestamp(end))
+}
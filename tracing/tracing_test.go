@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recorder is a trace.TracerProvider recording the spans its tracers start.
+type recorder struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (r *recorder) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return tracer{r}
+}
+
+type tracer struct {
+	r *recorder
+}
+
+func (t tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &span{Span: trace.SpanFromContext(context.Background()), name: name, start: cfg.Timestamp()}
+	if parent, ok := trace.SpanFromContext(ctx).(*span); ok {
+		s.parent = parent
+	}
+	t.r.mu.Lock()
+	t.r.spans = append(t.r.spans, s)
+	t.r.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// span is a recorded span. The methods it doesn't override are those of a
+// no-op span.
+type span struct {
+	trace.Span
+	name       string
+	parent     *span
+	start, end time.Time
+	ended      bool
+	status     codes.Code
+	err        error
+}
+
+func (s *span) End(opts ...trace.SpanEndOption) {
+	cfg := trace.NewSpanEndConfig(opts...)
+	s.end, s.ended = cfg.Timestamp(), true
+}
+
+func (s *span) RecordError(err error, opts ...trace.EventOption) {
+	s.err = err
+}
+
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.status = code
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 200; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 90, 255})
+		}
+	}
+	return img
+}
+
+func TestTracer(t *testing.T) {
+	r := &recorder{}
+	analyzer := smartcrop.New(smartcrop.WithResizer(xdraw.NewDefaultResizer()), smartcrop.WithTracer(New(r)))
+
+	ctx, parent := tracer{r}.Start(context.Background(), "request")
+	if _, err := analyzer.AnalyzeContext(ctx, testImage(), 100, 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.spans) < 3 {
+		t.Fatalf("expected the analysis and stage spans, got %d spans", len(r.spans))
+	}
+	analysis := r.spans[1]
+	if analysis.name != "smartcrop.Analyze" || analysis.parent != parent || !analysis.ended || analysis.status != codes.Unset {
+		t.Fatalf("expected an ended smartcrop.Analyze span below the request, got %+v", analysis)
+	}
+	stages := make(map[string]bool)
+	for _, s := range r.spans[2:] {
+		if s.parent != analysis || !s.ended || s.start.IsZero() || s.end.Before(s.start) {
+			t.Errorf("expected %s to be a finished child of the analysis, got %+v", s.name, s)
+		}
+		stages[s.name] = true
+	}
+	for _, name := range []string{"smartcrop." + smartcrop.StagePrescale, "smartcrop." + smartcrop.StageEdge, "smartcrop." + smartcrop.StageScore} {
+		if !stages[name] {
+			t.Errorf("expected a %s span, got %v", name, stages)
+		}
+	}
+}
+
+func TestTracerError(t *testing.T) {
+	r := &recorder{}
+	analyzer := smartcrop.New(smartcrop.WithResizer(xdraw.NewDefaultResizer()), smartcrop.WithTracer(New(r)))
+	if _, err := analyzer.Analyze(testImage(), 0, 0); err != smartcrop.ErrInvalidDimensions {
+		t.Fatalf("expected ErrInvalidDimensions, got %v", err)
+	}
+	if len(r.spans) != 1 {
+		t.Fatalf("expected only the analysis span, got %d spans", len(r.spans))
+	}
+	if s := r.spans[0]; !s.ended || s.status != codes.Error || s.err != smartcrop.ErrInvalidDimensions || s.parent != nil {
+		t.Fatalf("expected a failed root span, got %+v", s)
+	}
+}
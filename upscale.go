@@ -0,0 +1,37 @@
+package smartcrop
+
+import "image"
+
+// UpscalePolicy decides what an analysis returns when the requested size is
+// larger than the image, so the crop would have to be upscaled, see
+// Config.Upscale.
+type UpscalePolicy int
+
+const (
+	// UpscaleBestEffort returns the best crop of the requested aspect ratio at
+	// the largest size the image allows, usually spanning its full width or
+	// height. This is the default.
+	UpscaleBestEffort UpscalePolicy = iota
+	// UpscaleError fails the analysis with ErrTargetTooLarge.
+	UpscaleError
+	// UpscaleFullImage returns the whole image as the crop, whatever its aspect
+	// ratio, without analysing it.
+	UpscaleFullImage
+)
+
+// upscale applies Config.Upscale to a request for a width x height crop of an
+// image with the given bounds. It reports whether the full image should be
+// returned, or the error the analysis fails with.
+func (sca *smartcropAnalyzer) upscale(bounds image.Rectangle, width, height int) (bool, error) {
+	if width <= bounds.Dx() && height <= bounds.Dy() {
+		return false, nil
+	}
+	switch sca.config.Upscale {
+	case UpscaleError:
+		return false, ErrTargetTooLarge
+	case UpscaleFullImage:
+		sca.logger.Log.Printf("%dx%d exceeds the image, returning all of it\n", width, height)
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,48 @@
+// Package smartcrop exposes the API of github.com/muesli/smartcrop on top of
+// this fork, so code written against upstream only needs its import path
+// changed:
+//
+//	import "github.com/third-light/smartcrop/upstream"
+//
+// The package keeps the name smartcrop, so smartcrop.NewAnalyzer(resizer) and
+// smartcrop.NewAnalyzerWithLogger(resizer, logger) work as before, with the
+// fork's DefaultConfig. The resizers of upstream's nfnt package are available
+// from github.com/third-light/smartcrop/nfnt. Switch to the parent package to
+// use the fork's Config and the rest of its Analyzer.
+package smartcrop
+
+import (
+	"image"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/options"
+)
+
+// ErrInvalidDimensions gets returned when the supplied dimensions are invalid
+var ErrInvalidDimensions = smartcrop.ErrInvalidDimensions
+
+// Analyzer interface analyzes its struct and returns the best possible crop with the given
+// width and height returns an error if invalid
+type Analyzer interface {
+	FindBestCrop(img image.Image, width, height int) (image.Rectangle, error)
+}
+
+// Score contains values that classify matches. It has the fields of upstream's
+// Score and those the fork added.
+type Score = smartcrop.Score
+
+// Crop contains results
+type Crop = smartcrop.Crop
+
+// Logger contains a logger.
+type Logger = smartcrop.Logger
+
+// NewAnalyzer returns a new Analyzer using the given Resizer.
+func NewAnalyzer(resizer options.Resizer) Analyzer {
+	return smartcrop.NewAnalyzer(smartcrop.DefaultConfig, resizer)
+}
+
+// NewAnalyzerWithLogger returns a new analyzer with the given Resizer and Logger.
+func NewAnalyzerWithLogger(resizer options.Resizer, logger Logger) Analyzer {
+	return smartcrop.NewAnalyzerWithLogger(smartcrop.DefaultConfig, resizer, logger)
+}
@@ -0,0 +1,15 @@
+package smartcrop
+
+// AlgorithmVersion identifies the crop algorithm of this package. It is bumped
+// whenever a change to the detectors or the scoring changes the crops chosen
+// with DefaultConfig or FaceDetectConfig, so stored crops can be regenerated
+// when they were chosen by an older version.
+const AlgorithmVersion = 1
+
+// versioned stamps crops with the current AlgorithmVersion and returns them.
+func versioned(crops []Crop) []Crop {
+	for i := range crops {
+		crops[i].AlgorithmVersion = AlgorithmVersion
+	}
+	return crops
+}
@@ -0,0 +1,63 @@
+package vips
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// RegisterAVIF registers a decoder for AVIF images with the image package, so
+// image.Decode reads them through libvips, which has to be built with libheif.
+// Like the Resizer it needs vips.Startup to be called first.
+func RegisterAVIF() {
+	image.RegisterFormat("avif", "????ftypavif", Decode, DecodeConfig)
+	image.RegisterFormat("avif", "????ftypavis", Decode, DecodeConfig)
+}
+
+// RegisterHEIF registers a decoder for HEIC images, as taken by most phones,
+// with the image package, see RegisterAVIF. libheif applies the rotation and
+// mirroring the image is stored with.
+func RegisterHEIF() {
+	for _, brand := range []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"} {
+		image.RegisterFormat("heif", "????ftyp"+brand, Decode, DecodeConfig)
+	}
+}
+
+// Decode reads an image in any format libvips supports.
+func Decode(r io.Reader) (image.Image, error) {
+	ref, err := load(r)
+	if err != nil {
+		return nil, err
+	}
+	defer ref.Close()
+
+	out, _, err := ref.Export(vips.NewDefaultPNGExportParams())
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+// DecodeConfig returns the dimensions of an image in any format libvips
+// supports. The color model is that of the images Decode returns.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	ref, err := load(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer ref.Close()
+	return image.Config{ColorModel: color.NRGBAModel, Width: ref.Width(), Height: ref.Height()}, nil
+}
+
+func load(r io.Reader) (*vips.ImageRef, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return vips.NewImageFromBuffer(buf)
+}
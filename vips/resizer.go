@@ -0,0 +1,114 @@
+// Package vips implements an options.Resizer on top of libvips using
+// github.com/davidbyttow/govips, along with decoders for the AVIF and HEIC images
+// the standard library can't read. Callers are responsible for calling
+// vips.Startup before and vips.Shutdown after using it.
+package vips
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/third-light/smartcrop/options"
+)
+
+type vipsResizer struct {
+	kernel vips.Kernel
+}
+
+var _ options.ResizerV2 = vipsResizer{}
+
+// Resize works like ResizeCtx, but returns img unchanged when libvips fails.
+func (r vipsResizer) Resize(img image.Image, width, height uint) image.Image {
+	resized, err := r.ResizeCtx(context.Background(), img, width, height)
+	if err != nil {
+		return img
+	}
+	return resized
+}
+
+// ResizeCtx implements options.ResizerV2. It hands img to libvips as a lossless
+// PNG buffer; pipelines that already hold the encoded source should use
+// CropAndResize instead. libvips can't be interrupted, so ctx is only checked
+// before each step.
+func (r vipsResizer) ResizeCtx(ctx context.Context, img image.Image, width, height uint) (image.Image, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ref, err := vips.NewImageFromBuffer(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer ref.Close()
+
+	hScale, vScale := scales(ref.Width(), ref.Height(), int(width), int(height))
+	if err := ref.ResizeWithVScale(hScale, vScale, r.kernel); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	out, _, err := ref.Export(vips.NewDefaultPNGExportParams())
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+// NewResizer creates a new Resizer with the given vips kernel. It also
+// implements options.ResizerV2, so the analyzer gets the errors of libvips.
+func NewResizer(kernel vips.Kernel) options.Resizer {
+	return vipsResizer{kernel: kernel}
+}
+
+// NewDefaultResizer creates a new Resizer using the Lanczos3 kernel.
+func NewDefaultResizer() options.Resizer {
+	return NewResizer(vips.KernelLanczos3)
+}
+
+// CropAndResize crops the encoded image in buf to crop, e.g. the result of
+// Analyzer.FindBestCrop, resizes it to width x height and exports it using params.
+// All pixel work happens in libvips, so the cropped image never has to be decoded
+// into Go memory.
+func CropAndResize(buf []byte, crop image.Rectangle, width, height int, params *vips.ExportParams) ([]byte, error) {
+	ref, err := vips.NewImageFromBuffer(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer ref.Close()
+
+	if err := ref.ExtractArea(crop.Min.X, crop.Min.Y, crop.Dx(), crop.Dy()); err != nil {
+		return nil, err
+	}
+
+	hScale, vScale := scales(crop.Dx(), crop.Dy(), width, height)
+	if err := ref.ResizeWithVScale(hScale, vScale, vips.KernelLanczos3); err != nil {
+		return nil, err
+	}
+
+	out, _, err := ref.Export(params)
+	return out, err
+}
+
+// scales returns the horizontal and vertical scale factors to get from w x h to
+// width x height. A zero width or height preserves the aspect ratio.
+func scales(w, h, width, height int) (float64, float64) {
+	hScale := float64(width) / float64(w)
+	vScale := float64(height) / float64(h)
+	if width == 0 && height == 0 {
+		return 1, 1
+	} else if width == 0 {
+		hScale = vScale
+	} else if height == 0 {
+		vScale = hScale
+	}
+	return hScale, vScale
+}
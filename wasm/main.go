@@ -0,0 +1,63 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command wasm exposes smartcrop to JavaScript when compiled with
+// GOOS=js GOARCH=wasm. Face detection is not available in this build.
+//
+// It registers a global smartcrop object with a single function:
+//
+//	smartcrop.findBestCrop(imageData, cropWidth, cropHeight)
+//
+// imageData is a canvas ImageData (or any object with data, width and height
+// properties holding RGBA pixels). The result is an object with x, y, width,
+// height and score properties, or an object with an error property.
+package main
+
+import (
+	"image"
+	"syscall/js"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+func main() {
+	analyzer := smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer())
+
+	findBestCrop := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) != 3 {
+			return jsError("expected imageData, cropWidth and cropHeight")
+		}
+		img := imageFromJS(args[0])
+		res, err := analyzer.Analyze(img, args[1].Int(), args[2].Int())
+		if err != nil {
+			return jsError(err.Error())
+		}
+		topCrop := res.Crop
+		return map[string]interface{}{
+			"x":      topCrop.Min.X,
+			"y":      topCrop.Min.Y,
+			"width":  topCrop.Dx(),
+			"height": topCrop.Dy(),
+			"score":  topCrop.Score.Total,
+		}
+	})
+
+	js.Global().Set("smartcrop", map[string]interface{}{
+		"findBestCrop": findBestCrop,
+	})
+
+	// keep the Go runtime alive so the callbacks stay usable
+	select {}
+}
+
+// imageFromJS copies the pixels of an ImageData-like JS object into an image.RGBA.
+func imageFromJS(v js.Value) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, v.Get("width").Int(), v.Get("height").Int()))
+	js.CopyBytesToGo(img.Pix, v.Get("data"))
+	return img
+}
+
+func jsError(msg string) map[string]interface{} {
+	return map[string]interface{}{"error": msg}
+}
@@ -0,0 +1,212 @@
+// Package worker runs crop jobs from a queue, as the asynchronous thumbnailing
+// services built around smartcrop do. The queue, e.g. a pub/sub subscription, is
+// plugged in through the Queue interface. ChanQueue adapts a channel:
+//
+//	w := &worker.Worker{
+//		Queue:       worker.ChanQueue(jobs),
+//		Analyzer:    smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()),
+//		Concurrency: 4,
+//		OnResult:    func(r worker.Result) { log.Println(r.Job.ID, r.Duration, r.Err) },
+//	}
+//	http.HandleFunc("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+//		if err := w.Ready(); err != nil {
+//			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+//		}
+//	})
+//	err := w.Run(ctx) // returns after ctx is canceled and the running jobs are done
+package worker
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/third-light/smartcrop"
+)
+
+var (
+	// ErrNotStarted is returned by Worker.Ready before Run is called.
+	ErrNotStarted = errors.New("Worker is not running")
+	// ErrStopping is returned by Worker.Ready once the worker stops taking jobs.
+	ErrStopping = errors.New("Worker is shutting down")
+)
+
+// Job is a crop job.
+type Job struct {
+	// ID identifies the job in results.
+	ID string
+	// Open returns the image to crop.
+	Open func(ctx context.Context) (image.Image, error)
+	// Sizes are the sizes to find crops for.
+	Sizes []image.Point
+	// Done, if set, is called with the result when the job is finished, e.g. to
+	// acknowledge the message it came from.
+	Done func(Result)
+}
+
+// Result is the outcome of a job.
+type Result struct {
+	Job *Job
+	// Crops are the crops for Job.Sizes, unless Err is set.
+	Crops []smartcrop.Crop
+	Err   error
+	// Duration is how long the job took, including opening the image.
+	Duration time.Duration
+}
+
+// Queue is a source of jobs.
+type Queue interface {
+	// Receive returns the next job, blocking until there is one. It returns
+	// io.EOF when the queue is closed and no jobs are left, and ctx.Err() when ctx
+	// is canceled.
+	Receive(ctx context.Context) (*Job, error)
+}
+
+type chanQueue <-chan *Job
+
+// ChanQueue returns a Queue receiving the jobs sent on c, closed when c is.
+func ChanQueue(c <-chan *Job) Queue {
+	return chanQueue(c)
+}
+
+func (c chanQueue) Receive(ctx context.Context) (*Job, error) {
+	select {
+	case job, ok := <-c:
+		if !ok {
+			return nil, io.EOF
+		}
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stats counts the jobs of a worker.
+type Stats struct {
+	Succeeded, Failed, Running int
+}
+
+// Worker runs the jobs of a queue. Its fields must not be changed once Run is
+// called.
+type Worker struct {
+	Queue    Queue
+	Analyzer smartcrop.Analyzer
+	// Concurrency is the number of jobs run at once, 1 if not positive.
+	Concurrency int
+	// OnResult, if set, is called with the result of every job, before Job.Done.
+	OnResult func(Result)
+
+	mu       sync.Mutex
+	started  bool
+	stopping bool
+	stats    Stats
+}
+
+// Run receives and runs jobs until the queue is closed or ctx is canceled, then
+// waits for the running jobs to finish. They are not canceled, so jobs taken off
+// the queue complete. Run returns nil when the queue was closed, ctx.Err() when
+// ctx was canceled and the error of Queue.Receive otherwise.
+func (w *Worker) Run(ctx context.Context) error {
+	n := w.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	w.mu.Lock()
+	w.started, w.stopping = true, false
+	w.mu.Unlock()
+
+	// the running jobs finish with their own context
+	jobCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slots := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	var err error
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		var job *Job
+		job, err = w.Queue.Receive(ctx)
+		if err != nil {
+			<-slots
+			break
+		}
+		w.count(func(s *Stats) { s.Running++ })
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			w.run(jobCtx, job)
+		}()
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	w.mu.Lock()
+	w.stopping = true
+	w.mu.Unlock()
+	wg.Wait()
+	return err
+}
+
+// run runs job and reports its result.
+func (w *Worker) run(ctx context.Context, job *Job) {
+	start := time.Now()
+	res := Result{Job: job}
+	img, err := job.Open(ctx)
+	if err == nil {
+		res.Crops, err = smartcrop.FindBestCrops(w.Analyzer, img, job.Sizes)
+	}
+	res.Err = err
+	res.Duration = time.Since(start)
+
+	w.count(func(s *Stats) {
+		s.Running--
+		if err != nil {
+			s.Failed++
+		} else {
+			s.Succeeded++
+		}
+	})
+	if w.OnResult != nil {
+		w.OnResult(res)
+	}
+	if job.Done != nil {
+		job.Done(res)
+	}
+}
+
+// Ready returns nil while the worker takes jobs, for a readiness probe.
+func (w *Worker) Ready() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case !w.started:
+		return ErrNotStarted
+	case w.stopping:
+		return ErrStopping
+	}
+	return nil
+}
+
+// Stats returns the number of jobs finished and running.
+func (w *Worker) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+func (w *Worker) count(fn func(*Stats)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.stats)
+}
@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/third-light/smartcrop"
+	"github.com/third-light/smartcrop/xdraw"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 200; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 90, 255})
+		}
+	}
+	return img
+}
+
+func newWorker(q Queue, concurrency int) *Worker {
+	return &Worker{
+		Queue:       q,
+		Analyzer:    smartcrop.NewAnalyzer(smartcrop.DefaultConfig, xdraw.NewDefaultResizer()),
+		Concurrency: concurrency,
+	}
+}
+
+// results collects the results of a worker.
+type results struct {
+	mu   sync.Mutex
+	byID map[string]Result
+}
+
+func (r *results) add(res Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byID == nil {
+		r.byID = make(map[string]Result)
+	}
+	r.byID[res.Job.ID] = res
+}
+
+func TestRun(t *testing.T) {
+	errOpen := errors.New("no such image")
+	jobs := make(chan *Job, 3)
+	var done results
+	for _, id := range []string{"a", "b"} {
+		jobs <- &Job{
+			ID:    id,
+			Open:  func(ctx context.Context) (image.Image, error) { return testImage(), nil },
+			Sizes: []image.Point{{100, 100}, {120, 60}},
+			Done:  done.add,
+		}
+	}
+	jobs <- &Job{
+		ID:    "missing",
+		Open:  func(ctx context.Context) (image.Image, error) { return nil, errOpen },
+		Sizes: []image.Point{{100, 100}},
+		Done:  done.add,
+	}
+	close(jobs)
+
+	w := newWorker(ChanQueue(jobs), 2)
+	if err := w.Ready(); err != ErrNotStarted {
+		t.Fatalf("expected ErrNotStarted before Run, got %v", err)
+	}
+	var reported results
+	w.OnResult = reported.add
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(done.byID) != 3 || len(reported.byID) != 3 {
+		t.Fatalf("expected all jobs to be reported, got %v and %v", done.byID, reported.byID)
+	}
+	for _, id := range []string{"a", "b"} {
+		res := done.byID[id]
+		if res.Err != nil || len(res.Crops) != 2 || res.Crops[1].Dx() != 2*res.Crops[1].Dy() {
+			t.Errorf("%s: unexpected result %+v", id, res)
+		}
+	}
+	if res := done.byID["missing"]; res.Err != errOpen || res.Crops != nil {
+		t.Errorf("expected the error opening the image, got %+v", res)
+	}
+	if s := w.Stats(); s != (Stats{Succeeded: 2, Failed: 1}) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if err := w.Ready(); err != ErrStopping {
+		t.Errorf("expected ErrStopping after Run, got %v", err)
+	}
+
+	// analysis errors fail the job too
+	jobs = make(chan *Job, 1)
+	jobs <- &Job{ID: "empty", Open: func(ctx context.Context) (image.Image, error) { return testImage(), nil }, Sizes: []image.Point{{0, 0}}, Done: done.add}
+	close(jobs)
+	if err := newWorker(ChanQueue(jobs), 1).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if res := done.byID["empty"]; res.Err != smartcrop.ErrInvalidDimensions {
+		t.Errorf("expected ErrInvalidDimensions, got %+v", res)
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	jobs := make(chan *Job, 3)
+	started, release := make(chan string, 3), make(chan struct{})
+	var done results
+	for _, id := range []string{"a", "b", "c"} {
+		id := id
+		jobs <- &Job{
+			ID: id,
+			Open: func(ctx context.Context) (image.Image, error) {
+				started <- id
+				<-release
+				return testImage(), ctx.Err()
+			},
+			Sizes: []image.Point{{100, 100}},
+			Done:  done.add,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newWorker(ChanQueue(jobs), 2)
+	stopped := make(chan error)
+	go func() { stopped <- w.Run(ctx) }()
+
+	// two jobs run at once
+	<-started
+	<-started
+	if err := w.Ready(); err != nil {
+		t.Fatalf("expected the worker to be ready, got %v", err)
+	}
+	if s := w.Stats(); s.Running != 2 {
+		t.Fatalf("expected two running jobs, got %+v", s)
+	}
+
+	// the running jobs finish after cancellation, the queued one isn't taken
+	cancel()
+	select {
+	case err := <-stopped:
+		t.Fatalf("expected Run to wait for the running jobs, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := w.Ready(); err != ErrStopping {
+		t.Fatalf("expected ErrStopping while shutting down, got %v", err)
+	}
+	close(release)
+	if err := <-stopped; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(done.byID) != 2 || len(jobs) != 1 {
+		t.Fatalf("expected two jobs done and one left in the queue, got %v with %d queued", done.byID, len(jobs))
+	}
+	for id, res := range done.byID {
+		if res.Err != nil {
+			t.Errorf("%s: expected the job to complete, got %v", id, res.Err)
+		}
+	}
+	if s := w.Stats(); s != (Stats{Succeeded: 2}) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+// failingQueue fails to receive with err.
+type failingQueue struct {
+	err error
+}
+
+func (q failingQueue) Receive(ctx context.Context) (*Job, error) {
+	return nil, q.err
+}
+
+func TestRunQueueError(t *testing.T) {
+	errQueue := errors.New("subscription deleted")
+	if err := newWorker(failingQueue{errQueue}, 1).Run(context.Background()); err != errQueue {
+		t.Fatalf("expected the queue error, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Package xdraw implements an options.Resizer on top of the scalers in
+// golang.org/x/image/draw.
+package xdraw
+
+import (
+	"image"
+
+	"github.com/third-light/smartcrop/options"
+	"golang.org/x/image/draw"
+)
+
+type xdrawResizer struct {
+	scaler draw.Scaler
+}
+
+func (r xdrawResizer) Resize(img image.Image, width, height uint) image.Image {
+	bounds := img.Bounds()
+
+	// a zero width or height preserves the aspect ratio, like nfnt/resize does
+	if width == 0 && height == 0 {
+		width, height = uint(bounds.Dx()), uint(bounds.Dy())
+	} else if width == 0 {
+		width = uint(float64(height) * float64(bounds.Dx()) / float64(bounds.Dy()))
+	} else if height == 0 {
+		height = uint(float64(width) * float64(bounds.Dy()) / float64(bounds.Dx()))
+	}
+
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(image.Rect(0, 0, int(width), int(height)))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	}
+	r.scaler.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// NewResizer creates a new Resizer with the given scaler, e.g. draw.CatmullRom or
+// draw.ApproxBiLinear.
+func NewResizer(scaler draw.Scaler) options.Resizer {
+	return xdrawResizer{scaler: scaler}
+}
+
+// NewDefaultResizer creates a new Resizer using draw.CatmullRom.
+func NewDefaultResizer() options.Resizer {
+	return NewResizer(draw.CatmullRom)
+}
+
+// NewFastResizer creates a new Resizer using draw.ApproxBiLinear, trading quality
+// for speed.
+func NewFastResizer() options.Resizer {
+	return NewResizer(draw.ApproxBiLinear)
+}
@@ -0,0 +1,276 @@
+package smartcrop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// XMPNamespace is the XMP namespace of the crop properties written by WriteXMP.
+const XMPNamespace = "https://github.com/third-light/smartcrop/ns/1.0/"
+
+var (
+	// ErrUnsupportedFormat is returned by WriteXMP and ReadRegions for images
+	// that are neither JPEG nor PNG.
+	ErrUnsupportedFormat = errors.New("Unsupported image format, expect JPEG or PNG")
+	// ErrInvalidImage is returned by WriteXMP and ReadRegions when the image
+	// data is truncated or malformed.
+	ErrInvalidImage = errors.New("Invalid image data")
+)
+
+var (
+	jpegXMPHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	pngXMPKeyword = []byte("XML:com.adobe.xmp\x00")
+	// cropDescriptionStart starts the rdf:Description written by CropXMP
+	cropDescriptionStart = []byte("  <rdf:Description rdf:about=\"\" xmlns:smartcrop=")
+)
+
+// CropXMP returns an XMP packet recording crop, its rectangle in pixels of the
+// original image, its scores and its AlgorithmVersion, in the XMPNamespace.
+func CropXMP(crop Crop) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.Write(cropDescription(crop))
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return b.Bytes()
+}
+
+// cropDescription returns the rdf:Description of crop in packets of CropXMP.
+func cropDescription(crop Crop) []byte {
+	var b bytes.Buffer
+	b.Write(cropDescriptionStart)
+	fmt.Fprintf(&b, "%q\n", XMPNamespace)
+	fmt.Fprintf(&b, "   smartcrop:X=\"%d\"\n   smartcrop:Y=\"%d\"\n", crop.Min.X, crop.Min.Y)
+	fmt.Fprintf(&b, "   smartcrop:Width=\"%d\"\n   smartcrop:Height=\"%d\"\n", crop.Dx(), crop.Dy())
+	fmt.Fprintf(&b, "   smartcrop:Score=\"%g\"\n   smartcrop:NormalizedScore=\"%g\"\n", crop.Score.Total, crop.Score.Normalized)
+	fmt.Fprintf(&b, "   smartcrop:AlgorithmVersion=\"%d\"/>\n", crop.AlgorithmVersion)
+	return b.Bytes()
+}
+
+// mergeXMP adds the rdf:Description of crop to the existing packet, replacing
+// one written before. Without an existing packet it returns CropXMP(crop).
+func mergeXMP(existing []byte, crop Crop) ([]byte, error) {
+	if existing == nil {
+		return CropXMP(crop), nil
+	}
+	if start := bytes.Index(existing, cropDescriptionStart); start >= 0 {
+		end := bytes.Index(existing[start:], []byte("/>\n"))
+		if end < 0 {
+			return nil, ErrInvalidImage
+		}
+		existing = append(append([]byte{}, existing[:start]...), existing[start+end+3:]...)
+	}
+	end := bytes.LastIndex(existing, []byte("</rdf:RDF>"))
+	if end < 0 {
+		return nil, ErrInvalidImage
+	}
+	// start the closing tag on a line of its own, indented as by CropXMP
+	for end > 0 && (existing[end-1] == ' ' || existing[end-1] == '\t') {
+		end--
+	}
+	out := append([]byte{}, existing[:end]...)
+	out = append(out, cropDescription(crop)...)
+	return append(out, existing[end:]...), nil
+}
+
+// WriteXMP copies the JPEG or PNG image read from r to w, embedding the crop
+// decision as XMP (see CropXMP). The crop is added to an existing XMP packet,
+// whose other properties, such as rights, captions and regions, are kept, and
+// replaces a crop written before. All other segments and the pixel data are
+// copied unchanged.
+func WriteXMP(w io.Writer, r io.Reader, crop Crop) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	existing, err := extractXMP(data)
+	if err != nil {
+		return err
+	}
+	packet, err := mergeXMP(existing, crop)
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		data, err = jpegWithXMP(data, packet)
+	case bytes.HasPrefix(data, pngSignature):
+		data, err = pngWithXMP(data, packet)
+	default:
+		err = ErrUnsupportedFormat
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// jpegWithXMP inserts packet as an APP1 segment after the leading APP0 and Exif
+// APP1 segments, dropping any existing XMP segment.
+func jpegWithXMP(data, packet []byte) ([]byte, error) {
+	payload := append(append([]byte{}, jpegXMPHeader...), packet...)
+	if len(payload)+2 > 0xffff {
+		return nil, errors.New("XMP packet too large for a JPEG segment")
+	}
+
+	out := append([]byte{}, data[:2]...)
+	inserted := false
+	insert := func() {
+		out = append(out, 0xff, 0xe1)
+		out = append(out, byte((len(payload)+2)>>8), byte(len(payload)+2))
+		out = append(out, payload...)
+		inserted = true
+	}
+
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, ErrInvalidImage
+		}
+		marker := data[pos+1]
+		// the remaining segments, including the entropy coded data, are copied as is
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		segment := data[pos:end]
+
+		isXMP := marker == 0xe1 && bytes.HasPrefix(segment[4:], jpegXMPHeader)
+		isLeading := marker == 0xe0 || (marker == 0xe1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00")))
+		if !inserted && !isLeading {
+			insert()
+		}
+		if !isXMP {
+			out = append(out, segment...)
+		}
+		pos = end
+	}
+	if !inserted {
+		insert()
+	}
+	return append(out, data[pos:]...), nil
+}
+
+// pngWithXMP inserts packet as an iTXt chunk after the IHDR chunk, dropping any
+// existing XMP chunk.
+func pngWithXMP(data, packet []byte) ([]byte, error) {
+	var text bytes.Buffer
+	text.Write(pngXMPKeyword)
+	// no compression, empty language tag and translated keyword
+	text.Write([]byte{0, 0, 0, 0})
+	text.Write(packet)
+
+	out := append([]byte{}, pngSignature...)
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrInvalidImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		chunkData := data[pos+8 : pos+8+length]
+
+		if chunkType != "iTXt" || !bytes.HasPrefix(chunkData, pngXMPKeyword) {
+			out = append(out, data[pos:end]...)
+		}
+		if chunkType == "IHDR" {
+			out = appendPNGChunk(out, "iTXt", text.Bytes())
+		}
+		pos = end
+	}
+	return out, nil
+}
+
+func appendPNGChunk(out []byte, chunkType string, data []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	out = append(out, n[:]...)
+	start := len(out)
+	out = append(out, chunkType...)
+	out = append(out, data...)
+	binary.BigEndian.PutUint32(n[:], crc32.ChecksumIEEE(out[start:]))
+	return append(out, n[:]...)
+}
+
+// extractXMP returns the XMP packet embedded in JPEG or PNG data, nil if there is
+// none.
+func extractXMP(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		for pos := 2; ; {
+			if pos+4 > len(data) || data[pos] != 0xff {
+				return nil, ErrInvalidImage
+			}
+			marker := data[pos+1]
+			if marker == 0xda || marker == 0xd9 {
+				return nil, nil
+			}
+			length := int(binary.BigEndian.Uint16(data[pos+2:]))
+			end := pos + 2 + length
+			if length < 2 || end > len(data) {
+				return nil, ErrInvalidImage
+			}
+			if payload := data[pos+4 : end]; marker == 0xe1 && bytes.HasPrefix(payload, jpegXMPHeader) {
+				return payload[len(jpegXMPHeader):], nil
+			}
+			pos = end
+		}
+	case bytes.HasPrefix(data, pngSignature):
+		for pos := len(pngSignature); pos < len(data); {
+			if pos+12 > len(data) {
+				return nil, ErrInvalidImage
+			}
+			length := int(binary.BigEndian.Uint32(data[pos:]))
+			end := pos + 12 + length
+			if length < 0 || end > len(data) {
+				return nil, ErrInvalidImage
+			}
+			if chunk := data[pos+8 : pos+8+length]; string(data[pos+4:pos+8]) == "iTXt" && bytes.HasPrefix(chunk, pngXMPKeyword) {
+				return pngText(chunk[len(pngXMPKeyword):])
+			}
+			pos = end
+		}
+		return nil, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// pngText returns the text of an iTXt chunk following the keyword.
+func pngText(chunk []byte) ([]byte, error) {
+	if len(chunk) < 2 {
+		return nil, ErrInvalidImage
+	}
+	compressed := chunk[0] == 1
+	rest := chunk[2:]
+	// skip the language tag and the translated keyword
+	for i := 0; i < 2; i++ {
+		n := bytes.IndexByte(rest, 0)
+		if n < 0 {
+			return nil, ErrInvalidImage
+		}
+		rest = rest[n+1:]
+	}
+	if !compressed {
+		return rest, nil
+	}
+	return inflate(rest)
+}